@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -15,6 +16,12 @@ import (
 	"github.com/zboya/nala-coder/pkg/utils"
 )
 
+// 终端显示样式
+const (
+	reasoningStyle = "\033[2m"
+	resetStyle     = "\033[0m"
+)
+
 func runChat(cmd *cobra.Command, args []string) error {
 	// 交互式对话模式
 	return handleInteractiveChat()
@@ -31,6 +38,7 @@ func handleInteractiveChat() error {
 	fmt.Println("NaLa Coder - Interactive Chat Mode")
 	fmt.Println("Type 'exit' or 'quit' to end the conversation")
 	fmt.Println("Type 'help' for available commands")
+	fmt.Println("Press Ctrl-C to interrupt the current response")
 	fmt.Println()
 
 	currentSessionID := sessionID
@@ -39,6 +47,13 @@ func handleInteractiveChat() error {
 		fmt.Printf("Started new session: %s\n\n", currentSessionID)
 	}
 
+	// 模式只在切换后的第一次请求中发送，之后由会话沿用
+	var nextMode types.AgentMode
+	if planMode {
+		nextMode = types.ModePlan
+		fmt.Println("Plan mode: the agent can only read and will ask you to approve its plan")
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
@@ -65,6 +80,14 @@ func handleInteractiveChat() error {
 			currentSessionID = utils.GenerateID()
 			fmt.Printf("Started new session: %s\n", currentSessionID)
 			continue
+		case "plan":
+			nextMode = types.ModePlan
+			fmt.Println("Switched to plan mode: the agent can only read and will ask you to approve its plan")
+			continue
+		case "execute":
+			nextMode = types.ModeExecute
+			fmt.Println("Switched to execution mode: all tools are available")
+			continue
 		}
 
 		if input == "" {
@@ -77,6 +100,9 @@ func handleInteractiveChat() error {
 			Message:   query,
 			SessionID: currentSessionID,
 			Stream:    true,
+			Provider:  types.LLMProvider(provider),
+			Model:     model,
+			Mode:      nextMode,
 		}
 
 		ctx := context.Background()
@@ -85,14 +111,45 @@ func handleInteractiveChat() error {
 			fmt.Printf("Error: %v\n", err)
 			continue
 		}
+		nextMode = ""
+
+		// 本轮进行中按 Ctrl-C 只中断本轮，不退出对话
+		stopInterrupt := cancelOnInterrupt(agent, currentSessionID)
 
 		fmt.Print("AI: ")
+		thinking := false
 		for response := range stream {
+			// 推理内容以暗色显示，与正式回复区分
+			if response.Reasoning != "" {
+				if !thinking {
+					fmt.Print(reasoningStyle)
+					thinking = true
+				}
+				fmt.Print(response.Reasoning)
+				continue
+			}
+			if thinking {
+				fmt.Print(resetStyle + "\n")
+				thinking = false
+			}
+
+			// 工具调用需要确认，答复后本轮继续
+			if response.Approval != nil {
+				decision := askApproval(reader, response.Approval)
+				if err := agent.ResolveApproval(currentSessionID, response.Approval.ID, decision); err != nil {
+					fmt.Printf("Error: %v\n", err)
+				}
+				continue
+			}
+
 			if response.Response != "" {
 				fmt.Print(response.Response)
 			}
 
 			if response.Finished {
+				if interrupted, _ := response.Metadata["interrupted"].(bool); interrupted {
+					fmt.Print("\n[interrupted]")
+				}
 				fmt.Println()
 				if verbose && response.Usage.TotalTokens > 0 {
 					fmt.Printf("(Used %d tokens)\n", response.Usage.TotalTokens)
@@ -100,16 +157,83 @@ func handleInteractiveChat() error {
 				break
 			}
 		}
+		stopInterrupt()
 		fmt.Println()
 	}
 }
 
+// askApproval 在终端询问用户是否执行工具调用
+// 计划审批和配置的 ask 规则匹配的调用没有可记住的规则，只能选择是否批准
+func askApproval(reader *bufio.Reader, approval *types.ToolApproval) types.ApprovalDecision {
+	if approval.Tool == agent.ExitPlanModeTool {
+		fmt.Printf("\nProposed plan:\n%s\n\n", approval.Subject)
+		fmt.Println("  [y] approve and switch to execution mode  [n] keep planning")
+	} else if approval.Suggested == "" {
+		fmt.Printf("\nAllow %s: %s (rule %s)\n", approval.Tool, approval.Subject, approval.Rule)
+		fmt.Println("  [y] yes  [n] no")
+	} else {
+		fmt.Printf("\nAllow %s: %s\n", approval.Tool, approval.Subject)
+		fmt.Printf("  [y] yes  [n] no  [s] yes, allow %s for this session  [p] yes, allow %s for this project\n",
+			approval.Suggested, approval.Suggested)
+	}
+
+	for {
+		fmt.Print("> ")
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			return types.ApprovalDecision{Approved: false}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(input)) {
+		case "y", "yes":
+			return types.ApprovalDecision{Approved: true}
+		case "n", "no":
+			return types.ApprovalDecision{Approved: false}
+		case "s", "session":
+			if approval.Suggested == "" {
+				continue
+			}
+			return types.ApprovalDecision{Approved: true, Remember: types.ApprovalSession}
+		case "p", "project":
+			if approval.Suggested == "" {
+				continue
+			}
+			return types.ApprovalDecision{Approved: true, Remember: types.ApprovalProject}
+		}
+	}
+}
+
+// cancelOnInterrupt 在收到 Ctrl-C 时中断会话当前的一轮，返回停止监听的函数
+func cancelOnInterrupt(agent types.Agent, sessionID string) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				agent.CancelTurn(sessionID)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
 // printHelp 打印帮助信息
 func printHelp() {
 	fmt.Println("Available commands:")
 	fmt.Println("  help     - Show this help message")
 	fmt.Println("  session  - Show current session ID")
 	fmt.Println("  new      - Start a new session")
+	fmt.Println("  plan     - Switch to read-only plan mode")
+	fmt.Println("  execute  - Switch to execution mode")
 	fmt.Println("  exit     - Exit the chat")
 	fmt.Println("  quit     - Exit the chat")
 	fmt.Println()
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -15,6 +16,22 @@ var (
 	configFile string
 	verbose    bool
 	sessionID  string
+	provider   string
+	model      string
+	planMode   bool
+
+	usageGroupBy string
+	usageSince   string
+	usageUntil   string
+
+	traceFile   string
+	traceLoop   int
+	traceErrors bool
+	traceFull   bool
+
+	providersTimeout time.Duration
+	providersModels  bool
+	providersJSON    bool
 )
 
 func init() {
@@ -23,6 +40,25 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 	// 聊天命令标志
 	chatCmd.Flags().StringVar(&sessionID, "session", "", "session ID for conversation continuity")
+	chatCmd.Flags().StringVar(&provider, "provider", "", "LLM provider for this session (default is llm.default_provider)")
+	chatCmd.Flags().StringVar(&model, "model", "", "model for this session (default is the provider's configured model)")
+	chatCmd.Flags().BoolVar(&planMode, "plan", false, "start in read-only plan mode")
+	// 用量命令标志
+	usageCmd.Flags().StringVar(&usageGroupBy, "group-by", "day", "group totals by session, day or provider")
+	usageCmd.Flags().StringVar(&sessionID, "session", "", "only include the given session")
+	usageCmd.Flags().StringVar(&usageSince, "since", "", "start date (YYYY-MM-DD or RFC3339)")
+	usageCmd.Flags().StringVar(&usageUntil, "until", "", "end date, inclusive (YYYY-MM-DD or RFC3339)")
+	// 追踪命令标志
+	traceCmd.Flags().StringVar(&traceFile, "file", "", "trace file (default is llm.trace.path)")
+	traceCmd.Flags().StringVar(&sessionID, "session", "", "show the calls of the given session")
+	traceCmd.Flags().StringVar(&provider, "provider", "", "only include calls served by the given provider")
+	traceCmd.Flags().IntVar(&traceLoop, "loop", 0, "only include calls from the given agent loop")
+	traceCmd.Flags().BoolVar(&traceErrors, "errors", false, "only include failed calls")
+	traceCmd.Flags().BoolVar(&traceFull, "full", false, "print full prompts and responses instead of diffs and snippets")
+	// 提供商命令标志
+	providersCmd.Flags().DurationVar(&providersTimeout, "timeout", 15*time.Second, "timeout for checking all providers")
+	providersCmd.Flags().BoolVar(&providersModels, "models", false, "list the available models of each provider")
+	providersCmd.Flags().BoolVar(&providersJSON, "json", false, "print the result as JSON")
 }
 
 // rootCmd CLI根命令
@@ -44,9 +80,44 @@ You can provide a message directly or start an interactive session.`,
 	RunE: runChat,
 }
 
+// usageCmd 用量统计命令
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show token usage and cost totals",
+	Long: `Show token usage and cost recorded for every LLM call,
+grouped by session, day or provider. Costs use the llm.prices table.`,
+	Args: cobra.NoArgs,
+	RunE: runUsage,
+}
+
+// traceCmd LLM调用追踪查看命令
+var traceCmd = &cobra.Command{
+	Use:   "trace [session-id]",
+	Short: "Inspect recorded LLM calls",
+	Long: `Inspect the LLM calls recorded when llm.trace.enabled is set.
+Without a session, list traced sessions. With a session, print its calls
+loop by loop, showing only what changed in the prompt since the previous call.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTrace,
+}
+
+// providersCmd 提供商健康检查命令
+var providersCmd = &cobra.Command{
+	Use:   "providers",
+	Short: "Check configured LLM providers",
+	Long: `Check that every configured LLM provider is reachable with its credentials,
+and show the latency, available models and capabilities of the configured model.
+Capabilities marked with ~ are inferred from the model name.`,
+	Args: cobra.NoArgs,
+	RunE: runProviders,
+}
+
 func init() {
 	// 添加子命令
 	rootCmd.AddCommand(chatCmd)
+	rootCmd.AddCommand(usageCmd)
+	rootCmd.AddCommand(traceCmd)
+	rootCmd.AddCommand(providersCmd)
 }
 
 // initConfig 初始化配置
@@ -91,6 +162,8 @@ func setDefaultConfig() {
 	viper.SetDefault("agent.max_loops", 50)
 	viper.SetDefault("agent.context_window", 32000)
 	viper.SetDefault("agent.compression_threshold", 0.9)
+	viper.SetDefault("agent.max_continuations", 3)
+	viper.SetDefault("agent.task.max_loops", 20)
 	viper.SetDefault("tools.max_concurrency", 10)
 	viper.SetDefault("context.history_limit", 6)
 	viper.SetDefault("context.storage_path", filepath.Join(home, ".nala-coder", "storage"))
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func runProviders(cmd *cobra.Command, args []string) error {
+	agent, _, err := initializeAgent()
+	if err != nil {
+		return fmt.Errorf("failed to initialize agent: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), providersTimeout)
+	defer cancel()
+
+	statuses := agent.CheckProviders(ctx)
+	if providersJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	}
+
+	printProviderStatuses(statuses)
+	return nil
+}
+
+// printProviderStatuses 以表格形式打印提供商状态，--models 时列出每个提供商的模型
+func printProviderStatuses(statuses []types.ProviderStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tPROTOCOL\tMODEL\tSTATUS\tLATENCY\tMODELS\tCAPABILITIES")
+	for _, status := range statuses {
+		name := string(status.Provider)
+		if status.Default {
+			name += " *"
+		}
+
+		state, latency, models := "unsupported", "-", "-"
+		if status.Supported {
+			state = "unreachable"
+			latency = (time.Duration(status.LatencyMs) * time.Millisecond).String()
+		}
+		if status.Reachable {
+			state = "ok"
+			models = fmt.Sprint(len(status.Models))
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, status.Protocol, status.Model, state, latency, models, formatCapabilities(status.Capabilities))
+	}
+	w.Flush()
+
+	printed := false
+	for _, status := range statuses {
+		if status.Error == "" {
+			continue
+		}
+		if !printed {
+			fmt.Println()
+			printed = true
+		}
+		fmt.Printf("%s: %s\n", status.Provider, status.Error)
+	}
+
+	if !providersModels {
+		return
+	}
+	for _, status := range statuses {
+		if len(status.Models) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s models:\n", status.Provider)
+		for _, model := range status.Models {
+			fmt.Printf("  %s\n", model.ID)
+		}
+	}
+}
+
+// formatCapabilities 能力列表，推断得到的能力以 ~ 结尾
+func formatCapabilities(capabilities *types.ModelCapabilities) string {
+	if capabilities == nil {
+		return "-"
+	}
+
+	var names []string
+	for _, capability := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"tools", capabilities.Tools},
+		{"vision", capabilities.Vision},
+		{"reasoning", capabilities.Reasoning},
+		{"json", capabilities.StructuredOutput},
+	} {
+		if capability.enabled {
+			names = append(names, capability.name)
+		}
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+
+	formatted := strings.Join(names, ",")
+	if !capabilities.Probed {
+		formatted += " ~"
+	}
+	return formatted
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// traceSnippetLength 未指定 --full 时每条消息最多显示的字符数
+const traceSnippetLength = 300
+
+func runTrace(cmd *cobra.Command, args []string) error {
+	if err := initConfig(); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	path := traceFile
+	if path == "" {
+		path = viper.GetString("llm.trace.path")
+	}
+	if path == "" {
+		path = llm.DefaultTracePath
+	}
+
+	records, err := llm.ReadTrace(path)
+	if err != nil {
+		return err
+	}
+
+	session := sessionID
+	if len(args) > 0 {
+		session = args[0]
+	}
+	if session == "" {
+		printTraceSessions(filterTrace(records))
+		return nil
+	}
+
+	var calls []llm.TraceRecord
+	for _, record := range records {
+		if record.SessionID == session {
+			calls = append(calls, record)
+		}
+	}
+	if len(calls) == 0 {
+		return fmt.Errorf("no trace records for session %s", session)
+	}
+
+	printTraceSession(session, calls)
+	return nil
+}
+
+// filterTrace 按 --provider、--loop、--errors 过滤记录
+func filterTrace(records []llm.TraceRecord) []llm.TraceRecord {
+	var filtered []llm.TraceRecord
+	for _, record := range records {
+		if traceMatches(record) {
+			filtered = append(filtered, record)
+		}
+	}
+	return filtered
+}
+
+// traceMatches 判断记录是否满足过滤条件
+func traceMatches(record llm.TraceRecord) bool {
+	if provider != "" && string(record.Provider) != provider {
+		return false
+	}
+	if traceLoop > 0 && record.Loop != traceLoop {
+		return false
+	}
+	if traceErrors && record.Error == "" {
+		return false
+	}
+	return true
+}
+
+// traceSessionSummary 一个会话的调用汇总
+type traceSessionSummary struct {
+	id               string
+	calls            int
+	loops            int
+	errors           int
+	promptTokens     int
+	completionTokens int
+	latency          time.Duration
+	last             time.Time
+}
+
+// printTraceSessions 按最近调用时间列出会话
+func printTraceSessions(records []llm.TraceRecord) {
+	if len(records) == 0 {
+		fmt.Println("No trace records.")
+		return
+	}
+
+	summaries := make(map[string]*traceSessionSummary)
+	for _, record := range records {
+		id := record.SessionID
+		if id == "" {
+			id = "-"
+		}
+		summary, exists := summaries[id]
+		if !exists {
+			summary = &traceSessionSummary{id: id}
+			summaries[id] = summary
+		}
+
+		summary.calls++
+		summary.loops = max(summary.loops, record.Loop)
+		if record.Error != "" {
+			summary.errors++
+		}
+		if record.Response != nil {
+			summary.promptTokens += record.Response.Usage.PromptTokens
+			summary.completionTokens += record.Response.Usage.CompletionTokens
+		}
+		summary.latency += time.Duration(record.LatencyMs) * time.Millisecond
+		if record.Time.After(summary.last) {
+			summary.last = record.Time
+		}
+	}
+
+	sorted := make([]*traceSessionSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		sorted = append(sorted, summary)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].last.After(sorted[j].last) })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tCALLS\tLOOPS\tERRORS\tPROMPT\tCOMPLETION\tLATENCY\tLAST CALL")
+	for _, s := range sorted {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			s.id, s.calls, s.loops, s.errors, s.promptTokens, s.completionTokens,
+			s.latency.Round(time.Millisecond), s.last.Local().Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+}
+
+// printTraceSession 按循环打印会话的每次调用，提示词与会话中上一次调用比较只显示差异
+func printTraceSession(session string, calls []llm.TraceRecord) {
+	fmt.Printf("Session %s: %d calls\n", session, len(calls))
+
+	var previous *llm.TraceRecord
+	for i := range calls {
+		call := &calls[i]
+		if traceMatches(*call) {
+			printTraceCall(i+1, call, previous)
+		}
+		previous = call
+	}
+}
+
+// printTraceCall 打印一次调用的请求、响应和错误
+func printTraceCall(index int, call, previous *llm.TraceRecord) {
+	source := string(call.Provider)
+	if call.Model != "" {
+		source += "/" + call.Model
+	}
+	mode := "chat"
+	if call.Stream {
+		mode = "stream"
+	}
+	fmt.Printf("\n━━ Loop %d · call %d · %s · %s · %s · %s\n",
+		call.Loop, index, source, mode, time.Duration(call.LatencyMs)*time.Millisecond, call.Time.Local().Format("15:04:05"))
+
+	// 请求
+	request := call.Request
+	if previous == nil || traceFull {
+		fmt.Printf("Prompt: %d messages, %d tools\n", len(request.Messages), len(request.Tools))
+		for _, msg := range request.Messages {
+			printTraceMessage("  ", msg)
+		}
+	} else {
+		changes := llm.DiffPrompts(previous.Request.Messages, request.Messages)
+		counts := make(map[llm.PromptChangeKind]int)
+		for _, change := range changes {
+			counts[change.Kind]++
+		}
+		fmt.Printf("Prompt: %d messages (%d unchanged, %d modified, %d removed, %d added), %d tools\n",
+			len(request.Messages), counts[llm.PromptUnchanged], counts[llm.PromptModified],
+			counts[llm.PromptRemoved], counts[llm.PromptAdded], len(request.Tools))
+		printPromptDiff(changes)
+		if toolNames(previous.Request.Tools) != toolNames(request.Tools) {
+			fmt.Printf("  tools changed: %s\n", toolNames(request.Tools))
+		}
+	}
+
+	// 响应
+	if response := call.Response; response != nil {
+		usage := response.Usage
+		fmt.Printf("Response: %d prompt (%d cached) + %d completion tokens, finish %s\n",
+			usage.PromptTokens, usage.CachedTokens, usage.CompletionTokens, valueOr(string(response.FinishReason), "-"))
+		if response.Reasoning != "" {
+			fmt.Printf("  reasoning: %s\n", indentTrace(traceSnippet(response.Reasoning), "    "))
+		}
+		if response.Content != "" {
+			fmt.Printf("  %s\n", indentTrace(traceSnippet(response.Content), "  "))
+		}
+		for _, tc := range response.ToolCalls {
+			fmt.Printf("  → %s %s\n", tc.Function.Name, traceSnippet(tc.Function.Arguments))
+		}
+	}
+	if call.Error != "" {
+		fmt.Printf("Error: %s\n", call.Error)
+	}
+}
+
+// printPromptDiff 打印提示词差异，修改的消息逐行比较，只显示变化的行
+func printPromptDiff(changes []llm.PromptChange) {
+	for _, change := range changes {
+		switch change.Kind {
+		case llm.PromptModified:
+			fmt.Printf("  ~ [%s]\n", change.Message.Role)
+			for _, line := range llm.DiffLines(change.Previous.Content, change.Message.Content) {
+				if !strings.HasPrefix(line, "  ") {
+					fmt.Printf("      %s\n", traceSnippet(line))
+				}
+			}
+		case llm.PromptRemoved:
+			printTraceMessage("  - ", change.Message)
+		case llm.PromptAdded:
+			printTraceMessage("  + ", change.Message)
+		}
+	}
+}
+
+// printTraceMessage 打印一条提示词消息
+func printTraceMessage(prefix string, msg types.Message) {
+	fmt.Printf("%s[%s] %s\n", prefix, msg.Role, indentTrace(traceSnippet(msg.Content), strings.Repeat(" ", len(prefix)+2)))
+	for _, tc := range msg.ToolCalls {
+		fmt.Printf("%s    → %s %s\n", strings.Repeat(" ", len(prefix)), tc.Function.Name, traceSnippet(tc.Function.Arguments))
+	}
+}
+
+// traceSnippet 未指定 --full 时截断过长的内容
+func traceSnippet(text string) string {
+	if traceFull {
+		return text
+	}
+	return utils.TruncateString(text, traceSnippetLength)
+}
+
+// indentTrace 为多行内容的后续行添加缩进
+func indentTrace(text, indent string) string {
+	return strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n"+indent)
+}
+
+// toolNames 工具名称列表
+func toolNames(tools []types.Tool) string {
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// valueOr 值为空时返回默认值
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
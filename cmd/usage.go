@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+func runUsage(cmd *cobra.Command, args []string) error {
+	since, err := utils.ParseDate(usageSince)
+	if err != nil {
+		return err
+	}
+	until, err := utils.ParseDateEnd(usageUntil)
+	if err != nil {
+		return err
+	}
+
+	agent, _, err := initializeAgent()
+	if err != nil {
+		return fmt.Errorf("failed to initialize agent: %w", err)
+	}
+
+	report, err := agent.GetUsageReport(context.Background(), types.UsageQuery{
+		SessionID: sessionID,
+		Since:     since,
+		Until:     until,
+		GroupBy:   types.UsageGroupBy(usageGroupBy),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get usage report: %w", err)
+	}
+
+	printUsageReport(report)
+	return nil
+}
+
+// printUsageReport 以表格形式打印用量报告
+func printUsageReport(report *types.UsageReport) {
+	if len(report.Rows) == 0 {
+		fmt.Println("No usage recorded.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\tCALLS\tPROMPT\tCACHED\tCOMPLETION\tTOTAL\tCOST (USD)\t\n", strings.ToUpper(string(report.GroupBy)))
+	for _, row := range append(report.Rows, report.Total) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.4f\t\n",
+			row.Key, row.Calls, row.PromptTokens, row.CachedTokens, row.CompletionTokens, row.TotalTokens, row.Cost)
+	}
+	w.Flush()
+}
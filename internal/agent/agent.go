@@ -2,24 +2,40 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/internal/tools"
 	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/tokenizer"
 	"github.com/zboya/nala-coder/pkg/types"
 	"github.com/zboya/nala-coder/pkg/utils"
 )
 
+// 会话元数据中记录模型选择的键
+const (
+	metadataProvider = "llm_provider"
+	metadataModel    = "llm_model"
+)
+
 // Agent 主要Agent实现
 type Agent struct {
 	config         *Config
-	llmManager     types.LLMClient
+	llmManager     *llm.Manager
 	toolEngine     types.ToolEngine
 	contextManager types.ContextManager
 	promptManager  types.PromptManager
+	permissions    *tools.PermissionPolicy
+	turns          turnRegistry
+	approvals      *approvalRegistry
+	environments   sync.Map // 会话ID到环境信息提示词，每个会话只生成一次
+	parentSession  string   // 子任务所属的会话，只在运行子任务的Agent中设置
 	logger         log.Logger
 }
 
@@ -28,12 +44,15 @@ type Config struct {
 	MaxLoops           int `mapstructure:"max_loops"`
 	ContextWindow      int `mapstructure:"context_window"`
 	MaxToolConcurrency int `mapstructure:"max_tool_concurrency"`
+	MaxContinuations   int `mapstructure:"max_continuations"` // 输出被截断后连续自动续写的次数上限，负数关闭续写
+
+	Task TaskConfig `mapstructure:"task"`
 }
 
 // NewAgent 创建Agent
 func NewAgent(
 	config *Config,
-	llmManager types.LLMClient,
+	llmManager *llm.Manager,
 	toolEngine types.ToolEngine,
 	contextManager types.ContextManager,
 	promptManager types.PromptManager,
@@ -45,6 +64,7 @@ func NewAgent(
 		toolEngine:     toolEngine,
 		contextManager: contextManager,
 		promptManager:  promptManager,
+		approvals:      &approvalRegistry{},
 		logger:         logger,
 	}
 }
@@ -56,11 +76,26 @@ func (a *Agent) Chat(ctx context.Context, request types.ChatRequest) (*types.Cha
 		sessionID = utils.GenerateID()
 	}
 
+	// 登记本轮，使其可以通过 CancelTurn 中断
+	ctx, end, err := a.turns.begin(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	if err := a.selectModel(ctx, sessionID, request); err != nil {
+		return nil, err
+	}
+	if err := a.selectMode(ctx, sessionID, request); err != nil {
+		return nil, err
+	}
+
 	// 添加用户消息到上下文
 	userMessage := types.Message{
 		ID:        utils.GenerateID(),
 		Role:      types.RoleUser,
 		Content:   request.Message,
+		Parts:     request.Parts,
 		Metadata:  request.Metadata,
 		Timestamp: time.Now(),
 	}
@@ -71,6 +106,14 @@ func (a *Agent) Chat(ctx context.Context, request types.ChatRequest) (*types.Cha
 
 	// 执行Agent循环
 	response, usage, err := a.runAgentLoop(ctx, sessionID)
+	if errors.Is(err, ErrCancelledByUser) {
+		return &types.ChatResponse{
+			SessionID: sessionID,
+			Finished:  true,
+			Usage:     usage,
+			Metadata:  interruptedMetadata(context.Cause(ctx)),
+		}, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("agent loop failed: %w", err)
 	}
@@ -95,27 +138,60 @@ func (a *Agent) ChatStream(ctx context.Context, request types.ChatRequest) (<-ch
 		sessionID = utils.GenerateID()
 	}
 
+	// 登记本轮，使其可以通过 CancelTurn 中断
+	ctx, end, err := a.turns.begin(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.selectModel(ctx, sessionID, request); err != nil {
+		end()
+		return nil, err
+	}
+	if err := a.selectMode(ctx, sessionID, request); err != nil {
+		end()
+		return nil, err
+	}
+
 	// 添加用户消息到上下文
 	userMessage := types.Message{
 		ID:        utils.GenerateID(),
 		Role:      types.RoleUser,
 		Content:   request.Message,
+		Parts:     request.Parts,
 		Metadata:  request.Metadata,
 		Timestamp: time.Now(),
 	}
 
 	if err := a.contextManager.AddMessage(ctx, sessionID, userMessage); err != nil {
+		end()
 		return nil, fmt.Errorf("failed to add user message: %w", err)
 	}
 
 	// 创建响应通道
 	responseChan := make(chan types.ChatResponse, 10)
 
+	// 需要确认的工具调用通过响应通道交给调用方，收到答复前本轮暂停
+	ctx = withApprover(ctx, func(approval types.ToolApproval) {
+		responseChan <- types.ChatResponse{SessionID: sessionID, Approval: &approval}
+	})
+
 	// 启动流式处理
 	go func() {
 		defer close(responseChan)
 
 		usage, err := a.runAgentLoopStream(ctx, sessionID, responseChan)
+		// 先结束登记再发送最终响应，调用方收到后可以立即开始下一轮
+		end()
+		if errors.Is(err, ErrCancelledByUser) {
+			responseChan <- types.ChatResponse{
+				SessionID: sessionID,
+				Finished:  true,
+				Usage:     usage,
+				Metadata:  interruptedMetadata(context.Cause(ctx)),
+			}
+			return
+		}
 		if err != nil {
 			responseChan <- types.ChatResponse{
 				SessionID: sessionID,
@@ -152,71 +228,93 @@ func (a *Agent) GetState(sessionID string) (*types.AgentState, error) {
 	}
 
 	activeTools := make([]string, 0)
-	for _, tool := range a.toolEngine.GetToolDefinitions() {
+	for _, tool := range a.toolDefinitions(sessionID) {
 		activeTools = append(activeTools, tool.Function.Name)
 	}
 
+	provider, model := a.sessionModel(sessionID)
+
+	status := "ready"
+	if a.turns.running(sessionID) {
+		status = "running"
+	}
+
 	return &types.AgentState{
 		SessionID:         sessionID,
-		Status:            "ready",
+		Status:            status,
 		CurrentLoop:       0,
 		Messages:          sessionContext.Messages,
 		CompressedHistory: sessionContext.CompressedHistory,
 		ActiveTools:       activeTools,
 		LastActivity:      sessionContext.LastActivity,
+		Provider:          provider,
+		Model:             model,
+		Mode:              a.sessionMode(sessionID),
 	}, nil
 }
 
+// GetUsageReport 获取用量和费用报告
+func (a *Agent) GetUsageReport(ctx context.Context, query types.UsageQuery) (*types.UsageReport, error) {
+	return a.contextManager.GetUsageReport(ctx, query)
+}
+
+// CheckProviders 检查已配置提供商的连通性、可用模型和能力
+func (a *Agent) CheckProviders(ctx context.Context) []types.ProviderStatus {
+	return a.llmManager.CheckProviders(ctx)
+}
+
 // runAgentLoop 运行Agent主循环
 func (a *Agent) runAgentLoop(ctx context.Context, sessionID string) (string, types.Usage, error) {
 	var totalUsage types.Usage
 	var finalResponse string
+	var continued bool
+	continuations := 0
+	mode := a.sessionMode(sessionID)
+	prefix := a.buildPromptPrefix(sessionID)
 
 	for loop := 0; loop < a.config.MaxLoops; loop++ {
 		a.logger.Debugf("Agent loop %d/%d for session %s", loop+1, a.config.MaxLoops, sessionID)
+		// 计划被批准后切换到执行模式，重新构建包含模式说明的提示词前缀
+		if current := a.sessionMode(sessionID); current != mode {
+			mode = current
+			prefix = a.buildPromptPrefix(sessionID)
+		}
+		// 本轮发起的LLM调用（包括压缩历史）在追踪记录中标记会话和循环序号
+		ctx := llm.WithTraceInfo(ctx, sessionID, loop+1)
 
 		// 构建LLM请求
-		llmRequest, err := a.buildLLMRequest(ctx, sessionID)
+		llmRequest, err := a.buildLLMRequest(ctx, sessionID, prefix)
 		if err != nil {
-			return "", totalUsage, fmt.Errorf("failed to build LLM request: %w", err)
+			return "", totalUsage, a.interrupted(ctx, sessionID, "", fmt.Errorf("failed to build LLM request: %w", err))
 		}
 
 		// 调用LLM
-		llmResponse, err := a.llmManager.Chat(ctx, *llmRequest)
+		provider, _ := a.sessionModel(sessionID)
+		llmResponse, err := a.llmManager.ChatWithProvider(ctx, provider, *llmRequest)
 		if err != nil {
-			return "", totalUsage, fmt.Errorf("LLM call failed: %w", err)
+			return "", totalUsage, a.interrupted(ctx, sessionID, "", fmt.Errorf("LLM call failed: %w", err))
 		}
 
 		// 累积使用量
-		totalUsage.PromptTokens += llmResponse.Usage.PromptTokens
-		totalUsage.CompletionTokens += llmResponse.Usage.CompletionTokens
-		totalUsage.TotalTokens += llmResponse.Usage.TotalTokens
-
-		// 添加助手响应到上下文
-		assistantMessage := types.Message{
-			ID:        utils.GenerateID(),
-			Role:      types.RoleAssistant,
-			Content:   llmResponse.Content,
-			ToolCalls: llmResponse.ToolCalls,
-			Timestamp: time.Now(),
+		totalUsage.Add(llmResponse.Usage)
+		a.recordUsage(ctx, sessionID, llmResponse.Usage, llmResponse.Metadata)
+
+		// 续写的内容接在被截断的回复之后
+		if continued {
+			finalResponse += llmResponse.Content
+		} else {
+			finalResponse = llmResponse.Content
 		}
+		continued = llmResponse.FinishReason == types.FinishReasonLength && len(llmResponse.ToolCalls) == 0
 
-		if err := a.contextManager.AddMessage(ctx, sessionID, assistantMessage); err != nil {
-			return "", totalUsage, fmt.Errorf("failed to add assistant message: %w", err)
+		next, toolUsage, err := a.handleResponse(ctx, sessionID, llmResponse, &continuations)
+		totalUsage.Add(toolUsage)
+		if err != nil || ctx.Err() != nil {
+			return "", totalUsage, a.interrupted(ctx, sessionID, "", err)
 		}
-
-		finalResponse = llmResponse.Content
-
-		// 如果没有工具调用，结束循环
-		if len(llmResponse.ToolCalls) == 0 {
+		if !next {
 			break
 		}
-
-		// 执行工具调用
-		if err := a.executeToolCalls(ctx, sessionID, llmResponse.ToolCalls); err != nil {
-			a.logger.Errorf("Tool execution failed: %v", err)
-			// 继续循环，让LLM处理错误
-		}
 	}
 
 	return finalResponse, totalUsage, nil
@@ -225,31 +323,57 @@ func (a *Agent) runAgentLoop(ctx context.Context, sessionID string) (string, typ
 // runAgentLoopStream 运行流式Agent循环
 func (a *Agent) runAgentLoopStream(ctx context.Context, sessionID string, responseChan chan<- types.ChatResponse) (types.Usage, error) {
 	var totalUsage types.Usage
+	continuations := 0
+	mode := a.sessionMode(sessionID)
+	prefix := a.buildPromptPrefix(sessionID)
 
 	for loop := 0; loop < a.config.MaxLoops; loop++ {
 		a.logger.Debugf("Agent stream loop %d/%d for session %s", loop+1, a.config.MaxLoops, sessionID)
+		// 计划被批准后切换到执行模式，重新构建包含模式说明的提示词前缀
+		if current := a.sessionMode(sessionID); current != mode {
+			mode = current
+			prefix = a.buildPromptPrefix(sessionID)
+		}
+		// 本轮发起的LLM调用（包括压缩历史）在追踪记录中标记会话和循环序号
+		ctx := llm.WithTraceInfo(ctx, sessionID, loop+1)
 
 		// 构建LLM请求
-		llmRequest, err := a.buildLLMRequest(ctx, sessionID)
+		llmRequest, err := a.buildLLMRequest(ctx, sessionID, prefix)
 		if err != nil {
-			return totalUsage, fmt.Errorf("failed to build LLM request: %w", err)
+			return totalUsage, a.interrupted(ctx, sessionID, "", fmt.Errorf("failed to build LLM request: %w", err))
 		}
 
 		// 设置流式请求
 		llmRequest.Stream = true
 
 		// 调用LLM流式API
-		llmStream, err := a.llmManager.ChatStream(ctx, *llmRequest)
+		provider, _ := a.sessionModel(sessionID)
+		llmStream, err := a.llmManager.ChatStreamWithProvider(ctx, provider, *llmRequest)
 		if err != nil {
 			a.logger.Errorf("LLM stream call failed: %v", err)
-			return totalUsage, fmt.Errorf("LLM stream call failed: %w", err)
+			return totalUsage, a.interrupted(ctx, sessionID, "", fmt.Errorf("LLM stream call failed: %w", err))
 		}
 
-		var streamContent strings.Builder
+		var streamContent, streamReasoning strings.Builder
 		var toolCalls []types.ToolCall
+		var callUsage types.Usage
+		var callMetadata map[string]string
+		var finishReason types.FinishReason
+		var streamErr error
 
 		// 处理流式响应
 		for streamResp := range llmStream {
+			if streamResp.Reasoning != "" {
+				streamReasoning.WriteString(streamResp.Reasoning)
+
+				// 推理内容单独发送
+				responseChan <- types.ChatResponse{
+					SessionID: sessionID,
+					Reasoning: streamResp.Reasoning,
+					Finished:  false,
+				}
+			}
+
 			if streamResp.Content != "" {
 				streamContent.WriteString(streamResp.Content)
 
@@ -258,7 +382,6 @@ func (a *Agent) runAgentLoopStream(ctx context.Context, sessionID string, respon
 					SessionID: sessionID,
 					Response:  streamResp.Content,
 					Finished:  false,
-					Usage:     streamResp.Usage,
 				}
 			}
 
@@ -267,52 +390,153 @@ func (a *Agent) runAgentLoopStream(ctx context.Context, sessionID string, respon
 				toolCalls = append(toolCalls, streamResp.ToolCalls...)
 			}
 
-			// 累积使用量
-			totalUsage.PromptTokens += streamResp.Usage.PromptTokens
-			totalUsage.CompletionTokens += streamResp.Usage.CompletionTokens
-			totalUsage.TotalTokens += streamResp.Usage.TotalTokens
+			// 用量是整次调用的累计值，只取最后一次上报，不逐块相加
+			if !streamResp.Usage.IsZero() {
+				callUsage = streamResp.Usage
+			}
+			if streamResp.Metadata != nil {
+				callMetadata = streamResp.Metadata
+			}
+			if streamResp.FinishReason != "" {
+				finishReason = streamResp.FinishReason
+			}
+			if err := llm.StreamError(streamResp); err != nil {
+				streamErr = err
+			}
 		}
 
-		// 添加助手响应到上下文
-		assistantMessage := types.Message{
-			ID:        utils.GenerateID(),
-			Role:      types.RoleAssistant,
-			Content:   streamContent.String(),
-			ToolCalls: toolCalls,
-			Timestamp: time.Now(),
-		}
+		totalUsage.Add(callUsage)
+		a.recordUsage(ctx, sessionID, callUsage, callMetadata)
 
-		if err := a.contextManager.AddMessage(ctx, sessionID, assistantMessage); err != nil {
-			return totalUsage, fmt.Errorf("failed to add assistant message: %w", err)
+		// 流被取消时只保留已输出的内容，不执行可能不完整的工具调用
+		if ctx.Err() != nil {
+			return totalUsage, a.interrupted(ctx, sessionID, streamContent.String(), ctx.Err())
+		}
+		// 流中途出错时回复不完整，不保存也不执行其中的工具调用
+		if streamErr != nil {
+			return totalUsage, fmt.Errorf("LLM stream failed: %w", streamErr)
 		}
 
-		// 如果没有工具调用，结束循环
-		if len(toolCalls) == 0 {
+		next, toolUsage, err := a.handleResponse(ctx, sessionID, &types.LLMResponse{
+			Content:      streamContent.String(),
+			Reasoning:    streamReasoning.String(),
+			ToolCalls:    toolCalls,
+			Metadata:     callMetadata,
+			FinishReason: finishReason,
+		}, &continuations)
+		totalUsage.Add(toolUsage)
+		if err != nil || ctx.Err() != nil {
+			return totalUsage, a.interrupted(ctx, sessionID, "", err)
+		}
+		if !next {
 			a.logger.Debugf("No tool calls found, ending loop for session %s", sessionID)
 			break
 		}
-
-		// 执行工具调用
-		if err := a.executeToolCalls(ctx, sessionID, toolCalls); err != nil {
-			a.logger.Errorf("Tool execution failed: %v", err)
-		}
 	}
 
 	return totalUsage, nil
 }
 
-// buildLLMRequest 构建LLM请求
-func (a *Agent) buildLLMRequest(ctx context.Context, sessionID string) (*types.LLMRequest, error) {
+// handleResponse 保存助手响应并执行工具调用，返回是否需要进入下一轮和工具调用产生的LLM用量。
+// 输出因长度上限被截断时不执行参数不完整的工具调用，并追加续写提示让模型继续生成
+func (a *Agent) handleResponse(ctx context.Context, sessionID string, response *types.LLMResponse, continuations *int) (bool, types.Usage, error) {
+	toolCalls := response.ToolCalls
+	truncated := response.FinishReason == types.FinishReasonLength
+
+	var incomplete []types.ToolCall
+	if truncated {
+		toolCalls, incomplete = completeToolCalls(toolCalls)
+	}
+
+	// 添加助手响应到上下文，被丢弃的工具调用不保留，避免出现没有结果的工具调用
+	assistantMessage := types.Message{
+		ID:        utils.GenerateID(),
+		Role:      types.RoleAssistant,
+		Content:   response.Content,
+		Reasoning: response.Reasoning,
+		ToolCalls: toolCalls,
+		Metadata:  reasoningMetadata(response.Metadata),
+		Timestamp: time.Now(),
+	}
+
+	if err := a.contextManager.AddMessage(ctx, sessionID, assistantMessage); err != nil {
+		return false, types.Usage{}, fmt.Errorf("failed to add assistant message: %w", err)
+	}
+
+	// 执行工具调用
+	toolUsage, err := a.executeToolCalls(ctx, sessionID, toolCalls)
+	if err != nil {
+		a.logger.Errorf("Tool execution failed: %v", err)
+		// 继续循环，让LLM处理错误
+	}
+
+	if !truncated {
+		*continuations = 0
+		return len(toolCalls) > 0, toolUsage, nil
+	}
+
+	if *continuations >= a.maxContinuations() {
+		a.logger.Warnf("Response for session %s truncated at max tokens %d times in a row, stopping", sessionID, *continuations)
+		return false, toolUsage, nil
+	}
+	*continuations++
+
+	a.logger.Infof("Response for session %s truncated at max tokens, continuing (%d/%d, %d incomplete tool calls discarded)",
+		sessionID, *continuations, a.maxContinuations(), len(incomplete))
+	if err := a.contextManager.AddMessage(ctx, sessionID, continuationMessage(incomplete)); err != nil {
+		return false, toolUsage, fmt.Errorf("failed to add continuation message: %w", err)
+	}
+	return true, toolUsage, nil
+}
+
+// buildPromptPrefix 构建系统提示词和环境信息消息。每轮用户请求只构建一次，
+// 保证同一轮内多次调用LLM时请求前缀逐字节相同，提供商的提示词缓存才能命中。
+// 环境信息在会话内固定，文件变化不会使之后的整段历史失去缓存
+func (a *Agent) buildPromptPrefix(sessionID string) []types.Message {
+	provider, _ := a.sessionModel(sessionID)
+
 	// 获取系统提示词
 	systemPrompt, err := a.promptManager.GetPromptWithData("system", map[string]any{
-		"model_provider": a.llmManager.GetProvider(),
+		"model_provider": provider,
 	})
 	if err != nil {
 		a.logger.Warnf("Failed to get system prompt: %v", err)
 		systemPrompt = "You are a helpful AI assistant."
 	}
+	if a.sessionMode(sessionID) == types.ModePlan {
+		systemPrompt += "\n\n" + a.planModePrompt()
+	}
+	if a.parentSession != "" {
+		systemPrompt += "\n\n" + a.taskPrompt()
+	}
 
-	// 获取用户信息提示词
+	id := utils.GenerateID()
+	return []types.Message{
+		{
+			ID:      id,
+			Role:    types.RoleSystem,
+			Content: systemPrompt,
+		},
+		{
+			ID:       id,
+			Role:     types.RoleUser,
+			Content:  a.sessionEnvironment(sessionID),
+			Metadata: map[string]string{types.MetadataVolatile: "true"},
+		},
+	}
+}
+
+// sessionEnvironment 会话的环境信息提示词，在会话第一次请求时生成
+func (a *Agent) sessionEnvironment(sessionID string) string {
+	if prompt, ok := a.environments.Load(sessionID); ok {
+		return prompt.(string)
+	}
+	prompt, _ := a.environments.LoadOrStore(sessionID, a.buildEnvironmentPrompt())
+	return prompt.(string)
+}
+
+// buildEnvironmentPrompt 构建包含系统、工作目录和目录结构的环境信息提示词
+func (a *Agent) buildEnvironmentPrompt() string {
 	pwd, err := os.Getwd()
 	if err != nil {
 		a.logger.Warnf("Failed to get current working directory: %v", err)
@@ -327,70 +551,194 @@ func (a *Agent) buildLLMRequest(ctx context.Context, sessionID string) (*types.L
 		"os":             runtime.GOOS,
 		"pwd":            pwd,
 		"shell":          os.Getenv("SHELL"),
-		"date":           time.Now().Format("2006-01-02 15:04:05"),
+		"date":           time.Now().Format("2006-01-02"),
 		"file_structure": fileStructure,
 	})
 	if err != nil {
 		a.logger.Warnf("Failed to get user info prompt: %v", err)
 		userInfoPrompt = ""
 	}
+	return userInfoPrompt
+}
+
+// buildLLMRequest 构建LLM请求，prefix 为本轮固定的系统提示词和环境信息
+func (a *Agent) buildLLMRequest(ctx context.Context, sessionID string, prefix []types.Message) (*types.LLMRequest, error) {
+	_, model := a.sessionModel(sessionID)
 
 	// 获取历史消息
 	messages, err := a.contextManager.GetMessages(ctx, sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
-	id := utils.GenerateID()
+
 	// 构建消息列表
-	llmMessages := []types.Message{
-		{
-			ID:      id,
-			Role:    types.RoleSystem,
-			Content: systemPrompt,
-		},
-		{
-			ID:      id,
-			Role:    types.RoleUser,
-			Content: userInfoPrompt,
-		},
-	}
+	llmMessages := make([]types.Message, 0, len(prefix)+len(messages))
+	llmMessages = append(llmMessages, prefix...)
 
 	// 添加历史消息
 	llmMessages = append(llmMessages, messages...)
 
-	// 获取工具定义
-	tools := a.toolEngine.GetToolDefinitions()
+	// 获取当前模式下可用的工具定义
+	tools := a.toolDefinitions(sessionID)
 
 	return &types.LLMRequest{
 		Messages: llmMessages,
 		Tools:    tools,
 		Stream:   false,
+		Model:    model,
 	}, nil
 }
 
-// executeToolCalls 执行工具调用
-func (a *Agent) executeToolCalls(ctx context.Context, sessionID string, toolCalls []types.ToolCall) error {
-	if len(toolCalls) == 0 {
+// reasoningMetadata 提取需随消息保存的推理状态
+func reasoningMetadata(metadata map[string]string) map[string]string {
+	state, ok := metadata[types.MetadataReasoningState]
+	if !ok {
+		return nil
+	}
+	return map[string]string{types.MetadataReasoningState: state}
+}
+
+// recordUsage 将一次LLM调用的用量写入账本，子任务的用量记在父会话，失败不影响对话
+func (a *Agent) recordUsage(ctx context.Context, sessionID string, usage types.Usage, metadata map[string]string) {
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return
+	}
+
+	record := types.UsageRecord{
+		SessionID:        a.ownerSession(sessionID),
+		Provider:         types.LLMProvider(metadata[llm.MetadataProvider]),
+		Model:            metadata[llm.MetadataModel],
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CachedTokens:     usage.CachedTokens,
+		Timestamp:        time.Now(),
+	}
+	if err := a.contextManager.RecordUsage(ctx, record); err != nil {
+		a.logger.Warnf("Failed to record usage for session %s: %v", sessionID, err)
+	}
+}
+
+// selectModel 校验请求中的提供商和模型选择并写入会话元数据，后续轮次沿用该选择。
+// 切换提供商时同时重置模型。
+func (a *Agent) selectModel(ctx context.Context, sessionID string, request types.ChatRequest) error {
+	if request.Provider == "" && request.Model == "" {
+		return nil
+	}
+
+	metadata := map[string]string{metadataModel: request.Model}
+	if request.Provider != "" {
+		if _, err := a.llmManager.GetClient(request.Provider); err != nil {
+			return fmt.Errorf("invalid provider: %w", err)
+		}
+		metadata[metadataProvider] = string(request.Provider)
+	}
+
+	if err := a.contextManager.SetSessionMetadata(ctx, sessionID, metadata); err != nil {
+		return fmt.Errorf("failed to save model selection: %w", err)
+	}
+	return nil
+}
+
+// sessionModel 获取会话选择的提供商和模型，未选择时使用默认提供商及其配置的模型
+func (a *Agent) sessionModel(sessionID string) (types.LLMProvider, string) {
+	provider := a.llmManager.GetProvider()
+
+	session, err := a.contextManager.GetSessionContext(sessionID)
+	if err != nil {
+		return provider, ""
+	}
+
+	selected := types.LLMProvider(session.Metadata[metadataProvider])
+	if selected == "" {
+		return provider, session.Metadata[metadataModel]
+	}
+
+	// 配置变更后会话中记录的提供商可能已不存在
+	if _, err := a.llmManager.GetClient(selected); err != nil {
+		a.logger.Warnf("Session %s provider %s unavailable, using default provider %s", sessionID, selected, provider)
+		return provider, ""
+	}
+	return selected, session.Metadata[metadataModel]
+}
+
+// sessionTokenizer 按会话元数据中选择的提供商和模型选择token计数器，未选择时返回 nil 使用默认计数器
+func (a *Agent) sessionTokenizer(metadata map[string]string) tokenizer.Tokenizer {
+	provider := types.LLMProvider(metadata[metadataProvider])
+	model := metadata[metadataModel]
+	if provider == "" && model == "" {
 		return nil
 	}
 
+	client, err := a.llmManager.GetClient(provider)
+	if err != nil {
+		return nil
+	}
+	config := client.GetConfig()
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = config.Provider
+	}
+	if model == "" {
+		model = config.Model
+	}
+	return tokenizer.ForModel(string(protocol), model)
+}
+
+// executeToolCalls 执行工具调用，返回工具内部调用LLM（如子任务）的用量
+func (a *Agent) executeToolCalls(ctx context.Context, sessionID string, toolCalls []types.ToolCall) (types.Usage, error) {
+	var usage types.Usage
+	if len(toolCalls) == 0 {
+		return usage, nil
+	}
+
 	a.logger.Debugf("Executing %d tool calls for session %s", len(toolCalls), sessionID)
+	ctx = tools.WithSessionID(ctx, sessionID)
+
+	// 计划模式下只允许只读工具，exit_plan_mode 由Agent处理
+	ctx, handled := a.planModeCalls(ctx, sessionID, toolCalls)
+
+	// 按权限策略过滤，只执行放行的调用，被拒绝的调用以错误结果回传给模型
+	a.authorizeToolCalls(ctx, sessionID, toolCalls, handled)
+	allowed := make([]types.ToolCall, 0, len(toolCalls))
+	indices := make([]int, 0, len(toolCalls))
+	for i, call := range toolCalls {
+		if _, ok := handled[i]; !ok {
+			allowed = append(allowed, call)
+			indices = append(indices, i)
+		}
+	}
 
 	// 执行工具
-	results := a.toolEngine.ExecuteTools(ctx, toolCalls)
+	results := make([]types.ToolCallResult, len(toolCalls))
+	for i, result := range handled {
+		results[i] = result
+	}
+	for j, result := range a.toolEngine.ExecuteTools(ctx, allowed) {
+		results[indices[j]] = result
+	}
 
 	// 为每个工具调用添加结果消息
 	for i, result := range results {
 		if i < len(toolCalls) {
+			if result.Usage != nil {
+				usage.Add(*result.Usage)
+			}
+
+			metadata := map[string]string{
+				"tool_call_id": toolCalls[i].ID,
+				"tool_name":    toolCalls[i].Function.Name,
+				"success":      fmt.Sprintf("%t", result.Success),
+			}
+			for key, value := range result.Metadata {
+				metadata[key] = value
+			}
+
 			toolMessage := types.Message{
-				ID:      utils.GenerateID(),
-				Role:    types.RoleTool,
-				Content: a.formatToolResult(toolCalls[i], result),
-				Metadata: map[string]string{
-					"tool_call_id": toolCalls[i].ID,
-					"tool_name":    toolCalls[i].Function.Name,
-					"success":      fmt.Sprintf("%t", result.Success),
-				},
+				ID:        utils.GenerateID(),
+				Role:      types.RoleTool,
+				Content:   a.formatToolResult(toolCalls[i], result),
+				Parts:     result.Parts,
+				Metadata:  metadata,
 				Timestamp: time.Now(),
 			}
 
@@ -400,7 +748,7 @@ func (a *Agent) executeToolCalls(ctx context.Context, sessionID string, toolCall
 		}
 	}
 
-	return nil
+	return usage, nil
 }
 
 // formatToolResult 格式化工具执行结果
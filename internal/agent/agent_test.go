@@ -0,0 +1,318 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	contextmgr "github.com/zboya/nala-coder/internal/context"
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/internal/tools"
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// testTool 测试用工具，原样返回参数或返回错误
+type testTool struct {
+	name     string
+	fail     bool
+	readOnly bool
+}
+
+func (t *testTool) Name() string { return t.name }
+
+func (t *testTool) IsConcurrencySafe() bool { return true }
+
+func (t *testTool) IsReadOnly() bool { return t.readOnly }
+
+func (t *testTool) GetDefinition() types.Tool {
+	return types.Tool{
+		Type: "function",
+		Function: types.ToolFunction{
+			Name:       t.name,
+			Parameters: types.ToolCallFunctionArguments{"type": "object"},
+		},
+	}
+}
+
+func (t *testTool) Execute(ctx context.Context, call types.ToolCall) *types.ToolCallResult {
+	if t.fail {
+		return &types.ToolCallResult{Success: false, Error: "permission denied", Timestamp: time.Now()}
+	}
+	return &types.ToolCallResult{Success: true, Content: call.Function.Arguments, Timestamp: time.Now()}
+}
+
+// newTestAgent 创建使用模拟提供商、测试工具和JSON存储的Agent
+func newTestAgent(t *testing.T, maxLoops int, script llm.MockScript) (*Agent, *llm.MockClient) {
+	t.Helper()
+
+	logger, _ := log.New(log.DefaultConfig())
+
+	client := llm.NewMockClientFromScript(types.LLMConfig{Provider: types.ProviderMock}, script, logger)
+	manager := llm.NewManager(types.ProviderMock, logger)
+	manager.RegisterClient(types.ProviderMock, client)
+
+	engine := tools.NewEngine(&tools.Config{EnabledTools: []string{"echo", "deny"}}, logger)
+	engine.RegisterTool("echo", &testTool{name: "echo", readOnly: true})
+	engine.RegisterTool("deny", &testTool{name: "deny", fail: true})
+
+	promptDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(promptDir, "user_info.md"), []byte("<user_info>\n{{.file_structure}}\n</user_info>"), 0644); err != nil {
+		t.Fatalf("failed to write prompt: %v", err)
+	}
+	promptManager, err := contextmgr.NewPromptManager(promptDir, false, logger)
+	if err != nil {
+		t.Fatalf("NewPromptManager error: %v", err)
+	}
+	contextManager, err := contextmgr.NewContextManager(&contextmgr.Config{
+		HistoryLimit: 100,
+		StoragePath:  t.TempDir(),
+		StorageType:  contextmgr.StorageTypeJSON,
+	}, promptManager, client, logger)
+	if err != nil {
+		t.Fatalf("NewContextManager error: %v", err)
+	}
+	t.Cleanup(func() { contextManager.Close() })
+
+	agent := NewAgent(&Config{MaxLoops: maxLoops}, manager, engine, contextManager, promptManager, logger)
+	return agent, client
+}
+
+func TestAgentChatWithToolCalls(t *testing.T) {
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{
+			Content: "我先执行这些工具。",
+			ToolCalls: []llm.MockToolCall{
+				{ID: "call_echo", Name: "echo", Arguments: []byte(`{"text":"hi"}`)},
+				{ID: "call_deny", Name: "deny", Arguments: []byte(`{}`)},
+				{ID: "call_missing", Name: "missing", Arguments: []byte(`{}`)},
+			},
+			Usage: types.Usage{PromptTokens: 100, CompletionTokens: 10, TotalTokens: 110},
+		},
+		{Content: "完成。", Usage: types.Usage{PromptTokens: 200, CompletionTokens: 5, TotalTokens: 205}},
+	}})
+
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始"})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Response != "完成。" || response.Usage.PromptTokens != 300 || response.Usage.CompletionTokens != 15 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+
+	requests := client.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 LLM calls, got %d", len(requests))
+	}
+
+	// 第二次调用包含助手的工具调用和每个调用的结果，失败的工具结果也回传给模型
+	var results []types.Message
+	for _, msg := range requests[1].Messages {
+		if msg.Role == types.RoleTool {
+			results = append(results, msg)
+		}
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 tool results, got %+v", results)
+	}
+	if results[0].Metadata["tool_call_id"] != "call_echo" || results[0].Metadata["success"] != "true" || !strings.Contains(results[0].Content, `{"text":"hi"}`) {
+		t.Errorf("unexpected echo result: %+v", results[0])
+	}
+	if results[1].Metadata["success"] != "false" || !strings.Contains(results[1].Content, "permission denied") {
+		t.Errorf("unexpected deny result: %+v", results[1])
+	}
+	if !strings.Contains(results[2].Content, "tool missing not found") {
+		t.Errorf("unexpected missing tool result: %+v", results[2])
+	}
+
+	state, err := agent.GetState("s1")
+	if err != nil {
+		t.Fatalf("GetState error: %v", err)
+	}
+	// 用户、助手(工具调用)、3个工具结果、助手
+	if len(state.Messages) != 6 || state.Messages[5].Content != "完成。" {
+		t.Errorf("unexpected session messages: %+v", state.Messages)
+	}
+}
+
+func TestAgentRequestPrefixStable(t *testing.T) {
+	// 环境信息包含工作目录的文件结构
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{{Name: "echo", Arguments: []byte(`{"n":1}`)}}},
+		{ToolCalls: []llm.MockToolCall{{Name: "echo", Arguments: []byte(`{"n":2}`)}}},
+		{Content: "完成。"},
+		{Content: "好的。"},
+	}})
+
+	if _, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始"}); err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	// 文件变化不改变会话的环境信息，下一轮请求仍以之前的请求为前缀
+	if err := os.WriteFile(filepath.Join(dir, "new.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "继续"}); err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+
+	// 每次请求都以上一次请求为前缀，提示词缓存才能命中
+	requests := client.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 LLM calls, got %d", len(requests))
+	}
+	if !strings.Contains(requests[0].Messages[1].Content, "<user_info>") || strings.Contains(requests[3].Messages[1].Content, "new.go") {
+		t.Errorf("unexpected environment message: %q", requests[3].Messages[1].Content)
+	}
+	// 消息ID不发送给提供商，比较时忽略
+	sent := func(messages []types.Message) []byte {
+		messages = slices.Clone(messages)
+		for i := range messages {
+			messages[i].ID = ""
+		}
+		data, _ := json.Marshal(messages)
+		return data
+	}
+	for i := 1; i < len(requests); i++ {
+		previous := sent(requests[i-1].Messages)
+		current := sent(requests[i].Messages[:len(requests[i-1].Messages)])
+		if !bytes.Equal(previous, current) {
+			t.Errorf("request %d does not extend request %d:\n%s\n%s", i, i-1, previous, current)
+		}
+	}
+}
+
+func TestAgentMaxLoops(t *testing.T) {
+	agent, client := newTestAgent(t, 3, llm.MockScript{
+		Turns:  []llm.MockTurn{{Content: "再来一次", ToolCalls: []llm.MockToolCall{{Name: "echo"}}}},
+		Repeat: true,
+	})
+
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "一直循环"})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Response != "再来一次" {
+		t.Errorf("unexpected response: %q", response.Response)
+	}
+	if calls := len(client.Requests()); calls != 3 {
+		t.Errorf("expected loop to stop after 3 calls, got %d", calls)
+	}
+}
+
+func TestAgentContinuesTruncatedResponse(t *testing.T) {
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{Content: "第一部分，", FinishReason: types.FinishReasonLength},
+		{Content: "第二部分。"},
+	}})
+
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "写长文"})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Response != "第一部分，第二部分。" {
+		t.Errorf("unexpected response: %q", response.Response)
+	}
+
+	requests := client.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 LLM calls, got %d", len(requests))
+	}
+	messages := requests[1].Messages
+	if last := messages[len(messages)-1]; last.Metadata[metadataContinuation] != "true" {
+		t.Errorf("expected continuation prompt, got %+v", last)
+	}
+}
+
+func TestAgentChatErrors(t *testing.T) {
+	t.Run("provider error", func(t *testing.T) {
+		agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{{Error: "invalid api key", StatusCode: 401}}})
+
+		_, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "你好"})
+		if llm.StatusCodeOf(err) != 401 {
+			t.Errorf("expected 401 error, got %v", err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{{Content: "太慢了", Delay: 5000}}})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		_, err := agent.Chat(ctx, types.ChatRequest{SessionID: "s1", Message: "你好"})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation error, got %v", err)
+		}
+	})
+}
+
+func TestAgentChatStream(t *testing.T) {
+	agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{Reasoning: "需要查看", ToolCalls: []llm.MockToolCall{{Name: "echo", Arguments: []byte(`{}`)}}, ChunkSize: 2},
+		{Content: "目录里有三个文件。", ChunkSize: 3, Usage: types.Usage{PromptTokens: 50, CompletionTokens: 8, TotalTokens: 58}},
+	}})
+
+	stream, err := agent.ChatStream(context.Background(), types.ChatRequest{SessionID: "s1", Message: "看看目录"})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content, reasoning strings.Builder
+	var final types.ChatResponse
+	var deltas int
+	for resp := range stream {
+		if resp.Finished {
+			final = resp
+			continue
+		}
+		content.WriteString(resp.Response)
+		reasoning.WriteString(resp.Reasoning)
+		deltas++
+	}
+
+	if content.String() != "目录里有三个文件。" || reasoning.String() != "需要查看" {
+		t.Errorf("unexpected content %q, reasoning %q", content.String(), reasoning.String())
+	}
+	if deltas != 5 {
+		t.Errorf("expected 5 streamed deltas, got %d", deltas)
+	}
+	if final.Metadata["loop_completed"] != true || final.Usage.PromptTokens != 50 {
+		t.Errorf("unexpected final response: %+v", final)
+	}
+}
+
+func TestAgentChatStreamError(t *testing.T) {
+	agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{Content: "正在查看", ToolCalls: []llm.MockToolCall{{ID: "call_echo", Name: "echo", Arguments: []byte(`{}`)}}, StreamError: "connection reset"},
+	}})
+
+	stream, err := agent.ChatStream(context.Background(), types.ChatRequest{SessionID: "s1", Message: "看看目录"})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+	var final types.ChatResponse
+	for resp := range stream {
+		if resp.Finished {
+			final = resp
+		}
+	}
+
+	// 流中途出错时本轮以错误结束，不执行不完整回复中的工具调用
+	if !strings.Contains(fmt.Sprint(final.Metadata["error"]), "connection reset") {
+		t.Errorf("expected stream error in final response, got %+v", final)
+	}
+	if _, ok := toolResults(t, agent, "s1")["call_echo"]; ok {
+		t.Errorf("expected tool call from the failed stream not to run")
+	}
+}
@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/internal/tools"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// metadataApprovedTools 会话元数据中记住的放行规则，JSON数组
+const metadataApprovedTools = "approved_tools"
+
+const (
+	rejectedByUser     = "The user rejected this tool call. Do not retry it; ask the user how to proceed instead."
+	approvalNotAllowed = "This tool call requires user approval, which is not available for this request. " +
+		"Tell the user to allow it in the tool permissions or to use the streaming API or the CLI."
+)
+
+// approverKey 上下文中保存确认请求发送函数的键
+type approverKey struct{}
+
+// withApprover 在上下文中设置发送确认请求的函数，只有能把请求交给用户的调用方（流式对话、CLI）才设置
+func withApprover(ctx context.Context, send func(approval types.ToolApproval)) context.Context {
+	return context.WithValue(ctx, approverKey{}, send)
+}
+
+// approverFrom 获取上下文中的确认请求发送函数
+func approverFrom(ctx context.Context) (func(approval types.ToolApproval), bool) {
+	send, ok := ctx.Value(approverKey{}).(func(approval types.ToolApproval))
+	return send, ok
+}
+
+// pendingApproval 等待用户确认的工具调用
+type pendingApproval struct {
+	sessionID string
+	decision  chan types.ApprovalDecision
+}
+
+// approvalRegistry 记录等待确认的工具调用
+type approvalRegistry struct {
+	mu      sync.Mutex
+	pending map[string]pendingApproval
+}
+
+// add 登记等待确认的调用，返回接收确认结果的通道和移除登记的函数
+func (r *approvalRegistry) add(id, sessionID string) (<-chan types.ApprovalDecision, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending == nil {
+		r.pending = make(map[string]pendingApproval)
+	}
+	decision := make(chan types.ApprovalDecision, 1)
+	r.pending[id] = pendingApproval{sessionID: sessionID, decision: decision}
+
+	return decision, func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}
+}
+
+// resolve 提交确认结果，每个调用只接受一次
+func (r *approvalRegistry) resolve(sessionID, id string, decision types.ApprovalDecision) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending, exists := r.pending[id]
+	if !exists || pending.sessionID != sessionID {
+		return false
+	}
+	delete(r.pending, id)
+	pending.decision <- decision
+	return true
+}
+
+// SetPermissionPolicy 设置工具权限策略，未设置时所有工具调用直接执行
+func (a *Agent) SetPermissionPolicy(policy *tools.PermissionPolicy) {
+	a.permissions = policy
+}
+
+// ResolveApproval 提交用户对等待确认的工具调用的答复，本轮随后继续执行或跳过该调用
+func (a *Agent) ResolveApproval(sessionID, approvalID string, decision types.ApprovalDecision) error {
+	switch decision.Remember {
+	case types.ApprovalOnce, types.ApprovalSession, types.ApprovalProject:
+	default:
+		return fmt.Errorf("invalid approval scope %q", decision.Remember)
+	}
+
+	if !a.approvals.resolve(sessionID, approvalID, decision) {
+		return fmt.Errorf("approval %s for session %s: %w", approvalID, sessionID, types.ErrApprovalNotFound)
+	}
+	return nil
+}
+
+// authorizeToolCalls 按权限策略检查 handled 之外的工具调用，需要确认的调用逐个请求用户确认。
+// 不执行的调用及回传给模型的结果按下标写入 handled。只读模式下会产生修改的工具由引擎拒绝，不再询问
+func (a *Agent) authorizeToolCalls(ctx context.Context, sessionID string, toolCalls []types.ToolCall, handled map[int]types.ToolCallResult) {
+	if a.permissions == nil {
+		return
+	}
+
+	remembered := a.rememberedRules(sessionID)
+	for i, call := range toolCalls {
+		if _, ok := handled[i]; ok {
+			continue
+		}
+		if tools.ReadOnly(ctx) && !a.isReadOnlyTool(call.Function.Name) {
+			continue
+		}
+		decision := a.permissions.Check(call, remembered)
+
+		switch decision.Mode {
+		case tools.PermissionAllow:
+			continue
+		case tools.PermissionDeny:
+			reason := "permission denied by the default permission mode"
+			if decision.Rule != "" {
+				reason = fmt.Sprintf("permission denied by rule %s", decision.Rule)
+			}
+			handled[i] = types.ToolCallResult{Success: false, Error: reason, Timestamp: time.Now()}
+			continue
+		}
+
+		answer, err := a.requestApproval(ctx, sessionID, call, decision)
+		if err != nil {
+			handled[i] = types.ToolCallResult{Success: false, Error: err.Error(), Timestamp: time.Now()}
+			continue
+		}
+		if !answer.Approved {
+			handled[i] = types.ToolCallResult{Success: false, Error: rejectedByUser, Timestamp: time.Now()}
+			continue
+		}
+
+		// 配置的 ask 规则要求每次确认，只有按默认模式询问的调用可以记住
+		if decision.Rule != "" {
+			continue
+		}
+		rule := tools.SuggestPermissionRule(call)
+		switch answer.Remember {
+		case types.ApprovalSession:
+			remembered = append(remembered, rule)
+			a.rememberForSession(ctx, sessionID, remembered)
+		case types.ApprovalProject:
+			if err := a.permissions.RememberForProject(rule); err != nil {
+				a.logger.Errorf("Failed to remember permission rule %s: %v", rule, err)
+			}
+		}
+	}
+}
+
+// requestApproval 请求用户确认权限策略要求确认的工具调用。
+// 配置的 ask 规则匹配的调用没有可记住的规则，Suggested 为空
+func (a *Agent) requestApproval(ctx context.Context, sessionID string, call types.ToolCall, decision tools.PermissionDecision) (types.ApprovalDecision, error) {
+	approval := types.ToolApproval{
+		ID:         utils.GenerateID(),
+		ToolCallID: call.ID,
+		Tool:       call.Function.Name,
+		Arguments:  call.Function.Arguments,
+		Subject:    tools.PermissionSubject(call),
+		Rule:       decision.Rule,
+	}
+	if decision.Rule == "" {
+		approval.Suggested = tools.SuggestPermissionRule(call).String()
+	}
+	return a.awaitApproval(ctx, sessionID, approval)
+}
+
+// awaitApproval 向调用方发送确认请求并等待答复，本轮被取消时停止等待
+func (a *Agent) awaitApproval(ctx context.Context, sessionID string, approval types.ToolApproval) (types.ApprovalDecision, error) {
+	send, ok := approverFrom(ctx)
+	if !ok {
+		return types.ApprovalDecision{}, fmt.Errorf("%s", approvalNotAllowed)
+	}
+
+	// 子任务的确认登记在父会话，调用方按父会话答复
+	answer, remove := a.approvals.add(approval.ID, a.ownerSession(sessionID))
+	defer remove()
+
+	a.logger.Infof("Waiting for approval of %s in session %s", approval.Tool, sessionID)
+	send(approval)
+
+	select {
+	case decision := <-answer:
+		return decision, nil
+	case <-ctx.Done():
+		return types.ApprovalDecision{}, fmt.Errorf("approval interrupted: %w", context.Cause(ctx))
+	}
+}
+
+// rememberedRules 会话中记住的放行规则，子任务使用父会话的规则
+func (a *Agent) rememberedRules(sessionID string) []tools.PermissionRule {
+	sessionID = a.ownerSession(sessionID)
+	sessionContext, err := a.contextManager.GetSessionContext(sessionID)
+	if err != nil || sessionContext.Metadata[metadataApprovedTools] == "" {
+		return nil
+	}
+
+	var saved []string
+	if err := json.Unmarshal([]byte(sessionContext.Metadata[metadataApprovedTools]), &saved); err != nil {
+		a.logger.Warnf("Invalid approved tools for session %s: %v", sessionID, err)
+		return nil
+	}
+
+	var rules []tools.PermissionRule
+	for _, text := range saved {
+		if rule, err := tools.ParsePermissionRule(text); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// rememberForSession 保存会话中记住的放行规则，子任务记在父会话
+func (a *Agent) rememberForSession(ctx context.Context, sessionID string, rules []tools.PermissionRule) {
+	sessionID = a.ownerSession(sessionID)
+	saved := make([]string, len(rules))
+	for i, rule := range rules {
+		saved[i] = rule.String()
+	}
+	data, _ := json.Marshal(saved)
+
+	if err := a.contextManager.SetSessionMetadata(ctx, sessionID, map[string]string{metadataApprovedTools: string(data)}); err != nil {
+		a.logger.Errorf("Failed to remember approved tools for session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/internal/tools"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// toolResults 会话中的工具结果消息，按工具调用ID索引
+func toolResults(t *testing.T, agent *Agent, sessionID string) map[string]types.Message {
+	t.Helper()
+
+	state, err := agent.GetState(sessionID)
+	if err != nil {
+		t.Fatalf("GetState error: %v", err)
+	}
+	results := make(map[string]types.Message)
+	for _, msg := range state.Messages {
+		if msg.Role == types.RoleTool {
+			results[msg.Metadata["tool_call_id"]] = msg
+		}
+	}
+	return results
+}
+
+func TestToolApproval(t *testing.T) {
+	agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{
+			{ID: "call_echo", Name: "echo", Arguments: []byte(`{"text":"hi"}`)},
+			{ID: "call_deny", Name: "deny", Arguments: []byte(`{}`)},
+		}},
+		{Content: "完成。"},
+		{ToolCalls: []llm.MockToolCall{{ID: "call_echo2", Name: "echo", Arguments: []byte(`{"text":"again"}`)}}},
+		{Content: "又完成了。"},
+		{ToolCalls: []llm.MockToolCall{{ID: "call_echo3", Name: "echo", Arguments: []byte(`{}`)}}},
+		{Content: "没有执行。"},
+	}})
+	policy, err := tools.NewPermissionPolicy(tools.PermissionConfig{
+		Default:     tools.PermissionAsk,
+		Deny:        []string{"deny"},
+		ProjectFile: filepath.Join(t.TempDir(), "permissions.yaml"),
+	})
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+	agent.SetPermissionPolicy(policy)
+
+	// 流式对话中确认请求通过响应通道发送，答复后本轮继续
+	chat := func(message string) []*types.ToolApproval {
+		stream, err := agent.ChatStream(context.Background(), types.ChatRequest{SessionID: "s1", Message: message})
+		if err != nil {
+			t.Fatalf("ChatStream error: %v", err)
+		}
+		var approvals []*types.ToolApproval
+		for resp := range stream {
+			if resp.Approval == nil {
+				continue
+			}
+			approvals = append(approvals, resp.Approval)
+			if err := agent.ResolveApproval("other", resp.Approval.ID, types.ApprovalDecision{Approved: true}); !errors.Is(err, types.ErrApprovalNotFound) {
+				t.Errorf("expected approval to belong to its session, got %v", err)
+			}
+			if err := agent.ResolveApproval("s1", resp.Approval.ID, types.ApprovalDecision{Approved: true, Remember: types.ApprovalSession}); err != nil {
+				t.Errorf("ResolveApproval error: %v", err)
+			}
+		}
+		return approvals
+	}
+
+	approvals := chat("开始")
+	if len(approvals) != 1 || approvals[0].ToolCallID != "call_echo" || approvals[0].Rule != "" || approvals[0].Suggested != "echo" {
+		t.Fatalf("unexpected approvals: %+v", approvals)
+	}
+	results := toolResults(t, agent, "s1")
+	if results["call_echo"].Metadata["success"] != "true" {
+		t.Errorf("expected approved call to run: %+v", results["call_echo"])
+	}
+	if !strings.Contains(results["call_deny"].Content, "permission denied by rule deny") {
+		t.Errorf("expected denied call to be rejected: %+v", results["call_deny"])
+	}
+
+	// 记住到会话后同一会话不再询问
+	if approvals := chat("再来"); len(approvals) != 0 {
+		t.Errorf("expected remembered rule to skip approval, got %+v", approvals)
+	}
+	if results := toolResults(t, agent, "s1"); results["call_echo2"].Metadata["success"] != "true" {
+		t.Errorf("expected remembered call to run: %+v", results["call_echo2"])
+	}
+
+	// 非流式对话无法确认，按拒绝处理
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s2", Message: "开始"})
+	if err != nil || response.Response != "没有执行。" {
+		t.Fatalf("unexpected chat response: %+v, %v", response, err)
+	}
+	if result := toolResults(t, agent, "s2")["call_echo3"]; result.Metadata["success"] != "false" || !strings.Contains(result.Content, "requires user approval") {
+		t.Errorf("expected call without approver to be rejected: %+v", result)
+	}
+}
+
+func TestToolApprovalRejected(t *testing.T) {
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{{ID: "call_echo", Name: "echo", Arguments: []byte(`{}`)}}},
+		{Content: "好的，不执行。"},
+	}})
+	policy, err := tools.NewPermissionPolicy(tools.PermissionConfig{
+		Default:     tools.PermissionAsk,
+		ProjectFile: filepath.Join(t.TempDir(), "permissions.yaml"),
+	})
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+	agent.SetPermissionPolicy(policy)
+
+	stream, err := agent.ChatStream(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始"})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+	for resp := range stream {
+		if resp.Approval != nil {
+			agent.ResolveApproval("s1", resp.Approval.ID, types.ApprovalDecision{Approved: false})
+		}
+	}
+
+	requests := client.Requests()
+	last := requests[len(requests)-1].Messages
+	if result := last[len(last)-1]; result.Role != types.RoleTool || !strings.Contains(result.Content, "The user rejected this tool call") {
+		t.Errorf("expected rejection to be reported to the model, got %+v", result)
+	}
+}
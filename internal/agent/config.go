@@ -2,6 +2,7 @@ package agent
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/zboya/nala-coder/internal/context"
 	"github.com/zboya/nala-coder/internal/llm"
@@ -48,6 +49,7 @@ type Builder struct {
 	logger         log.Logger
 	llmManager     *llm.Manager
 	toolEngine     *tools.Engine
+	permissions    *tools.PermissionPolicy
 	contextManager *context.ContextManager
 	promptManager  *context.PromptManager
 }
@@ -74,6 +76,32 @@ func (b *Builder) BuildLLMManager() error {
 		return err
 	}
 
+	// 设置故障转移链
+	if err := manager.SetFallbackProviders(b.config.LLM.FallbackProviders); err != nil {
+		return err
+	}
+
+	// 设置向量化提供商
+	if provider := b.config.LLM.EmbeddingProvider; provider != "" {
+		if err := manager.SetEmbeddingProvider(provider); err != nil {
+			return err
+		}
+	}
+
+	// 开启调用追踪，记录中出现的已配置密钥会被脱敏
+	if b.config.LLM.Trace.Enabled {
+		var secrets []string
+		for _, config := range providerConfigs {
+			secrets = append(secrets, config.APIKey)
+		}
+		tracer, err := llm.NewTracer(b.config.LLM.Trace.Path, secrets)
+		if err != nil {
+			return err
+		}
+		manager.SetTracer(tracer)
+		b.logger.Infof("LLM call tracing enabled, writing to %s", tracer.Path())
+	}
+
 	b.llmManager = manager
 	return nil
 }
@@ -99,7 +127,17 @@ func (b *Builder) BuildPromptManager() error {
 // BuildToolEngine 构建工具引擎
 func (b *Builder) BuildToolEngine() error {
 	engine := tools.NewEngine(&b.config.Tools, b.logger)
+
+	// 工具权限策略，项目级规则文件路径处理 ~ 符号
+	permissionConfig := b.config.Tools.Permissions
+	permissionConfig.ProjectFile = utils.ExpandPath(permissionConfig.ProjectFile)
+	permissions, err := tools.NewPermissionPolicy(permissionConfig)
+	if err != nil {
+		return fmt.Errorf("invalid tool permissions: %w", err)
+	}
+
 	b.toolEngine = engine
+	b.permissions = permissions
 	return nil
 }
 
@@ -110,11 +148,6 @@ func (b *Builder) BuildContextManager() error {
 		return fmt.Errorf("LLM manager must be built before context manager")
 	}
 
-	compressionLLM, err := b.llmManager.GetDefaultClient()
-	if err != nil {
-		return fmt.Errorf("failed to get compression LLM client: %w", err)
-	}
-
 	// 扩展存储路径，处理 ~ 符号
 	contextConfig := b.config.Context
 	contextConfig.StoragePath = utils.ExpandPath(contextConfig.StoragePath)
@@ -122,12 +155,13 @@ func (b *Builder) BuildContextManager() error {
 	manager, err := context.NewContextManager(
 		&contextConfig,
 		b.promptManager,
-		compressionLLM,
+		b.llmManager,
 		b.logger,
 	)
 	if err != nil {
 		return err
 	}
+	manager.SetPriceTable(b.config.LLM.Prices)
 
 	b.contextManager = manager
 	return nil
@@ -152,21 +186,25 @@ func (b *Builder) Build() (*Agent, error) {
 		return nil, fmt.Errorf("failed to build context manager: %w", err)
 	}
 
-	// 获取默认LLM客户端
-	defaultLLM, err := b.llmManager.GetDefaultClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get default LLM client: %w", err)
-	}
-
-	// 创建Agent
+	// 创建Agent，LLM调用经由管理器的故障转移链
 	agent := NewAgent(
 		&b.config.Agent,
-		defaultLLM,
+		b.llmManager,
 		b.toolEngine,
 		b.contextManager,
 		b.promptManager,
 		b.logger,
 	)
+	agent.SetPermissionPolicy(b.permissions)
+	// 会话的token数按会话选择的提供商和模型计算
+	b.contextManager.SetTokenizerSelector(agent.sessionTokenizer)
+
+	// 启用 task 工具时由Agent注册，子任务的工具集取自同一引擎
+	if slices.Contains(b.config.Tools.EnabledTools, TaskToolName) {
+		if err := agent.EnableTasks(b.toolEngine, b.config.Agent.Task); err != nil {
+			return nil, fmt.Errorf("failed to enable tasks: %w", err)
+		}
+	}
 
 	return agent, nil
 }
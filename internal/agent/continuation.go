@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// defaultMaxContinuations 输出被截断后默认最多连续续写的次数
+const defaultMaxContinuations = 3
+
+// metadataContinuation 标记自动续写提示消息
+const metadataContinuation = "continuation"
+
+const (
+	continuePrompt = "Your previous response was cut off because it reached the maximum output length. " +
+		"Continue exactly where you stopped, without repeating what you have already written."
+
+	truncatedToolCallsPrompt = "Your previous response reached the maximum output length while writing tool call arguments, " +
+		"so the incomplete tool calls (%s) were discarded and not executed. " +
+		"Call them again with smaller arguments, for example by writing a large file in several smaller edits."
+)
+
+// completeToolCalls 拆分出参数完整的工具调用。输出被截断时最后一个工具调用的参数可能只写了一半，
+// 参数不是合法JSON的调用不能执行
+func completeToolCalls(toolCalls []types.ToolCall) (complete, incomplete []types.ToolCall) {
+	for _, tc := range toolCalls {
+		if json.Valid([]byte(tc.Function.Arguments)) {
+			complete = append(complete, tc)
+		} else {
+			incomplete = append(incomplete, tc)
+		}
+	}
+	return complete, incomplete
+}
+
+// continuationMessage 截断后要求模型继续生成的提示消息
+func continuationMessage(incomplete []types.ToolCall) types.Message {
+	content := continuePrompt
+	if len(incomplete) > 0 {
+		names := make([]string, len(incomplete))
+		for i, tc := range incomplete {
+			names[i] = tc.Function.Name
+		}
+		content = fmt.Sprintf(truncatedToolCallsPrompt, strings.Join(names, ", "))
+	}
+
+	return types.Message{
+		ID:        utils.GenerateID(),
+		Role:      types.RoleUser,
+		Content:   content,
+		Metadata:  map[string]string{metadataContinuation: "true"},
+		Timestamp: time.Now(),
+	}
+}
+
+// maxContinuations 获取最大续写次数
+func (a *Agent) maxContinuations() int {
+	if a.config.MaxContinuations != 0 {
+		return a.config.MaxContinuations
+	}
+	return defaultMaxContinuations
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestCompleteToolCalls(t *testing.T) {
+	toolCalls := []types.ToolCall{
+		{ID: "1", Function: types.ToolCallFunction{Name: "read", Arguments: `{"file_path":"a.go"}`}},
+		{ID: "2", Function: types.ToolCallFunction{Name: "write", Arguments: `{"file_path":"b.go","content":"pack`}},
+	}
+
+	complete, incomplete := completeToolCalls(toolCalls)
+	if len(complete) != 1 || complete[0].ID != "1" {
+		t.Errorf("unexpected complete tool calls: %+v", complete)
+	}
+	if len(incomplete) != 1 || incomplete[0].ID != "2" {
+		t.Errorf("unexpected incomplete tool calls: %+v", incomplete)
+	}
+
+	message := continuationMessage(incomplete)
+	if message.Role != types.RoleUser || !strings.Contains(message.Content, "write") || message.Metadata[metadataContinuation] != "true" {
+		t.Errorf("unexpected continuation message: %+v", message)
+	}
+	if message := continuationMessage(nil); message.Content != continuePrompt {
+		t.Errorf("unexpected continuation message: %q", message.Content)
+	}
+}
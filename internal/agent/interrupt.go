@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// metadataInterrupted 标记本轮被中断时追加的消息
+const metadataInterrupted = "interrupted"
+
+var (
+	// ErrTurnInterrupted 本轮在完成前被取消
+	ErrTurnInterrupted = errors.New("turn interrupted")
+	// ErrCancelledByUser 用户主动取消本轮，作为取消原因
+	ErrCancelledByUser = errors.New("cancelled by user")
+)
+
+// turnRegistry 记录每个会话正在运行的一轮，用于取消
+type turnRegistry struct {
+	mu    sync.Mutex
+	turns map[string]context.CancelCauseFunc
+}
+
+// begin 登记会话的一轮，返回可取消的上下文和结束登记的函数。
+// 同一会话同时只能运行一轮，否则历史消息会交错
+func (r *turnRegistry) begin(ctx context.Context, sessionID string) (context.Context, func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, running := r.turns[sessionID]; running {
+		return nil, nil, fmt.Errorf("session %s: %w", sessionID, types.ErrTurnInProgress)
+	}
+	if r.turns == nil {
+		r.turns = make(map[string]context.CancelCauseFunc)
+	}
+
+	turnCtx, cancel := context.WithCancelCause(ctx)
+	r.turns[sessionID] = cancel
+
+	var once sync.Once
+	end := func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.turns, sessionID)
+			r.mu.Unlock()
+			cancel(nil)
+		})
+	}
+	return turnCtx, end, nil
+}
+
+// cancel 取消会话正在运行的一轮，没有正在运行的一轮时返回 false
+func (r *turnRegistry) cancel(sessionID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancel, running := r.turns[sessionID]
+	if running {
+		cancel(ErrCancelledByUser)
+	}
+	return running
+}
+
+// running 会话是否有正在运行的一轮
+func (r *turnRegistry) running(sessionID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, running := r.turns[sessionID]
+	return running
+}
+
+// CancelTurn 中断会话正在运行的一轮，停止进行中的LLM流和工具执行。
+// 没有正在运行的一轮时返回 false
+func (a *Agent) CancelTurn(sessionID string) bool {
+	if !a.turns.cancel(sessionID) {
+		return false
+	}
+	a.logger.Infof("Cancelling current turn for session %s", sessionID)
+	return true
+}
+
+// interrupted 本轮上下文已取消时补全会话历史并返回 ErrTurnInterrupted，否则原样返回 err。
+// 为没有结果的工具调用补充中断结果，再追加一条助手中断标记（包含已流式输出的部分内容），
+// 保证下一轮请求的消息顺序对所有提供商都合法
+func (a *Agent) interrupted(ctx context.Context, sessionID, partial string, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	cause := context.Cause(ctx)
+	a.logger.Infof("Turn for session %s interrupted: %v", sessionID, cause)
+
+	// 上下文已取消，写入历史不能再受其影响
+	writeCtx := context.WithoutCancel(ctx)
+
+	var messages []types.Message
+	if sessionContext, err := a.contextManager.GetSessionContext(sessionID); err == nil {
+		messages = sessionContext.Messages
+	}
+	for _, call := range danglingToolCalls(messages) {
+		toolMessage := types.Message{
+			ID:      utils.GenerateID(),
+			Role:    types.RoleTool,
+			Content: fmt.Sprintf("Tool: %s\nSuccess: false\nError: interrupted before the tool returned a result\n", call.Function.Name),
+			Metadata: map[string]string{
+				"tool_call_id":      call.ID,
+				"tool_name":         call.Function.Name,
+				"success":           "false",
+				metadataInterrupted: "true",
+			},
+			Timestamp: time.Now(),
+		}
+		if err := a.contextManager.AddMessage(writeCtx, sessionID, toolMessage); err != nil {
+			a.logger.Errorf("Failed to add interrupted tool result: %v", err)
+		}
+	}
+
+	content := fmt.Sprintf("[Request interrupted: %v]", cause)
+	if partial != "" {
+		content = partial + "\n\n" + content
+	}
+	marker := types.Message{
+		ID:        utils.GenerateID(),
+		Role:      types.RoleAssistant,
+		Content:   content,
+		Metadata:  map[string]string{metadataInterrupted: "true"},
+		Timestamp: time.Now(),
+	}
+	if err := a.contextManager.AddMessage(writeCtx, sessionID, marker); err != nil {
+		a.logger.Errorf("Failed to add interrupted marker: %v", err)
+	}
+
+	return fmt.Errorf("%w: %w", ErrTurnInterrupted, cause)
+}
+
+// danglingToolCalls 最后一条助手消息中还没有结果消息的工具调用
+func danglingToolCalls(messages []types.Message) []types.ToolCall {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != types.RoleAssistant {
+			continue
+		}
+
+		answered := make(map[string]bool)
+		for _, msg := range messages[i+1:] {
+			if msg.Role == types.RoleTool {
+				answered[msg.Metadata["tool_call_id"]] = true
+			}
+		}
+
+		var dangling []types.ToolCall
+		for _, call := range messages[i].ToolCalls {
+			if !answered[call.ID] {
+				dangling = append(dangling, call)
+			}
+		}
+		return dangling
+	}
+	return nil
+}
+
+// interruptedMetadata 被中断的一轮最终响应的元数据
+func interruptedMetadata(cause error) map[string]interface{} {
+	return map[string]interface{}{
+		metadataInterrupted: true,
+		"reason":            cause.Error(),
+	}
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// blockingTool 测试用工具，开始执行后一直阻塞到上下文取消
+type blockingTool struct {
+	once    sync.Once
+	started chan struct{}
+}
+
+func (t *blockingTool) Name() string { return "block" }
+
+func (t *blockingTool) IsConcurrencySafe() bool { return true }
+
+func (t *blockingTool) IsReadOnly() bool { return false }
+
+func (t *blockingTool) GetDefinition() types.Tool {
+	return types.Tool{
+		Type:     "function",
+		Function: types.ToolFunction{Name: "block", Parameters: types.ToolCallFunctionArguments{"type": "object"}},
+	}
+}
+
+func (t *blockingTool) Execute(ctx context.Context, call types.ToolCall) *types.ToolCallResult {
+	t.once.Do(func() { close(t.started) })
+	<-ctx.Done()
+	return &types.ToolCallResult{Success: false, Error: ctx.Err().Error(), Timestamp: time.Now()}
+}
+
+func TestCancelTurnDuringTool(t *testing.T) {
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{Content: "开始执行。", ToolCalls: []llm.MockToolCall{{ID: "call_block", Name: "block", Arguments: []byte(`{}`)}}},
+		{Content: "好的，换个方式。"},
+	}})
+	tool := &blockingTool{started: make(chan struct{})}
+	agent.toolEngine.RegisterTool("block", tool)
+
+	if agent.CancelTurn("s1") {
+		t.Errorf("expected no running turn before chat")
+	}
+
+	stream, err := agent.ChatStream(context.Background(), types.ChatRequest{SessionID: "s1", Message: "运行"})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+	<-tool.started
+
+	if _, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "再来"}); !errors.Is(err, types.ErrTurnInProgress) {
+		t.Errorf("expected turn in progress error, got %v", err)
+	}
+	if state, _ := agent.GetState("s1"); state.Status != "running" {
+		t.Errorf("expected running status, got %s", state.Status)
+	}
+	if !agent.CancelTurn("s1") {
+		t.Fatalf("expected running turn to be cancelled")
+	}
+
+	var final types.ChatResponse
+	for response := range stream {
+		final = response
+	}
+	if final.Metadata["interrupted"] != true || final.Metadata["reason"] != "cancelled by user" {
+		t.Errorf("unexpected final response: %+v", final)
+	}
+	if agent.CancelTurn("s1") {
+		t.Errorf("expected no running turn after interruption")
+	}
+
+	// 用户、助手(工具调用)、工具结果、中断标记
+	state, _ := agent.GetState("s1")
+	if len(state.Messages) != 4 {
+		t.Fatalf("unexpected session messages: %+v", state.Messages)
+	}
+	if result := state.Messages[2]; result.Role != types.RoleTool || result.Metadata["tool_call_id"] != "call_block" || !strings.Contains(result.Content, "context canceled") {
+		t.Errorf("unexpected tool result: %+v", result)
+	}
+	if marker := state.Messages[3]; marker.Role != types.RoleAssistant || marker.Metadata["interrupted"] != "true" || marker.Content != "[Request interrupted: cancelled by user]" {
+		t.Errorf("unexpected interrupted marker: %+v", marker)
+	}
+
+	// 中断后同一会话可以继续，请求历史保持完整
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "换个方式"})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Response != "好的，换个方式。" {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	requests := client.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected no LLM call after cancellation, got %d calls", len(requests))
+	}
+	messages := requests[1].Messages
+	if marker := messages[len(messages)-2]; marker.Metadata["interrupted"] != "true" {
+		t.Errorf("expected interrupted marker before the new query, got %+v", marker)
+	}
+}
+
+func TestCancelTurnDuringLLMCall(t *testing.T) {
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{Content: "太慢了", Delay: 10000},
+	}})
+
+	done := make(chan struct{})
+	var response *types.ChatResponse
+	var err error
+	go func() {
+		defer close(done)
+		response, err = agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "你好"})
+	}()
+
+	for !agent.CancelTurn("s1") {
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Chat did not return after cancellation")
+	}
+
+	if err != nil || response.Metadata["interrupted"] != true {
+		t.Fatalf("expected interrupted response, got %+v, %v", response, err)
+	}
+	state, _ := agent.GetState("s1")
+	if len(state.Messages) != 2 || state.Messages[1].Metadata["interrupted"] != "true" {
+		t.Errorf("unexpected session messages: %+v", state.Messages)
+	}
+	if len(client.Requests()) != 1 {
+		t.Errorf("expected the cancelled LLM call to be recorded")
+	}
+}
+
+func TestDanglingToolCalls(t *testing.T) {
+	messages := []types.Message{
+		{Role: types.RoleUser},
+		{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{{ID: "a"}, {ID: "b"}}},
+		{Role: types.RoleTool, Metadata: map[string]string{"tool_call_id": "a"}},
+	}
+	dangling := danglingToolCalls(messages)
+	if len(dangling) != 1 || dangling[0].ID != "b" {
+		t.Errorf("expected call b to be dangling, got %+v", dangling)
+	}
+
+	messages = append(messages, types.Message{Role: types.RoleTool, Metadata: map[string]string{"tool_call_id": "b"}})
+	if dangling := danglingToolCalls(messages); len(dangling) != 0 {
+		t.Errorf("expected no dangling calls, got %+v", dangling)
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zboya/nala-coder/internal/tools"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// metadataMode 会话元数据中记录工作模式的键
+const metadataMode = "agent_mode"
+
+// ExitPlanModeTool 计划模式下提交计划并请求切换到执行模式的工具
+const ExitPlanModeTool = "exit_plan_mode"
+
+// defaultPlanModePrompt 提示词目录中没有 plan_mode 时使用的计划模式说明
+const defaultPlanModePrompt = `<plan_mode>
+Plan mode is active. You can only use read-only tools: do not edit files, run commands or change the system in any way.
+Explore the codebase to understand the task, then write a concrete, step-by-step plan: the files to change, what to change in each, and how to verify the result.
+When the plan is ready, call the ` + ExitPlanModeTool + ` tool with the full plan to ask the user to approve it and switch to execution mode.
+</plan_mode>`
+
+const (
+	planApproved = "The user approved the plan. Execution mode is now active and all tools are available. Start implementing the plan."
+	planRejected = "The user did not approve the plan. Plan mode is still active: ask the user what should change and revise the plan."
+	planPending  = "The plan was shown to the user. The session stays in plan mode until the user switches it to execution mode; stop here and wait for the user."
+)
+
+// selectMode 校验请求中的工作模式并写入会话元数据，后续轮次沿用该模式
+func (a *Agent) selectMode(ctx context.Context, sessionID string, request types.ChatRequest) error {
+	switch request.Mode {
+	case "":
+		return nil
+	case types.ModeExecute, types.ModePlan:
+	default:
+		return fmt.Errorf("invalid agent mode %q", request.Mode)
+	}
+
+	if err := a.contextManager.SetSessionMetadata(ctx, sessionID, map[string]string{metadataMode: string(request.Mode)}); err != nil {
+		return fmt.Errorf("failed to save agent mode: %w", err)
+	}
+	return nil
+}
+
+// sessionMode 会话当前的工作模式，未选择时为执行模式
+func (a *Agent) sessionMode(sessionID string) types.AgentMode {
+	sessionContext, err := a.contextManager.GetSessionContext(sessionID)
+	if err != nil || sessionContext.Metadata[metadataMode] == "" {
+		return types.ModeExecute
+	}
+	return types.AgentMode(sessionContext.Metadata[metadataMode])
+}
+
+// toolDefinitions 会话当前模式下提供给模型的工具，计划模式下只有只读工具和 exit_plan_mode
+func (a *Agent) toolDefinitions(sessionID string) []types.Tool {
+	definitions := a.toolEngine.GetToolDefinitions()
+	if a.sessionMode(sessionID) != types.ModePlan {
+		return definitions
+	}
+
+	readOnly := make([]types.Tool, 0, len(definitions)+1)
+	for _, definition := range definitions {
+		if a.isReadOnlyTool(definition.Function.Name) {
+			readOnly = append(readOnly, definition)
+		}
+	}
+	return append(readOnly, exitPlanModeDefinition())
+}
+
+// isReadOnlyTool 判断工具是否只读，未注册的工具视为只读，由引擎报告不存在
+func (a *Agent) isReadOnlyTool(name string) bool {
+	tool, exists := a.toolEngine.GetTool(name)
+	return !exists || tool.IsReadOnly()
+}
+
+// planModePrompt 计划模式的提示词段落
+func (a *Agent) planModePrompt() string {
+	prompt, err := a.promptManager.GetPrompt("plan_mode")
+	if err != nil {
+		return defaultPlanModePrompt
+	}
+	return prompt
+}
+
+// exitPlanModeDefinition exit_plan_mode 工具定义
+func exitPlanModeDefinition() types.Tool {
+	return types.Tool{
+		Type: "function",
+		Function: types.ToolFunction{
+			Name:        ExitPlanModeTool,
+			Description: "Present the finished plan to the user and ask for approval to leave plan mode and start making changes",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"plan": map[string]any{
+						"type":        "string",
+						"description": "The full implementation plan, in markdown",
+					},
+				},
+				"required": []string{"plan"},
+			},
+		},
+	}
+}
+
+// exitPlanMode 请求用户批准计划，批准后会话切换到执行模式，本轮接下来的调用可以使用所有工具。
+// 无法请求确认时只展示计划，由用户之后以执行模式发起请求
+func (a *Agent) exitPlanMode(ctx context.Context, sessionID string, call types.ToolCall) types.ToolCallResult {
+	var params struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &params); err != nil || strings.TrimSpace(params.Plan) == "" {
+		return types.ToolCallResult{Success: false, Error: "plan is required", Timestamp: time.Now()}
+	}
+
+	if _, ok := approverFrom(ctx); !ok {
+		return types.ToolCallResult{Success: true, Content: planPending, Timestamp: time.Now()}
+	}
+
+	decision, err := a.awaitApproval(ctx, sessionID, types.ToolApproval{
+		ID:         utils.GenerateID(),
+		ToolCallID: call.ID,
+		Tool:       ExitPlanModeTool,
+		Arguments:  call.Function.Arguments,
+		Subject:    params.Plan,
+	})
+	if err != nil {
+		return types.ToolCallResult{Success: false, Error: err.Error(), Timestamp: time.Now()}
+	}
+	if !decision.Approved {
+		return types.ToolCallResult{Success: true, Content: planRejected, Timestamp: time.Now()}
+	}
+
+	if err := a.contextManager.SetSessionMetadata(ctx, sessionID, map[string]string{metadataMode: string(types.ModeExecute)}); err != nil {
+		return types.ToolCallResult{Success: false, Error: fmt.Sprintf("failed to switch to execution mode: %v", err), Timestamp: time.Now()}
+	}
+	a.logger.Infof("Plan approved, session %s switched to execution mode", sessionID)
+	return types.ToolCallResult{Success: true, Content: planApproved, Timestamp: time.Now()}
+}
+
+// planModeCalls 计划模式下处理 exit_plan_mode 调用并标记只允许只读工具，返回已处理调用的结果（按下标）
+func (a *Agent) planModeCalls(ctx context.Context, sessionID string, toolCalls []types.ToolCall) (context.Context, map[int]types.ToolCallResult) {
+	handled := make(map[int]types.ToolCallResult)
+	if a.sessionMode(sessionID) != types.ModePlan {
+		return ctx, handled
+	}
+
+	ctx = tools.WithReadOnly(ctx)
+	for i, call := range toolCalls {
+		if call.Function.Name == ExitPlanModeTool {
+			handled[i] = a.exitPlanMode(ctx, sessionID, call)
+		}
+	}
+	return ctx, handled
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// toolNames 请求中提供给模型的工具名
+func toolNames(request types.LLMRequest) []string {
+	names := make([]string, len(request.Tools))
+	for i, tool := range request.Tools {
+		names[i] = tool.Function.Name
+	}
+	return names
+}
+
+func TestPlanMode(t *testing.T) {
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{
+			{ID: "call_echo", Name: "echo", Arguments: []byte(`{"text":"hi"}`)},
+			{ID: "call_deny", Name: "deny", Arguments: []byte(`{}`)},
+		}},
+		{ToolCalls: []llm.MockToolCall{{ID: "call_plan", Name: ExitPlanModeTool, Arguments: []byte(`{"plan":"1. 修改 main.go"}`)}}},
+		{ToolCalls: []llm.MockToolCall{{ID: "call_deny2", Name: "deny", Arguments: []byte(`{}`)}}},
+		{Content: "完成。"},
+	}})
+
+	stream, err := agent.ChatStream(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始", Mode: types.ModePlan})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+	var approvals []*types.ToolApproval
+	for resp := range stream {
+		if resp.Approval != nil {
+			approvals = append(approvals, resp.Approval)
+			agent.ResolveApproval("s1", resp.Approval.ID, types.ApprovalDecision{Approved: true})
+		}
+	}
+
+	if len(approvals) != 1 || approvals[0].Tool != ExitPlanModeTool || approvals[0].Subject != "1. 修改 main.go" || approvals[0].Suggested != "" {
+		t.Fatalf("unexpected approvals: %+v", approvals)
+	}
+
+	// 计划模式下只提供只读工具和 exit_plan_mode，系统提示词包含计划模式说明
+	requests := client.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("expected 4 requests, got %d", len(requests))
+	}
+	if names := strings.Join(toolNames(requests[0]), ","); names != "echo,"+ExitPlanModeTool {
+		t.Errorf("unexpected plan mode tools: %s", names)
+	}
+	if !strings.Contains(requests[0].Messages[0].Content, "<plan_mode>") {
+		t.Errorf("expected plan mode prompt in system message")
+	}
+
+	// 模型仍然调用写工具时由引擎拒绝
+	results := toolResults(t, agent, "s1")
+	if results["call_echo"].Metadata["success"] != "true" {
+		t.Errorf("expected read-only tool to run: %+v", results["call_echo"])
+	}
+	if !strings.Contains(results["call_deny"].Content, "not available in read-only plan mode") {
+		t.Errorf("expected write tool to be rejected: %+v", results["call_deny"])
+	}
+
+	// 批准计划后同一轮切换到执行模式，可以使用所有工具
+	if !strings.Contains(results["call_plan"].Content, "Execution mode is now active") {
+		t.Errorf("unexpected exit_plan_mode result: %+v", results["call_plan"])
+	}
+	if names := strings.Join(toolNames(requests[2]), ","); strings.Contains(names, ExitPlanModeTool) || !strings.Contains(names, "deny") {
+		t.Errorf("unexpected execution mode tools: %s", names)
+	}
+	if strings.Contains(requests[2].Messages[0].Content, "<plan_mode>") {
+		t.Errorf("expected plan mode prompt to be removed after approval")
+	}
+	if strings.Contains(results["call_deny2"].Content, "plan mode") {
+		t.Errorf("expected write tool to run in execution mode: %+v", results["call_deny2"])
+	}
+
+	state, err := agent.GetState("s1")
+	if err != nil || state.Mode != types.ModeExecute {
+		t.Errorf("expected session to be in execution mode, got %+v, %v", state.Mode, err)
+	}
+}
+
+func TestPlanModeWithoutApprover(t *testing.T) {
+	agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{{ID: "call_plan", Name: ExitPlanModeTool, Arguments: []byte(`{"plan":"1. 修改 main.go"}`)}}},
+		{Content: "计划如上。"},
+	}})
+
+	if _, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始", Mode: "review"}); err == nil {
+		t.Errorf("expected invalid mode error")
+	}
+
+	// 非流式对话只展示计划，会话保持计划模式
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始", Mode: types.ModePlan})
+	if err != nil || response.Response != "计划如上。" {
+		t.Fatalf("unexpected chat response: %+v, %v", response, err)
+	}
+	if result := toolResults(t, agent, "s1")["call_plan"]; !strings.Contains(result.Content, "stays in plan mode") {
+		t.Errorf("unexpected exit_plan_mode result: %+v", result)
+	}
+	if state, _ := agent.GetState("s1"); state.Mode != types.ModePlan {
+		t.Errorf("expected session to stay in plan mode, got %s", state.Mode)
+	}
+}
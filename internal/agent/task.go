@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zboya/nala-coder/internal/tools"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// TaskToolName 在独立会话中运行子Agent的工具
+const TaskToolName = "task"
+
+// 子任务会话元数据的键
+const (
+	metadataParentSession   = "parent_session"
+	metadataTaskDescription = "task_description"
+	// metadataTaskSession 父会话工具结果消息中记录子任务会话的键，用于查看子任务的完整记录
+	metadataTaskSession = "task_session_id"
+)
+
+// defaultTaskMaxLoops 子任务默认的循环上限
+const defaultTaskMaxLoops = 20
+
+// taskExcludedTools 子任务默认不提供的只读工具：不能再启动子任务，也不修改主会话的任务列表
+var taskExcludedTools = []string{TaskToolName, "todo_write"}
+
+// defaultTaskPrompt 提示词目录中没有 task 时使用的子任务说明
+const defaultTaskPrompt = `<sub_agent>
+You are a sub-agent working on one task for another agent. The other agent only sees your final message, not your tool calls or their output.
+Do the task with the tools available, then finish with a concise, self-contained report: what you found, with file paths and line numbers, and anything you could not determine.
+</sub_agent>`
+
+// TaskConfig 子任务配置，task 需要在 tools.enabled_tools 中启用
+type TaskConfig struct {
+	MaxLoops int               `mapstructure:"max_loops"` // 子任务的循环上限，默认 20
+	Tools    []string          `mapstructure:"tools"`     // 子任务可用的工具，默认所有已启用的只读工具
+	Provider types.LLMProvider `mapstructure:"provider"`  // 子任务使用的提供商，默认与父会话相同
+	Model    string            `mapstructure:"model"`     // 子任务使用的模型，默认与父会话相同
+}
+
+// taskTool 启动子Agent完成独立的调查任务，只把最终报告返回给父会话，
+// 避免大量搜索和读取结果占用父会话的上下文
+type taskTool struct {
+	agent    *Agent
+	engine   *tools.Engine
+	readOnly bool
+	config   TaskConfig
+}
+
+// newTaskTool 创建子任务工具，子任务的工具集取自 engine
+func newTaskTool(agent *Agent, engine *tools.Engine, config TaskConfig) *taskTool {
+	if config.MaxLoops <= 0 {
+		config.MaxLoops = defaultTaskMaxLoops
+	}
+
+	names := config.Tools
+	if len(names) == 0 {
+		for _, definition := range engine.GetToolDefinitions() {
+			name := definition.Function.Name
+			if tool, exists := engine.GetTool(name); exists && tool.IsReadOnly() && !slices.Contains(taskExcludedTools, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	names = slices.DeleteFunc(slices.Clone(names), func(name string) bool { return name == TaskToolName })
+
+	subset := engine.Subset(names)
+	readOnly := true
+	for _, definition := range subset.GetToolDefinitions() {
+		if tool, _ := subset.GetTool(definition.Function.Name); !tool.IsReadOnly() {
+			readOnly = false
+		}
+	}
+
+	return &taskTool{agent: agent, engine: subset, readOnly: readOnly, config: config}
+}
+
+func (t *taskTool) Name() string {
+	return TaskToolName
+}
+
+func (t *taskTool) GetDefinition() types.Tool {
+	return types.Tool{
+		Type: "function",
+		Function: types.ToolFunction{
+			Name: TaskToolName,
+			Description: "Launch a sub-agent to carry out a self-contained task, such as searching the codebase or researching a question, in its own context. " +
+				"Only the sub-agent's final report is returned, so use it to keep large search and file output out of this conversation. " +
+				"Call it several times in one response to run independent tasks concurrently. " +
+				"The sub-agent cannot see this conversation: give it all the context it needs and say exactly what it should report back.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"description": map[string]any{
+						"type":        "string",
+						"description": "A short (3-5 word) description of the task",
+					},
+					"prompt": map[string]any{
+						"type":        "string",
+						"description": "The full instructions for the sub-agent",
+					},
+				},
+				"required": []string{"description", "prompt"},
+			},
+		},
+	}
+}
+
+func (t *taskTool) IsConcurrencySafe() bool {
+	return true
+}
+
+// IsReadOnly 子任务的工具都只读时子任务也只读，计划模式下可用
+func (t *taskTool) IsReadOnly() bool {
+	return t.readOnly
+}
+
+func (t *taskTool) Execute(ctx context.Context, call types.ToolCall) *types.ToolCallResult {
+	var params struct {
+		Description string `json:"description"`
+		Prompt      string `json:"prompt"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &params); err != nil {
+		return &types.ToolCallResult{Success: false, Error: fmt.Sprintf("invalid arguments: %v", err), Timestamp: time.Now()}
+	}
+	if strings.TrimSpace(params.Prompt) == "" {
+		return &types.ToolCallResult{Success: false, Error: "prompt is required", Timestamp: time.Now()}
+	}
+
+	parentSession := tools.SessionID(ctx)
+	child := t.agent.subAgent(parentSession, t.engine, t.config.MaxLoops)
+	sessionID := utils.GenerateID()
+	metadata := map[string]string{metadataTaskSession: sessionID}
+
+	report, usage, err := child.runTask(ctx, sessionID, params.Description, params.Prompt, t.config)
+	if err != nil {
+		return &types.ToolCallResult{Success: false, Error: err.Error(), Usage: &usage, Metadata: metadata, Timestamp: time.Now()}
+	}
+	if strings.TrimSpace(report) == "" {
+		return &types.ToolCallResult{
+			Success:   false,
+			Error:     fmt.Sprintf("task ended without a final report (loop limit %d)", t.config.MaxLoops),
+			Usage:     &usage,
+			Metadata:  metadata,
+			Timestamp: time.Now(),
+		}
+	}
+
+	return &types.ToolCallResult{Success: true, Content: report, Usage: &usage, Metadata: metadata, Timestamp: time.Now()}
+}
+
+// subAgent 创建运行子任务的Agent，与父Agent共享LLM、上下文、权限策略和确认登记，
+// 使用独立的工具集和循环上限。用量、确认和记住的规则都记在父会话
+func (a *Agent) subAgent(parentSession string, engine types.ToolEngine, maxLoops int) *Agent {
+	config := *a.config
+	config.MaxLoops = maxLoops
+
+	return &Agent{
+		config:         &config,
+		llmManager:     a.llmManager,
+		toolEngine:     engine,
+		contextManager: a.contextManager,
+		promptManager:  a.promptManager,
+		permissions:    a.permissions,
+		approvals:      a.approvals,
+		parentSession:  a.ownerSession(parentSession),
+		logger:         a.logger,
+	}
+}
+
+// ownerSession 用量、确认和记住的规则所属的会话，子任务记在父会话
+func (a *Agent) ownerSession(sessionID string) string {
+	if a.parentSession != "" {
+		return a.parentSession
+	}
+	return sessionID
+}
+
+// runTask 在新会话中运行子任务，返回最后的回复作为报告。会话保留，可以查看子任务的完整记录
+func (a *Agent) runTask(ctx context.Context, sessionID, description, prompt string, config TaskConfig) (string, types.Usage, error) {
+	metadata := map[string]string{
+		metadataParentSession:   a.parentSession,
+		metadataTaskDescription: description,
+	}
+	if err := a.contextManager.SetSessionMetadata(ctx, sessionID, metadata); err != nil {
+		return "", types.Usage{}, fmt.Errorf("failed to create task session: %w", err)
+	}
+
+	// 未指定模型时沿用父会话的选择
+	request := types.ChatRequest{Provider: config.Provider, Model: config.Model}
+	if request.Provider == "" && request.Model == "" {
+		request.Provider, request.Model = a.sessionModel(a.parentSession)
+	}
+	if err := a.selectModel(ctx, sessionID, request); err != nil {
+		return "", types.Usage{}, err
+	}
+
+	userMessage := types.Message{
+		ID:        utils.GenerateID(),
+		Role:      types.RoleUser,
+		Content:   prompt,
+		Timestamp: time.Now(),
+	}
+	if err := a.contextManager.AddMessage(ctx, sessionID, userMessage); err != nil {
+		return "", types.Usage{}, fmt.Errorf("failed to add task prompt: %w", err)
+	}
+
+	a.logger.Infof("Running task %q in session %s for session %s", description, sessionID, a.parentSession)
+	report, usage, err := a.runAgentLoop(ctx, sessionID)
+	if err != nil {
+		return "", usage, fmt.Errorf("task failed: %w", err)
+	}
+	return report, usage, nil
+}
+
+// taskPrompt 子任务的提示词段落
+func (a *Agent) taskPrompt() string {
+	prompt, err := a.promptManager.GetPrompt("task")
+	if err != nil {
+		return defaultTaskPrompt
+	}
+	return prompt
+}
+
+// EnableTasks 在工具引擎中注册 task 工具，子任务的工具集取自该引擎
+func (a *Agent) EnableTasks(engine *tools.Engine, config TaskConfig) error {
+	return engine.RegisterTool(TaskToolName, newTaskTool(a, engine, config))
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/internal/tools"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestTaskTool(t *testing.T) {
+	usage := types.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+	agent, client := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{
+			{ID: "call_task1", Name: TaskToolName, Arguments: []byte(`{"description":"查找入口","prompt":"找到 main 函数"}`)},
+			{ID: "call_task2", Name: TaskToolName, Arguments: []byte(`{"description":"查找配置","prompt":"找到配置加载"}`)},
+		}, Usage: usage},
+		// 两个子任务并发运行，各取一个回复作为报告
+		{Content: "报告：在 cmd/main.go", Usage: usage},
+		{Content: "报告：在 cmd/main.go", Usage: usage},
+		{Content: "完成。", Usage: usage},
+	}})
+	if err := agent.EnableTasks(agent.toolEngine.(*tools.Engine), TaskConfig{MaxLoops: 3}); err != nil {
+		t.Fatalf("EnableTasks error: %v", err)
+	}
+
+	response, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始"})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	// 子任务的用量计入父会话本轮的总用量
+	if response.Usage.TotalTokens != 4*usage.TotalTokens {
+		t.Errorf("expected task usage in turn total, got %+v", response.Usage)
+	}
+
+	// 父会话只收到子任务的报告，结果元数据记录子任务会话
+	results := toolResults(t, agent, "s1")
+	sessions := make(map[string]bool)
+	for _, id := range []string{"call_task1", "call_task2"} {
+		result := results[id]
+		if result.Metadata["success"] != "true" || !strings.Contains(result.Content, "报告：在 cmd/main.go") {
+			t.Errorf("unexpected task result: %+v", result)
+		}
+		sessions[result.Metadata[metadataTaskSession]] = true
+	}
+	if len(sessions) != 2 || sessions[""] {
+		t.Fatalf("expected two task sessions, got %v", sessions)
+	}
+
+	// 子任务会话保留完整记录
+	for sessionID := range sessions {
+		sessionContext, err := agent.contextManager.GetSessionContext(sessionID)
+		if err != nil {
+			t.Fatalf("GetSessionContext error: %v", err)
+		}
+		if sessionContext.Metadata[metadataParentSession] != "s1" || len(sessionContext.Messages) != 2 {
+			t.Errorf("unexpected task session: %+v", sessionContext)
+		}
+	}
+
+	// 子任务使用独立的提示词和只读工具集，不能再启动子任务
+	requests := client.Requests()
+	for _, request := range requests[1:3] {
+		if names := strings.Join(toolNames(request), ","); names != "echo" {
+			t.Errorf("unexpected task tools: %s", names)
+		}
+		if !strings.Contains(request.Messages[0].Content, "<sub_agent>") {
+			t.Errorf("expected sub-agent prompt in task system message")
+		}
+	}
+
+	// 用量账本中子任务的用量记在父会话
+	report, err := agent.GetUsageReport(context.Background(), types.UsageQuery{GroupBy: types.UsageGroupBySession})
+	if err != nil {
+		t.Fatalf("GetUsageReport error: %v", err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].Key != "s1" || report.Rows[0].Calls != 4 {
+		t.Errorf("unexpected usage report: %+v", report.Rows)
+	}
+}
+
+func TestTaskToolLoopLimit(t *testing.T) {
+	agent, _ := newTestAgent(t, 10, llm.MockScript{Turns: []llm.MockTurn{
+		{ToolCalls: []llm.MockToolCall{{ID: "call_task", Name: TaskToolName, Arguments: []byte(`{"description":"查找","prompt":"一直查找"}`)}}},
+		{ToolCalls: []llm.MockToolCall{{Name: "echo", Arguments: []byte(`{}`)}}},
+		{ToolCalls: []llm.MockToolCall{{Name: "echo", Arguments: []byte(`{}`)}}},
+		{Content: "没有找到。"},
+	}})
+	if err := agent.EnableTasks(agent.toolEngine.(*tools.Engine), TaskConfig{MaxLoops: 2}); err != nil {
+		t.Fatalf("EnableTasks error: %v", err)
+	}
+
+	if _, err := agent.Chat(context.Background(), types.ChatRequest{SessionID: "s1", Message: "开始"}); err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if result := toolResults(t, agent, "s1")["call_task"]; result.Metadata["success"] != "false" || !strings.Contains(result.Content, "without a final report") {
+		t.Errorf("expected task to fail at its loop limit: %+v", result)
+	}
+}
@@ -7,20 +7,25 @@ import (
 	"sync"
 	"time"
 
+	"github.com/zboya/nala-coder/internal/llm"
 	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/tokenizer"
 	"github.com/zboya/nala-coder/pkg/types"
 	"github.com/zboya/nala-coder/pkg/utils"
 )
 
 // ContextManager 上下文管理器
 type ContextManager struct {
-	config         *Config
-	sessions       map[string]*types.SessionContext
-	promptManager  types.PromptManager
-	compressionLLM types.LLMClient
-	storage        SessionStorage
-	mu             sync.RWMutex
-	logger         log.Logger
+	config          *Config
+	sessions        map[string]*types.SessionContext
+	promptManager   types.PromptManager
+	compressionLLM  types.LLMClient
+	tokenizer       tokenizer.Tokenizer
+	selectTokenizer TokenizerSelector
+	storage         SessionStorage
+	prices          PriceTable
+	mu              sync.RWMutex
+	logger          log.Logger
 }
 
 // Config 上下文管理器配置
@@ -32,6 +37,9 @@ type Config struct {
 	CompressionThreshold float64     `mapstructure:"compression_threshold"`
 }
 
+// TokenizerSelector 按会话元数据中选择的提供商和模型返回token计数器，返回 nil 时使用默认提供商的计数器
+type TokenizerSelector func(metadata map[string]string) tokenizer.Tokenizer
+
 // NewContextManager 创建上下文管理器
 func NewContextManager(config *Config, promptManager types.PromptManager, compressionLLM types.LLMClient, logger log.Logger) (*ContextManager, error) {
 	// 设置默认存储类型
@@ -50,9 +58,11 @@ func NewContextManager(config *Config, promptManager types.PromptManager, compre
 		sessions:       make(map[string]*types.SessionContext),
 		promptManager:  promptManager,
 		compressionLLM: compressionLLM,
+		tokenizer:      newTokenizer(compressionLLM),
 		storage:        storage,
 		logger:         logger,
 	}
+	cm.logger.Debugf("Using tokenizer: %s", cm.tokenizer.Name())
 
 	// 加载持久化数据
 	if err := cm.loadSessions(); err != nil {
@@ -62,6 +72,20 @@ func NewContextManager(config *Config, promptManager types.PromptManager, compre
 	return cm, nil
 }
 
+// newTokenizer 按默认模型选择token计数器
+func newTokenizer(client types.LLMClient) tokenizer.Tokenizer {
+	if client == nil {
+		return tokenizer.Default()
+	}
+
+	config := client.GetConfig()
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = config.Provider
+	}
+	return tokenizer.ForModel(string(protocol), config.Model)
+}
+
 // AddMessage 添加消息到会话
 func (cm *ContextManager) AddMessage(ctx context.Context, sessionID string, message types.Message) error {
 	cm.mu.Lock()
@@ -74,7 +98,7 @@ func (cm *ContextManager) AddMessage(ctx context.Context, sessionID string, mess
 	session.LastActivity = time.Now()
 
 	// 计算token使用量
-	tokens := utils.CountTokens(message.Content)
+	tokens := cm.sessionTokenizer(session).Count(message.Content)
 	session.TotalTokens += tokens
 
 	// 检查是否需要压缩
@@ -158,10 +182,95 @@ func (cm *ContextManager) GetSessionContext(sessionID string) (*types.SessionCon
 	sessionCopy := *session
 	sessionCopy.Messages = make([]types.Message, len(session.Messages))
 	copy(sessionCopy.Messages, session.Messages)
+	sessionCopy.Metadata = make(map[string]string, len(session.Metadata))
+	for key, value := range session.Metadata {
+		sessionCopy.Metadata[key] = value
+	}
 
 	return &sessionCopy, nil
 }
 
+// SetSessionMetadata 更新会话元数据，值为空时删除对应键
+func (cm *ContextManager) SetSessionMetadata(ctx context.Context, sessionID string, metadata map[string]string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	session := cm.getOrCreateSession(sessionID)
+	if session.Metadata == nil {
+		session.Metadata = make(map[string]string)
+	}
+
+	for key, value := range metadata {
+		if value == "" {
+			delete(session.Metadata, key)
+			continue
+		}
+		session.Metadata[key] = value
+	}
+
+	// 切换提供商或模型后按新的计数器统计
+	cm.recountTokens(session)
+	return cm.saveSession(ctx, session)
+}
+
+// SetTokenizerSelector 设置按会话选择token计数器的函数，未设置时所有会话使用默认提供商的计数器
+func (cm *ContextManager) SetTokenizerSelector(selector TokenizerSelector) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.selectTokenizer = selector
+}
+
+// sessionTokenizer 会话使用的token计数器
+func (cm *ContextManager) sessionTokenizer(session *types.SessionContext) tokenizer.Tokenizer {
+	if cm.selectTokenizer != nil {
+		if t := cm.selectTokenizer(session.Metadata); t != nil {
+			return t
+		}
+	}
+	return cm.tokenizer
+}
+
+// recountTokens 重新计算会话的token数量
+func (cm *ContextManager) recountTokens(session *types.SessionContext) {
+	counter := cm.sessionTokenizer(session)
+	session.TotalTokens = counter.Count(session.CompressedHistory)
+	for _, msg := range session.Messages {
+		session.TotalTokens += counter.Count(msg.Content)
+	}
+}
+
+// SetPriceTable 设置用量报告使用的价格表
+func (cm *ContextManager) SetPriceTable(prices []types.ModelPrice) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.prices = prices
+}
+
+// RecordUsage 记录一次LLM调用的用量
+func (cm *ContextManager) RecordUsage(ctx context.Context, record types.UsageRecord) error {
+	if record.ID == "" {
+		record.ID = utils.GenerateID()
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	return cm.storage.SaveUsage(ctx, record)
+}
+
+// GetUsageReport 按会话、日期或提供商汇总用量和费用
+func (cm *ContextManager) GetUsageReport(ctx context.Context, query types.UsageQuery) (*types.UsageReport, error) {
+	records, err := cm.storage.LoadUsage(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	cm.mu.RLock()
+	prices := cm.prices
+	cm.mu.RUnlock()
+
+	return BuildUsageReport(records, query.GroupBy, prices)
+}
+
 // getOrCreateSession 获取或创建会话
 func (cm *ContextManager) getOrCreateSession(sessionID string) *types.SessionContext {
 	session, exists := cm.sessions[sessionID]
@@ -232,6 +341,7 @@ func (cm *ContextManager) compressSessionHistory(ctx context.Context, session *t
 	if err != nil {
 		return fmt.Errorf("failed to compress history: %w", err)
 	}
+	cm.recordCompressionUsage(ctx, session.ID, response)
 
 	// 保存压缩后的历史
 	if session.CompressedHistory != "" {
@@ -247,15 +357,41 @@ func (cm *ContextManager) compressSessionHistory(ctx context.Context, session *t
 	}
 
 	// 重新计算token数量
-	session.TotalTokens = utils.CountTokens(session.CompressedHistory)
-	for _, msg := range session.Messages {
-		session.TotalTokens += utils.CountTokens(msg.Content)
-	}
+	cm.recountTokens(session)
 
 	cm.logger.Infof("Compressed session %s history, new token count: %d", session.ID, session.TotalTokens)
 	return nil
 }
 
+// recordCompressionUsage 将压缩调用的用量写入账本，失败不影响压缩
+func (cm *ContextManager) recordCompressionUsage(ctx context.Context, sessionID string, response *types.LLMResponse) {
+	if response.Usage.PromptTokens == 0 && response.Usage.CompletionTokens == 0 {
+		return
+	}
+
+	// 经过LLM管理器时元数据中记录了实际应答的提供商和模型
+	provider := types.LLMProvider(response.Metadata[llm.MetadataProvider])
+	if provider == "" {
+		provider = cm.compressionLLM.GetProvider()
+	}
+	model := response.Metadata[llm.MetadataModel]
+	if model == "" {
+		model = cm.compressionLLM.GetConfig().Model
+	}
+
+	record := types.UsageRecord{
+		SessionID:        sessionID,
+		Provider:         provider,
+		Model:            model,
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+		CachedTokens:     response.Usage.CachedTokens,
+	}
+	if err := cm.RecordUsage(ctx, record); err != nil {
+		cm.logger.Warnf("Failed to record compression usage for session %s: %v", sessionID, err)
+	}
+}
+
 // limitSessionMessages 限制会话消息数量
 func (cm *ContextManager) limitSessionMessages(session *types.SessionContext) {
 	if len(session.Messages) > cm.config.HistoryLimit*2 {
@@ -263,10 +399,7 @@ func (cm *ContextManager) limitSessionMessages(session *types.SessionContext) {
 		session.Messages = session.Messages[len(session.Messages)-cm.config.HistoryLimit:]
 
 		// 重新计算token数量
-		session.TotalTokens = utils.CountTokens(session.CompressedHistory)
-		for _, msg := range session.Messages {
-			session.TotalTokens += utils.CountTokens(msg.Content)
-		}
+		cm.recountTokens(session)
 	}
 }
 
@@ -0,0 +1,136 @@
+package context
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/tokenizer"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestCompressHistory(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+
+	promptDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(promptDir, "compression.md"), []byte("Summarize:\n{{.conversation_history}}"), 0644); err != nil {
+		t.Fatalf("failed to write prompt: %v", err)
+	}
+	promptManager, err := NewPromptManager(promptDir, false, logger)
+	if err != nil {
+		t.Fatalf("NewPromptManager error: %v", err)
+	}
+
+	client := llm.NewMockClientFromScript(types.LLMConfig{Provider: types.ProviderMock, MaxTokens: 100}, llm.MockScript{
+		Turns: []llm.MockTurn{{Content: "用户在重构登录模块。", Usage: types.Usage{PromptTokens: 80, CompletionTokens: 10}}},
+	}, logger)
+
+	cm, err := NewContextManager(&Config{
+		HistoryLimit:         2,
+		StoragePath:          t.TempDir(),
+		StorageType:          StorageTypeJSON,
+		CompressionThreshold: 0.5,
+	}, promptManager, client, logger)
+	if err != nil {
+		t.Fatalf("NewContextManager error: %v", err)
+	}
+	defer cm.Close()
+
+	ctx := context.Background()
+	contents := []string{
+		"请帮我重构登录模块，" + strings.Repeat("login ", 30),
+		"好的，我先阅读代码。" + strings.Repeat("read ", 30),
+		"继续",
+	}
+	for i, content := range contents {
+		role := types.RoleUser
+		if i == 1 {
+			role = types.RoleAssistant
+		}
+		if err := cm.AddMessage(ctx, "s1", types.Message{Role: role, Content: content}); err != nil {
+			t.Fatalf("AddMessage error: %v", err)
+		}
+	}
+
+	requests := client.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 compression call, got %d", len(requests))
+	}
+	prompt := requests[0].Messages[0].Content
+	if !strings.HasPrefix(prompt, "Summarize:") || !strings.Contains(prompt, "user: 请帮我重构登录模块") || strings.Contains(prompt, "继续") {
+		t.Errorf("unexpected compression prompt: %q", prompt)
+	}
+
+	session, err := cm.GetSessionContext("s1")
+	if err != nil {
+		t.Fatalf("GetSessionContext error: %v", err)
+	}
+	if session.CompressedHistory != "用户在重构登录模块。" {
+		t.Errorf("unexpected compressed history: %q", session.CompressedHistory)
+	}
+	if len(session.Messages) != 2 || session.Messages[1].Content != "继续" {
+		t.Errorf("expected last 2 messages kept, got %+v", session.Messages)
+	}
+
+	// 压缩调用的用量记在会话下
+	report, err := cm.GetUsageReport(ctx, types.UsageQuery{GroupBy: types.UsageGroupBySession})
+	if err != nil {
+		t.Fatalf("GetUsageReport error: %v", err)
+	}
+	if len(report.Rows) != 1 || report.Rows[0].Key != "s1" || report.Rows[0].Calls != 1 || report.Rows[0].PromptTokens != 80 {
+		t.Errorf("expected compression usage recorded for s1, got %+v", report.Rows)
+	}
+}
+
+// wordTokenizer 测试用计数器，按空白分隔的单词计数
+type wordTokenizer struct{}
+
+func (wordTokenizer) Name() string { return "words" }
+
+func (wordTokenizer) Count(text string) int { return len(strings.Fields(text)) }
+
+func TestSessionTokenizer(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+	client := llm.NewMockClientFromScript(types.LLMConfig{Provider: types.ProviderMock}, llm.MockScript{}, logger)
+
+	cm, err := NewContextManager(&Config{
+		HistoryLimit: 10,
+		StoragePath:  t.TempDir(),
+		StorageType:  StorageTypeJSON,
+	}, nil, client, logger)
+	if err != nil {
+		t.Fatalf("NewContextManager error: %v", err)
+	}
+	defer cm.Close()
+	cm.SetTokenizerSelector(func(metadata map[string]string) tokenizer.Tokenizer {
+		if metadata["llm_provider"] == "words" {
+			return wordTokenizer{}
+		}
+		return nil
+	})
+
+	ctx := context.Background()
+	content := strings.Repeat("token ", 8)
+	if err := cm.AddMessage(ctx, "s1", types.Message{Role: types.RoleUser, Content: content}); err != nil {
+		t.Fatalf("AddMessage error: %v", err)
+	}
+	session, _ := cm.GetSessionContext("s1")
+	if want := cm.tokenizer.Count(content); session.TotalTokens != want {
+		t.Errorf("expected default tokenizer count %d, got %d", want, session.TotalTokens)
+	}
+
+	// 切换提供商后按会话的计数器重新统计
+	if err := cm.SetSessionMetadata(ctx, "s1", map[string]string{"llm_provider": "words"}); err != nil {
+		t.Fatalf("SetSessionMetadata error: %v", err)
+	}
+	if err := cm.AddMessage(ctx, "s1", types.Message{Role: types.RoleAssistant, Content: "ok done"}); err != nil {
+		t.Fatalf("AddMessage error: %v", err)
+	}
+	if session, _ := cm.GetSessionContext("s1"); session.TotalTokens != 10 {
+		t.Errorf("expected session tokenizer count 10, got %d", session.TotalTokens)
+	}
+}
@@ -1,11 +1,13 @@
 package context
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/zboya/nala-coder/pkg/log"
 	"github.com/zboya/nala-coder/pkg/types"
@@ -16,6 +18,7 @@ import (
 type JSONStorage struct {
 	storagePath string
 	logger      log.Logger
+	usageMu     sync.Mutex
 }
 
 // NewJSONStorage 创建JSON存储
@@ -105,6 +108,66 @@ func (js *JSONStorage) DeleteSession(ctx context.Context, sessionID string) erro
 	return os.Remove(sessionPath)
 }
 
+// SaveUsage 追加一条用量记录到 usage.jsonl
+func (js *JSONStorage) SaveUsage(ctx context.Context, record types.UsageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+
+	js.usageMu.Lock()
+	defer js.usageMu.Unlock()
+
+	file, err := os.OpenFile(js.usagePath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open usage file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage record: %w", err)
+	}
+	return nil
+}
+
+// LoadUsage 按会话和时间范围加载用量记录
+func (js *JSONStorage) LoadUsage(ctx context.Context, query types.UsageQuery) ([]types.UsageRecord, error) {
+	js.usageMu.Lock()
+	defer js.usageMu.Unlock()
+
+	file, err := os.Open(js.usagePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []types.UsageRecord{}, nil
+		}
+		return nil, fmt.Errorf("failed to open usage file: %w", err)
+	}
+	defer file.Close()
+
+	records := make([]types.UsageRecord, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record types.UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			js.logger.Warnf("Failed to parse usage record: %v", err)
+			continue
+		}
+		if matchUsageQuery(record, query) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage file: %w", err)
+	}
+
+	return records, nil
+}
+
+// usagePath 用量记录文件路径
+func (js *JSONStorage) usagePath() string {
+	return filepath.Join(js.storagePath, "usage.jsonl")
+}
+
 // Close 关闭存储连接（JSON存储无需关闭）
 func (js *JSONStorage) Close() error {
 	return nil
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/zboya/nala-coder/pkg/log"
@@ -68,6 +69,24 @@ func (ss *SQLiteStorage) initTables() error {
 		return fmt.Errorf("failed to create sessions table: %w", err)
 	}
 
+	createUsageTable := `
+	CREATE TABLE IF NOT EXISTS usage (
+		id TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		model TEXT NOT NULL,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		cached_tokens INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_usage_session ON usage(session_id);
+	CREATE INDEX IF NOT EXISTS idx_usage_created_at ON usage(created_at)`
+
+	if _, err := ss.db.Exec(createUsageTable); err != nil {
+		return fmt.Errorf("failed to create usage table: %w", err)
+	}
+
 	return nil
 }
 
@@ -233,6 +252,94 @@ func (ss *SQLiteStorage) DeleteSession(ctx context.Context, sessionID string) er
 	return nil
 }
 
+// SaveUsage 追加一条用量记录
+func (ss *SQLiteStorage) SaveUsage(ctx context.Context, record types.UsageRecord) error {
+	query := `
+	INSERT INTO usage (
+		id, session_id, provider, model,
+		prompt_tokens, completion_tokens, cached_tokens, created_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := ss.db.ExecContext(ctx, query,
+		record.ID,
+		record.SessionID,
+		string(record.Provider),
+		record.Model,
+		record.PromptTokens,
+		record.CompletionTokens,
+		record.CachedTokens,
+		record.Timestamp.UTC(), // 统一使用UTC，保证按时间比较的顺序正确
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save usage record: %w", err)
+	}
+
+	return nil
+}
+
+// LoadUsage 按会话和时间范围加载用量记录
+func (ss *SQLiteStorage) LoadUsage(ctx context.Context, query types.UsageQuery) ([]types.UsageRecord, error) {
+	var conditions []string
+	var args []interface{}
+	if query.SessionID != "" {
+		conditions = append(conditions, "session_id = ?")
+		args = append(args, query.SessionID)
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, query.Since.UTC())
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, query.Until.UTC())
+	}
+
+	sqlQuery := `
+	SELECT id, session_id, provider, model,
+		   prompt_tokens, completion_tokens, cached_tokens, created_at
+	FROM usage`
+	if len(conditions) > 0 {
+		sqlQuery += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sqlQuery += " ORDER BY created_at"
+
+	rows, err := ss.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]types.UsageRecord, 0)
+	for rows.Next() {
+		var record types.UsageRecord
+		var provider string
+
+		err := rows.Scan(
+			&record.ID,
+			&record.SessionID,
+			&provider,
+			&record.Model,
+			&record.PromptTokens,
+			&record.CompletionTokens,
+			&record.CachedTokens,
+			&record.Timestamp,
+		)
+		if err != nil {
+			ss.logger.Warnf("Failed to scan usage row: %v", err)
+			continue
+		}
+
+		record.Provider = types.LLMProvider(provider)
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage: %w", err)
+	}
+
+	return records, nil
+}
+
 // Close 关闭数据库连接
 func (ss *SQLiteStorage) Close() error {
 	if ss.db != nil {
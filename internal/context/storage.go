@@ -32,6 +32,12 @@ type SessionStorage interface {
 	// DeleteSession 删除会话
 	DeleteSession(ctx context.Context, sessionID string) error
 
+	// SaveUsage 追加一条用量记录
+	SaveUsage(ctx context.Context, record types.UsageRecord) error
+
+	// LoadUsage 按会话和时间范围加载用量记录
+	LoadUsage(ctx context.Context, query types.UsageQuery) ([]types.UsageRecord, error)
+
 	// Close 关闭存储连接
 	Close() error
 }
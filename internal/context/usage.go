@@ -0,0 +1,143 @@
+package context
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// PriceTable 模型价格表
+type PriceTable []types.ModelPrice
+
+// Lookup 查找价格：先按提供商和模型精确匹配，再按模型匹配，最后使用提供商默认价格
+func (pt PriceTable) Lookup(provider types.LLMProvider, model string) (types.ModelPrice, bool) {
+	var modelMatch, providerMatch *types.ModelPrice
+
+	for i := range pt {
+		price := &pt[i]
+		providerOK := price.Provider == "" || strings.EqualFold(string(price.Provider), string(provider))
+		if !providerOK {
+			continue
+		}
+
+		switch {
+		case price.Model != "" && strings.EqualFold(price.Model, model):
+			if price.Provider != "" {
+				return *price, true
+			}
+			if modelMatch == nil {
+				modelMatch = price
+			}
+		case price.Model == "" && price.Provider != "" && providerMatch == nil:
+			providerMatch = price
+		}
+	}
+
+	if modelMatch != nil {
+		return *modelMatch, true
+	}
+	if providerMatch != nil {
+		return *providerMatch, true
+	}
+	return types.ModelPrice{}, false
+}
+
+// Cost 计算单条记录的费用（美元），价格表中没有的模型费用为0
+func (pt PriceTable) Cost(record types.UsageRecord) float64 {
+	price, ok := pt.Lookup(record.Provider, record.Model)
+	if !ok {
+		return 0
+	}
+
+	cachedPrice := price.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = price.Input
+	}
+
+	cached := record.CachedTokens
+	if cached > record.PromptTokens {
+		cached = record.PromptTokens
+	}
+	uncached := record.PromptTokens - cached
+
+	cost := float64(uncached)*price.Input + float64(cached)*cachedPrice + float64(record.CompletionTokens)*price.Output
+	return cost / 1_000_000
+}
+
+// BuildUsageReport 按指定维度汇总用量记录
+func BuildUsageReport(records []types.UsageRecord, groupBy types.UsageGroupBy, prices PriceTable) (*types.UsageReport, error) {
+	if groupBy == "" {
+		groupBy = types.UsageGroupByDay
+	}
+
+	keyOf, err := usageGroupKey(groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &types.UsageReport{GroupBy: groupBy, Rows: make([]types.UsageSummary, 0)}
+	rows := make(map[string]*types.UsageSummary)
+
+	for _, record := range records {
+		key := keyOf(record)
+		row, exists := rows[key]
+		if !exists {
+			row = &types.UsageSummary{Key: key}
+			rows[key] = row
+		}
+
+		cost := prices.Cost(record)
+		addUsage(row, record, cost)
+		addUsage(&report.Total, record, cost)
+	}
+
+	for _, row := range rows {
+		report.Rows = append(report.Rows, *row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		return report.Rows[i].Key < report.Rows[j].Key
+	})
+
+	report.Total.Key = "total"
+	return report, nil
+}
+
+// usageGroupKey 返回汇总维度对应的分组函数
+func usageGroupKey(groupBy types.UsageGroupBy) (func(types.UsageRecord) string, error) {
+	switch groupBy {
+	case types.UsageGroupBySession:
+		return func(record types.UsageRecord) string { return record.SessionID }, nil
+	case types.UsageGroupByDay:
+		return func(record types.UsageRecord) string { return record.Timestamp.Local().Format("2006-01-02") }, nil
+	case types.UsageGroupByProvider:
+		return func(record types.UsageRecord) string { return string(record.Provider) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported usage group: %s", groupBy)
+	}
+}
+
+// addUsage 将记录累加到汇总
+func addUsage(summary *types.UsageSummary, record types.UsageRecord, cost float64) {
+	summary.Calls++
+	summary.PromptTokens += record.PromptTokens
+	summary.CompletionTokens += record.CompletionTokens
+	summary.CachedTokens += record.CachedTokens
+	summary.TotalTokens += record.PromptTokens + record.CompletionTokens
+	summary.Cost += cost
+}
+
+// matchUsageQuery 判断记录是否满足查询条件
+func matchUsageQuery(record types.UsageRecord, query types.UsageQuery) bool {
+	if query.SessionID != "" && record.SessionID != query.SessionID {
+		return false
+	}
+	if !query.Since.IsZero() && record.Timestamp.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && !record.Timestamp.Before(query.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package context
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestPriceTableCost(t *testing.T) {
+	prices := PriceTable{
+		{Provider: types.ProviderDeepSeek, Input: 1, Output: 2},
+		{Model: "deepseek-chat", Input: 0.5, Output: 1, CachedInput: 0.1},
+		{Provider: types.ProviderDeepSeek, Model: "deepseek-chat", Input: 0.25, Output: 1, CachedInput: 0.05},
+	}
+
+	record := types.UsageRecord{
+		Provider:         types.ProviderDeepSeek,
+		Model:            "deepseek-chat",
+		PromptTokens:     1_000_000,
+		CompletionTokens: 1_000_000,
+		CachedTokens:     500_000,
+	}
+	// 提供商+模型精确匹配优先
+	if cost := prices.Cost(record); math.Abs(cost-(0.125+0.025+1)) > 1e-9 {
+		t.Errorf("unexpected exact-match cost: %v", cost)
+	}
+
+	// 其他模型使用提供商默认价格，未设置缓存价格时按输入价格计
+	record.Model = "deepseek-reasoner"
+	if cost := prices.Cost(record); math.Abs(cost-3) > 1e-9 {
+		t.Errorf("unexpected provider default cost: %v", cost)
+	}
+
+	record.Provider = types.ProviderOpenAI
+	if cost := prices.Cost(record); cost != 0 {
+		t.Errorf("expected zero cost for unpriced model, got %v", cost)
+	}
+}
+
+func TestUsageLedger(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+
+	for _, storageType := range []StorageType{StorageTypeJSON, StorageTypeSQLite} {
+		t.Run(string(storageType), func(t *testing.T) {
+			storage, err := NewSessionStorage(storageType, t.TempDir(), logger)
+			if err != nil {
+				t.Fatalf("NewSessionStorage error: %v", err)
+			}
+			defer storage.Close()
+
+			day1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local)
+			day2 := day1.AddDate(0, 0, 1)
+			records := []types.UsageRecord{
+				{ID: "1", SessionID: "s1", Provider: types.ProviderDeepSeek, Model: "deepseek-chat", PromptTokens: 100, CompletionTokens: 10, Timestamp: day1},
+				{ID: "2", SessionID: "s1", Provider: types.ProviderClaude, Model: "claude-sonnet", PromptTokens: 200, CompletionTokens: 20, CachedTokens: 150, Timestamp: day1.Add(time.Hour)},
+				{ID: "3", SessionID: "s2", Provider: types.ProviderDeepSeek, Model: "deepseek-chat", PromptTokens: 300, CompletionTokens: 30, Timestamp: day2},
+			}
+			for _, record := range records {
+				if err := storage.SaveUsage(context.Background(), record); err != nil {
+					t.Fatalf("SaveUsage error: %v", err)
+				}
+			}
+
+			loaded, err := storage.LoadUsage(context.Background(), types.UsageQuery{Since: day1.Add(30 * time.Minute)})
+			if err != nil {
+				t.Fatalf("LoadUsage error: %v", err)
+			}
+			if len(loaded) != 2 {
+				t.Fatalf("expected 2 records since filter, got %d", len(loaded))
+			}
+
+			loaded, err = storage.LoadUsage(context.Background(), types.UsageQuery{SessionID: "s1"})
+			if err != nil {
+				t.Fatalf("LoadUsage error: %v", err)
+			}
+			report, err := BuildUsageReport(loaded, types.UsageGroupByProvider, nil)
+			if err != nil {
+				t.Fatalf("BuildUsageReport error: %v", err)
+			}
+			if len(report.Rows) != 2 || report.Total.Calls != 2 || report.Total.TotalTokens != 330 || report.Total.CachedTokens != 150 {
+				t.Errorf("unexpected provider report: %+v", report)
+			}
+
+			loaded, _ = storage.LoadUsage(context.Background(), types.UsageQuery{})
+			report, _ = BuildUsageReport(loaded, types.UsageGroupByDay, nil)
+			if len(report.Rows) != 2 || report.Rows[0].Key != "2024-05-01" || report.Rows[0].Calls != 2 {
+				t.Errorf("unexpected daily report: %+v", report.Rows)
+			}
+		})
+	}
+}
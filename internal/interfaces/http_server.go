@@ -2,9 +2,12 @@ package interfaces
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,8 +19,12 @@ import (
 	"github.com/zboya/nala-coder/pkg/embedded"
 	"github.com/zboya/nala-coder/pkg/log"
 	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
 )
 
+// providerCheckTimeout 提供商健康检查的超时时间
+const providerCheckTimeout = 15 * time.Second
+
 // HTTPServer HTTP服务器
 type HTTPServer struct {
 	agent        types.Agent
@@ -52,8 +59,13 @@ func (s *HTTPServer) SetupRoutes() *gin.Engine {
 
 		// 会话管理
 		api.GET("/session/:id", s.handleGetSession)
+		api.POST("/session/:id/cancel", s.handleCancelSession)
+		api.POST("/session/:id/approvals/:approval_id", s.handleResolveApproval)
 		api.GET("/sessions", s.handleListSessions)
 
+		// 用量统计
+		api.GET("/usage", s.handleGetUsage)
+
 		// 文件浏览
 		api.GET("/files/tree", s.handleGetFileTree)
 		api.GET("/files/content", s.handleGetFileContent)
@@ -64,6 +76,7 @@ func (s *HTTPServer) SetupRoutes() *gin.Engine {
 		// 系统信息
 		api.GET("/health", s.handleHealth)
 		api.GET("/tools", s.handleGetTools)
+		api.GET("/providers", s.handleGetProviders)
 	}
 
 	// 设置嵌入式静态文件 - React构建后的资源
@@ -199,16 +212,22 @@ func (s *HTTPServer) SetupRoutes() *gin.Engine {
 
 // ChatRequest HTTP聊天请求
 type ChatRequest struct {
-	Message   string            `json:"message" binding:"required"`
-	SessionID string            `json:"session_id,omitempty"`
-	Stream    bool              `json:"stream,omitempty"`
-	Metadata  map[string]string `json:"metadata,omitempty"`
+	Message   string              `json:"message" binding:"required"`
+	SessionID string              `json:"session_id,omitempty"`
+	Stream    bool                `json:"stream,omitempty"`
+	Metadata  map[string]string   `json:"metadata,omitempty"`
+	Provider  string              `json:"provider,omitempty"`
+	Model     string              `json:"model,omitempty"`
+	Mode      string              `json:"mode,omitempty"`
+	Parts     []types.ContentPart `json:"parts,omitempty"`
 }
 
 // ChatResponse HTTP聊天响应
 type ChatResponse struct {
 	SessionID string                 `json:"session_id"`
 	Response  string                 `json:"response"`
+	Reasoning string                 `json:"reasoning,omitempty"`
+	Approval  *types.ToolApproval    `json:"approval,omitempty"`
 	Finished  bool                   `json:"finished"`
 	Usage     types.Usage            `json:"usage"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
@@ -242,10 +261,74 @@ type FileContentResponse struct {
 	ModTime  string `json:"mod_time"`
 }
 
+// bindChatRequest 解析聊天请求，支持JSON和带图片文件的 multipart/form-data
+func (s *HTTPServer) bindChatRequest(c *gin.Context) (ChatRequest, error) {
+	var req ChatRequest
+	if c.ContentType() != "multipart/form-data" {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return req, err
+		}
+	} else {
+		if err := c.Request.ParseMultipartForm(4 * utils.MaxImageSize); err != nil {
+			return req, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		req.Message = c.PostForm("message")
+		req.SessionID = c.PostForm("session_id")
+		req.Provider = c.PostForm("provider")
+		req.Model = c.PostForm("model")
+		req.Mode = c.PostForm("mode")
+		if req.Message == "" {
+			return req, fmt.Errorf("message is required")
+		}
+
+		for _, file := range c.Request.MultipartForm.File["images"] {
+			part, err := readImageFile(file)
+			if err != nil {
+				return req, fmt.Errorf("image %s: %w", file.Filename, err)
+			}
+			req.Parts = append(req.Parts, part)
+		}
+	}
+
+	// 提前校验图片，避免在模型调用时才失败。HTTP客户端只能上传图片数据，
+	// 不能让服务读取本机上的文件
+	for _, part := range req.Parts {
+		if part.Type != types.ContentPartImage {
+			continue
+		}
+		if part.Path != "" {
+			return req, fmt.Errorf("image path is not supported, send base64 data or upload the file")
+		}
+		if _, _, err := utils.ResolveImage(part); err != nil {
+			return req, err
+		}
+	}
+	return req, nil
+}
+
+// readImageFile 读取上传的图片文件
+func readImageFile(file *multipart.FileHeader) (types.ContentPart, error) {
+	if file.Size > utils.MaxImageSize {
+		return types.ContentPart{}, fmt.Errorf("image too large: %d bytes (max %d)", file.Size, utils.MaxImageSize)
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return types.ContentPart{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return types.ContentPart{}, err
+	}
+	return utils.ImagePart(data)
+}
+
 // handleChat 处理聊天请求
 func (s *HTTPServer) handleChat(c *gin.Context) {
-	var req ChatRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	req, err := s.bindChatRequest(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -257,6 +340,10 @@ func (s *HTTPServer) handleChat(c *gin.Context) {
 		SessionID: req.SessionID,
 		Stream:    false,
 		Metadata:  req.Metadata,
+		Provider:  types.LLMProvider(req.Provider),
+		Model:     req.Model,
+		Mode:      types.AgentMode(req.Mode),
+		Parts:     req.Parts,
 	}
 
 	// 调用Agent
@@ -264,6 +351,10 @@ func (s *HTTPServer) handleChat(c *gin.Context) {
 	defer cancel()
 
 	response, err := s.agent.Chat(ctx, agentReq)
+	if errors.Is(err, types.ErrTurnInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		s.logger.Errorf("Chat failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -284,8 +375,8 @@ func (s *HTTPServer) handleChat(c *gin.Context) {
 
 // handleChatStream 处理流式聊天请求
 func (s *HTTPServer) handleChatStream(c *gin.Context) {
-	var req ChatRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	req, err := s.bindChatRequest(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -303,6 +394,10 @@ func (s *HTTPServer) handleChatStream(c *gin.Context) {
 		SessionID: req.SessionID,
 		Stream:    true,
 		Metadata:  req.Metadata,
+		Provider:  types.LLMProvider(req.Provider),
+		Model:     req.Model,
+		Mode:      types.AgentMode(req.Mode),
+		Parts:     req.Parts,
 	}
 
 	// 调用Agent流式API
@@ -318,6 +413,25 @@ func (s *HTTPServer) handleChatStream(c *gin.Context) {
 
 	// 发送流式响应
 	for response := range stream {
+		if response.Reasoning != "" {
+			c.SSEvent("reasoning", ChatResponse{
+				SessionID: response.SessionID,
+				Reasoning: response.Reasoning,
+			})
+			c.Writer.Flush()
+			continue
+		}
+
+		// 工具调用等待确认，客户端通过 /api/session/:id/approvals/:approval_id 答复
+		if response.Approval != nil {
+			c.SSEvent("approval", ChatResponse{
+				SessionID: response.SessionID,
+				Approval:  response.Approval,
+			})
+			c.Writer.Flush()
+			continue
+		}
+
 		httpResp := ChatResponse{
 			SessionID: response.SessionID,
 			Response:  response.Response,
@@ -355,6 +469,45 @@ func (s *HTTPServer) handleGetSession(c *gin.Context) {
 	c.JSON(http.StatusOK, state)
 }
 
+// handleCancelSession 中断会话正在运行的一轮
+func (s *HTTPServer) handleCancelSession(c *gin.Context) {
+	sessionID := c.Param("id")
+	if !s.agent.CancelTurn(sessionID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no running turn for session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"cancelled":  true,
+	})
+}
+
+// handleResolveApproval 答复等待确认的工具调用
+func (s *HTTPServer) handleResolveApproval(c *gin.Context) {
+	var decision types.ApprovalDecision
+	if err := c.ShouldBindJSON(&decision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := s.agent.ResolveApproval(c.Param("id"), c.Param("approval_id"), decision)
+	if errors.Is(err, types.ErrApprovalNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"approval_id": c.Param("approval_id"),
+		"approved":    decision.Approved,
+		"remember":    decision.Remember,
+	})
+}
+
 // handleListSessions 列出所有会话
 func (s *HTTPServer) handleListSessions(c *gin.Context) {
 	// 这里需要实现会话列表功能
@@ -365,6 +518,44 @@ func (s *HTTPServer) handleListSessions(c *gin.Context) {
 	})
 }
 
+// handleGetUsage 按会话、日期或提供商汇总token用量和费用
+func (s *HTTPServer) handleGetUsage(c *gin.Context) {
+	since, err := utils.ParseDate(c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	until, err := utils.ParseDateEnd(c.Query("until"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := types.UsageGroupBy(c.DefaultQuery("group_by", string(types.UsageGroupByDay)))
+	switch groupBy {
+	case types.UsageGroupBySession, types.UsageGroupByDay, types.UsageGroupByProvider:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported group_by: %s", groupBy)})
+		return
+	}
+
+	query := types.UsageQuery{
+		SessionID: c.Query("session_id"),
+		Since:     since,
+		Until:     until,
+		GroupBy:   groupBy,
+	}
+
+	report, err := s.agent.GetUsageReport(c.Request.Context(), query)
+	if err != nil {
+		s.logger.Errorf("Failed to get usage report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
 // handleHealth 健康检查
 func (s *HTTPServer) handleHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -374,6 +565,18 @@ func (s *HTTPServer) handleHealth(c *gin.Context) {
 	})
 }
 
+// handleGetProviders 检查各提供商的连通性、延迟和可用模型
+func (s *HTTPServer) handleGetProviders(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), providerCheckTimeout)
+	defer cancel()
+
+	providers := s.agent.CheckProviders(ctx)
+	c.JSON(http.StatusOK, gin.H{
+		"providers": providers,
+		"count":     len(providers),
+	})
+}
+
 // handleGetTools 获取可用工具列表
 func (s *HTTPServer) handleGetTools(c *gin.Context) {
 	// 这里需要通过Agent获取工具列表
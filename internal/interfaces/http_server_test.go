@@ -0,0 +1,249 @@
+package interfaces
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zboya/nala-coder/internal/agent"
+	contextmgr "github.com/zboya/nala-coder/internal/context"
+	"github.com/zboya/nala-coder/internal/llm"
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+const chatStreamScript = `
+turns:
+  - reasoning: "先看一下"
+    tool_calls:
+      - name: missing
+        arguments: {}
+  - content: "没有找到这个工具，"
+    chunk_size: 4
+  - error: "upstream overloaded"
+`
+
+// newTestServer 通过构建器创建使用模拟提供商的HTTP服务
+func newTestServer(t *testing.T, script string) *gin.Engine {
+	t.Helper()
+
+	dir := t.TempDir()
+	scriptPath := filepath.Join(dir, "script.yaml")
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	config := &agent.AppConfig{
+		LLM: llm.Config{
+			DefaultProvider: types.ProviderMock,
+			Mock:            types.LLMConfig{Script: scriptPath, Retry: types.RetryConfig{MaxRetries: -1}},
+		},
+		Agent:   agent.Config{MaxLoops: 5},
+		Context: contextmgr.Config{HistoryLimit: 50, StoragePath: filepath.Join(dir, "sessions"), StorageType: contextmgr.StorageTypeJSON},
+		Prompts: agent.PromptsConfig{Directory: filepath.Join(dir, "prompts")},
+	}
+
+	logger, _ := log.New(log.DefaultConfig())
+	a, err := agent.NewBuilder(config, logger).Build()
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	return NewHTTPServer(a, logger, types.SpeechConfig{}).SetupRoutes()
+}
+
+// sseEvent 一个SSE事件
+type sseEvent struct {
+	name string
+	data ChatResponse
+}
+
+// readSSE 解析SSE响应体，end 事件没有数据
+func readSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			current.name = strings.TrimPrefix(line, "event:")
+		case strings.HasPrefix(line, "data:") && current.name != "end":
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &current.data); err != nil {
+				t.Fatalf("invalid SSE data %q: %v", line, err)
+			}
+		case line == "" && current.name != "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+func TestHandleChatStream(t *testing.T) {
+	router := newTestServer(t, chatStreamScript)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/chat/stream", strings.NewReader(`{"message":"调用工具","session_id":"s1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("unexpected response: %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	var reasoning, content strings.Builder
+	var final ChatResponse
+	events := readSSE(t, recorder.Body.String())
+	if last := events[len(events)-1]; last.name != "end" {
+		t.Errorf("expected end event, got %+v", last)
+	}
+	for _, event := range events {
+		switch event.name {
+		case "reasoning":
+			reasoning.WriteString(event.data.Reasoning)
+		case "message":
+			content.WriteString(event.data.Response)
+			if event.data.Finished {
+				final = event.data
+			}
+		}
+	}
+
+	if reasoning.String() != "先看一下" || content.String() != "没有找到这个工具，" {
+		t.Errorf("unexpected reasoning %q, content %q", reasoning.String(), content.String())
+	}
+	if final.SessionID != "s1" || final.Metadata["loop_completed"] != true {
+		t.Errorf("unexpected final event: %+v", final)
+	}
+
+	// 第三轮脚本返回错误，以 finished 的 message 事件携带错误
+	request = httptest.NewRequest(http.MethodPost, "/api/chat/stream", strings.NewReader(`{"message":"再试一次","session_id":"s1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	events = readSSE(t, recorder.Body.String())
+	if len(events) != 2 || events[1].name != "end" || !events[0].data.Finished || !strings.Contains(fmt.Sprint(events[0].data.Metadata["error"]), "upstream overloaded") {
+		t.Errorf("expected error event, got %+v", events)
+	}
+}
+
+func TestHandleChatBadRequest(t *testing.T) {
+	router := newTestServer(t, "turns: []")
+
+	request := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"session_id":"s1"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// 图片路径不被接受，无论文件是否存在
+	image := filepath.Join(t.TempDir(), "a.png")
+	if err := os.WriteFile(image, []byte("\x89PNG\r\n\x1a\n"), 0644); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	var bodies []string
+	for _, path := range []string{image, image + ".missing"} {
+		body := fmt.Sprintf(`{"message":"看图","parts":[{"type":"image","path":%q}]}`, path)
+		request := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for image path, got %d: %s", recorder.Code, recorder.Body.String())
+		}
+		bodies = append(bodies, recorder.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("expected the same error for existing and missing files: %v", bodies)
+	}
+}
+
+func TestHandleGetProviders(t *testing.T) {
+	router := newTestServer(t, "turns: []")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/providers", nil))
+
+	var body struct {
+		Providers []types.ProviderStatus `json:"providers"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("unexpected response %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if len(body.Providers) != 1 || body.Providers[0].Provider != types.ProviderMock || !body.Providers[0].Reachable || !body.Providers[0].Default {
+		t.Errorf("unexpected providers: %+v", body.Providers)
+	}
+}
+
+func TestHandleCancelSession(t *testing.T) {
+	router := newTestServer(t, "turns:\n  - content: \"太慢了\"\n    delay: 10000\n")
+
+	cancel := func() int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/session/s1/cancel", nil))
+		return recorder.Code
+	}
+	if code := cancel(); code != http.StatusNotFound {
+		t.Errorf("expected 404 without running turn, got %d", code)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		request := httptest.NewRequest(http.MethodPost, "/api/chat/stream", strings.NewReader(`{"message":"你好","session_id":"s1"}`))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		done <- recorder
+	}()
+
+	for cancel() != http.StatusOK {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var recorder *httptest.ResponseRecorder
+	select {
+	case recorder = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream did not end after cancellation")
+	}
+
+	events := readSSE(t, recorder.Body.String())
+	if len(events) != 2 || !events[0].data.Finished || events[0].data.Metadata["interrupted"] != true {
+		t.Errorf("expected interrupted event, got %+v", events)
+	}
+}
+
+func TestHandleResolveApproval(t *testing.T) {
+	router := newTestServer(t, "turns: []")
+
+	resolve := func(body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/session/s1/approvals/a1", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := resolve(`{"approved":true}`); code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown approval, got %d", code)
+	}
+	if code := resolve(`{"approved":true,"remember":"forever"}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid scope, got %d", code)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/zboya/nala-coder/pkg/log"
 	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
 )
 
 // ClaudeClient Claude客户端
@@ -23,40 +26,118 @@ type ClaudeClient struct {
 
 // ClaudeMessage Claude消息格式
 type ClaudeMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string               `json:"role"`
+	Content []ClaudeContentBlock `json:"content"`
+}
+
+// ClaudeContentBlock Claude内容块，覆盖 text、tool_use、tool_result 以及 thinking、redacted_thinking 类型
+type ClaudeContentBlock struct {
+	Type      string             `json:"type"`
+	Text      string             `json:"text,omitempty"`
+	Thinking  string             `json:"thinking,omitempty"`
+	Signature string             `json:"signature,omitempty"`
+	Data      string             `json:"data,omitempty"`
+	ID        string             `json:"id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Input     json.RawMessage    `json:"input,omitempty"`
+	ToolUseID string             `json:"tool_use_id,omitempty"`
+	Content   any                `json:"content,omitempty"` // tool_result 内容：文本或内容块列表
+	IsError   bool               `json:"is_error,omitempty"`
+	Source    *ClaudeImageSource `json:"source,omitempty"`
+
+	CacheControl *ClaudeCacheControl `json:"cache_control,omitempty"`
+}
+
+// ClaudeCacheControl Claude提示词缓存断点，断点及之前的前缀会被缓存供后续请求复用
+type ClaudeCacheControl struct {
+	Type string `json:"type"`
+}
+
+// ClaudeImageSource Claude图片数据
+type ClaudeImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// ClaudeTool Claude工具定义
+type ClaudeTool struct {
+	Name        string                          `json:"name"`
+	Description string                          `json:"description,omitempty"`
+	InputSchema types.ToolCallFunctionArguments `json:"input_schema"`
+
+	CacheControl *ClaudeCacheControl `json:"cache_control,omitempty"`
 }
 
 // ClaudeRequest Claude请求格式
 type ClaudeRequest struct {
-	Model     string          `json:"model"`
-	MaxTokens int             `json:"max_tokens"`
-	Messages  []ClaudeMessage `json:"messages"`
-	System    string          `json:"system,omitempty"`
-	Stream    bool            `json:"stream,omitempty"`
+	Model      string               `json:"model"`
+	MaxTokens  int                  `json:"max_tokens"`
+	Messages   []ClaudeMessage      `json:"messages"`
+	System     []ClaudeContentBlock `json:"system,omitempty"`
+	Tools      []ClaudeTool         `json:"tools,omitempty"`
+	ToolChoice *ClaudeToolChoice    `json:"tool_choice,omitempty"`
+	Thinking   *ClaudeThinking      `json:"thinking,omitempty"`
+	Stream     bool                 `json:"stream,omitempty"`
+}
+
+// ClaudeToolChoice Claude工具选择，type 为 tool 时强制调用指定工具
+type ClaudeToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// ClaudeThinking Claude扩展思考配置
+type ClaudeThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// ClaudeUsage Claude token使用情况
+type ClaudeUsage struct {
+	InputTokens              int `json:"input_tokens"` // 不包含读写缓存的部分
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // ClaudeResponse Claude响应格式
 type ClaudeResponse struct {
-	ID      string `json:"id"`
-	Type    string `json:"type"`
-	Role    string `json:"role"`
-	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
-	} `json:"content"`
-	Model string `json:"model"`
-	Usage struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+	ID         string               `json:"id"`
+	Type       string               `json:"type"`
+	Role       string               `json:"role"`
+	Content    []ClaudeContentBlock `json:"content"`
+	Model      string               `json:"model"`
+	StopReason string               `json:"stop_reason"`
+	Usage      ClaudeUsage          `json:"usage"`
+}
+
+// ClaudeStreamEvent Claude流式事件
+type ClaudeStreamEvent struct {
+	Type         string              `json:"type"`
+	Index        int                 `json:"index"`
+	Message      *ClaudeResponse     `json:"message,omitempty"`
+	ContentBlock *ClaudeContentBlock `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text,omitempty"`
+		Thinking    string `json:"thinking,omitempty"`
+		Signature   string `json:"signature,omitempty"`
+		PartialJSON string `json:"partial_json,omitempty"`
+		StopReason  string `json:"stop_reason,omitempty"`
+	} `json:"delta,omitempty"`
+	Usage *ClaudeUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
 }
 
 // NewClaudeClient 创建Claude客户端
 func NewClaudeClient(config types.LLMConfig, logger log.Logger) *ClaudeClient {
 	return &ClaudeClient{
 		config:     config,
-		httpClient: &http.Client{},
+		httpClient: newHTTPClient(),
 		logger:     logger,
 	}
 }
@@ -68,44 +149,28 @@ func (c *ClaudeClient) GetConfig() types.LLMConfig {
 
 // GetProvider 获取提供商
 func (c *ClaudeClient) GetProvider() types.LLMProvider {
-	return types.ProviderClaude
+	return providerName(c.config, types.ProviderClaude)
 }
 
 // Chat 对话
 func (c *ClaudeClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
 	claudeReq := c.convertRequest(request)
+	claudeReq.Stream = false
 
-	reqBody, err := json.Marshal(claudeReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+"/v1/messages", bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", c.config.APIKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.doRequest(ctx, claudeReq)
 	if err != nil {
 		return nil, fmt.Errorf("Claude API error: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Claude API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
 	var claudeResp ClaudeResponse
 	if err := json.NewDecoder(resp.Body).Decode(&claudeResp); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return c.convertResponse(claudeResp), nil
+	response := c.convertResponse(claudeResp)
+	c.extractStructuredOutput(request.ResponseFormat, response)
+	return response, nil
 }
 
 // ChatStream 流式对话
@@ -113,22 +178,7 @@ func (c *ClaudeClient) ChatStream(ctx context.Context, request types.LLMRequest)
 	claudeReq := c.convertRequest(request)
 	claudeReq.Stream = true
 
-	reqBody, err := json.Marshal(claudeReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+"/v1/messages", bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", c.config.APIKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-	httpReq.Header.Set("Accept", "text/event-stream")
-
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err := c.doRequest(ctx, claudeReq)
 	if err != nil {
 		return nil, fmt.Errorf("Claude stream API error: %w", err)
 	}
@@ -140,90 +190,597 @@ func (c *ClaudeClient) ChatStream(ctx context.Context, request types.LLMRequest)
 		defer resp.Body.Close()
 
 		scanner := bufio.NewScanner(resp.Body)
-		var fullContent strings.Builder
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+		var responseID string
+		// 输入token在 message_start 中返回，输出token在 message_delta 中累计返回
+		var usage ClaudeUsage
+		var stopReason string
+		// 使用map来跟踪正在构建的工具调用，key是内容块的index
+		toolCallsMap := make(map[int]*types.ToolCall)
+		toolCallOrder := make([]int, 0)
+		// 思考块需原样保留签名，才能在后续轮次回传
+		thinkingBlocks := make(map[int]*ClaudeContentBlock)
+		thinkingOrder := make([]int, 0)
+
+		buildFinalResponse := func() types.LLMResponse {
+			// 按内容块顺序构建最终的工具调用数组
+			var toolCalls []types.ToolCall
+			for _, index := range toolCallOrder {
+				tc := *toolCallsMap[index]
+				if tc.Function.Arguments == "" {
+					tc.Function.Arguments = "{}"
+				}
+				toolCalls = append(toolCalls, tc)
+			}
+
+			var thinking []ClaudeContentBlock
+			for _, index := range thinkingOrder {
+				thinking = append(thinking, *thinkingBlocks[index])
+			}
+
+			response := types.LLMResponse{
+				ID:           responseID,
+				Content:      "",
+				Role:         "assistant",
+				ToolCalls:    toolCalls,
+				Usage:        c.convertUsage(usage),
+				Metadata:     c.reasoningState(thinking),
+				FinishReason: normalizeFinishReason(stopReason, len(toolCalls) > 0),
+			}
+			c.extractStructuredOutput(request.ResponseFormat, &response)
+			return response
+		}
 
+		// 兼容接口可能以 [DONE] 结束，其他情况下流在 message_stop 之前结束视为中断
+		done := false
 		for scanner.Scan() {
 			line := scanner.Text()
-			if strings.HasPrefix(line, "data: ") {
-				data := strings.TrimPrefix(line, "data: ")
-				if data == "[DONE]" {
-					break
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				done = true
+				break
+			}
+
+			var event ClaudeStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				c.logger.Debugf("Failed to parse Claude stream event: %v", err)
+				continue
+			}
+
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					responseID = event.Message.ID
+					usage = event.Message.Usage
 				}
 
-				var event map[string]interface{}
-				if err := json.Unmarshal([]byte(data), &event); err != nil {
-					continue
+			case "message_delta":
+				if event.Usage != nil {
+					usage.OutputTokens = event.Usage.OutputTokens
+				}
+				if event.Delta != nil && event.Delta.StopReason != "" {
+					stopReason = event.Delta.StopReason
+				}
+
+			case "content_block_start":
+				if event.ContentBlock != nil && (event.ContentBlock.Type == "thinking" || event.ContentBlock.Type == "redacted_thinking") {
+					block := *event.ContentBlock
+					thinkingBlocks[event.Index] = &block
+					thinkingOrder = append(thinkingOrder, event.Index)
 				}
 
-				if event["type"] == "content_block_delta" {
-					if delta, ok := event["delta"].(map[string]interface{}); ok {
-						if text, ok := delta["text"].(string); ok {
-							fullContent.WriteString(text)
-
-							streamResp := types.LLMResponse{
-								Content: text,
-								Role:    "assistant",
-							}
-							responseChan <- streamResp
-						}
+				// 工具调用以 tool_use 内容块开始，参数随后以 input_json_delta 增量到达
+				if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+					toolCallsMap[event.Index] = &types.ToolCall{
+						ID:   event.ContentBlock.ID,
+						Type: "function",
+						Function: types.ToolCallFunction{
+							Name:      event.ContentBlock.Name,
+							Arguments: "",
+						},
+					}
+					toolCallOrder = append(toolCallOrder, event.Index)
+				}
+
+			case "content_block_delta":
+				if event.Delta == nil {
+					continue
+				}
+				switch event.Delta.Type {
+				case "text_delta":
+					if event.Delta.Text == "" {
+						continue
+					}
+					streamResp := types.LLMResponse{
+						ID:      responseID,
+						Content: event.Delta.Text,
+						Role:    "assistant",
+					}
+					select {
+					case responseChan <- streamResp:
+					case <-ctx.Done():
+						return
+					}
+				case "thinking_delta":
+					if block, exists := thinkingBlocks[event.Index]; exists {
+						block.Thinking += event.Delta.Thinking
+					}
+					if event.Delta.Thinking == "" {
+						continue
+					}
+					streamResp := types.LLMResponse{
+						ID:        responseID,
+						Reasoning: event.Delta.Thinking,
+						Role:      "assistant",
+					}
+					select {
+					case responseChan <- streamResp:
+					case <-ctx.Done():
+						return
+					}
+				case "signature_delta":
+					if block, exists := thinkingBlocks[event.Index]; exists {
+						block.Signature += event.Delta.Signature
+					}
+				case "input_json_delta":
+					// 累积arguments
+					if tc, exists := toolCallsMap[event.Index]; exists {
+						tc.Function.Arguments += event.Delta.PartialJSON
 					}
 				}
+
+			case "message_stop":
+				select {
+				case responseChan <- buildFinalResponse():
+				case <-ctx.Done():
+				}
+				return
+
+			case "error":
+				// 流中途出错（如 overloaded_error），以错误结束本次响应
+				streamErr := errors.New("Claude stream error")
+				if event.Error != nil {
+					streamErr = fmt.Errorf("Claude stream error: %s: %s", event.Error.Type, event.Error.Message)
+				}
+				c.logger.Errorf("%v", streamErr)
+				select {
+				case responseChan <- streamErrorResponse(streamErr):
+				case <-ctx.Done():
+				}
+				return
 			}
 		}
 
-		// 发送最终响应
-		final := types.LLMResponse{
-			Content: fullContent.String(),
-			Role:    "assistant",
+		// 发送最终响应，读取失败或未收到 message_stop 时以错误结束
+		final := buildFinalResponse()
+		if err := scanner.Err(); err != nil {
+			c.logger.Errorf("Claude stream read error: %v", err)
+			final = streamErrorResponse(fmt.Errorf("Claude stream read error: %w", err))
+		} else if !done {
+			c.logger.Errorf("Claude stream ended before message_stop")
+			final = streamErrorResponse(errors.New("Claude stream ended before message_stop"))
+		}
+		select {
+		case responseChan <- final:
+		case <-ctx.Done():
 		}
-		responseChan <- final
 	}()
 
 	return responseChan, nil
 }
 
+// ClaudeModelList 模型列表接口响应
+type ClaudeModelList struct {
+	Data []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"data"`
+}
+
+// Ping 通过模型列表接口检查连通性和密钥
+func (c *ClaudeClient) Ping(ctx context.Context) error {
+	_, err := c.ListModels(ctx)
+	return err
+}
+
+// ListModels 列出 /v1/models 返回的模型
+func (c *ClaudeClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	baseURL := c.config.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
+	}
+	header := http.Header{
+		"X-Api-Key":         []string{c.config.APIKey},
+		"Anthropic-Version": []string{"2023-06-01"},
+	}
+
+	var list ClaudeModelList
+	if err := getJSON(ctx, c.httpClient, c.GetProvider(), strings.TrimSuffix(baseURL, "/")+"/v1/models?limit=1000", header, &list); err != nil {
+		return nil, err
+	}
+
+	models := make([]types.ModelInfo, 0, len(list.Data))
+	for _, model := range list.Data {
+		models = append(models, types.ModelInfo{ID: model.ID, OwnedBy: "anthropic"})
+	}
+	return models, nil
+}
+
+// Capabilities 接口不返回模型能力，按模型名推断
+func (c *ClaudeClient) Capabilities(ctx context.Context, model string) (types.ModelCapabilities, error) {
+	return inferCapabilities(types.ProviderClaude, c.getModel(model)), nil
+}
+
+// doRequest 发送请求，非200状态码视为错误
+func (c *ClaudeClient) doRequest(ctx context.Context, claudeReq ClaudeRequest) (*http.Response, error) {
+	reqBody, err := json.Marshal(claudeReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.getEndpoint(), bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", c.config.APIKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	if claudeReq.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{
+			Provider:   c.GetProvider(),
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	return resp, nil
+}
+
 // convertRequest 转换请求格式
 func (c *ClaudeClient) convertRequest(request types.LLMRequest) ClaudeRequest {
 	messages := make([]ClaudeMessage, 0)
 	var systemMessage string
 
 	for _, msg := range request.Messages {
-		if msg.Role == types.RoleSystem {
+		var role string
+		var blocks []ClaudeContentBlock
+
+		switch msg.Role {
+		case types.RoleSystem:
 			systemMessage = msg.Content
-		} else {
-			messages = append(messages, ClaudeMessage{
-				Role:    string(msg.Role),
-				Content: msg.Content,
-			})
+			continue
+
+		case types.RoleTool:
+			// 工具结果以 user 角色的 tool_result 内容块回传
+			toolCallID := msg.ToolCallID
+			if toolCallID == "" {
+				toolCallID = msg.Metadata["tool_call_id"]
+			}
+			role = string(types.RoleUser)
+			var content any = msg.Content
+			// 工具返回的图片放在 tool_result 内部，保证 tool_result 位于消息开头
+			if images := c.convertImages(msg.Parts); len(images) > 0 {
+				content = append([]ClaudeContentBlock{{Type: "text", Text: msg.Content}}, images...)
+			}
+			blocks = []ClaudeContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: toolCallID,
+				Content:   content,
+				IsError:   msg.Metadata["success"] == "false",
+			}}
+
+		case types.RoleAssistant:
+			role = string(types.RoleAssistant)
+			// 开启扩展思考后，工具调用所在的助手消息必须带回思考块
+			if c.config.SendReasoning || c.config.ThinkingBudget > 0 {
+				blocks = append(blocks, c.thinkingBlocks(msg)...)
+			}
+			if msg.Content != "" {
+				blocks = append(blocks, ClaudeContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				blocks = append(blocks, ClaudeContentBlock{
+					Type:  "tool_use",
+					ID:    tc.ID,
+					Name:  tc.Function.Name,
+					Input: c.convertToolInput(tc.Function.Arguments),
+				})
+			}
+
+		default:
+			role = string(types.RoleUser)
+			if msg.Content != "" {
+				blocks = append(blocks, ClaudeContentBlock{Type: "text", Text: msg.Content})
+			}
+			blocks = append(blocks, c.convertImages(msg.Parts)...)
 		}
+
+		// Claude 不接受空内容块
+		if len(blocks) == 0 {
+			continue
+		}
+
+		// Claude 要求 user/assistant 交替出现，相邻同角色消息合并为一条
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			continue
+		}
+
+		messages = append(messages, ClaudeMessage{
+			Role:    role,
+			Content: blocks,
+		})
 	}
 
-	return ClaudeRequest{
+	claudeReq := ClaudeRequest{
 		Model:     c.getModel(request.Model),
 		MaxTokens: c.getMaxTokens(request.MaxTokens),
 		Messages:  messages,
-		System:    systemMessage,
+		Tools:     c.convertTools(request.Tools),
 		Stream:    request.Stream,
 	}
+	if systemMessage != "" {
+		claudeReq.System = []ClaudeContentBlock{{Type: "text", Text: systemMessage}}
+	}
+	setCacheBreakpoints(&claudeReq)
+	if c.config.ThinkingBudget > 0 {
+		claudeReq.Thinking = &ClaudeThinking{Type: "enabled", BudgetTokens: c.config.ThinkingBudget}
+	}
+
+	// Claude 没有响应格式参数，通过强制调用以 Schema 为参数的工具获得结构化输出
+	if format := request.ResponseFormat; format != nil {
+		claudeReq.Tools = append(claudeReq.Tools, ClaudeTool{
+			Name:        format.Name,
+			Description: format.Description,
+			InputSchema: structuredInputSchema(format.Schema),
+		})
+		claudeReq.ToolChoice = &ClaudeToolChoice{Type: "tool", Name: format.Name}
+		// 强制工具调用与扩展思考不能同时使用
+		claudeReq.Thinking = nil
+	}
+
+	return claudeReq
+}
+
+// setCacheBreakpoints 设置提示词缓存断点。缓存按工具、系统提示词、消息的顺序匹配前缀：
+// 系统提示词（没有时为最后一个工具）缓存固定部分，最后一条消息缓存本次完整历史供下一轮读取，
+// 上一条用户消息命中上一轮写入的缓存。Claude 最多允许4个断点
+func setCacheBreakpoints(req *ClaudeRequest) {
+	if n := len(req.System); n > 0 {
+		req.System[n-1].CacheControl = &ClaudeCacheControl{Type: "ephemeral"}
+	} else if n := len(req.Tools); n > 0 {
+		req.Tools[n-1].CacheControl = &ClaudeCacheControl{Type: "ephemeral"}
+	}
+
+	last := len(req.Messages) - 1
+	if last < 0 {
+		return
+	}
+	markCacheBreakpoint(&req.Messages[last])
+
+	for i := last - 1; i >= 0; i-- {
+		if req.Messages[i].Role == string(types.RoleUser) {
+			markCacheBreakpoint(&req.Messages[i])
+			break
+		}
+	}
+}
+
+// markCacheBreakpoint 在消息最后一个可缓存的内容块上设置断点，思考块不能设置断点
+func markCacheBreakpoint(msg *ClaudeMessage) {
+	for i := len(msg.Content) - 1; i >= 0; i-- {
+		block := &msg.Content[i]
+		if block.Type == "thinking" || block.Type == "redacted_thinking" {
+			continue
+		}
+		block.CacheControl = &ClaudeCacheControl{Type: "ephemeral"}
+		return
+	}
+}
+
+// structuredInputSchema 工具参数必须是对象，其他类型的 Schema 包装在 value 字段中
+func structuredInputSchema(schema map[string]any) types.ToolCallFunctionArguments {
+	if schema["type"] == "object" {
+		return schema
+	}
+	return types.ToolCallFunctionArguments{
+		"type":       "object",
+		"properties": map[string]any{structuredValueKey: schema},
+		"required":   []string{structuredValueKey},
+	}
+}
+
+// structuredValueKey 包装非对象 Schema 使用的字段名
+const structuredValueKey = "value"
+
+// extractStructuredOutput 将强制调用的工具参数作为响应内容，并移除该工具调用
+func (c *ClaudeClient) extractStructuredOutput(format *types.ResponseFormat, response *types.LLMResponse) {
+	if format == nil {
+		return
+	}
+
+	for i, tc := range response.ToolCalls {
+		if tc.Function.Name != format.Name {
+			continue
+		}
+
+		content := tc.Function.Arguments
+		if format.Schema["type"] != "object" {
+			var wrapped map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(content), &wrapped); err == nil {
+				content = string(wrapped[structuredValueKey])
+			}
+		}
+
+		response.Content = content
+		response.ToolCalls = append(response.ToolCalls[:i], response.ToolCalls[i+1:]...)
+		if response.FinishReason == types.FinishReasonToolCalls && len(response.ToolCalls) == 0 {
+			response.FinishReason = types.FinishReasonStop
+		}
+		return
+	}
+}
+
+// convertImages 将图片片段转换为 base64 图片块
+func (c *ClaudeClient) convertImages(parts []types.ContentPart) []ClaudeContentBlock {
+	var blocks []ClaudeContentBlock
+	for _, part := range parts {
+		if part.Type != types.ContentPartImage {
+			continue
+		}
+		mediaType, data, err := utils.ResolveImage(part)
+		if err != nil {
+			c.logger.Warnf("Skip image: %v", err)
+			continue
+		}
+		blocks = append(blocks, ClaudeContentBlock{
+			Type: "image",
+			Source: &ClaudeImageSource{
+				Type:      "base64",
+				MediaType: mediaType,
+				Data:      base64.StdEncoding.EncodeToString(data),
+			},
+		})
+	}
+	return blocks
+}
+
+// thinkingBlocks 还原消息中保存的思考块，没有签名的思考内容无法回传
+func (c *ClaudeClient) thinkingBlocks(msg types.Message) []ClaudeContentBlock {
+	state := msg.Metadata[types.MetadataReasoningState]
+	if state == "" {
+		return nil
+	}
+
+	var blocks []ClaudeContentBlock
+	if err := json.Unmarshal([]byte(state), &blocks); err != nil {
+		c.logger.Warnf("Failed to restore Claude thinking blocks: %v", err)
+		return nil
+	}
+	return blocks
+}
+
+// reasoningState 将思考块序列化到响应元数据，供后续轮次回传
+func (c *ClaudeClient) reasoningState(blocks []ClaudeContentBlock) map[string]string {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(blocks)
+	if err != nil {
+		return nil
+	}
+	return map[string]string{types.MetadataReasoningState: string(data)}
+}
+
+// convertTools 转换工具格式
+func (c *ClaudeClient) convertTools(tools []types.Tool) []ClaudeTool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	result := make([]ClaudeTool, len(tools))
+	for i, tool := range tools {
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = types.ToolCallFunctionArguments{"type": "object", "properties": map[string]any{}}
+		}
+		result[i] = ClaudeTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		}
+	}
+
+	return result
+}
+
+// convertToolInput 将工具调用参数转换为 tool_use 的 input 字段，非法JSON回退为空对象
+func (c *ClaudeClient) convertToolInput(arguments string) json.RawMessage {
+	if strings.TrimSpace(arguments) == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
 }
 
 // convertResponse 转换响应格式
 func (c *ClaudeClient) convertResponse(resp ClaudeResponse) *types.LLMResponse {
-	var content string
-	if len(resp.Content) > 0 {
-		content = resp.Content[0].Text
+	var content, reasoning strings.Builder
+	var toolCalls []types.ToolCall
+	var thinking []ClaudeContentBlock
+
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "thinking":
+			reasoning.WriteString(block.Thinking)
+			thinking = append(thinking, block)
+		case "redacted_thinking":
+			thinking = append(thinking, block)
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, types.ToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: types.ToolCallFunction{
+					Name:      block.Name,
+					Arguments: arguments,
+				},
+			})
+		}
 	}
 
 	return &types.LLMResponse{
-		ID:      resp.ID,
-		Content: content,
-		Role:    resp.Role,
-		Usage: types.Usage{
-			PromptTokens:     resp.Usage.InputTokens,
-			CompletionTokens: resp.Usage.OutputTokens,
-			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
-		},
+		ID:           resp.ID,
+		Content:      content.String(),
+		Reasoning:    reasoning.String(),
+		Role:         resp.Role,
+		ToolCalls:    toolCalls,
+		Usage:        c.convertUsage(resp.Usage),
+		Metadata:     c.reasoningState(thinking),
+		FinishReason: normalizeFinishReason(resp.StopReason, len(toolCalls) > 0),
+	}
+}
+
+// convertUsage 转换用量
+func (c *ClaudeClient) convertUsage(usage ClaudeUsage) types.Usage {
+	promptTokens := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	return types.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      promptTokens + usage.OutputTokens,
+		CachedTokens:     usage.CacheReadInputTokens,
+	}
+}
+
+// getEndpoint 获取 Messages API 地址
+func (c *ClaudeClient) getEndpoint() string {
+	baseURL := c.config.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
 	}
+	return strings.TrimSuffix(baseURL, "/") + "/v1/messages"
 }
 
 // getModel 获取模型名称
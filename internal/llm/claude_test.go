@@ -0,0 +1,433 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func newClaudeTestClient(t *testing.T, handler http.HandlerFunc) *ClaudeClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	logger, _ := log.New(log.DefaultConfig())
+	return NewClaudeClient(types.LLMConfig{
+		APIKey:    "test-key",
+		BaseURL:   server.URL + "/",
+		Model:     "claude-test",
+		MaxTokens: 1024,
+	}, logger)
+}
+
+func claudeTestRequest() types.LLMRequest {
+	return types.LLMRequest{
+		Messages: []types.Message{
+			{Role: types.RoleSystem, Content: "You are a helpful assistant."},
+			{Role: types.RoleUser, Content: "今天北京的天气怎么样？"},
+			{
+				Role: types.RoleAssistant,
+				ToolCalls: []types.ToolCall{{
+					ID:   "toolu_01",
+					Type: "function",
+					Function: types.ToolCallFunction{
+						Name:      "get_weather",
+						Arguments: `{"city":"北京"}`,
+					},
+				}},
+			},
+			{
+				Role:     types.RoleTool,
+				Content:  "晴，25度",
+				Metadata: map[string]string{"tool_call_id": "toolu_01", "success": "true"},
+			},
+		},
+		Tools: []types.Tool{{
+			Type: "function",
+			Function: types.ToolFunction{
+				Name:        "get_weather",
+				Description: "获取指定城市的天气信息",
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"city": map[string]any{"type": "string", "description": "城市名称"},
+					},
+					"required": []string{"city"},
+				},
+			},
+		}},
+	}
+}
+
+// checkClaudeRequest 校验发往Claude的请求包含工具定义和工具结果
+func checkClaudeRequest(t *testing.T, r *http.Request) ClaudeRequest {
+	t.Helper()
+
+	if r.URL.Path != "/v1/messages" {
+		t.Errorf("unexpected path: %s", r.URL.Path)
+	}
+	if r.Header.Get("x-api-key") != "test-key" {
+		t.Errorf("missing api key header")
+	}
+
+	var req ClaudeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+		return req
+	}
+
+	if len(req.System) != 1 || req.System[0].Text != "You are a helpful assistant." {
+		t.Errorf("unexpected system prompt: %+v", req.System)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "get_weather" || req.Tools[0].InputSchema["type"] != "object" {
+		t.Errorf("unexpected tools: %+v", req.Tools)
+	}
+	if len(req.Messages) != 3 {
+		t.Errorf("expected 3 messages, got %d: %+v", len(req.Messages), req.Messages)
+		return req
+	}
+
+	toolUse := req.Messages[1].Content[0]
+	if req.Messages[1].Role != "assistant" || toolUse.Type != "tool_use" || toolUse.ID != "toolu_01" {
+		t.Errorf("unexpected tool_use block: %+v", req.Messages[1])
+	}
+	if string(toolUse.Input) != `{"city":"北京"}` {
+		t.Errorf("unexpected tool_use input: %s", toolUse.Input)
+	}
+
+	toolResult := req.Messages[2].Content[0]
+	if req.Messages[2].Role != "user" || toolResult.Type != "tool_result" || toolResult.ToolUseID != "toolu_01" {
+		t.Errorf("unexpected tool_result block: %+v", req.Messages[2])
+	}
+
+	return req
+}
+
+func TestClaudeChatWithTools(t *testing.T) {
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		req := checkClaudeRequest(t, r)
+		if req.Stream {
+			t.Errorf("expected non-stream request")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "msg_01",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-test",
+			"stop_reason": "tool_use",
+			"content": [
+				{"type": "text", "text": "我来查一下上海的天气。"},
+				{"type": "tool_use", "id": "toolu_02", "name": "get_weather", "input": {"city": "上海"}}
+			],
+			"usage": {"input_tokens": 120, "output_tokens": 30}
+		}`)
+	})
+
+	response, err := client.Chat(context.Background(), claudeTestRequest())
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+
+	if response.Content != "我来查一下上海的天气。" {
+		t.Errorf("unexpected content: %q", response.Content)
+	}
+	if len(response.ToolCalls) != 1 {
+		t.Fatalf("expected 1 tool call, got %d", len(response.ToolCalls))
+	}
+	tc := response.ToolCalls[0]
+	if tc.ID != "toolu_02" || tc.Function.Name != "get_weather" || tc.Function.Arguments != `{"city": "上海"}` {
+		t.Errorf("unexpected tool call: %+v", tc)
+	}
+	if response.Usage.TotalTokens != 150 {
+		t.Errorf("unexpected usage: %+v", response.Usage)
+	}
+}
+
+func TestClaudeStreamWithTools(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_02","type":"message","role":"assistant","content":[],"usage":{"input_tokens":120,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"我来"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"查一下。"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_03","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\": "}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"上海\"}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_04","name":"get_time","input":{}}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":40}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		req := checkClaudeRequest(t, r)
+		if !req.Stream {
+			t.Errorf("expected stream request")
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			var typed struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(event), &typed)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typed.Type, event)
+		}
+	})
+
+	stream, err := client.ChatStream(context.Background(), claudeTestRequest())
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content string
+	var toolCalls []types.ToolCall
+	var usage types.Usage
+	for resp := range stream {
+		content += resp.Content
+		toolCalls = append(toolCalls, resp.ToolCalls...)
+		if !resp.Usage.IsZero() {
+			usage = resp.Usage
+		}
+	}
+
+	if content != "我来查一下。" {
+		t.Errorf("unexpected content: %q", content)
+	}
+	if len(toolCalls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %d: %+v", len(toolCalls), toolCalls)
+	}
+	if toolCalls[0].ID != "toolu_03" || toolCalls[0].Function.Name != "get_weather" || toolCalls[0].Function.Arguments != `{"city": "上海"}` {
+		t.Errorf("unexpected first tool call: %+v", toolCalls[0])
+	}
+	if toolCalls[1].ID != "toolu_04" || toolCalls[1].Function.Arguments != "{}" {
+		t.Errorf("unexpected second tool call: %+v", toolCalls[1])
+	}
+	if usage.PromptTokens != 120 || usage.CompletionTokens != 40 || usage.TotalTokens != 160 {
+		t.Errorf("unexpected stream usage: %+v", usage)
+	}
+}
+
+func TestClaudeStreamThinking(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_03","type":"message","role":"assistant","content":[],"usage":{"input_tokens":50,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"需要查询"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"天气。"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig-123"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"好的"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":12}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Thinking == nil || req.Thinking.BudgetTokens != 512 {
+			t.Errorf("expected thinking config in request, got %+v", req.Thinking)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	})
+	client.config.ThinkingBudget = 512
+
+	stream, err := client.ChatStream(context.Background(), types.LLMRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "天气如何"}},
+	})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content, reasoning string
+	var metadata map[string]string
+	for resp := range stream {
+		content += resp.Content
+		reasoning += resp.Reasoning
+		if resp.Metadata != nil {
+			metadata = resp.Metadata
+		}
+	}
+
+	if content != "好的" || reasoning != "需要查询天气。" {
+		t.Errorf("unexpected content %q / reasoning %q", content, reasoning)
+	}
+
+	// 思考块连同签名回传到下一轮请求
+	claudeReq := client.convertRequest(types.LLMRequest{
+		Messages: []types.Message{
+			{Role: types.RoleUser, Content: "天气如何"},
+			{Role: types.RoleAssistant, Content: content, Reasoning: reasoning, Metadata: metadata},
+			{Role: types.RoleUser, Content: "谢谢"},
+		},
+	})
+	blocks := claudeReq.Messages[1].Content
+	if len(blocks) != 2 || blocks[0].Type != "thinking" || blocks[0].Thinking != "需要查询天气。" || blocks[0].Signature != "sig-123" {
+		t.Errorf("unexpected assistant blocks: %+v", blocks)
+	}
+}
+
+func TestClaudeChatErrorStatus(t *testing.T) {
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`)
+	})
+
+	if _, err := client.Chat(context.Background(), claudeTestRequest()); err == nil {
+		t.Fatal("expected error for non-200 status")
+	}
+	if _, err := client.ChatStream(context.Background(), claudeTestRequest()); err == nil {
+		t.Fatal("expected error for non-200 stream status")
+	}
+}
+
+// testPNG 能被识别为PNG的最小数据
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestClaudeConvertImages(t *testing.T) {
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	image := types.ContentPart{Type: types.ContentPartImage, Data: base64.StdEncoding.EncodeToString(testPNG)}
+
+	claudeReq := client.convertRequest(types.LLMRequest{
+		Messages: []types.Message{
+			{Role: types.RoleUser, Content: "看看这张图", Parts: []types.ContentPart{image}},
+			{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{{ID: "toolu_01", Function: types.ToolCallFunction{Name: "read", Arguments: "{}"}}}},
+			{Role: types.RoleTool, Content: "Image file", ToolCallID: "toolu_01", Parts: []types.ContentPart{image}},
+		},
+	})
+
+	user := claudeReq.Messages[0].Content
+	if len(user) != 2 || user[1].Type != "image" || user[1].Source == nil || user[1].Source.MediaType != "image/png" {
+		t.Fatalf("unexpected user blocks: %+v", user)
+	}
+
+	// 工具返回的图片嵌在 tool_result 中
+	data, _ := json.Marshal(claudeReq.Messages[2].Content[0])
+	var toolResult struct {
+		Type    string `json:"type"`
+		Content []struct {
+			Type   string `json:"type"`
+			Source struct {
+				Data string `json:"data"`
+			} `json:"source"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(data, &toolResult); err != nil {
+		t.Fatalf("unexpected tool_result: %s", data)
+	}
+	if toolResult.Type != "tool_result" || len(toolResult.Content) != 2 || toolResult.Content[1].Type != "image" || toolResult.Content[1].Source.Data != image.Data {
+		t.Errorf("unexpected tool_result: %s", data)
+	}
+}
+
+func TestClaudePromptCaching(t *testing.T) {
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var raw map[string]any
+		json.NewDecoder(r.Body).Decode(&raw)
+
+		// 断点：系统提示词、上一条用户消息和最后一条消息，共3个
+		data, _ := json.Marshal(raw)
+		if count := strings.Count(string(data), `"cache_control":{"type":"ephemeral"}`); count != 3 {
+			t.Errorf("expected 3 cache breakpoints, got %d: %s", count, data)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_01","type":"message","role":"assistant","content":[{"type":"text","text":"好的"}],
+			"usage":{"input_tokens":20,"output_tokens":5,"cache_creation_input_tokens":100,"cache_read_input_tokens":1000}}`)
+	})
+
+	request := claudeTestRequest()
+	request.Messages = append(request.Messages,
+		types.Message{Role: types.RoleAssistant, Content: "北京晴，25度。"},
+		types.Message{Role: types.RoleUser, Content: "那上海呢？"},
+	)
+
+	converted := client.convertRequest(request)
+	if converted.System[0].CacheControl == nil || converted.Tools[0].CacheControl != nil {
+		t.Errorf("expected breakpoint on system prompt only: %+v %+v", converted.System, converted.Tools)
+	}
+	messages := converted.Messages
+	if last := messages[len(messages)-1]; last.Content[0].CacheControl == nil {
+		t.Errorf("expected breakpoint on last message: %+v", last)
+	}
+	if previous := messages[len(messages)-3]; previous.Role != "user" || previous.Content[0].Type != "tool_result" || previous.Content[0].CacheControl == nil {
+		t.Errorf("expected breakpoint on previous user message: %+v", previous)
+	}
+
+	response, err := client.Chat(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	// input_tokens 不包含读写缓存的token
+	usage := response.Usage
+	if usage.PromptTokens != 1120 || usage.CachedTokens != 1000 || usage.TotalTokens != 1125 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestClaudeStreamError(t *testing.T) {
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_05\",\"type\":\"message\",\"role\":\"assistant\",\"content\":[],\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"我来\"}}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n")
+	})
+
+	stream, err := client.ChatStream(context.Background(), claudeTestRequest())
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content string
+	var last types.LLMResponse
+	for resp := range stream {
+		content += resp.Content
+		last = resp
+	}
+	if content != "我来" {
+		t.Errorf("unexpected content: %q", content)
+	}
+	if err := StreamError(last); err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Errorf("expected the last chunk to carry the stream error, got %+v", last)
+	}
+}
+
+func TestClaudeStreamWithoutMessageStop(t *testing.T) {
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_06\",\"type\":\"message\",\"role\":\"assistant\",\"content\":[],\"usage\":{\"input_tokens\":10,\"output_tokens\":1}}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"我来\"}}\n\n")
+		// 连接在 message_stop 之前正常关闭
+	})
+
+	stream, err := client.ChatStream(context.Background(), claudeTestRequest())
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var last types.LLMResponse
+	for resp := range stream {
+		last = resp
+	}
+	if err := StreamError(last); err == nil || !strings.Contains(err.Error(), "message_stop") {
+		t.Errorf("expected a stream without message_stop to end with an error, got %+v", last)
+	}
+}
@@ -3,30 +3,90 @@ package llm
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/zboya/nala-coder/pkg/log"
 	"github.com/zboya/nala-coder/pkg/types"
 )
 
-// Manager LLM管理器
+// 响应元数据中记录实际应答提供商和模型的键
+const (
+	MetadataProvider = "provider"
+	MetadataModel    = "model"
+)
+
+// Manager LLM管理器，按故障转移链依次调用各提供商，并按提供商限流
 type Manager struct {
-	clients         map[types.LLMProvider]types.LLMClient
-	defaultProvider types.LLMProvider
-	logger          log.Logger
+	clients           map[types.LLMProvider]types.LLMClient
+	limiters          map[types.LLMProvider]*RateLimiter
+	defaultProvider   types.LLMProvider
+	fallbackProviders []types.LLMProvider
+	embeddingProvider types.LLMProvider
+	tracer            *Tracer
+	logger            log.Logger
 }
 
 // NewManager 创建LLM管理器
 func NewManager(defaultProvider types.LLMProvider, logger log.Logger) *Manager {
 	return &Manager{
 		clients:         make(map[types.LLMProvider]types.LLMClient),
+		limiters:        make(map[types.LLMProvider]*RateLimiter),
 		defaultProvider: defaultProvider,
 		logger:          logger,
 	}
 }
 
-// RegisterClient 注册LLM客户端
+// RegisterClient 注册LLM客户端。客户端未包装限流而配置了限流时，在最外层包装限流
 func (m *Manager) RegisterClient(provider types.LLMProvider, client types.LLMClient) {
+	limiter := rateLimiterOf(client)
+	if limiter == nil {
+		if limiter = NewRateLimiter(client.GetConfig().RateLimit); limiter != nil {
+			client = NewRateLimitedClient(client, limiter, m.logger)
+		}
+	}
+
 	m.clients[provider] = client
+	if limiter != nil {
+		m.limiters[provider] = limiter
+	} else {
+		delete(m.limiters, provider)
+	}
+}
+
+// QueueDepth 获取提供商限流队列中等待的请求数
+func (m *Manager) QueueDepth(provider types.LLMProvider) int {
+	if provider == "" {
+		provider = m.defaultProvider
+	}
+	if limiter, exists := m.limiters[provider]; exists {
+		return limiter.QueueDepth()
+	}
+	return 0
+}
+
+// SetTracer 设置调用追踪器，为nil时不记录
+func (m *Manager) SetTracer(tracer *Tracer) {
+	m.tracer = tracer
+}
+
+// SetFallbackProviders 设置故障转移链，默认提供商失败后按顺序尝试
+func (m *Manager) SetFallbackProviders(providers []types.LLMProvider) error {
+	fallbacks := make([]types.LLMProvider, 0, len(providers))
+	seen := map[types.LLMProvider]bool{m.defaultProvider: true}
+
+	for _, provider := range providers {
+		if seen[provider] {
+			continue
+		}
+		if _, exists := m.clients[provider]; !exists {
+			return fmt.Errorf("fallback provider %s not configured", provider)
+		}
+		seen[provider] = true
+		fallbacks = append(fallbacks, provider)
+	}
+
+	m.fallbackProviders = fallbacks
+	return nil
 }
 
 // GetClient 获取LLM客户端
@@ -48,34 +108,117 @@ func (m *Manager) GetDefaultClient() (types.LLMClient, error) {
 	return m.GetClient(m.defaultProvider)
 }
 
-// Chat 使用默认客户端进行对话
-func (m *Manager) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+// GetProvider 获取默认提供商
+func (m *Manager) GetProvider() types.LLMProvider {
+	return m.defaultProvider
+}
+
+// GetConfig 获取默认提供商的配置
+func (m *Manager) GetConfig() types.LLMConfig {
 	client, err := m.GetDefaultClient()
 	if err != nil {
-		return nil, err
+		return types.LLMConfig{Provider: m.defaultProvider}
 	}
+	return client.GetConfig()
+}
 
-	return client.Chat(ctx, request)
+// Chat 沿故障转移链进行对话
+func (m *Manager) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	return m.ChatWithProvider(ctx, m.defaultProvider, request)
 }
 
-// ChatStream 使用默认客户端进行流式对话
+// ChatStream 沿故障转移链进行流式对话，只在收到第一个响应前切换提供商
 func (m *Manager) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
-	client, err := m.GetDefaultClient()
+	return m.ChatStreamWithProvider(ctx, m.defaultProvider, request)
+}
+
+// ChatWithProvider 使用指定提供商进行对话，失败时沿故障转移链切换
+func (m *Manager) ChatWithProvider(ctx context.Context, provider types.LLMProvider, request types.LLMRequest) (*types.LLMResponse, error) {
+	chain, err := m.providerChain(provider)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.ChatStream(ctx, request)
+	var lastErr error
+	for i, provider := range chain {
+		client := m.clients[provider]
+
+		providerRequest := chainRequest(request, i)
+		start := time.Now()
+		response, err := client.Chat(ctx, providerRequest)
+		m.trace(ctx, provider, requestModel(client, providerRequest), providerRequest, false, start, response, err)
+		if err == nil {
+			m.logger.Infof("LLM response served by provider %s", provider)
+			setResponseSource(response, provider, requestModel(client, providerRequest))
+			return response, nil
+		}
+
+		lastErr = err
+		if !m.shouldFailover(ctx, err) {
+			return nil, err
+		}
+		m.logger.Warnf("LLM provider %s failed, trying next provider: %v", provider, err)
+	}
+
+	return nil, fmt.Errorf("all LLM providers failed: %w", lastErr)
 }
 
-// ChatWithProvider 使用指定提供商进行对话
-func (m *Manager) ChatWithProvider(ctx context.Context, provider types.LLMProvider, request types.LLMRequest) (*types.LLMResponse, error) {
-	client, err := m.GetClient(provider)
+// ChatStreamWithProvider 使用指定提供商进行流式对话，只在收到第一个响应前切换提供商
+func (m *Manager) ChatStreamWithProvider(ctx context.Context, provider types.LLMProvider, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	chain, err := m.providerChain(provider)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.Chat(ctx, request)
+	var lastErr error
+	for i, provider := range chain {
+		client := m.clients[provider]
+
+		providerRequest := chainRequest(request, i)
+		start := time.Now()
+		model := requestModel(client, providerRequest)
+		stream, err := client.ChatStream(ctx, providerRequest)
+		if err != nil {
+			m.trace(ctx, provider, model, providerRequest, true, start, nil, err)
+			lastErr = err
+			if !m.shouldFailover(ctx, err) {
+				return nil, err
+			}
+			m.logger.Warnf("LLM provider %s stream failed, trying next provider: %v", provider, err)
+			continue
+		}
+
+		// 部分客户端在协程中才发现错误，通道未产出任何响应即关闭或第一个响应即为错误，同样视为失败
+		first, ok := <-stream
+		if streamErr := StreamError(first); !ok || streamErr != nil {
+			// 排空上游通道，使限流客户端在流结束时归还名额
+			go func() {
+				for range stream {
+				}
+			}()
+			lastErr = fmt.Errorf("LLM provider %s closed stream without response", provider)
+			if streamErr != nil {
+				lastErr = fmt.Errorf("LLM provider %s stream failed: %w", provider, streamErr)
+			}
+			m.trace(ctx, provider, model, providerRequest, true, start, nil, lastErr)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// 与建立流失败时相同，只对网络错误、超时和5xx切换
+			if streamErr != nil && !IsFailoverError(streamErr) {
+				return nil, lastErr
+			}
+			m.logger.Warnf("%v, trying next provider", lastErr)
+			continue
+		}
+
+		m.logger.Infof("LLM stream served by provider %s", provider)
+		return forwardStream(ctx, provider, model, first, stream, func(response *types.LLMResponse) {
+			m.trace(ctx, provider, model, providerRequest, true, start, response, StreamError(*response))
+		}), nil
+	}
+
+	return nil, fmt.Errorf("all LLM providers failed: %w", lastErr)
 }
 
 // ListProviders 列出所有可用的提供商
@@ -86,3 +229,134 @@ func (m *Manager) ListProviders() []types.LLMProvider {
 	}
 	return providers
 }
+
+// providerChain 返回故障转移链：指定提供商在前，其后为已配置的备用提供商
+func (m *Manager) providerChain(provider types.LLMProvider) ([]types.LLMProvider, error) {
+	if provider == "" {
+		provider = m.defaultProvider
+	}
+	if _, exists := m.clients[provider]; !exists {
+		return nil, fmt.Errorf("LLM provider %s not found", provider)
+	}
+
+	chain := make([]types.LLMProvider, 0, len(m.fallbackProviders)+1)
+	chain = append(chain, provider)
+	for _, fallback := range m.fallbackProviders {
+		if fallback != provider {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain, nil
+}
+
+// chainRequest 返回链上第i个提供商使用的请求，指定的模型只对第一个提供商有效
+func chainRequest(request types.LLMRequest, i int) types.LLMRequest {
+	if i > 0 {
+		request.Model = ""
+	}
+	return request
+}
+
+// requestModel 返回请求实际使用的模型
+func requestModel(client types.LLMClient, request types.LLMRequest) string {
+	if request.Model != "" {
+		return request.Model
+	}
+	return client.GetConfig().Model
+}
+
+// usedTokens 调用实际消耗的token数，失败计为0，未返回用量时为-1沿用估算值
+func usedTokens(response *types.LLMResponse, err error) int {
+	if err != nil {
+		return 0
+	}
+	if response == nil || response.Usage.IsZero() {
+		return -1
+	}
+	return response.Usage.PromptTokens + response.Usage.CompletionTokens
+}
+
+// trace 记录一次提供商调用，未启用追踪时忽略
+func (m *Manager) trace(ctx context.Context, provider types.LLMProvider, model string, request types.LLMRequest, stream bool, start time.Time, response *types.LLMResponse, err error) {
+	if m.tracer == nil {
+		return
+	}
+
+	info := traceInfoFrom(ctx)
+	record := TraceRecord{
+		Time:      start,
+		SessionID: info.sessionID,
+		Loop:      info.loop,
+		Provider:  provider,
+		Model:     model,
+		Stream:    stream,
+		LatencyMs: time.Since(start).Milliseconds(),
+		Request:   request,
+		Response:  response,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	if err := m.tracer.Record(record); err != nil {
+		m.logger.Warnf("Failed to record LLM trace: %v", err)
+	}
+}
+
+// shouldFailover 判断失败后是否继续尝试下一个提供商
+func (m *Manager) shouldFailover(ctx context.Context, err error) bool {
+	// 调用方已取消或超时，不再切换
+	if ctx.Err() != nil {
+		return false
+	}
+	return IsFailoverError(err)
+}
+
+// forwardStream 转发流式响应，并在每个响应的元数据中记录提供商和模型。
+// 流结束后以合并的完整响应调用 done，用于记录追踪
+func forwardStream(ctx context.Context, provider types.LLMProvider, model string, first types.LLMResponse, stream <-chan types.LLMResponse, done func(response *types.LLMResponse)) <-chan types.LLMResponse {
+	responseChan := make(chan types.LLMResponse, 10)
+
+	go func() {
+		defer close(responseChan)
+		var chunks []types.LLMResponse
+		defer func() { done(mergeStream(chunks)) }()
+		// 提前退出时排空上游通道，避免上游协程阻塞
+		defer func() {
+			for resp := range stream {
+				chunks = append(chunks, resp)
+			}
+		}()
+
+		setResponseSource(&first, provider, model)
+		chunks = append(chunks, first)
+		select {
+		case responseChan <- first:
+		case <-ctx.Done():
+			return
+		}
+
+		for resp := range stream {
+			setResponseSource(&resp, provider, model)
+			chunks = append(chunks, resp)
+			select {
+			case responseChan <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return responseChan
+}
+
+// setResponseSource 在响应元数据中记录提供商和模型
+func setResponseSource(response *types.LLMResponse, provider types.LLMProvider, model string) {
+	if response.Metadata == nil {
+		response.Metadata = make(map[string]string)
+	}
+	response.Metadata[MetadataProvider] = string(provider)
+	if model != "" {
+		response.Metadata[MetadataModel] = model
+	}
+}
@@ -0,0 +1,191 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// fakeClient 按预设结果应答的测试客户端
+type fakeClient struct {
+	provider types.LLMProvider
+	err      error
+	chunks   []types.LLMResponse
+	calls    int
+	request  types.LLMRequest
+}
+
+func (c *fakeClient) GetProvider() types.LLMProvider { return c.provider }
+
+func (c *fakeClient) GetConfig() types.LLMConfig { return types.LLMConfig{Provider: c.provider} }
+
+func (c *fakeClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	c.calls++
+	c.request = request
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &types.LLMResponse{Content: string(c.provider)}, nil
+}
+
+func (c *fakeClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	c.calls++
+	c.request = request
+	if c.err != nil {
+		return nil, c.err
+	}
+	stream := make(chan types.LLMResponse, len(c.chunks))
+	for _, chunk := range c.chunks {
+		stream <- chunk
+	}
+	close(stream)
+	return stream, nil
+}
+
+func newTestManager(t *testing.T, clients ...*fakeClient) *Manager {
+	t.Helper()
+
+	logger, _ := log.New(log.DefaultConfig())
+	manager := NewManager(clients[0].provider, logger)
+	fallbacks := make([]types.LLMProvider, 0, len(clients))
+	for _, client := range clients {
+		manager.RegisterClient(client.provider, client)
+		fallbacks = append(fallbacks, client.provider)
+	}
+	if err := manager.SetFallbackProviders(fallbacks); err != nil {
+		t.Fatalf("SetFallbackProviders error: %v", err)
+	}
+	return manager
+}
+
+func TestManagerChatFailover(t *testing.T) {
+	deepseek := &fakeClient{provider: types.ProviderDeepSeek, err: &APIError{Provider: types.ProviderDeepSeek, StatusCode: http.StatusBadGateway}}
+	openai := &fakeClient{provider: types.ProviderOpenAI, err: context.DeadlineExceeded}
+	ollama := &fakeClient{provider: types.ProviderOllama}
+	manager := newTestManager(t, deepseek, openai, ollama)
+
+	response, err := manager.Chat(context.Background(), types.LLMRequest{})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Metadata[MetadataProvider] != string(types.ProviderOllama) {
+		t.Errorf("expected ollama to answer, got metadata %+v", response.Metadata)
+	}
+	if deepseek.calls != 1 || openai.calls != 1 || ollama.calls != 1 {
+		t.Errorf("unexpected call counts: %d %d %d", deepseek.calls, openai.calls, ollama.calls)
+	}
+}
+
+func TestManagerChatNoFailoverOnClientError(t *testing.T) {
+	deepseek := &fakeClient{provider: types.ProviderDeepSeek, err: &APIError{Provider: types.ProviderDeepSeek, StatusCode: http.StatusBadRequest}}
+	openai := &fakeClient{provider: types.ProviderOpenAI}
+	manager := newTestManager(t, deepseek, openai)
+
+	if _, err := manager.Chat(context.Background(), types.LLMRequest{}); err == nil {
+		t.Fatal("expected 4xx error to be returned")
+	}
+	if openai.calls != 0 {
+		t.Errorf("expected no failover on 4xx, openai called %d times", openai.calls)
+	}
+}
+
+func TestManagerChatStreamFailover(t *testing.T) {
+	// 第一个提供商未产出任何响应即关闭通道
+	deepseek := &fakeClient{provider: types.ProviderDeepSeek}
+	openai := &fakeClient{provider: types.ProviderOpenAI, chunks: []types.LLMResponse{{Content: "hello"}, {Content: " world"}}}
+	manager := newTestManager(t, deepseek, openai)
+
+	stream, err := manager.ChatStream(context.Background(), types.LLMRequest{})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content string
+	for resp := range stream {
+		content += resp.Content
+		if resp.Metadata[MetadataProvider] != string(types.ProviderOpenAI) {
+			t.Errorf("unexpected provider metadata: %+v", resp.Metadata)
+		}
+	}
+	if content != "hello world" {
+		t.Errorf("unexpected content: %q", content)
+	}
+}
+
+func TestManagerChatStreamFailoverOnErrorChunk(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		failover bool
+	}{
+		{"server error", &APIError{Provider: types.ProviderDeepSeek, StatusCode: http.StatusServiceUnavailable}, true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"client error", &APIError{Provider: types.ProviderDeepSeek, StatusCode: http.StatusUnauthorized}, false},
+		{"unknown", errors.New("model not found"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 第一个响应块即为错误，按错误类型决定是否切换
+			deepseek := &fakeClient{provider: types.ProviderDeepSeek, chunks: []types.LLMResponse{streamErrorResponse(tt.err)}}
+			openai := &fakeClient{provider: types.ProviderOpenAI, chunks: []types.LLMResponse{{Content: "hello"}}}
+			manager := newTestManager(t, deepseek, openai)
+
+			stream, err := manager.ChatStream(context.Background(), types.LLMRequest{})
+			if tt.failover {
+				if err != nil {
+					t.Fatalf("ChatStream error: %v", err)
+				}
+				for range stream {
+				}
+			} else if err == nil || StatusCodeOf(err) != StatusCodeOf(tt.err) {
+				t.Errorf("expected the stream error to be returned, got %v", err)
+			}
+			if (openai.calls == 1) != tt.failover {
+				t.Errorf("expected failover %t, openai called %d times", tt.failover, openai.calls)
+			}
+		})
+	}
+}
+
+func TestManagerChatAllProvidersFailed(t *testing.T) {
+	deepseek := &fakeClient{provider: types.ProviderDeepSeek, err: &APIError{Provider: types.ProviderDeepSeek, StatusCode: http.StatusServiceUnavailable}}
+	openai := &fakeClient{provider: types.ProviderOpenAI, err: &APIError{Provider: types.ProviderOpenAI, StatusCode: http.StatusInternalServerError}}
+	manager := newTestManager(t, deepseek, openai)
+
+	_, err := manager.Chat(context.Background(), types.LLMRequest{})
+	if err == nil {
+		t.Fatal("expected error when all providers fail")
+	}
+	if StatusCodeOf(err) != http.StatusInternalServerError {
+		t.Errorf("expected last provider error to be wrapped, got %v", err)
+	}
+}
+
+func TestManagerChatWithProvider(t *testing.T) {
+	deepseek := &fakeClient{provider: types.ProviderDeepSeek}
+	claude := &fakeClient{provider: types.ProviderClaude, err: &APIError{Provider: types.ProviderClaude, StatusCode: http.StatusServiceUnavailable}}
+	ollama := &fakeClient{provider: types.ProviderOllama}
+	manager := newTestManager(t, deepseek, claude, ollama)
+
+	response, err := manager.ChatWithProvider(context.Background(), types.ProviderClaude, types.LLMRequest{Model: "claude-opus"})
+	if err != nil {
+		t.Fatalf("ChatWithProvider error: %v", err)
+	}
+	if response.Metadata[MetadataProvider] != string(types.ProviderOllama) {
+		t.Errorf("expected ollama to answer, got metadata %+v", response.Metadata)
+	}
+	if deepseek.calls != 0 {
+		t.Errorf("default provider should not be called, got %d calls", deepseek.calls)
+	}
+	if claude.request.Model != "claude-opus" || ollama.request.Model != "" {
+		t.Errorf("model should only apply to selected provider: %q %q", claude.request.Model, ollama.request.Model)
+	}
+
+	if _, err := manager.ChatWithProvider(context.Background(), "unknown", types.LLMRequest{}); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
@@ -2,17 +2,42 @@ package llm
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/zboya/nala-coder/pkg/types"
 )
 
 // Config LLM模块配置
 type Config struct {
-	DefaultProvider types.LLMProvider `mapstructure:"default_provider"`
-	OpenAI          types.LLMConfig   `mapstructure:"openai"`
-	DeepSeek        types.LLMConfig   `mapstructure:"deepseek"`
-	Claude          types.LLMConfig   `mapstructure:"claude"`
-	Ollama          types.LLMConfig   `mapstructure:"ollama"`
+	DefaultProvider   types.LLMProvider   `mapstructure:"default_provider"`
+	FallbackProviders []types.LLMProvider `mapstructure:"fallback_providers"` // 默认提供商失败后按顺序尝试
+	EmbeddingProvider types.LLMProvider   `mapstructure:"embedding_provider"` // 向量化使用的提供商，为空时不启用
+	OpenAI            types.LLMConfig     `mapstructure:"openai"`
+	DeepSeek          types.LLMConfig     `mapstructure:"deepseek"`
+	Claude            types.LLMConfig     `mapstructure:"claude"`
+	Ollama            types.LLMConfig     `mapstructure:"ollama"`
+	Gemini            types.LLMConfig     `mapstructure:"gemini"`
+	Replay            types.LLMConfig     `mapstructure:"replay"`
+	Mock              types.LLMConfig     `mapstructure:"mock"`
+
+	// Prices 模型价格表，用于用量报告计算费用
+	Prices []types.ModelPrice `mapstructure:"prices"`
+
+	// Trace 调用追踪，开启后每次LLM调用写入一条JSONL记录
+	Trace TraceConfig `mapstructure:"trace"`
+
+	// Providers 命名提供商，键为名称，按 protocol 选择协议（默认openai），可与内置提供商同时使用
+	Providers map[string]types.LLMConfig `mapstructure:"providers"`
+}
+
+// namedProtocols 命名提供商支持的协议
+var namedProtocols = map[types.LLMProvider]bool{
+	types.ProviderOpenAI:   true,
+	types.ProviderDeepSeek: true,
+	types.ProviderClaude:   true,
+	types.ProviderOllama:   true,
+	types.ProviderGemini:   true,
+	types.ProviderMock:     true,
 }
 
 // GetProviderConfigs 获取所有提供商配置
@@ -44,6 +69,34 @@ func (c *Config) GetProviderConfigs() map[types.LLMProvider]types.LLMConfig {
 		configs[types.ProviderOllama] = ollamaConfig
 	}
 
+	if c.Gemini.APIKey != "" || c.Gemini.BaseURL != "" {
+		geminiConfig := c.Gemini
+		geminiConfig.Provider = types.ProviderGemini
+		configs[types.ProviderGemini] = geminiConfig
+	}
+
+	for name, config := range c.Providers {
+		namedConfig := config
+		namedConfig.Provider = types.LLMProvider(name)
+		if namedConfig.Protocol == "" {
+			namedConfig.Protocol = types.ProviderOpenAI
+		}
+		namedConfig.Protocol = types.LLMProvider(strings.ToLower(string(namedConfig.Protocol)))
+		configs[namedConfig.Provider] = namedConfig
+	}
+
+	if c.Replay.Cassette != "" {
+		replayConfig := c.Replay
+		replayConfig.Provider = types.ProviderReplay
+		configs[types.ProviderReplay] = replayConfig
+	}
+
+	if c.Mock.Script != "" {
+		mockConfig := c.Mock
+		mockConfig.Provider = types.ProviderMock
+		configs[types.ProviderMock] = mockConfig
+	}
+
 	return configs
 }
 
@@ -51,6 +104,15 @@ func (c *Config) GetProviderConfigs() map[types.LLMProvider]types.LLMConfig {
 func (c *Config) ValidateConfig() error {
 	configs := c.GetProviderConfigs()
 
+	for name, config := range c.Providers {
+		if provider := types.LLMProvider(name); namedProtocols[provider] || provider == types.ProviderReplay {
+			return fmt.Errorf("named provider %s conflicts with built-in provider", name)
+		}
+		if config.Protocol != "" && !namedProtocols[types.LLMProvider(strings.ToLower(string(config.Protocol)))] {
+			return fmt.Errorf("named provider %s has unsupported protocol %s", name, config.Protocol)
+		}
+	}
+
 	if len(configs) == 0 {
 		return fmt.Errorf("no valid LLM providers configured")
 	}
@@ -59,5 +121,28 @@ func (c *Config) ValidateConfig() error {
 		return fmt.Errorf("default provider %s is not configured", c.DefaultProvider)
 	}
 
+	for _, provider := range c.FallbackProviders {
+		if _, exists := configs[provider]; !exists {
+			return fmt.Errorf("fallback provider %s is not configured", provider)
+		}
+	}
+
+	if c.EmbeddingProvider != "" {
+		config, exists := configs[c.EmbeddingProvider]
+		if !exists {
+			return fmt.Errorf("embedding provider %s is not configured", c.EmbeddingProvider)
+		}
+		protocol := config.Protocol
+		if protocol == "" {
+			protocol = c.EmbeddingProvider
+		}
+		if !embeddingProtocols[protocol] {
+			return fmt.Errorf("embedding provider %s does not support embeddings", c.EmbeddingProvider)
+		}
+		if config.EmbeddingModel == "" && protocol != types.ProviderMock {
+			return fmt.Errorf("embedding provider %s has no embedding_model", c.EmbeddingProvider)
+		}
+	}
+
 	return nil
 }
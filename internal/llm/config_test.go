@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestNamedProviders(t *testing.T) {
+	config := Config{
+		DefaultProvider: "gateway",
+		Claude:          types.LLMConfig{APIKey: "claude-key"},
+		Providers: map[string]types.LLMConfig{
+			"gateway": {Protocol: "Claude", BaseURL: "http://gateway.local", Model: "claude-sonnet"},
+		},
+	}
+	if err := config.ValidateConfig(); err != nil {
+		t.Fatalf("ValidateConfig error: %v", err)
+	}
+
+	configs := config.GetProviderConfigs()
+	gateway, exists := configs["gateway"]
+	if !exists || gateway.Protocol != types.ProviderClaude || gateway.Provider != "gateway" {
+		t.Fatalf("unexpected gateway config: %+v", gateway)
+	}
+
+	logger, _ := log.New(log.DefaultConfig())
+	manager, err := CreateManagerFromConfigs(configs, config.DefaultProvider, logger)
+	if err != nil {
+		t.Fatalf("CreateManagerFromConfigs error: %v", err)
+	}
+	client, err := manager.GetClient("gateway")
+	if err != nil {
+		t.Fatalf("GetClient error: %v", err)
+	}
+	if client.GetProvider() != "gateway" {
+		t.Errorf("expected client named gateway, got %s", client.GetProvider())
+	}
+	if client, _ := manager.GetClient(types.ProviderClaude); client.GetProvider() != types.ProviderClaude {
+		t.Errorf("expected built-in claude client, got %s", client.GetProvider())
+	}
+}
+
+func TestNamedProvidersValidation(t *testing.T) {
+	conflict := Config{
+		DefaultProvider: "openai",
+		Providers:       map[string]types.LLMConfig{"openai": {BaseURL: "http://localhost:8000/v1/"}},
+	}
+	if err := conflict.ValidateConfig(); err == nil {
+		t.Error("expected error for name conflicting with built-in provider")
+	}
+
+	unsupported := Config{
+		DefaultProvider: "local",
+		Providers:       map[string]types.LLMConfig{"local": {Protocol: "grpc", BaseURL: "http://localhost:9000"}},
+	}
+	if err := unsupported.ValidateConfig(); err == nil {
+		t.Error("expected error for unsupported protocol")
+	}
+}
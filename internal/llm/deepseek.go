@@ -3,7 +3,11 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strings"
 
 	deepseek "github.com/cohesion-org/deepseek-go"
 	"github.com/zboya/nala-coder/pkg/log"
@@ -19,18 +23,16 @@ type DeepSeekClient struct {
 
 // NewDeepSeekClient 创建DeepSeek客户端
 func NewDeepSeekClient(config types.LLMConfig, logger log.Logger) *DeepSeekClient {
-	var client *deepseek.Client
-
+	// 使用带 Retry-After 处理的 HTTP 客户端，重试时按服务端要求等待
+	options := []deepseek.Option{deepseek.WithHTTPClient(newHTTPClient())}
 	if config.BaseURL != "" {
 		// 使用自定义 BaseURL
-		client = deepseek.NewClient(config.APIKey, config.BaseURL)
-	} else {
-		// 使用默认 DeepSeek API
-		client = deepseek.NewClient(config.APIKey)
+		options = append(options, deepseek.WithBaseURL(config.BaseURL))
 	}
 
-	if client == nil {
-		logger.Error("Failed to create DeepSeek client")
+	client, err := deepseek.NewClientWithOptions(config.APIKey, options...)
+	if err != nil {
+		logger.Errorf("Failed to create DeepSeek client: %v", err)
 		return nil
 	}
 
@@ -48,7 +50,7 @@ func (c *DeepSeekClient) GetConfig() types.LLMConfig {
 
 // GetProvider 获取提供商
 func (c *DeepSeekClient) GetProvider() types.LLMProvider {
-	return types.ProviderDeepSeek
+	return providerName(c.config, types.ProviderDeepSeek)
 }
 
 // Chat 对话
@@ -88,6 +90,8 @@ func (c *DeepSeekClient) ChatStream(ctx context.Context, request types.LLMReques
 		// 使用map来跟踪正在构建的工具调用，key是index
 		toolCallsMap := make(map[int]*types.ToolCall)
 		var finalResponse *deepseek.StreamChatCompletionResponse
+		var usage types.Usage
+		var finishReason string
 
 		buildFinalResponse := func() types.LLMResponse {
 			// 构建最终的工具调用数组
@@ -104,31 +108,50 @@ func (c *DeepSeekClient) ChatStream(ctx context.Context, request types.LLMReques
 				responseID = finalResponse.ID
 			}
 			return types.LLMResponse{
-				ID:        responseID,
-				Content:   "",
-				Role:      "assistant",
-				ToolCalls: toolCalls,
+				ID:           responseID,
+				Content:      "",
+				Role:         "assistant",
+				ToolCalls:    toolCalls,
+				Usage:        usage,
+				FinishReason: normalizeFinishReason(finishReason, len(toolCalls) > 0),
 			}
 		}
 
 		for {
 			response, err := stream.Recv()
 			if err != nil {
-				if err.Error() != "EOF" {
+				// 发送最终响应，读取失败时以错误结束，调用方不会把不完整的回复当作正常结束
+				final := buildFinalResponse()
+				if !errors.Is(err, io.EOF) {
 					c.logger.Errorf("DeepSeek stream error: %v", err)
+					final = streamErrorResponse(fmt.Errorf("DeepSeek stream error: %w", err))
+				}
+				select {
+				case responseChan <- final:
+				case <-ctx.Done():
 				}
-				// 发送最终响应
-				responseChan <- buildFinalResponse()
 				return
 			}
 
 			finalResponse = response
+			// 用量在最后一个数据块中返回，之前的数据块为0
+			if response.Usage != nil && response.Usage.TotalTokens > 0 {
+				usage = types.Usage{
+					PromptTokens:     response.Usage.PromptTokens,
+					CompletionTokens: response.Usage.CompletionTokens,
+					TotalTokens:      response.Usage.TotalTokens,
+					CachedTokens:     response.Usage.PromptCacheHitTokens,
+				}
+			}
 
 			if len(response.Choices) == 0 {
 				continue
 			}
 
 			choice := response.Choices[0]
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
 			choiceBytes, _ := json.Marshal(choice)
 			c.logger.Debugf("DeepSeek stream choice: %s", string(choiceBytes))
 
@@ -168,9 +191,10 @@ func (c *DeepSeekClient) ChatStream(ctx context.Context, request types.LLMReques
 			}
 			// 发送增量响应（不包含工具调用，避免重复发送未完成的工具调用）
 			resp := types.LLMResponse{
-				ID:      response.ID,
-				Content: choice.Delta.Content,
-				Role:    "assistant",
+				ID:        response.ID,
+				Content:   choice.Delta.Content,
+				Reasoning: choice.Delta.ReasoningContent,
+				Role:      "assistant",
 			}
 
 			select {
@@ -178,17 +202,47 @@ func (c *DeepSeekClient) ChatStream(ctx context.Context, request types.LLMReques
 			case <-ctx.Done():
 				return
 			}
-			// 检查是否完成
-			if choice.FinishReason != "" {
-				responseChan <- buildFinalResponse()
-				return
-			}
 		}
 	}()
 
 	return responseChan, nil
 }
 
+// Ping 通过模型列表接口检查连通性和密钥
+func (c *DeepSeekClient) Ping(ctx context.Context) error {
+	_, err := c.ListModels(ctx)
+	return err
+}
+
+// ListModels 列出 /models 返回的模型。SDK 的模型列表固定请求官方地址，配置了 BaseURL 时直接请求
+func (c *DeepSeekClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	var list deepseek.APIModels
+	if c.config.BaseURL == "" {
+		response, err := deepseek.ListAllModels(c.client, ctx)
+		if err != nil {
+			return nil, fmt.Errorf("DeepSeek API error: %w", err)
+		}
+		list = *response
+	} else {
+		header := http.Header{"Authorization": []string{"Bearer " + c.config.APIKey}}
+		endpoint := strings.TrimSuffix(c.config.BaseURL, "/") + "/models"
+		if err := getJSON(ctx, newHTTPClient(), c.GetProvider(), endpoint, header, &list); err != nil {
+			return nil, fmt.Errorf("DeepSeek API error: %w", err)
+		}
+	}
+
+	models := make([]types.ModelInfo, 0, len(list.Data))
+	for _, model := range list.Data {
+		models = append(models, types.ModelInfo{ID: model.ID, OwnedBy: model.OwnedBy})
+	}
+	return models, nil
+}
+
+// Capabilities 接口不返回模型能力，按模型名推断
+func (c *DeepSeekClient) Capabilities(ctx context.Context, model string) (types.ModelCapabilities, error) {
+	return inferCapabilities(types.ProviderDeepSeek, c.getModel(model)), nil
+}
+
 // convertToDeepSeekRequest 将内部请求转换为 DeepSeek 请求
 func (c *DeepSeekClient) convertToDeepSeekRequest(request types.LLMRequest) *deepseek.ChatCompletionRequest {
 	messages := make([]deepseek.ChatCompletionMessage, len(request.Messages))
@@ -198,6 +252,10 @@ func (c *DeepSeekClient) convertToDeepSeekRequest(request types.LLMRequest) *dee
 			Content:    msg.Content,
 			ToolCallID: msg.Metadata["tool_call_id"],
 		}
+		if c.config.SendReasoning && msg.Role == types.RoleAssistant {
+			messages[i].ReasoningContent = msg.Reasoning
+		}
+		c.warnImages(msg)
 		// 转换工具调用
 		if len(msg.ToolCalls) > 0 {
 			toolCalls := make([]deepseek.ToolCall, len(msg.ToolCalls))
@@ -231,13 +289,19 @@ func (c *DeepSeekClient) convertToDeepSeekRequest(request types.LLMRequest) *dee
 		}
 	}
 
-	return &deepseek.ChatCompletionRequest{
+	dsRequest := &deepseek.ChatCompletionRequest{
 		Model:       c.getModel(request.Model),
 		Messages:    messages,
 		MaxTokens:   c.getMaxTokens(request.MaxTokens),
 		Temperature: c.getTemperature(request.Temperature),
 		Tools:       tools,
 	}
+	if request.ResponseFormat != nil {
+		// DeepSeek 只支持 json_object，Schema 通过系统提示词传达
+		dsRequest.Messages = c.withSchemaInstruction(dsRequest.Messages, request.ResponseFormat)
+		dsRequest.ResponseFormat = &deepseek.ResponseFormat{Type: "json_object"}
+	}
+	return dsRequest
 }
 
 // convertToDeepSeekStreamRequest 将内部请求转换为 DeepSeek 流式请求
@@ -249,6 +313,10 @@ func (c *DeepSeekClient) convertToDeepSeekStreamRequest(request types.LLMRequest
 			Content:    msg.Content,
 			ToolCallID: msg.Metadata["tool_call_id"],
 		}
+		if c.config.SendReasoning && msg.Role == types.RoleAssistant {
+			messages[i].ReasoningContent = msg.Reasoning
+		}
+		c.warnImages(msg)
 
 		// 转换工具调用
 		if len(msg.ToolCalls) > 0 {
@@ -283,24 +351,51 @@ func (c *DeepSeekClient) convertToDeepSeekStreamRequest(request types.LLMRequest
 		}
 	}
 
-	return &deepseek.StreamChatCompletionRequest{
-		Model:       c.getModel(request.Model),
-		Messages:    messages,
-		MaxTokens:   c.getMaxTokens(request.MaxTokens),
-		Temperature: c.getTemperature(request.Temperature),
-		Tools:       tools,
-		Stream:      true,
+	dsRequest := &deepseek.StreamChatCompletionRequest{
+		Model:         c.getModel(request.Model),
+		Messages:      messages,
+		MaxTokens:     c.getMaxTokens(request.MaxTokens),
+		Temperature:   c.getTemperature(request.Temperature),
+		Tools:         tools,
+		Stream:        true,
+		StreamOptions: deepseek.StreamOptions{IncludeUsage: true},
+	}
+	if request.ResponseFormat != nil {
+		dsRequest.Messages = c.withSchemaInstruction(dsRequest.Messages, request.ResponseFormat)
+		dsRequest.ResponseFormat = &deepseek.ResponseFormat{Type: "json_object"}
+	}
+	return dsRequest
+}
+
+// withSchemaInstruction 在消息末尾追加描述 Schema 的系统提示词，json_object 模式要求提示词中包含 "json"
+func (c *DeepSeekClient) withSchemaInstruction(messages []deepseek.ChatCompletionMessage, format *types.ResponseFormat) []deepseek.ChatCompletionMessage {
+	instruction := deepseek.ChatCompletionMessage{
+		Role:    string(types.RoleSystem),
+		Content: schemaInstruction(format),
+	}
+	return append(messages, instruction)
+}
+
+// warnImages DeepSeek 模型不支持图片输入，图片内容被忽略
+func (c *DeepSeekClient) warnImages(msg types.Message) {
+	for _, part := range msg.Parts {
+		if part.Type == types.ContentPartImage {
+			c.logger.Warnf("DeepSeek does not support image input, %d content parts ignored", len(msg.Parts))
+			return
+		}
 	}
 }
 
 // convertFromDeepSeekResponse 将 DeepSeek 响应转换为内部响应
 func (c *DeepSeekClient) convertFromDeepSeekResponse(response *deepseek.ChatCompletionResponse) *types.LLMResponse {
-	var content string
+	var content, reasoning, finishReason string
 	var toolCalls []types.ToolCall
 
 	if len(response.Choices) > 0 {
 		choice := response.Choices[0]
 		content = choice.Message.Content
+		finishReason = choice.FinishReason
+		reasoning = choice.Message.ReasoningContent
 
 		// 转换工具调用
 		if len(choice.Message.ToolCalls) > 0 {
@@ -319,15 +414,18 @@ func (c *DeepSeekClient) convertFromDeepSeekResponse(response *deepseek.ChatComp
 	}
 
 	return &types.LLMResponse{
-		ID:      response.ID,
-		Content: content,
-		Role:    "assistant",
+		ID:        response.ID,
+		Content:   content,
+		Reasoning: reasoning,
+		Role:      "assistant",
 		Usage: types.Usage{
 			PromptTokens:     response.Usage.PromptTokens,
 			CompletionTokens: response.Usage.CompletionTokens,
 			TotalTokens:      response.Usage.TotalTokens,
+			CachedTokens:     response.Usage.PromptCacheHitTokens,
 		},
-		ToolCalls: toolCalls,
+		ToolCalls:    toolCalls,
+		FinishReason: normalizeFinishReason(finishReason, len(toolCalls) > 0),
 	}
 }
 
@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestDeepSeekStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := "data: {\"id\":\"chat-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"你好\"}}]}\n\n"
+		// 声明的长度大于实际写入的内容，连接在响应中途断开
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Length", fmt.Sprint(len(chunk)+100))
+		fmt.Fprint(w, chunk)
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewDeepSeekClient(types.LLMConfig{Provider: types.ProviderDeepSeek, APIKey: "test", BaseURL: server.URL + "/", Model: "deepseek-chat"}, logger)
+
+	stream, err := client.ChatStream(context.Background(), types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "你好"}}})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content strings.Builder
+	var last types.LLMResponse
+	for resp := range stream {
+		content.WriteString(resp.Content)
+		last = resp
+	}
+	if content.String() != "你好" {
+		t.Errorf("unexpected content: %q", content.String())
+	}
+	if StreamError(last) == nil {
+		t.Errorf("expected the last chunk to carry the read error, got %+v", last)
+	}
+}
@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zboya/nala-coder/pkg/tokenizer"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// defaultEmbeddingBatchSize 未配置时每次请求的最大输入条数
+const defaultEmbeddingBatchSize = 64
+
+// ErrEmbeddingsNotSupported 提供商不支持向量化
+var ErrEmbeddingsNotSupported = errors.New("embeddings not supported by provider")
+
+// embeddingProtocols 支持向量化的协议
+var embeddingProtocols = map[types.LLMProvider]bool{
+	types.ProviderOpenAI: true,
+	types.ProviderOllama: true,
+	types.ProviderMock:   true,
+}
+
+// Embedder 返回客户端的向量化接口。是否支持由最内层的客户端决定，
+// 返回最外层实现了接口的包装，使向量化请求同样经过重试
+func Embedder(client types.LLMClient) (types.Embedder, bool) {
+	var outer types.Embedder
+	for client != nil {
+		if embedder, ok := client.(types.Embedder); ok && outer == nil {
+			outer = embedder
+		}
+		wrapper, ok := client.(interface{ Unwrap() types.LLMClient })
+		if !ok {
+			_, supported := client.(types.Embedder)
+			return outer, supported
+		}
+		client = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// SetEmbeddingProvider 设置默认向量化提供商
+func (m *Manager) SetEmbeddingProvider(provider types.LLMProvider) error {
+	client, exists := m.clients[provider]
+	if !exists {
+		return fmt.Errorf("embedding provider %s not found", provider)
+	}
+	if _, ok := Embedder(client); !ok {
+		return fmt.Errorf("embedding provider %s: %w", provider, ErrEmbeddingsNotSupported)
+	}
+
+	m.embeddingProvider = provider
+	return nil
+}
+
+// EmbeddingProvider 默认向量化提供商，未配置时为空
+func (m *Manager) EmbeddingProvider() types.LLMProvider {
+	return m.embeddingProvider
+}
+
+// Embed 使用默认向量化提供商生成向量
+func (m *Manager) Embed(ctx context.Context, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	if m.embeddingProvider == "" {
+		return nil, fmt.Errorf("no embedding provider configured")
+	}
+	return m.EmbedWithProvider(ctx, m.embeddingProvider, request)
+}
+
+// EmbedWithProvider 使用指定提供商生成向量，输入按配置的批大小分批请求，共享该提供商的限流
+func (m *Manager) EmbedWithProvider(ctx context.Context, provider types.LLMProvider, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	client, exists := m.clients[provider]
+	if !exists {
+		return nil, fmt.Errorf("LLM provider %s not found", provider)
+	}
+	embedder, ok := Embedder(client)
+	if !ok {
+		return nil, fmt.Errorf("LLM provider %s: %w", provider, ErrEmbeddingsNotSupported)
+	}
+
+	config := client.GetConfig()
+	if request.Model == "" {
+		request.Model = config.EmbeddingModel
+	}
+	if request.Dimensions == 0 {
+		request.Dimensions = config.EmbeddingDimensions
+	}
+
+	return embedInBatches(ctx, request, config.EmbeddingBatchSize, embedder.Embed)
+}
+
+// embedInBatches 分批生成向量并按输入顺序合并，校验每批返回的向量数和维度
+func embedInBatches(ctx context.Context, request types.EmbeddingRequest, batchSize int, embed func(ctx context.Context, batch types.EmbeddingRequest) (*types.EmbeddingResponse, error)) (*types.EmbeddingResponse, error) {
+	if len(request.Input) == 0 {
+		return nil, fmt.Errorf("embedding input is empty")
+	}
+	if batchSize <= 0 {
+		batchSize = defaultEmbeddingBatchSize
+	}
+
+	result := &types.EmbeddingResponse{
+		Model:      request.Model,
+		Embeddings: make([][]float32, 0, len(request.Input)),
+	}
+	for start := 0; start < len(request.Input); start += batchSize {
+		batch := request
+		batch.Input = request.Input[start:min(start+batchSize, len(request.Input))]
+
+		response, err := embed(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed inputs %d-%d: %w", start, start+len(batch.Input)-1, err)
+		}
+		if len(response.Embeddings) != len(batch.Input) {
+			return nil, fmt.Errorf("expected %d embeddings, got %d", len(batch.Input), len(response.Embeddings))
+		}
+
+		for _, embedding := range response.Embeddings {
+			if result.Dimensions == 0 {
+				result.Dimensions = len(embedding)
+			}
+			if len(embedding) != result.Dimensions {
+				return nil, fmt.Errorf("inconsistent embedding dimensions: %d and %d", result.Dimensions, len(embedding))
+			}
+		}
+
+		result.Embeddings = append(result.Embeddings, response.Embeddings...)
+		result.Usage.Add(response.Usage)
+		if response.Model != "" {
+			result.Model = response.Model
+		}
+	}
+
+	return result, nil
+}
+
+// embeddingModel 返回向量化使用的模型，未指定时报错
+func embeddingModel(request types.EmbeddingRequest, config types.LLMConfig) (string, error) {
+	if request.Model != "" {
+		return request.Model, nil
+	}
+	if config.EmbeddingModel != "" {
+		return config.EmbeddingModel, nil
+	}
+	return "", fmt.Errorf("embedding_model is not configured for provider %s", config.Provider)
+}
+
+// estimateEmbeddingTokens 调用前估算向量化输入的token数，只用于限流
+func estimateEmbeddingTokens(input []string) int {
+	counter := tokenizer.Heuristic()
+
+	tokens := 0
+	for _, text := range input {
+		tokens += counter.Count(text)
+	}
+	return tokens
+}
@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestOpenAIEmbedBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input      []string `json:"input"`
+			Model      string   `json:"model"`
+			Dimensions int      `json:"dimensions"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1/embeddings" || req.Model != "text-embedding-3-small" || req.Dimensions != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batches = append(batches, req.Input)
+		mu.Unlock()
+
+		// 倒序返回，客户端按 index 还原顺序
+		var data []string
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			value := float64(len(req.Input[i]))
+			data = append(data, fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%v,0,1]}`, i, value))
+		}
+		fmt.Fprintf(w, `{"object":"list","model":"text-embedding-3-small","data":[%s],"usage":{"prompt_tokens":%d,"total_tokens":%d}}`,
+			strings.Join(data, ","), len(req.Input), len(req.Input))
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client, err := CreateClient(types.ProviderOpenAI, types.LLMConfig{
+		APIKey:              "key",
+		BaseURL:             server.URL + "/v1",
+		EmbeddingModel:      "text-embedding-3-small",
+		EmbeddingDimensions: 3,
+		EmbeddingBatchSize:  2,
+	}, logger)
+	if err != nil {
+		t.Fatalf("CreateClient error: %v", err)
+	}
+
+	manager := NewManager(types.ProviderOpenAI, logger)
+	manager.RegisterClient(types.ProviderOpenAI, client)
+	if err := manager.SetEmbeddingProvider(types.ProviderOpenAI); err != nil {
+		t.Fatalf("SetEmbeddingProvider error: %v", err)
+	}
+
+	response, err := manager.Embed(context.Background(), types.EmbeddingRequest{Input: []string{"a", "bb", "ccc", "dddd", "eeeee"}})
+	if err != nil {
+		t.Fatalf("Embed error: %v", err)
+	}
+
+	if len(batches) != 3 || len(batches[2]) != 1 {
+		t.Errorf("expected batches of 2, got %v", batches)
+	}
+	if response.Dimensions != 3 || len(response.Embeddings) != 5 || response.Usage.PromptTokens != 5 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	for i, embedding := range response.Embeddings {
+		if embedding[0] != float32(i+1) {
+			t.Errorf("embedding %d out of order: %v", i, embedding)
+		}
+	}
+}
+
+func TestOpenAIEmbedMissingIndex(t *testing.T) {
+	// 两条输入都未返回 index，解析为同一个位置
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"object":"list","model":"text-embedding-3-small","data":[{"object":"embedding","embedding":[1,0]},{"object":"embedding","embedding":[0,1]}]}`)
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewOpenAIClient(types.LLMConfig{APIKey: "key", BaseURL: server.URL + "/v1", EmbeddingModel: "text-embedding-3-small"}, logger)
+
+	_, err := client.Embed(context.Background(), types.EmbeddingRequest{Input: []string{"a", "b"}})
+	if err == nil || !strings.Contains(err.Error(), "no embedding for input 1") {
+		t.Errorf("expected missing embedding error, got %v", err)
+	}
+}
+
+func TestEmbeddingProviderSupport(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+
+	claude, _ := CreateClient(types.ProviderClaude, types.LLMConfig{APIKey: "key"}, logger)
+	mock := NewMockClientFromScript(types.LLMConfig{Provider: types.ProviderMock}, MockScript{}, logger)
+
+	manager := NewManager(types.ProviderClaude, logger)
+	manager.RegisterClient(types.ProviderClaude, claude)
+	manager.RegisterClient(types.ProviderMock, NewRetryClient(mock, types.RetryConfig{}, logger))
+
+	if err := manager.SetEmbeddingProvider(types.ProviderClaude); !errors.Is(err, ErrEmbeddingsNotSupported) {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+	if _, err := manager.Embed(context.Background(), types.EmbeddingRequest{Input: []string{"x"}}); err == nil {
+		t.Errorf("expected error without embedding provider")
+	}
+
+	response, err := manager.EmbedWithProvider(context.Background(), types.ProviderMock, types.EmbeddingRequest{
+		Input:      []string{"read file", "read the file", "run tests"},
+		Dimensions: 32,
+	})
+	if err != nil {
+		t.Fatalf("EmbedWithProvider error: %v", err)
+	}
+	similarity := func(a, b []float32) float32 {
+		var dot float32
+		for i := range a {
+			dot += a[i] * b[i]
+		}
+		return dot
+	}
+	if response.Dimensions != 32 || similarity(response.Embeddings[0], response.Embeddings[1]) <= similarity(response.Embeddings[0], response.Embeddings[2]) {
+		t.Errorf("expected similar texts to have closer mock embeddings: %+v", response)
+	}
+
+	config := Config{
+		DefaultProvider:   types.ProviderDeepSeek,
+		EmbeddingProvider: types.ProviderDeepSeek,
+		DeepSeek:          types.LLMConfig{APIKey: "key", EmbeddingModel: "x"},
+	}
+	if err := config.ValidateConfig(); err == nil || !strings.Contains(err.Error(), "does not support embeddings") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+	config.EmbeddingProvider = "local"
+	config.Providers = map[string]types.LLMConfig{"local": {Protocol: "Ollama", BaseURL: "http://localhost:11434"}}
+	if err := config.ValidateConfig(); err == nil || !strings.Contains(err.Error(), "no embedding_model") {
+		t.Errorf("expected missing model error, got %v", err)
+	}
+}
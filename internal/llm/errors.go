@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/sashabaranov/go-openai"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// APIError 提供商返回的非200 HTTP错误
+type APIError struct {
+	Provider   types.LLMProvider
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API returned status %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// streamError 流式响应块中携带的错误
+type streamError struct {
+	message    string
+	statusCode int
+	transport  bool
+}
+
+// Error 实现error接口
+func (e *streamError) Error() string {
+	return e.message
+}
+
+// statusCodePattern 从SDK未结构化的错误信息中提取HTTP状态码
+var statusCodePattern = regexp.MustCompile(`(?i)(?:status(?:\s+code)?|http)[:=]?\s*(\d{3})\b`)
+
+// StatusCodeOf 提取错误对应的HTTP状态码，无法识别时返回0
+func StatusCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+
+	var streamErr *streamError
+	if errors.As(err, &streamErr) && streamErr.statusCode > 0 {
+		return streamErr.statusCode
+	}
+
+	var openaiAPIErr *openai.APIError
+	if errors.As(err, &openaiAPIErr) {
+		return openaiAPIErr.HTTPStatusCode
+	}
+
+	var openaiReqErr *openai.RequestError
+	if errors.As(err, &openaiReqErr) {
+		return openaiReqErr.HTTPStatusCode
+	}
+
+	var ollamaErr api.StatusError
+	if errors.As(err, &ollamaErr) {
+		return ollamaErr.StatusCode
+	}
+
+	if matches := statusCodePattern.FindStringSubmatch(err.Error()); len(matches) == 2 {
+		code, _ := strconv.Atoi(matches[1])
+		return code
+	}
+
+	return 0
+}
+
+// IsTransportError 判断是否为网络传输错误或超时
+func IsTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var streamErr *streamError
+	if errors.As(err, &streamErr) {
+		return streamErr.transport
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// IsFailoverError 判断错误是否应切换到下一个提供商：网络错误、超时或5xx
+func IsFailoverError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if IsTransportError(err) {
+		return true
+	}
+
+	return StatusCodeOf(err) >= 500
+}
@@ -8,21 +8,53 @@ import (
 	"github.com/zboya/nala-coder/pkg/types"
 )
 
-// CreateClient 根据配置创建LLM客户端
+// CreateClient 根据配置创建LLM客户端，并按配置包装限流、重试和结构化输出校验。
+// 命名提供商按 config.Protocol 选择客户端实现，客户端以 provider 作为名称。
 func CreateClient(provider types.LLMProvider, config types.LLMConfig, logger log.Logger) (types.LLMClient, error) {
-	provider = types.LLMProvider(strings.ToLower(string(provider)))
-	switch provider {
+	if config.Provider == "" {
+		config.Provider = provider
+	}
+
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = provider
+	}
+	protocol = types.LLMProvider(strings.ToLower(string(protocol)))
+
+	var client types.LLMClient
+	switch protocol {
 	case types.ProviderOpenAI:
-		return NewOpenAIClient(config, logger), nil
+		client = NewOpenAIClient(config, logger)
 	case types.ProviderDeepSeek:
-		return NewDeepSeekClient(config, logger), nil
+		deepseekClient := NewDeepSeekClient(config, logger)
+		if deepseekClient == nil {
+			return nil, fmt.Errorf("failed to create DeepSeek client")
+		}
+		client = deepseekClient
 	case types.ProviderClaude:
-		return NewClaudeClient(config, logger), nil
+		client = NewClaudeClient(config, logger)
 	case types.ProviderOllama:
-		return NewOllamaClient(config, logger), nil
+		client = NewOllamaClient(config, logger)
+	case types.ProviderGemini:
+		client = NewGeminiClient(config, logger)
+	case types.ProviderMock:
+		mockClient, err := NewMockClient(config, logger)
+		if err != nil {
+			return nil, err
+		}
+		client = mockClient
+	case types.ProviderReplay:
+		// 回放不访问网络，无需重试；record模式需要上游客户端，由 CreateManagerFromConfigs 创建
+		return NewReplayClient(config, nil, logger)
 	default:
-		return nil, fmt.Errorf("unsupported LLM provider: %s", provider)
+		return nil, fmt.Errorf("unsupported LLM provider: %s", protocol)
 	}
+
+	// 限流在重试之内，每次尝试都受限流约束
+	if limiter := NewRateLimiter(config.RateLimit); limiter != nil {
+		client = NewRateLimitedClient(client, limiter, logger)
+	}
+	return NewStructuredClient(NewRetryClient(client, config.Retry, logger), logger), nil
 }
 
 // CreateManagerFromConfigs 从配置创建LLM管理器
@@ -30,6 +62,9 @@ func CreateManagerFromConfigs(configs map[types.LLMProvider]types.LLMConfig, def
 	manager := NewManager(defaultProvider, logger)
 
 	for provider, config := range configs {
+		if provider == types.ProviderReplay {
+			continue
+		}
 		client, err := CreateClient(provider, config, logger)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create client for provider %s: %w", provider, err)
@@ -37,6 +72,24 @@ func CreateManagerFromConfigs(configs map[types.LLMProvider]types.LLMConfig, def
 		manager.RegisterClient(provider, client)
 	}
 
+	// replay 提供商在 record 模式下包装已创建的上游客户端
+	if config, exists := configs[types.ProviderReplay]; exists {
+		var upstream types.LLMClient
+		if config.Upstream != "" {
+			client, err := manager.GetClient(config.Upstream)
+			if err != nil {
+				return nil, fmt.Errorf("replay upstream provider %s not configured: %w", config.Upstream, err)
+			}
+			upstream = client
+		}
+
+		client, err := NewReplayClient(config, upstream, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client for provider %s: %w", types.ProviderReplay, err)
+		}
+		manager.RegisterClient(types.ProviderReplay, client)
+	}
+
 	// 确保默认提供商存在
 	if _, err := manager.GetClient(defaultProvider); err != nil {
 		return nil, fmt.Errorf("default provider %s not configured: %w", defaultProvider, err)
@@ -44,3 +97,11 @@ func CreateManagerFromConfigs(configs map[types.LLMProvider]types.LLMConfig, def
 
 	return manager, nil
 }
+
+// providerName 返回客户端名称，命名提供商使用配置中的名称
+func providerName(config types.LLMConfig, protocol types.LLMProvider) types.LLMProvider {
+	if config.Provider != "" {
+		return config.Provider
+	}
+	return protocol
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// finishReasons 各提供商的结束原因到统一取值的映射
+var finishReasons = map[string]types.FinishReason{
+	// OpenAI / DeepSeek / Ollama
+	"stop":           types.FinishReasonStop,
+	"length":         types.FinishReasonLength,
+	"tool_calls":     types.FinishReasonToolCalls,
+	"function_call":  types.FinishReasonToolCalls,
+	"content_filter": types.FinishReasonContentFilter,
+	// DeepSeek 推理资源不足时中断生成，与截断同样处理
+	"insufficient_system_resource": types.FinishReasonLength,
+	// Claude
+	"end_turn":                      types.FinishReasonStop,
+	"stop_sequence":                 types.FinishReasonStop,
+	"pause_turn":                    types.FinishReasonStop,
+	"max_tokens":                    types.FinishReasonLength,
+	"model_context_window_exceeded": types.FinishReasonLength,
+	"tool_use":                      types.FinishReasonToolCalls,
+	"refusal":                       types.FinishReasonContentFilter,
+	// Gemini（MAX_TOKENS、STOP 与上面共用）
+	"safety":             types.FinishReasonContentFilter,
+	"recitation":         types.FinishReasonContentFilter,
+	"blocklist":          types.FinishReasonContentFilter,
+	"prohibited_content": types.FinishReasonContentFilter,
+	"spii":               types.FinishReasonContentFilter,
+}
+
+// normalizeFinishReason 统一结束原因，并按实际是否有工具调用修正：
+// 部分提供商产生工具调用时仍返回 stop，Claude 结构化输出的工具调用则已转为内容
+func normalizeFinishReason(reason string, hasToolCalls bool) types.FinishReason {
+	normalized, known := finishReasons[strings.ToLower(reason)]
+
+	switch {
+	case normalized == types.FinishReasonLength, normalized == types.FinishReasonContentFilter:
+		return normalized
+	case hasToolCalls:
+		return types.FinishReasonToolCalls
+	case normalized == types.FinishReasonToolCalls:
+		return types.FinishReasonStop
+	case known:
+		return normalized
+	case reason == "":
+		return ""
+	default:
+		return types.FinishReasonStop
+	}
+}
+
+// 错误响应块元数据中保留的错误分类，调用方据此判断能否故障转移
+const (
+	metadataStatusCode     = "status_code"
+	metadataTransportError = "transport_error"
+)
+
+// streamErrorResponse 流式响应中途出错时发送的最后一个响应块，调用方据此区分出错和正常结束
+func streamErrorResponse(err error) types.LLMResponse {
+	response := types.LLMResponse{
+		Role:         string(types.RoleAssistant),
+		FinishReason: types.FinishReasonError,
+		Error:        err.Error(),
+	}
+	if code := StatusCodeOf(err); code > 0 {
+		response.Metadata = map[string]string{metadataStatusCode: strconv.Itoa(code)}
+	}
+	if IsTransportError(err) {
+		if response.Metadata == nil {
+			response.Metadata = make(map[string]string)
+		}
+		response.Metadata[metadataTransportError] = "true"
+	}
+	return response
+}
+
+// StreamError 返回流式响应块携带的错误，正常的响应块返回 nil。
+// 返回的错误保留原错误的状态码和是否为网络错误
+func StreamError(response types.LLMResponse) error {
+	if response.FinishReason != types.FinishReasonError {
+		return nil
+	}
+
+	err := &streamError{
+		message:   response.Error,
+		transport: response.Metadata[metadataTransportError] == "true",
+	}
+	if err.message == "" {
+		err.message = "stream failed"
+	}
+	err.statusCode, _ = strconv.Atoi(response.Metadata[metadataStatusCode])
+	return err
+}
@@ -0,0 +1,65 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestNormalizeFinishReason(t *testing.T) {
+	tests := []struct {
+		reason       string
+		hasToolCalls bool
+		want         types.FinishReason
+	}{
+		{"stop", false, types.FinishReasonStop},
+		{"end_turn", false, types.FinishReasonStop},
+		{"length", false, types.FinishReasonLength},
+		{"max_tokens", true, types.FinishReasonLength},
+		{"tool_use", true, types.FinishReasonToolCalls},
+		{"stop", true, types.FinishReasonToolCalls},
+		{"", true, types.FinishReasonToolCalls},
+		{"tool_use", false, types.FinishReasonStop},
+		{"refusal", false, types.FinishReasonContentFilter},
+		{"insufficient_system_resource", false, types.FinishReasonLength},
+		{"", false, ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeFinishReason(tt.reason, tt.hasToolCalls); got != tt.want {
+			t.Errorf("normalizeFinishReason(%q, %t) = %q, want %q", tt.reason, tt.hasToolCalls, got, tt.want)
+		}
+	}
+}
+
+func TestClaudeStreamTruncated(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_01","role":"assistant","usage":{"input_tokens":10,"output_tokens":0}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_01","name":"write"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"file_path\":\"a.go\",\"content\":\"package"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":1024}}`,
+		`{"type":"message_stop"}`,
+	}
+	client := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	})
+
+	stream, err := client.ChatStream(context.Background(), claudeTestRequest())
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var final types.LLMResponse
+	for resp := range stream {
+		final = resp
+	}
+	if final.FinishReason != types.FinishReasonLength || len(final.ToolCalls) != 1 {
+		t.Errorf("expected truncated tool call, got %+v", final)
+	}
+}
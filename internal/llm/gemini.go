@@ -0,0 +1,637 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// GeminiClient Google Gemini客户端，基于 generateContent REST 接口
+type GeminiClient struct {
+	config     types.LLMConfig
+	httpClient *http.Client
+	logger     log.Logger
+}
+
+// GeminiRequest Gemini请求格式
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// GeminiContent Gemini消息内容，role 为 user 或 model
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+// GeminiPart Gemini内容片段，覆盖文本、图片、函数调用和函数结果
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+	InlineData       *GeminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// GeminiInlineData Gemini内嵌的图片数据
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// GeminiFunctionCall Gemini函数调用
+type GeminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// GeminiFunctionResponse Gemini函数执行结果
+type GeminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// GeminiTool Gemini工具定义
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+// GeminiFunctionDeclaration Gemini函数声明
+type GeminiFunctionDeclaration struct {
+	Name        string                          `json:"name"`
+	Description string                          `json:"description,omitempty"`
+	Parameters  types.ToolCallFunctionArguments `json:"parameters,omitempty"`
+}
+
+// GeminiToolConfig Gemini函数调用配置
+type GeminiToolConfig struct {
+	FunctionCallingConfig GeminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+// GeminiFunctionCallingConfig mode 为 AUTO、ANY 或 NONE
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// GeminiGenerationConfig Gemini生成参数
+type GeminiGenerationConfig struct {
+	MaxOutputTokens    int                   `json:"maxOutputTokens,omitempty"`
+	Temperature        float64               `json:"temperature,omitempty"`
+	ResponseMimeType   string                `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema map[string]any        `json:"responseJsonSchema,omitempty"`
+	ThinkingConfig     *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// GeminiThinkingConfig Gemini思考配置
+type GeminiThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget,omitempty"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+// GeminiResponse Gemini响应格式，流式响应的每个数据块格式相同
+type GeminiResponse struct {
+	ResponseID     string            `json:"responseId"`
+	Candidates     []GeminiCandidate `json:"candidates"`
+	UsageMetadata  *GeminiUsage      `json:"usageMetadata,omitempty"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason,omitempty"`
+	} `json:"promptFeedback,omitempty"`
+}
+
+// GeminiCandidate Gemini候选结果
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+}
+
+// GeminiUsage Gemini用量
+type GeminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+}
+
+// NewGeminiClient 创建Gemini客户端
+func NewGeminiClient(config types.LLMConfig, logger log.Logger) *GeminiClient {
+	return &GeminiClient{
+		config:     config,
+		httpClient: newHTTPClient(),
+		logger:     logger,
+	}
+}
+
+// GetConfig 获取配置
+func (c *GeminiClient) GetConfig() types.LLMConfig {
+	return c.config
+}
+
+// GetProvider 获取提供商
+func (c *GeminiClient) GetProvider() types.LLMProvider {
+	return providerName(c.config, types.ProviderGemini)
+}
+
+// Chat 对话
+func (c *GeminiClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	geminiReq := c.convertRequest(request)
+
+	resp, err := c.doRequest(ctx, c.getEndpoint(request.Model, false), geminiReq)
+	if err != nil {
+		return nil, fmt.Errorf("Gemini API error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var geminiResp GeminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	var acc geminiAccumulator
+	acc.add(geminiResp)
+	return acc.response(), nil
+}
+
+// ChatStream 流式对话
+func (c *GeminiClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	geminiReq := c.convertRequest(request)
+
+	resp, err := c.doRequest(ctx, c.getEndpoint(request.Model, true), geminiReq)
+	if err != nil {
+		return nil, fmt.Errorf("Gemini stream API error: %w", err)
+	}
+
+	responseChan := make(chan types.LLMResponse, 10)
+
+	go func() {
+		defer close(responseChan)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+		// 函数调用、用量和结束原因汇总后随最后一个响应块发送
+		var acc geminiAccumulator
+
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+			var chunk GeminiResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				c.logger.Debugf("Failed to parse Gemini stream chunk: %v", err)
+				continue
+			}
+
+			content, reasoning := acc.add(chunk)
+			if content == "" && reasoning == "" {
+				continue
+			}
+
+			streamResp := types.LLMResponse{
+				ID:        acc.id,
+				Content:   content,
+				Reasoning: reasoning,
+				Role:      string(types.RoleAssistant),
+			}
+			select {
+			case responseChan <- streamResp:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		final := acc.response()
+		final.Content = ""
+		final.Reasoning = ""
+		// 读取失败时以错误结束，调用方不会把不完整的回复当作正常结束
+		if err := scanner.Err(); err != nil {
+			c.logger.Errorf("Gemini stream read error: %v", err)
+			*final = streamErrorResponse(fmt.Errorf("Gemini stream read error: %w", err))
+		}
+		select {
+		case responseChan <- *final:
+		case <-ctx.Done():
+		}
+	}()
+
+	return responseChan, nil
+}
+
+// GeminiModelList 模型列表接口响应
+type GeminiModelList struct {
+	Models []GeminiModel `json:"models"`
+}
+
+// GeminiModel 模型信息
+type GeminiModel struct {
+	Name                       string   `json:"name"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+	Thinking                   bool     `json:"thinking"`
+}
+
+// Ping 通过模型列表接口检查连通性和密钥
+func (c *GeminiClient) Ping(ctx context.Context) error {
+	_, err := c.listModels(ctx)
+	return err
+}
+
+// ListModels 列出支持 generateContent 的模型
+func (c *GeminiClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	list, err := c.listModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []types.ModelInfo
+	for _, model := range list {
+		if slices.Contains(model.SupportedGenerationMethods, "generateContent") {
+			models = append(models, types.ModelInfo{ID: strings.TrimPrefix(model.Name, "models/"), OwnedBy: "google"})
+		}
+	}
+	return models, nil
+}
+
+// Capabilities 模型列表中返回的 thinking 字段用于判断推理能力，其余按模型名推断
+func (c *GeminiClient) Capabilities(ctx context.Context, model string) (types.ModelCapabilities, error) {
+	name := strings.TrimPrefix(c.getModel(model), "models/")
+	capabilities := inferCapabilities(types.ProviderGemini, name)
+
+	list, err := c.listModels(ctx)
+	if err != nil {
+		return capabilities, err
+	}
+	for _, info := range list {
+		if strings.TrimPrefix(info.Name, "models/") == name {
+			capabilities.Reasoning = info.Thinking
+			capabilities.Probed = true
+			break
+		}
+	}
+	return capabilities, nil
+}
+
+// listModels 请求 /v1beta/models
+func (c *GeminiClient) listModels(ctx context.Context) ([]GeminiModel, error) {
+	baseURL := c.config.BaseURL
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com"
+	}
+	header := http.Header{"X-Goog-Api-Key": []string{c.config.APIKey}}
+
+	var list GeminiModelList
+	if err := getJSON(ctx, c.httpClient, c.GetProvider(), strings.TrimSuffix(baseURL, "/")+"/v1beta/models?pageSize=1000", header, &list); err != nil {
+		return nil, err
+	}
+	return list.Models, nil
+}
+
+// doRequest 发送请求，非200状态返回 APIError
+func (c *GeminiClient) doRequest(ctx context.Context, endpoint string, geminiReq GeminiRequest) (*http.Response, error) {
+	reqBody, err := json.Marshal(geminiReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", c.config.APIKey)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{
+			Provider:   c.GetProvider(),
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	return resp, nil
+}
+
+// convertRequest 转换请求格式
+func (c *GeminiClient) convertRequest(request types.LLMRequest) GeminiRequest {
+	var contents []GeminiContent
+	var system []GeminiPart
+	// 函数结果需要函数名，按调用ID从之前的助手消息中查找
+	toolNames := make(map[string]string)
+
+	for _, msg := range request.Messages {
+		var role string
+		var parts []GeminiPart
+
+		switch msg.Role {
+		case types.RoleSystem:
+			system = append(system, GeminiPart{Text: msg.Content})
+			continue
+
+		case types.RoleAssistant:
+			role = "model"
+			signatures := c.thoughtSignatures(msg)
+			if msg.Content != "" {
+				parts = append(parts, GeminiPart{Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				toolNames[tc.ID] = tc.Function.Name
+				parts = append(parts, GeminiPart{
+					FunctionCall: &GeminiFunctionCall{
+						Name: tc.Function.Name,
+						Args: c.convertArgs(tc.Function.Arguments),
+					},
+					ThoughtSignature: signatures[tc.ID],
+				})
+			}
+
+		case types.RoleTool:
+			role = "user"
+			toolCallID := msg.ToolCallID
+			if toolCallID == "" {
+				toolCallID = msg.Metadata["tool_call_id"]
+			}
+			name := toolNames[toolCallID]
+			if name == "" {
+				name = msg.Metadata["tool_name"]
+			}
+
+			result := map[string]any{"output": msg.Content}
+			if msg.Metadata["success"] == "false" {
+				result = map[string]any{"error": msg.Content}
+			}
+			parts = append(parts, GeminiPart{
+				FunctionResponse: &GeminiFunctionResponse{Name: name, Response: result},
+			})
+			parts = append(parts, c.convertImages(msg.Parts)...)
+
+		default:
+			role = "user"
+			if msg.Content != "" {
+				parts = append(parts, GeminiPart{Text: msg.Content})
+			}
+			parts = append(parts, c.convertImages(msg.Parts)...)
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		// 相邻同角色消息合并，同一轮的多个函数结果放在一条内容中
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			continue
+		}
+		contents = append(contents, GeminiContent{Role: role, Parts: parts})
+	}
+
+	geminiReq := GeminiRequest{
+		Contents:         contents,
+		Tools:            c.convertTools(request.Tools),
+		GenerationConfig: c.buildGenerationConfig(request),
+	}
+	if len(system) > 0 {
+		geminiReq.SystemInstruction = &GeminiContent{Parts: system}
+	}
+
+	return geminiReq
+}
+
+// buildGenerationConfig 构建生成参数
+func (c *GeminiClient) buildGenerationConfig(request types.LLMRequest) *GeminiGenerationConfig {
+	config := &GeminiGenerationConfig{
+		MaxOutputTokens: request.MaxTokens,
+		Temperature:     request.Temperature,
+	}
+	if config.MaxOutputTokens == 0 {
+		config.MaxOutputTokens = c.config.MaxTokens
+	}
+	if config.Temperature == 0 {
+		config.Temperature = c.config.Temperature
+	}
+
+	if c.config.ThinkingBudget > 0 {
+		config.ThinkingConfig = &GeminiThinkingConfig{ThinkingBudget: c.config.ThinkingBudget, IncludeThoughts: true}
+	}
+
+	if request.ResponseFormat != nil {
+		config.ResponseMimeType = "application/json"
+		config.ResponseJSONSchema = request.ResponseFormat.Schema
+	}
+
+	return config
+}
+
+// convertTools 转换为函数声明
+func (c *GeminiClient) convertTools(tools []types.Tool) []GeminiTool {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	declarations := make([]GeminiFunctionDeclaration, len(tools))
+	for i, tool := range tools {
+		declarations[i] = GeminiFunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		}
+	}
+	return []GeminiTool{{FunctionDeclarations: declarations}}
+}
+
+// convertArgs 将工具调用参数转换为 args 字段，非法JSON回退为空对象
+func (c *GeminiClient) convertArgs(arguments string) json.RawMessage {
+	if arguments == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// convertImages 将图片片段转换为 inlineData
+func (c *GeminiClient) convertImages(parts []types.ContentPart) []GeminiPart {
+	var result []GeminiPart
+	for _, part := range parts {
+		if part.Type != types.ContentPartImage {
+			continue
+		}
+		mediaType, data, err := utils.ResolveImage(part)
+		if err != nil {
+			c.logger.Warnf("Skip image: %v", err)
+			continue
+		}
+		result = append(result, GeminiPart{
+			InlineData: &GeminiInlineData{
+				MimeType: mediaType,
+				Data:     base64.StdEncoding.EncodeToString(data),
+			},
+		})
+	}
+	return result
+}
+
+// thoughtSignatures 还原函数调用的思考签名，开启思考的模型要求签名随函数调用回传
+func (c *GeminiClient) thoughtSignatures(msg types.Message) map[string]string {
+	state := msg.Metadata[types.MetadataReasoningState]
+	if state == "" {
+		return nil
+	}
+
+	var signatures map[string]string
+	if err := json.Unmarshal([]byte(state), &signatures); err != nil {
+		// 会话中途切换提供商时，保存的是其他提供商的推理状态
+		c.logger.Debugf("Ignore non-Gemini reasoning state: %v", err)
+		return nil
+	}
+	return signatures
+}
+
+// getEndpoint 获取接口地址
+func (c *GeminiClient) getEndpoint(requestModel string, stream bool) string {
+	baseURL := c.config.BaseURL
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com"
+	}
+
+	model := strings.TrimPrefix(c.getModel(requestModel), "models/")
+	endpoint := fmt.Sprintf("%s/v1beta/models/%s:generateContent", strings.TrimSuffix(baseURL, "/"), model)
+	if stream {
+		endpoint = fmt.Sprintf("%s/v1beta/models/%s:streamGenerateContent?alt=sse", strings.TrimSuffix(baseURL, "/"), model)
+	}
+	return endpoint
+}
+
+// getModel 获取模型名称
+func (c *GeminiClient) getModel(requestModel string) string {
+	if requestModel != "" {
+		return requestModel
+	}
+	return c.config.Model
+}
+
+// geminiAccumulator 汇总一次调用的响应，流式和非流式共用
+type geminiAccumulator struct {
+	id           string
+	content      strings.Builder
+	reasoning    strings.Builder
+	toolCalls    []types.ToolCall
+	signatures   map[string]string
+	usage        types.Usage
+	finishReason string
+}
+
+// add 合并一个响应块，返回其中新增的文本和思考内容
+func (a *geminiAccumulator) add(resp GeminiResponse) (content, reasoning string) {
+	if resp.ResponseID != "" {
+		a.id = resp.ResponseID
+	}
+	// 每个数据块中的用量都是截至当前的累计值
+	if resp.UsageMetadata != nil {
+		a.usage = convertGeminiUsage(*resp.UsageMetadata)
+	}
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		a.finishReason = "safety"
+	}
+	if len(resp.Candidates) == 0 {
+		return "", ""
+	}
+
+	candidate := resp.Candidates[0]
+	if candidate.FinishReason != "" {
+		a.finishReason = candidate.FinishReason
+	}
+
+	var contentDelta, reasoningDelta strings.Builder
+	for _, part := range candidate.Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			id := part.FunctionCall.ID
+			if id == "" {
+				id = "call_" + utils.GenerateID()
+			}
+			arguments := "{}"
+			if len(part.FunctionCall.Args) > 0 {
+				arguments = string(part.FunctionCall.Args)
+			}
+			a.toolCalls = append(a.toolCalls, types.ToolCall{
+				ID:   id,
+				Type: "function",
+				Function: types.ToolCallFunction{
+					Name:      part.FunctionCall.Name,
+					Arguments: arguments,
+				},
+			})
+			if part.ThoughtSignature != "" {
+				if a.signatures == nil {
+					a.signatures = make(map[string]string)
+				}
+				a.signatures[id] = part.ThoughtSignature
+			}
+		case part.Thought:
+			reasoningDelta.WriteString(part.Text)
+		default:
+			contentDelta.WriteString(part.Text)
+		}
+	}
+
+	a.content.WriteString(contentDelta.String())
+	a.reasoning.WriteString(reasoningDelta.String())
+	return contentDelta.String(), reasoningDelta.String()
+}
+
+// response 构建汇总后的响应
+func (a *geminiAccumulator) response() *types.LLMResponse {
+	response := &types.LLMResponse{
+		ID:           a.id,
+		Content:      a.content.String(),
+		Reasoning:    a.reasoning.String(),
+		Role:         string(types.RoleAssistant),
+		ToolCalls:    a.toolCalls,
+		Usage:        a.usage,
+		FinishReason: normalizeFinishReason(a.finishReason, len(a.toolCalls) > 0),
+	}
+
+	if len(a.signatures) > 0 {
+		state, _ := json.Marshal(a.signatures)
+		response.Metadata = map[string]string{types.MetadataReasoningState: string(state)}
+	}
+	return response
+}
+
+// convertGeminiUsage 转换用量，思考token计入输出
+func convertGeminiUsage(usage GeminiUsage) types.Usage {
+	return types.Usage{
+		PromptTokens:     usage.PromptTokenCount,
+		CompletionTokens: usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
+		TotalTokens:      usage.TotalTokenCount,
+		CachedTokens:     usage.CachedContentTokenCount,
+	}
+}
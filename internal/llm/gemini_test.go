@@ -0,0 +1,262 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func newGeminiTestClient(t *testing.T, handler http.HandlerFunc) *GeminiClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	logger, _ := log.New(log.DefaultConfig())
+	return NewGeminiClient(types.LLMConfig{
+		APIKey:    "test-key",
+		BaseURL:   server.URL + "/",
+		Model:     "gemini-test",
+		MaxTokens: 1024,
+	}, logger)
+}
+
+func geminiTestRequest() types.LLMRequest {
+	return types.LLMRequest{
+		Messages: []types.Message{
+			{Role: types.RoleSystem, Content: "You are a helpful assistant."},
+			{Role: types.RoleUser, Content: "北京和上海的天气怎么样？"},
+			{
+				Role: types.RoleAssistant,
+				ToolCalls: []types.ToolCall{
+					{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"北京"}`}},
+					{ID: "call_2", Type: "function", Function: types.ToolCallFunction{Name: "get_weather", Arguments: `{"city":"上海"}`}},
+				},
+			},
+			{Role: types.RoleTool, Content: "晴，25度", Metadata: map[string]string{"tool_call_id": "call_1"}},
+			{Role: types.RoleTool, Content: "city not found", Metadata: map[string]string{"tool_call_id": "call_2", "success": "false"}},
+		},
+		Tools: []types.Tool{{
+			Type: "function",
+			Function: types.ToolFunction{
+				Name:        "get_weather",
+				Description: "获取城市天气",
+				Parameters: types.ToolCallFunctionArguments{
+					"type":       "object",
+					"properties": map[string]any{"city": map[string]any{"type": "string"}},
+					"required":   []string{"city"},
+				},
+			},
+		}},
+	}
+}
+
+// checkGeminiRequest 校验请求转换：系统指令、函数声明以及合并后的函数结果
+func checkGeminiRequest(t *testing.T, r *http.Request, wantPath string) {
+	t.Helper()
+
+	if r.URL.Path != wantPath {
+		t.Errorf("unexpected path: %s", r.URL.Path)
+	}
+	if r.Header.Get("x-goog-api-key") != "test-key" {
+		t.Errorf("missing api key header")
+	}
+
+	var req GeminiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		t.Fatalf("failed to decode request: %v", err)
+	}
+
+	if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "You are a helpful assistant." {
+		t.Errorf("unexpected system instruction: %+v", req.SystemInstruction)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].FunctionDeclarations[0].Name != "get_weather" {
+		t.Errorf("unexpected tools: %+v", req.Tools)
+	}
+	if req.GenerationConfig == nil || req.GenerationConfig.MaxOutputTokens != 1024 {
+		t.Errorf("unexpected generation config: %+v", req.GenerationConfig)
+	}
+
+	if len(req.Contents) != 3 {
+		t.Fatalf("expected 3 contents, got %d: %+v", len(req.Contents), req.Contents)
+	}
+
+	model := req.Contents[1]
+	if model.Role != "model" || len(model.Parts) != 2 || model.Parts[0].FunctionCall == nil || string(model.Parts[0].FunctionCall.Args) != `{"city":"北京"}` {
+		t.Errorf("unexpected model content: %+v", model)
+	}
+
+	results := req.Contents[2]
+	if results.Role != "user" || len(results.Parts) != 2 {
+		t.Fatalf("expected both function responses in one content, got %+v", results)
+	}
+	first, second := results.Parts[0].FunctionResponse, results.Parts[1].FunctionResponse
+	if first == nil || first.Name != "get_weather" || first.Response["output"] != "晴，25度" {
+		t.Errorf("unexpected function response: %+v", first)
+	}
+	if second == nil || second.Response["error"] != "city not found" {
+		t.Errorf("unexpected error function response: %+v", second)
+	}
+}
+
+func TestGeminiChatWithTools(t *testing.T) {
+	client := newGeminiTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		checkGeminiRequest(t, r, "/v1beta/models/gemini-test:generateContent")
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"responseId": "resp-1",
+			"candidates": [{
+				"content": {"role": "model", "parts": [
+					{"text": "需要思考一下", "thought": true},
+					{"text": "我再查一下广州。"},
+					{"functionCall": {"name": "get_weather", "args": {"city": "广州"}}, "thoughtSignature": "sig-1"}
+				]},
+				"finishReason": "STOP"
+			}],
+			"usageMetadata": {"promptTokenCount": 50, "candidatesTokenCount": 10, "thoughtsTokenCount": 5, "cachedContentTokenCount": 20, "totalTokenCount": 65}
+		}`)
+	})
+
+	response, err := client.Chat(context.Background(), geminiTestRequest())
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+
+	if response.ID != "resp-1" || response.Content != "我再查一下广州。" || response.Reasoning != "需要思考一下" {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	if len(response.ToolCalls) != 1 || response.ToolCalls[0].Function.Arguments != `{"city": "广州"}` || response.ToolCalls[0].ID == "" {
+		t.Fatalf("unexpected tool calls: %+v", response.ToolCalls)
+	}
+	if response.FinishReason != types.FinishReasonToolCalls {
+		t.Errorf("unexpected finish reason: %q", response.FinishReason)
+	}
+
+	usage := response.Usage
+	if usage.PromptTokens != 50 || usage.CompletionTokens != 15 || usage.TotalTokens != 65 || usage.CachedTokens != 20 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+
+	// 思考签名随函数调用回传
+	request := client.convertRequest(types.LLMRequest{Messages: []types.Message{
+		{Role: types.RoleUser, Content: "广州天气"},
+		{Role: types.RoleAssistant, ToolCalls: response.ToolCalls, Metadata: response.Metadata},
+	}})
+	if part := request.Contents[1].Parts[0]; part.ThoughtSignature != "sig-1" {
+		t.Errorf("expected thought signature round trip, got %+v", part)
+	}
+}
+
+func TestGeminiChatStream(t *testing.T) {
+	chunks := []string{
+		`{"responseId":"resp-2","candidates":[{"content":{"role":"model","parts":[{"text":"北京晴，"}]}}],"usageMetadata":{"promptTokenCount":50,"totalTokenCount":50}}`,
+		`{"responseId":"resp-2","candidates":[{"content":{"role":"model","parts":[{"text":"上海未找到。"}]}}]}`,
+		`{"responseId":"resp-2","candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"广州"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":50,"candidatesTokenCount":12,"totalTokenCount":62}}`,
+	}
+
+	client := newGeminiTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		checkGeminiRequest(t, r, "/v1beta/models/gemini-test:streamGenerateContent")
+		if r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("expected alt=sse, got %s", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\r\n\r\n", chunk)
+		}
+	})
+
+	stream, err := client.ChatStream(context.Background(), geminiTestRequest())
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content strings.Builder
+	var final types.LLMResponse
+	for resp := range stream {
+		content.WriteString(resp.Content)
+		final = resp
+	}
+
+	if content.String() != "北京晴，上海未找到。" {
+		t.Errorf("unexpected content: %q", content.String())
+	}
+	if len(final.ToolCalls) != 1 || final.ToolCalls[0].Function.Name != "get_weather" {
+		t.Errorf("expected tool call on final chunk, got %+v", final)
+	}
+	if final.Usage.PromptTokens != 50 || final.Usage.CompletionTokens != 12 || final.FinishReason != types.FinishReasonToolCalls {
+		t.Errorf("unexpected final chunk: %+v", final)
+	}
+}
+
+func TestGeminiChatStreamReadError(t *testing.T) {
+	client := newGeminiTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		chunk := `data: {"responseId":"resp-3","candidates":[{"content":{"role":"model","parts":[{"text":"北京晴，"}]}}]}` + "\r\n\r\n"
+		// 声明的长度大于实际写入的内容，连接在响应中途断开
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Length", fmt.Sprint(len(chunk)+100))
+		fmt.Fprint(w, chunk)
+	})
+
+	stream, err := client.ChatStream(context.Background(), geminiTestRequest())
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content strings.Builder
+	var last types.LLMResponse
+	for resp := range stream {
+		content.WriteString(resp.Content)
+		last = resp
+	}
+	if content.String() != "北京晴，" {
+		t.Errorf("unexpected content: %q", content.String())
+	}
+	if StreamError(last) == nil {
+		t.Errorf("expected the last chunk to carry the read error, got %+v", last)
+	}
+}
+
+func TestGeminiStructuredOutput(t *testing.T) {
+	client := newGeminiTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req GeminiRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.GenerationConfig.ResponseMimeType != "application/json" || req.GenerationConfig.ResponseJSONSchema["type"] != "object" {
+			t.Errorf("unexpected generation config: %+v", req.GenerationConfig)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"{\"summary\":\"ok\",\"status\":\"done\"}"}]},"finishReason":"MAX_TOKENS"}]}`)
+	})
+
+	response, err := client.Chat(context.Background(), types.LLMRequest{
+		Messages:       []types.Message{{Role: types.RoleUser, Content: "总结"}},
+		ResponseFormat: &types.ResponseFormat{Name: "summary", Schema: summarySchema},
+	})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.FinishReason != types.FinishReasonLength {
+		t.Errorf("unexpected finish reason: %q", response.FinishReason)
+	}
+}
+
+func TestGeminiChatErrorStatus(t *testing.T) {
+	client := newGeminiTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"code":429,"message":"quota exceeded","status":"RESOURCE_EXHAUSTED"}}`)
+	})
+
+	_, err := client.Chat(context.Background(), geminiTestRequest())
+	if StatusCodeOf(err) != http.StatusTooManyRequests || !IsRetryableError(err) {
+		t.Errorf("expected retryable 429 error, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// HealthChecker 返回客户端实现的健康检查接口，会穿过重试、结构化输出等包装层
+func HealthChecker(client types.LLMClient) (types.LLMHealthChecker, bool) {
+	for client != nil {
+		if checker, ok := client.(types.LLMHealthChecker); ok {
+			return checker, true
+		}
+		wrapper, ok := client.(interface{ Unwrap() types.LLMClient })
+		if !ok {
+			break
+		}
+		client = wrapper.Unwrap()
+	}
+	return nil, false
+}
+
+// CheckProviders 并发检查所有已注册提供商的连通性、可用模型和配置模型的能力
+func (m *Manager) CheckProviders(ctx context.Context) []types.ProviderStatus {
+	providers := m.ListProviders()
+	sort.Slice(providers, func(i, j int) bool { return providers[i] < providers[j] })
+
+	statuses := make([]types.ProviderStatus, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, provider types.LLMProvider) {
+			defer wg.Done()
+			statuses[i] = m.checkProvider(ctx, provider)
+		}(i, provider)
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+// checkProvider 检查单个提供商，延迟为 Ping 的耗时
+func (m *Manager) checkProvider(ctx context.Context, provider types.LLMProvider) types.ProviderStatus {
+	client := m.clients[provider]
+	config := client.GetConfig()
+
+	status := types.ProviderStatus{
+		Provider: provider,
+		Protocol: config.Protocol,
+		Model:    config.Model,
+		Default:  provider == m.defaultProvider,
+	}
+	if status.Protocol == "" {
+		status.Protocol = provider
+	}
+
+	checker, ok := HealthChecker(client)
+	if !ok {
+		status.Error = "health check not supported"
+		return status
+	}
+	status.Supported = true
+
+	start := time.Now()
+	err := checker.Ping(ctx)
+	status.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+
+	models, err := checker.ListModels(ctx)
+	if err != nil {
+		status.Error = fmt.Sprintf("failed to list models: %v", err)
+	}
+	status.Models = models
+	if err == nil && config.Model != "" && !modelListed(models, config.Model) {
+		status.Error = fmt.Sprintf("configured model %s is not in the model list", config.Model)
+	}
+
+	capabilities, err := checker.Capabilities(ctx, config.Model)
+	if err != nil {
+		m.logger.Warnf("Failed to probe capabilities of %s: %v", provider, err)
+	} else {
+		status.Capabilities = &capabilities
+	}
+
+	return status
+}
+
+// modelListed 判断配置的模型是否在模型列表中，Ollama 省略标签时默认为 latest
+func modelListed(models []types.ModelInfo, model string) bool {
+	model = strings.TrimPrefix(model, "models/")
+	for _, info := range models {
+		if info.ID == model || info.ID == model+":latest" {
+			return true
+		}
+	}
+	return false
+}
+
+// inferCapabilities 无法通过接口查询时，按协议和模型名推断模型能力
+func inferCapabilities(protocol types.LLMProvider, model string) types.ModelCapabilities {
+	model = strings.ToLower(model)
+	containsAny := func(names ...string) bool {
+		for _, name := range names {
+			if strings.Contains(model, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch protocol {
+	case types.ProviderOpenAI:
+		reasoning := strings.HasPrefix(model, "o1") || strings.HasPrefix(model, "o3") || strings.HasPrefix(model, "o4") || strings.HasPrefix(model, "gpt-5")
+		return types.ModelCapabilities{
+			Tools:            true,
+			Vision:           reasoning || containsAny("gpt-4o", "gpt-4.1", "gpt-4-turbo", "vision"),
+			Reasoning:        reasoning,
+			StructuredOutput: true,
+		}
+	case types.ProviderDeepSeek:
+		return types.ModelCapabilities{
+			Tools:     true,
+			Reasoning: containsAny("reasoner", "r1"),
+		}
+	case types.ProviderClaude:
+		return types.ModelCapabilities{
+			Tools:            true,
+			Vision:           true,
+			Reasoning:        containsAny("claude-3-7", "sonnet-4", "opus-4", "haiku-4"),
+			StructuredOutput: true,
+		}
+	case types.ProviderGemini:
+		return types.ModelCapabilities{
+			Tools:            true,
+			Vision:           true,
+			Reasoning:        containsAny("2.5", "thinking"),
+			StructuredOutput: true,
+		}
+	default:
+		return types.ModelCapabilities{}
+	}
+}
+
+// getJSON 发送GET请求并解析JSON响应，非200状态返回 APIError
+func getJSON(ctx context.Context, client *http.Client, provider types.LLMProvider, url string, header http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &APIError{Provider: provider, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestCheckProviders(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/models" && r.Header.Get("Authorization") == "Bearer openai-key":
+			w.Write([]byte(`{"object":"list","data":[{"id":"gpt-4o","owned_by":"openai"},{"id":"gpt-4o-mini","owned_by":"openai"}]}`))
+		case r.URL.Path == "/v1beta/models" && r.Header.Get("X-Goog-Api-Key") == "gemini-key":
+			w.Write([]byte(`{"models":[
+				{"name":"models/gemini-2.5-flash","supportedGenerationMethods":["generateContent","countTokens"],"thinking":true},
+				{"name":"models/text-embedding-004","supportedGenerationMethods":["embedContent"]}]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid api key"}`))
+		}
+	}))
+	defer server.Close()
+
+	configs := map[types.LLMProvider]types.LLMConfig{
+		types.ProviderOpenAI: {APIKey: "openai-key", BaseURL: server.URL + "/v1", Model: "gpt-4o"},
+		types.ProviderClaude: {APIKey: "wrong-key", BaseURL: server.URL, Model: "claude-sonnet-4"},
+		types.ProviderGemini: {APIKey: "gemini-key", BaseURL: server.URL, Model: "gemini-2.0-pro"},
+		"vllm":               {Protocol: types.ProviderOpenAI, APIKey: "openai-key", BaseURL: server.URL + "/v1", Model: "qwen"},
+	}
+	manager := NewManager(types.ProviderOpenAI, logger)
+	for provider, config := range configs {
+		config.Retry.MaxRetries = -1
+		client, err := CreateClient(provider, config, logger)
+		if err != nil {
+			t.Fatalf("CreateClient error: %v", err)
+		}
+		manager.RegisterClient(provider, client)
+	}
+
+	statuses := manager.CheckProviders(context.Background())
+	byProvider := make(map[types.LLMProvider]types.ProviderStatus)
+	for _, status := range statuses {
+		byProvider[status.Provider] = status
+	}
+	if len(statuses) != 4 || statuses[0].Provider != types.ProviderClaude {
+		t.Fatalf("expected 4 statuses sorted by provider, got %+v", statuses)
+	}
+
+	openai := byProvider[types.ProviderOpenAI]
+	if !openai.Default || !openai.Reachable || len(openai.Models) != 2 || openai.Error != "" {
+		t.Errorf("unexpected openai status: %+v", openai)
+	}
+	if caps := openai.Capabilities; caps == nil || !caps.Tools || !caps.Vision || caps.Probed {
+		t.Errorf("unexpected openai capabilities: %+v", caps)
+	}
+
+	if claude := byProvider[types.ProviderClaude]; claude.Reachable || !claude.Supported || !strings.Contains(claude.Error, "status 401") {
+		t.Errorf("expected unauthorized claude status, got %+v", claude)
+	}
+
+	// 只列出可用于对话的模型，配置的模型不在列表中时给出提示
+	gemini := byProvider[types.ProviderGemini]
+	if !gemini.Reachable || len(gemini.Models) != 1 || gemini.Models[0].ID != "gemini-2.5-flash" || gemini.Error == "" {
+		t.Errorf("unexpected gemini status: %+v", gemini)
+	}
+
+	if vllm := byProvider["vllm"]; vllm.Protocol != types.ProviderOpenAI || vllm.Error == "" {
+		t.Errorf("expected missing model for vllm, got %+v", vllm)
+	}
+}
+
+func TestGeminiProbedCapabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"models/gemini-2.0-flash-thinking","supportedGenerationMethods":["generateContent"],"thinking":false}]}`))
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewGeminiClient(types.LLMConfig{BaseURL: server.URL, Model: "models/gemini-2.0-flash-thinking"}, logger)
+
+	caps, err := client.Capabilities(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Capabilities error: %v", err)
+	}
+	if !caps.Probed || caps.Reasoning {
+		t.Errorf("expected reasoning from model list, got %+v", caps)
+	}
+}
@@ -0,0 +1,353 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/tokenizer"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// rateWindow 每分钟限制的统计窗口
+const rateWindow = time.Minute
+
+// RateLimiter 单个提供商的限流器，限制并发请求数、每分钟请求数和每分钟token数。
+// 等待的请求按到达顺序放行，队首未放行前后面的请求不会插队，大请求不会被饿死
+type RateLimiter struct {
+	config types.RateLimitConfig
+
+	mu       sync.Mutex
+	inFlight int
+	queue    []*rateWaiter
+	window   []*rateEvent // 最近一分钟内放行的请求，按放行时间排序
+	timer    *time.Timer
+}
+
+// rateWaiter 排队中的请求
+type rateWaiter struct {
+	tokens int
+	ready  chan struct{}
+	event  *rateEvent
+}
+
+// rateEvent 一次放行的请求及其token数
+type rateEvent struct {
+	at     time.Time
+	tokens int
+}
+
+// NewRateLimiter 创建限流器，未设置任何限制时返回nil
+func NewRateLimiter(config types.RateLimitConfig) *RateLimiter {
+	if config.IsZero() {
+		return nil
+	}
+	return &RateLimiter{config: config}
+}
+
+// Acquire 排队等待放行，tokens 为估算的token数。
+// 返回的 release 在请求结束后调用，传入实际用量修正估算，小于0表示沿用估算值
+func (l *RateLimiter) Acquire(ctx context.Context, tokens int) (func(actual int), error) {
+	waiter := &rateWaiter{tokens: tokens, ready: make(chan struct{})}
+
+	l.mu.Lock()
+	l.queue = append(l.queue, waiter)
+	l.dispatchLocked()
+	l.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+	case <-ctx.Done():
+		l.cancel(waiter)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func(actual int) {
+		once.Do(func() { l.release(waiter.event, actual) })
+	}, nil
+}
+
+// QueueDepth 当前排队等待的请求数
+func (l *RateLimiter) QueueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// InFlight 当前进行中的请求数
+func (l *RateLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// cancel 调用方取消等待。已经放行的请求归还名额，未发出的请求不计入窗口
+func (l *RateLimiter) cancel(waiter *rateWaiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		l.inFlight--
+		for i, event := range l.window {
+			if event == waiter.event {
+				l.window = append(l.window[:i], l.window[i+1:]...)
+				break
+			}
+		}
+	default:
+		for i, w := range l.queue {
+			if w == waiter {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				break
+			}
+		}
+	}
+
+	l.dispatchLocked()
+}
+
+// release 请求结束，归还并发名额并修正token数
+func (l *RateLimiter) release(event *rateEvent, actual int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if actual >= 0 {
+		event.tokens = actual
+	}
+	l.dispatchLocked()
+}
+
+// dispatchLocked 按顺序放行队首满足限制的请求，受时间窗口限制时定时重试
+func (l *RateLimiter) dispatchLocked() {
+	now := time.Now()
+	l.pruneLocked(now)
+
+	for len(l.queue) > 0 {
+		waiter := l.queue[0]
+		ok, retryIn := l.admitLocked(waiter.tokens, now)
+		if !ok {
+			// retryIn 为0时受并发限制，等待请求结束后再分派
+			if retryIn > 0 {
+				l.scheduleLocked(retryIn)
+			}
+			return
+		}
+
+		l.queue = l.queue[1:]
+		l.inFlight++
+		waiter.event = &rateEvent{at: now, tokens: waiter.tokens}
+		l.window = append(l.window, waiter.event)
+		close(waiter.ready)
+	}
+}
+
+// admitLocked 判断请求能否放行，不能放行时返回需要等待窗口滑动的时长
+func (l *RateLimiter) admitLocked(tokens int, now time.Time) (bool, time.Duration) {
+	if l.config.MaxConcurrency > 0 && l.inFlight >= l.config.MaxConcurrency {
+		return false, 0
+	}
+
+	var wait time.Duration
+	if rpm := l.config.RequestsPerMinute; rpm > 0 && len(l.window) >= rpm {
+		wait = l.window[len(l.window)-rpm].at.Add(rateWindow).Sub(now)
+	}
+
+	// 单个请求超过上限时只在窗口为空时放行
+	if tpm := l.config.TokensPerMinute; tpm > 0 && len(l.window) > 0 {
+		used := 0
+		for _, event := range l.window {
+			used += event.tokens
+		}
+		if excess := used + tokens - tpm; excess > 0 {
+			freed := 0
+			for i, event := range l.window {
+				freed += event.tokens
+				if freed >= excess || i == len(l.window)-1 {
+					wait = max(wait, event.at.Add(rateWindow).Sub(now))
+					break
+				}
+			}
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// pruneLocked 移除窗口外的请求
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	expired := 0
+	for expired < len(l.window) && !l.window[expired].at.Add(rateWindow).After(now) {
+		expired++
+	}
+	l.window = l.window[expired:]
+}
+
+// scheduleLocked 在窗口滑动后重新分派
+func (l *RateLimiter) scheduleLocked(delay time.Duration) {
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.timer = time.AfterFunc(delay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.dispatchLocked()
+	})
+}
+
+// RateLimitedClient 在限流器中排队后再调用被包装的客户端。
+// 位于重试之内，每次重试都重新排队，退避等待期间不占用名额
+type RateLimitedClient struct {
+	client  types.LLMClient
+	limiter *RateLimiter
+	logger  log.Logger
+}
+
+// NewRateLimitedClient 创建限流客户端
+func NewRateLimitedClient(client types.LLMClient, limiter *RateLimiter, logger log.Logger) *RateLimitedClient {
+	return &RateLimitedClient{
+		client:  client,
+		limiter: limiter,
+		logger:  logger,
+	}
+}
+
+// GetConfig 获取配置
+func (c *RateLimitedClient) GetConfig() types.LLMConfig {
+	return c.client.GetConfig()
+}
+
+// GetProvider 获取提供商
+func (c *RateLimitedClient) GetProvider() types.LLMProvider {
+	return c.client.GetProvider()
+}
+
+// Unwrap 返回被包装的客户端
+func (c *RateLimitedClient) Unwrap() types.LLMClient {
+	return c.client
+}
+
+// Limiter 返回使用的限流器
+func (c *RateLimitedClient) Limiter() *RateLimiter {
+	return c.limiter
+}
+
+// Chat 对话，请求结束后按实际用量归还名额
+func (c *RateLimitedClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	release, err := c.acquire(ctx, estimateTokens(request))
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.client.Chat(ctx, request)
+	release(usedTokens(response, err))
+	return response, err
+}
+
+// ChatStream 流式对话，流结束前一直占用名额
+func (c *RateLimitedClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	release, err := c.acquire(ctx, estimateTokens(request))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.client.ChatStream(ctx, request)
+	if err != nil {
+		release(0)
+		return nil, err
+	}
+
+	responseChan := make(chan types.LLMResponse, 10)
+	go func() {
+		defer close(responseChan)
+		var usage types.Usage
+		var streamErr error
+		defer func() { release(usedTokens(&types.LLMResponse{Usage: usage}, streamErr)) }()
+
+		for resp := range stream {
+			if !resp.Usage.IsZero() {
+				usage = resp.Usage
+			}
+			if err := StreamError(resp); err != nil {
+				streamErr = err
+			}
+			select {
+			case responseChan <- resp:
+			case <-ctx.Done():
+				// 排空上游通道，避免上游协程阻塞
+				for range stream {
+				}
+				return
+			}
+		}
+	}()
+
+	return responseChan, nil
+}
+
+// Embed 向量化，每次请求按输入估算的token数排队
+func (c *RateLimitedClient) Embed(ctx context.Context, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	embedder, ok := Embedder(c.client)
+	if !ok {
+		return nil, ErrEmbeddingsNotSupported
+	}
+
+	release, err := c.acquire(ctx, estimateEmbeddingTokens(request.Input))
+	if err != nil {
+		return nil, err
+	}
+	response, err := embedder.Embed(ctx, request)
+	if err != nil {
+		release(0)
+		return nil, err
+	}
+	release(usedTokens(&types.LLMResponse{Usage: response.Usage}, nil))
+	return response, nil
+}
+
+// acquire 按估算的token数在限流器中排队
+func (c *RateLimitedClient) acquire(ctx context.Context, tokens int) (func(actual int), error) {
+	if depth := c.limiter.QueueDepth(); depth > 0 {
+		c.logger.Debugf("LLM provider %s rate limited, %d requests queued", c.client.GetProvider(), depth)
+	}
+	return c.limiter.Acquire(ctx, tokens)
+}
+
+// rateLimiterOf 返回客户端包装链中的限流器
+func rateLimiterOf(client types.LLMClient) *RateLimiter {
+	for client != nil {
+		if limited, ok := client.(*RateLimitedClient); ok {
+			return limited.limiter
+		}
+		wrapper, ok := client.(interface{ Unwrap() types.LLMClient })
+		if !ok {
+			return nil
+		}
+		client = wrapper.Unwrap()
+	}
+	return nil
+}
+
+// estimateTokens 调用前估算请求的token数，只用于限流，使用开销小的字符估算
+func estimateTokens(request types.LLMRequest) int {
+	counter := tokenizer.Heuristic()
+
+	tokens := 0
+	for _, msg := range request.Messages {
+		tokens += counter.Count(msg.Content) + counter.Count(msg.Reasoning)
+		for _, tc := range msg.ToolCalls {
+			tokens += counter.Count(tc.Function.Arguments)
+		}
+	}
+	if len(request.Tools) > 0 {
+		if data, err := json.Marshal(request.Tools); err == nil {
+			tokens += counter.Count(string(data))
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,202 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// waitQueueDepth 等待队列长度达到预期
+func waitQueueDepth(t *testing.T, depth func() int, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for depth() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth %d, want %d", depth(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateLimiterConcurrencyFIFO(t *testing.T) {
+	limiter := NewRateLimiter(types.RateLimitConfig{MaxConcurrency: 1})
+
+	release, err := limiter.Acquire(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("Acquire error: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := limiter.Acquire(context.Background(), 10)
+			if err != nil {
+				t.Errorf("Acquire error: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			release(-1)
+		}(i)
+		// 确保按顺序入队
+		waitQueueDepth(t, limiter.QueueDepth, i)
+	}
+
+	release(-1)
+	wg.Wait()
+
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("expected FIFO order, got %v", order)
+	}
+	if limiter.InFlight() != 0 || limiter.QueueDepth() != 0 {
+		t.Errorf("expected idle limiter, got %d in flight, %d queued", limiter.InFlight(), limiter.QueueDepth())
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := NewRateLimiter(types.RateLimitConfig{MaxConcurrency: 1})
+
+	release, _ := limiter.Acquire(context.Background(), 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx, 10); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if depth := limiter.QueueDepth(); depth != 0 {
+		t.Errorf("cancelled request still queued: %d", depth)
+	}
+
+	release(-1)
+	if _, err := limiter.Acquire(context.Background(), 10); err != nil {
+		t.Errorf("expected slot after release, got %v", err)
+	}
+}
+
+func TestRateLimiterPerMinute(t *testing.T) {
+	blocked := func(limiter *RateLimiter, tokens int) bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := limiter.Acquire(ctx, tokens)
+		return errors.Is(err, context.DeadlineExceeded)
+	}
+
+	t.Run("requests", func(t *testing.T) {
+		limiter := NewRateLimiter(types.RateLimitConfig{RequestsPerMinute: 2})
+		for i := 0; i < 2; i++ {
+			release, _ := limiter.Acquire(context.Background(), 0)
+			release(-1)
+		}
+		if !blocked(limiter, 0) {
+			t.Errorf("expected third request in a minute to wait")
+		}
+	})
+
+	t.Run("tokens", func(t *testing.T) {
+		limiter := NewRateLimiter(types.RateLimitConfig{TokensPerMinute: 100})
+
+		// 单个超过上限的请求在窗口为空时放行
+		release, err := limiter.Acquire(context.Background(), 150)
+		if err != nil {
+			t.Fatalf("Acquire error: %v", err)
+		}
+		// 按实际用量修正后窗口内只剩30
+		release(30)
+
+		release, _ = limiter.Acquire(context.Background(), 60)
+		release(-1)
+		if !blocked(limiter, 20) {
+			t.Errorf("expected request over tokens per minute to wait")
+		}
+	})
+}
+
+func TestManagerRateLimit(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewMockClientFromScript(types.LLMConfig{
+		Provider:  types.ProviderMock,
+		RateLimit: types.RateLimitConfig{MaxConcurrency: 1},
+	}, MockScript{Turns: []MockTurn{{
+		Content:   strings.Repeat("好", 30),
+		ChunkSize: 1,
+		Usage:     types.Usage{PromptTokens: 10, CompletionTokens: 30},
+	}}, Repeat: true}, logger)
+
+	manager := NewManager(types.ProviderMock, logger)
+	manager.RegisterClient(types.ProviderMock, client)
+
+	// 流式请求在上游流结束前一直占用名额，块数超过转发缓冲，调用方读取前流不会结束
+	stream, err := manager.ChatStream(context.Background(), types.LLMRequest{})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := manager.Chat(context.Background(), types.LLMRequest{})
+		done <- err
+	}()
+	waitQueueDepth(t, func() int { return manager.QueueDepth(types.ProviderMock) }, 1)
+
+	for range stream {
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Chat error: %v", err)
+	}
+	if depth := manager.QueueDepth(types.ProviderMock); depth != 0 {
+		t.Errorf("unexpected queue depth: %d", depth)
+	}
+}
+
+func TestRateLimitedClientRetry(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+	mock := NewMockClientFromScript(types.LLMConfig{Provider: types.ProviderMock}, MockScript{Turns: []MockTurn{
+		{StatusCode: 503, Error: "overloaded"},
+		{Content: "好"},
+	}}, logger)
+	limiter := NewRateLimiter(types.RateLimitConfig{MaxConcurrency: 1, RequestsPerMinute: 3})
+	client := NewRetryClient(NewRateLimitedClient(mock, limiter, logger), types.RetryConfig{MaxRetries: 1, InitialBackoff: 400}, logger)
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Chat(context.Background(), types.LLMRequest{})
+		done <- err
+	}()
+
+	// 第一次尝试失败后进入退避，退避期间不占用并发名额
+	waitQueueDepth(t, func() int {
+		limiter.mu.Lock()
+		defer limiter.mu.Unlock()
+		return len(limiter.window) - limiter.inFlight
+	}, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	release, err := limiter.Acquire(ctx, 0)
+	if err != nil {
+		t.Fatalf("expected free slot during backoff, got %v", err)
+	}
+	release(-1)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+
+	// 重试同样计入每分钟请求数
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limiter.Acquire(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected retried attempt to count against requests per minute, got %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// MockScript 模拟提供商的脚本，每次调用按顺序消费一轮
+type MockScript struct {
+	Turns  []MockTurn `json:"turns"`
+	Repeat bool       `json:"repeat,omitempty"` // 轮次用完后重复最后一轮，否则返回错误
+}
+
+// MockTurn 一轮模拟响应
+type MockTurn struct {
+	Content      string             `json:"content,omitempty"`
+	Reasoning    string             `json:"reasoning,omitempty"`
+	ToolCalls    []MockToolCall     `json:"tool_calls,omitempty"`
+	FinishReason types.FinishReason `json:"finish_reason,omitempty"` // 为空时按是否有工具调用推断
+	Usage        types.Usage        `json:"usage"`
+
+	Error      string `json:"error,omitempty"`       // 返回错误而不是响应
+	StatusCode int    `json:"status_code,omitempty"` // 设置后错误为带状态码的 APIError，可触发重试和故障转移
+	// StreamError 流式响应输出内容后以该错误结束，模拟连接中途断开
+	StreamError string `json:"stream_error,omitempty"`
+
+	Delay     int `json:"delay,omitempty"`      // 响应前等待的时间，milliseconds
+	ChunkSize int `json:"chunk_size,omitempty"` // 流式响应每块的字符数，0 表示整段一块
+}
+
+// MockToolCall 模拟工具调用，arguments 可写成对象或原样的字符串（用于模拟被截断的参数）
+type MockToolCall struct {
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// mockEmbeddingDimensions 模拟向量的默认维度
+const mockEmbeddingDimensions = 64
+
+// MockClient 脚本驱动的模拟客户端，不访问网络，用于测试Agent循环和接口
+type MockClient struct {
+	config types.LLMConfig
+	script MockScript
+	logger log.Logger
+
+	mu       sync.Mutex
+	next     int
+	requests []types.LLMRequest
+}
+
+// NewMockClient 从 config.Script 指定的 YAML 或 JSON 脚本创建模拟客户端
+func NewMockClient(config types.LLMConfig, logger log.Logger) (*MockClient, error) {
+	if config.Script == "" {
+		return nil, fmt.Errorf("mock provider requires script path")
+	}
+
+	script, err := LoadMockScript(utils.ExpandPath(config.Script))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMockClientFromScript(config, script, logger), nil
+}
+
+// NewMockClientFromScript 使用内存中的脚本创建模拟客户端
+func NewMockClientFromScript(config types.LLMConfig, script MockScript, logger log.Logger) *MockClient {
+	if config.Model == "" {
+		config.Model = "mock"
+	}
+
+	return &MockClient{
+		config: config,
+		script: script,
+		logger: logger,
+	}
+}
+
+// LoadMockScript 读取脚本文件，JSON 是 YAML 的子集，统一按 YAML 解析
+func LoadMockScript(path string) (MockScript, error) {
+	var script MockScript
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return script, fmt.Errorf("failed to read mock script: %w", err)
+	}
+
+	// 先解析为通用结构再转为JSON，脚本字段只需维护json标签
+	var raw any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return script, fmt.Errorf("failed to parse mock script %s: %w", path, err)
+	}
+	converted, err := json.Marshal(raw)
+	if err != nil {
+		return script, fmt.Errorf("failed to parse mock script %s: %w", path, err)
+	}
+	if err := json.Unmarshal(converted, &script); err != nil {
+		return script, fmt.Errorf("failed to parse mock script %s: %w", path, err)
+	}
+
+	return script, nil
+}
+
+// GetConfig 获取配置
+func (c *MockClient) GetConfig() types.LLMConfig {
+	return c.config
+}
+
+// GetProvider 获取提供商
+func (c *MockClient) GetProvider() types.LLMProvider {
+	return providerName(c.config, types.ProviderMock)
+}
+
+// Requests 返回已收到的请求，供测试断言
+func (c *MockClient) Requests() []types.LLMRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	requests := make([]types.LLMRequest, len(c.requests))
+	copy(requests, c.requests)
+	return requests
+}
+
+// Chat 对话
+func (c *MockClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	turn, err := c.take(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return c.response(turn), nil
+}
+
+// ChatStream 流式对话，内容和推理按 chunk_size 拆分，最终块携带工具调用、用量和结束原因
+func (c *MockClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	turn, err := c.take(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := c.response(turn)
+	var chunks []types.LLMResponse
+	for _, text := range splitRunes(response.Reasoning, turn.ChunkSize) {
+		chunks = append(chunks, types.LLMResponse{ID: response.ID, Reasoning: text})
+	}
+	for _, text := range splitRunes(response.Content, turn.ChunkSize) {
+		chunks = append(chunks, types.LLMResponse{ID: response.ID, Content: text})
+	}
+	final := *response
+	final.Content = ""
+	final.Reasoning = ""
+	if turn.StreamError != "" {
+		final = streamErrorResponse(errors.New(turn.StreamError))
+	}
+	chunks = append(chunks, final)
+
+	responseChan := make(chan types.LLMResponse)
+
+	go func() {
+		defer close(responseChan)
+
+		for _, chunk := range chunks {
+			select {
+			case responseChan <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return responseChan, nil
+}
+
+// Ping 模拟提供商始终可达
+func (c *MockClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+// ListModels 返回配置的模型
+func (c *MockClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	model := c.config.Model
+	if model == "" {
+		model = string(types.ProviderMock)
+	}
+	return []types.ModelInfo{{ID: model, OwnedBy: string(types.ProviderMock)}}, nil
+}
+
+// Capabilities 脚本可以返回任意内容，视为支持全部能力
+func (c *MockClient) Capabilities(ctx context.Context, model string) (types.ModelCapabilities, error) {
+	return types.ModelCapabilities{Tools: true, Vision: true, Reasoning: true, StructuredOutput: true, Probed: true}, nil
+}
+
+// Embed 生成确定性的词袋哈希向量，包含相同词的文本向量相近，不消耗脚本
+func (c *MockClient) Embed(ctx context.Context, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	dimensions := request.Dimensions
+	if dimensions <= 0 {
+		dimensions = mockEmbeddingDimensions
+	}
+
+	embeddings := make([][]float32, len(request.Input))
+	tokens := 0
+	for i, text := range request.Input {
+		vector := make([]float32, dimensions)
+		words := strings.Fields(strings.ToLower(text))
+		for _, word := range words {
+			hash := fnv.New32a()
+			hash.Write([]byte(word))
+			vector[hash.Sum32()%uint32(dimensions)]++
+		}
+		tokens += len(words)
+
+		var norm float64
+		for _, value := range vector {
+			norm += float64(value * value)
+		}
+		if norm > 0 {
+			for j := range vector {
+				vector[j] /= float32(math.Sqrt(norm))
+			}
+		}
+		embeddings[i] = vector
+	}
+
+	model := request.Model
+	if model == "" {
+		model = string(types.ProviderMock)
+	}
+	return &types.EmbeddingResponse{
+		Model:      model,
+		Embeddings: embeddings,
+		Dimensions: dimensions,
+		Usage:      types.Usage{PromptTokens: tokens, TotalTokens: tokens},
+	}, nil
+}
+
+// take 记录请求并取出下一轮，按脚本等待后返回脚本中的错误
+func (c *MockClient) take(ctx context.Context, request types.LLMRequest) (MockTurn, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, request)
+	index := c.next
+	c.next++
+	c.mu.Unlock()
+
+	if index >= len(c.script.Turns) {
+		if !c.script.Repeat || len(c.script.Turns) == 0 {
+			return MockTurn{}, fmt.Errorf("mock script exhausted after %d turns", len(c.script.Turns))
+		}
+		index = len(c.script.Turns) - 1
+	}
+	turn := c.script.Turns[index]
+
+	if turn.Delay > 0 {
+		timer := time.NewTimer(time.Duration(turn.Delay) * time.Millisecond)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return MockTurn{}, ctx.Err()
+		}
+	}
+
+	if turn.Error != "" {
+		if turn.StatusCode != 0 {
+			return MockTurn{}, &APIError{Provider: c.GetProvider(), StatusCode: turn.StatusCode, Body: turn.Error}
+		}
+		return MockTurn{}, fmt.Errorf("%s", turn.Error)
+	}
+
+	return turn, nil
+}
+
+// response 将脚本中的一轮转为响应
+func (c *MockClient) response(turn MockTurn) *types.LLMResponse {
+	response := &types.LLMResponse{
+		ID:        utils.GenerateID(),
+		Content:   turn.Content,
+		Reasoning: turn.Reasoning,
+		Usage:     turn.Usage,
+	}
+
+	for _, tc := range turn.ToolCalls {
+		id := tc.ID
+		if id == "" {
+			id = "call_" + utils.GenerateID()
+		}
+		response.ToolCalls = append(response.ToolCalls, types.ToolCall{
+			ID:   id,
+			Type: "function",
+			Function: types.ToolCallFunction{
+				Name:      tc.Name,
+				Arguments: mockArguments(tc.Arguments),
+			},
+		})
+	}
+
+	response.FinishReason = normalizeFinishReason(string(turn.FinishReason), len(response.ToolCalls) > 0)
+	if response.FinishReason == "" {
+		response.FinishReason = types.FinishReasonStop
+	}
+
+	return response
+}
+
+// mockArguments 对象参数原样使用，字符串参数取其内容
+func mockArguments(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "{}"
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	return string(raw)
+}
+
+// splitRunes 按字符数拆分文本，size 不大于0时不拆分
+func splitRunes(text string, size int) []string {
+	if text == "" {
+		return nil
+	}
+
+	runes := []rune(text)
+	if size <= 0 || size >= len(runes) {
+		return []string{text}
+	}
+
+	parts := make([]string, 0, (len(runes)+size-1)/size)
+	for start := 0; start < len(runes); start += size {
+		end := min(start+size, len(runes))
+		parts = append(parts, string(runes[start:end]))
+	}
+	return parts
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+const mockTestScript = `
+turns:
+  - reasoning: "先看看目录"
+    content: "让我列出文件。"
+    tool_calls:
+      - name: ls
+        arguments: {path: "."}
+    usage: {prompt_tokens: 100, completion_tokens: 20, total_tokens: 120}
+    chunk_size: 2
+  - content: "写到一半"
+    finish_reason: max_tokens
+    tool_calls:
+      - id: call_cut
+        name: write
+        arguments: '{"path": "a.go", "content": "pack'
+  - error: "rate limited"
+    status_code: 429
+`
+
+func newMockTestClient(t *testing.T, script string) *MockClient {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	logger, _ := log.New(log.DefaultConfig())
+	client, err := NewMockClient(types.LLMConfig{Script: path}, logger)
+	if err != nil {
+		t.Fatalf("NewMockClient error: %v", err)
+	}
+	return client
+}
+
+func TestMockClientScript(t *testing.T) {
+	client := newMockTestClient(t, mockTestScript)
+	request := types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "有哪些文件？"}}}
+
+	stream, err := client.ChatStream(context.Background(), request)
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content, reasoning strings.Builder
+	var chunks int
+	var final types.LLMResponse
+	for resp := range stream {
+		content.WriteString(resp.Content)
+		reasoning.WriteString(resp.Reasoning)
+		chunks++
+		final = resp
+	}
+
+	if content.String() != "让我列出文件。" || reasoning.String() != "先看看目录" {
+		t.Errorf("unexpected content %q, reasoning %q", content.String(), reasoning.String())
+	}
+	// 推理3块、内容4块、最终块1块
+	if chunks != 8 {
+		t.Errorf("expected 8 chunks, got %d", chunks)
+	}
+	if len(final.ToolCalls) != 1 || final.ToolCalls[0].Function.Arguments != `{"path":"."}` || final.ToolCalls[0].ID == "" {
+		t.Errorf("unexpected tool calls: %+v", final.ToolCalls)
+	}
+	if final.Usage.PromptTokens != 100 || final.FinishReason != types.FinishReasonToolCalls {
+		t.Errorf("unexpected final chunk: %+v", final)
+	}
+
+	response, err := client.Chat(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.FinishReason != types.FinishReasonLength || response.ToolCalls[0].Function.Arguments != `{"path": "a.go", "content": "pack` {
+		t.Errorf("unexpected truncated response: %+v", response)
+	}
+
+	_, err = client.Chat(context.Background(), request)
+	if StatusCodeOf(err) != http.StatusTooManyRequests || !IsRetryableError(err) {
+		t.Errorf("expected retryable 429 error, got %v", err)
+	}
+
+	if _, err := client.Chat(context.Background(), request); err == nil || !strings.Contains(err.Error(), "exhausted") {
+		t.Errorf("expected exhausted script error, got %v", err)
+	}
+	if requests := client.Requests(); len(requests) != 4 || requests[0].Messages[0].Content != "有哪些文件？" {
+		t.Errorf("unexpected recorded requests: %+v", requests)
+	}
+}
+
+func TestMockClientDelayCancel(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewMockClientFromScript(types.LLMConfig{}, MockScript{
+		Turns:  []MockTurn{{Content: "太慢了", Delay: 5000}},
+		Repeat: true,
+	}, logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Chat(ctx, types.LLMRequest{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("delay not interrupted by cancellation, took %v", elapsed)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/url"
 
 	"github.com/ollama/ollama/api"
 	"github.com/zboya/nala-coder/pkg/log"
 	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
 )
 
 // OllamaClient Ollama客户端
@@ -26,7 +26,7 @@ func NewOllamaClient(config types.LLMConfig, logger log.Logger) *OllamaClient {
 		baseURL, _ = url.Parse("http://localhost:11434")
 	}
 
-	client := api.NewClient(baseURL, &http.Client{})
+	client := api.NewClient(baseURL, newHTTPClient())
 	return &OllamaClient{
 		config: config,
 		client: client,
@@ -41,7 +41,7 @@ func (c *OllamaClient) GetConfig() types.LLMConfig {
 
 // GetProvider 获取提供商
 func (c *OllamaClient) GetProvider() types.LLMProvider {
-	return types.ProviderOllama
+	return providerName(c.config, types.ProviderOllama)
 }
 
 // Chat 对话
@@ -59,6 +59,10 @@ func (c *OllamaClient) Chat(ctx context.Context, request types.LLMRequest) (*typ
 	if len(request.Tools) > 0 {
 		chatRequest.Tools = c.convertTools(request.Tools)
 	}
+	if request.ResponseFormat != nil {
+		// format 直接接收 JSON Schema
+		chatRequest.Format, _ = json.Marshal(request.ResponseFormat.Schema)
+	}
 
 	var response api.ChatResponse
 	err := c.client.Chat(ctx, chatRequest, func(resp api.ChatResponse) error {
@@ -88,6 +92,10 @@ func (c *OllamaClient) ChatStream(ctx context.Context, request types.LLMRequest)
 	if len(request.Tools) > 0 {
 		chatRequest.Tools = c.convertTools(request.Tools)
 	}
+	if request.ResponseFormat != nil {
+		// format 直接接收 JSON Schema
+		chatRequest.Format, _ = json.Marshal(request.ResponseFormat.Schema)
+	}
 
 	*chatRequest.Stream = true
 
@@ -96,49 +104,139 @@ func (c *OllamaClient) ChatStream(ctx context.Context, request types.LLMRequest)
 	go func() {
 		defer close(responseChan)
 
-		var fullContent string
+		// 未开启 think 参数时，qwen3 等模型把推理内容放在 <think> 标签中
+		var parser thinkTagParser
+		var hasToolCalls, done bool
 
 		err := c.client.Chat(ctx, chatRequest, func(resp api.ChatResponse) error {
-			content := resp.Message.Content
-			fullContent += content
+			content, reasoning := parser.Feed(resp.Message.Content)
+			if resp.Done {
+				restContent, restReasoning := parser.Flush()
+				content += restContent
+				reasoning += restReasoning
+			}
 
 			streamResp := types.LLMResponse{
-				Content: content,
-				Role:    string(resp.Message.Role),
+				Content:   content,
+				Reasoning: resp.Message.Thinking + reasoning,
+				Role:      string(resp.Message.Role),
 			}
 
 			if resp.Message.ToolCalls != nil {
 				streamResp.ToolCalls = c.convertToolCalls(resp.Message.ToolCalls)
+				hasToolCalls = true
 			}
 
-			responseChan <- streamResp
-
+			// 内容和工具调用已随各数据块发送，最后一块只补充用量和结束原因
 			if resp.Done {
-				final := types.LLMResponse{
-					Content: fullContent,
-					Role:    string(resp.Message.Role),
-					Usage: types.Usage{
-						PromptTokens:     resp.PromptEvalCount,
-						CompletionTokens: resp.EvalCount,
-						TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
-					},
-				}
-				if resp.Message.ToolCalls != nil {
-					final.ToolCalls = c.convertToolCalls(resp.Message.ToolCalls)
+				done = true
+				streamResp.Usage = types.Usage{
+					PromptTokens:     resp.PromptEvalCount,
+					CompletionTokens: resp.EvalCount,
+					TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
 				}
-				responseChan <- final
+				streamResp.FinishReason = normalizeFinishReason(resp.DoneReason, hasToolCalls)
+			}
+
+			select {
+			case responseChan <- streamResp:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			return nil
 		})
+		if ctx.Err() != nil {
+			return
+		}
 
+		// 连接或读取失败、流在结束块之前中断时以错误结束，调用方不会把不完整的回复当作正常结束
+		if err == nil && !done {
+			err = fmt.Errorf("stream ended before the final chunk")
+		}
 		if err != nil {
-			c.logger.Error("Ollama stream chat error", "error", err)
+			c.logger.Errorf("Ollama stream chat error: %v", err)
+			select {
+			case responseChan <- streamErrorResponse(fmt.Errorf("Ollama stream error: %w", err)):
+			case <-ctx.Done():
+			}
 		}
 	}()
 
 	return responseChan, nil
 }
 
+// Ping 检查 Ollama 服务是否可达
+func (c *OllamaClient) Ping(ctx context.Context) error {
+	if err := c.client.Heartbeat(ctx); err != nil {
+		return fmt.Errorf("Ollama API error: %w", err)
+	}
+	return nil
+}
+
+// ListModels 列出 /api/tags 返回的本地模型
+func (c *OllamaClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	list, err := c.client.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Ollama API error: %w", err)
+	}
+
+	models := make([]types.ModelInfo, 0, len(list.Models))
+	for _, model := range list.Models {
+		models = append(models, types.ModelInfo{ID: model.Name})
+	}
+	return models, nil
+}
+
+// Capabilities 通过 /api/show 查询模型能力
+func (c *OllamaClient) Capabilities(ctx context.Context, model string) (types.ModelCapabilities, error) {
+	show, err := c.client.Show(ctx, &api.ShowRequest{Model: c.getModel(model)})
+	if err != nil {
+		return types.ModelCapabilities{}, fmt.Errorf("Ollama API error: %w", err)
+	}
+
+	capabilities := types.ModelCapabilities{Probed: true, StructuredOutput: true}
+	for _, capability := range show.Capabilities {
+		switch string(capability) {
+		case "tools":
+			capabilities.Tools = true
+		case "vision":
+			capabilities.Vision = true
+		case "thinking":
+			capabilities.Reasoning = true
+		}
+	}
+	return capabilities, nil
+}
+
+// Embed 调用 /api/embed 生成向量，Ollama 不支持指定维度
+func (c *OllamaClient) Embed(ctx context.Context, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	model, err := embeddingModel(request, c.config)
+	if err != nil {
+		return nil, err
+	}
+	if request.Dimensions > 0 {
+		c.logger.Debugf("Ollama does not support embedding dimensions, ignoring %d", request.Dimensions)
+	}
+
+	resp, err := c.client.Embed(ctx, &api.EmbedRequest{
+		Model: model,
+		Input: request.Input,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Ollama API error: %w", err)
+	}
+
+	response := &types.EmbeddingResponse{
+		Model:      resp.Model,
+		Embeddings: resp.Embeddings,
+		Usage:      types.Usage{PromptTokens: resp.PromptEvalCount, TotalTokens: resp.PromptEvalCount},
+	}
+	if len(resp.Embeddings) > 0 {
+		response.Dimensions = len(resp.Embeddings[0])
+	}
+	return response, nil
+}
+
 // convertMessages 转换消息格式
 func (c *OllamaClient) convertMessages(messages []types.Message) []api.Message {
 	ollamaMessages := make([]api.Message, len(messages))
@@ -147,6 +245,10 @@ func (c *OllamaClient) convertMessages(messages []types.Message) []api.Message {
 			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
+		if c.config.SendReasoning && msg.Role == types.RoleAssistant {
+			ollamaMessages[i].Thinking = msg.Reasoning
+		}
+		ollamaMessages[i].Images = c.convertImages(msg.Parts)
 
 		// 转换工具调用
 		if len(msg.ToolCalls) > 0 {
@@ -156,6 +258,23 @@ func (c *OllamaClient) convertMessages(messages []types.Message) []api.Message {
 	return ollamaMessages
 }
 
+// convertImages 提取消息中的图片，Ollama 直接接收原始字节
+func (c *OllamaClient) convertImages(parts []types.ContentPart) []api.ImageData {
+	var images []api.ImageData
+	for _, part := range parts {
+		if part.Type != types.ContentPartImage {
+			continue
+		}
+		_, data, err := utils.ResolveImage(part)
+		if err != nil {
+			c.logger.Warnf("Skip image: %v", err)
+			continue
+		}
+		images = append(images, data)
+	}
+	return images
+}
+
 // convertTools 转换工具格式
 func (c *OllamaClient) convertTools(tools []types.Tool) []api.Tool {
 	ollamaTools := make([]api.Tool, len(tools))
@@ -246,9 +365,15 @@ func (c *OllamaClient) buildOptions(request types.LLMRequest) map[string]any {
 
 // convertResponse 转换响应格式
 func (c *OllamaClient) convertResponse(resp api.ChatResponse) *types.LLMResponse {
+	content, reasoning := splitThinkTags(resp.Message.Content)
+	if resp.Message.Thinking != "" {
+		reasoning = resp.Message.Thinking
+	}
+
 	result := &types.LLMResponse{
-		Content: resp.Message.Content,
-		Role:    string(resp.Message.Role),
+		Content:   content,
+		Reasoning: reasoning,
+		Role:      string(resp.Message.Role),
 		Usage: types.Usage{
 			PromptTokens:     resp.PromptEvalCount,
 			CompletionTokens: resp.EvalCount,
@@ -259,6 +384,7 @@ func (c *OllamaClient) convertResponse(resp api.ChatResponse) *types.LLMResponse
 	if resp.Message.ToolCalls != nil {
 		result.ToolCalls = c.convertToolCalls(resp.Message.ToolCalls)
 	}
+	result.FinishReason = normalizeFinishReason(resp.DoneReason, len(result.ToolCalls) > 0)
 
 	return result
 }
@@ -2,6 +2,10 @@ package llm
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/zboya/nala-coder/pkg/log"
@@ -118,3 +122,65 @@ func TestOllamaStreamWithTools(t *testing.T) {
 		}
 	}
 }
+
+// ollamaTestStream 读取 Ollama 流式响应，返回拼接的内容和最后一个响应块
+func ollamaTestStream(t *testing.T, baseURL string) (string, types.LLMResponse) {
+	t.Helper()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewOllamaClient(types.LLMConfig{BaseURL: baseURL, Model: "qwen3:30b"}, logger)
+
+	stream, err := client.ChatStream(context.Background(), types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "你好"}}})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content strings.Builder
+	var last types.LLMResponse
+	for resp := range stream {
+		content.WriteString(resp.Content)
+		last = resp
+	}
+	return content.String(), last
+}
+
+func TestOllamaStreamError(t *testing.T) {
+	t.Run("connection refused", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		baseURL := server.URL
+		server.Close()
+
+		// 第一个响应块之前失败，调用方据此切换提供商
+		if _, last := ollamaTestStream(t, baseURL); StreamError(last) == nil {
+			t.Errorf("expected an error chunk, got %+v", last)
+		}
+	})
+
+	t.Run("status error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model \"qwen3:30b\" not found"}`)
+		}))
+		defer server.Close()
+
+		if _, last := ollamaTestStream(t, server.URL); StreamError(last) == nil || !strings.Contains(last.Error, "not found") {
+			t.Errorf("expected a not found error chunk, got %+v", last)
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintln(w, `{"model":"qwen3:30b","message":{"role":"assistant","content":"你好"},"done":false}`)
+		}))
+		defer server.Close()
+
+		content, last := ollamaTestStream(t, server.URL)
+		if content != "你好" {
+			t.Errorf("unexpected content: %q", content)
+		}
+		if StreamError(last) == nil {
+			t.Errorf("expected a stream without the final chunk to end with an error, got %+v", last)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/zboya/nala-coder/pkg/log"
 	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
 )
 
 // OpenAIClient OpenAI客户端
@@ -24,6 +26,7 @@ func NewOpenAIClient(config types.LLMConfig, logger log.Logger) *OpenAIClient {
 	if config.BaseURL != "" {
 		clientConfig.BaseURL = config.BaseURL
 	}
+	clientConfig.HTTPClient = newHTTPClient()
 
 	return &OpenAIClient{
 		client: openai.NewClientWithConfig(clientConfig),
@@ -39,7 +42,7 @@ func (c *OpenAIClient) GetConfig() types.LLMConfig {
 
 // GetProvider 获取提供商
 func (c *OpenAIClient) GetProvider() types.LLMProvider {
-	return types.ProviderOpenAI
+	return providerName(c.config, types.ProviderOpenAI)
 }
 
 // Chat 对话
@@ -59,6 +62,9 @@ func (c *OpenAIClient) Chat(ctx context.Context, request types.LLMRequest) (*typ
 		req.Tools = tools
 		req.ToolChoice = "auto"
 	}
+	if request.ResponseFormat != nil {
+		req.ResponseFormat = c.convertResponseFormat(request.ResponseFormat)
+	}
 
 	jsonReq, _ := json.Marshal(req)
 	c.logger.Infof("OpenAI request: %s", string(jsonReq))
@@ -81,12 +87,17 @@ func (c *OpenAIClient) ChatStream(ctx context.Context, request types.LLMRequest)
 		MaxTokens:   c.getMaxTokens(request.MaxTokens),
 		Temperature: c.getTemperature(request.Temperature),
 		Stream:      true,
+		// 最后一个数据块返回整次调用的用量
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
 	}
 
 	if len(tools) > 0 {
 		req.Tools = tools
 		req.ToolChoice = "auto"
 	}
+	if request.ResponseFormat != nil {
+		req.ResponseFormat = c.convertResponseFormat(request.ResponseFormat)
+	}
 
 	jsonReq, _ := json.Marshal(req)
 	c.logger.Infof("OpenAI stream request: %s", string(jsonReq))
@@ -101,10 +112,11 @@ func (c *OpenAIClient) ChatStream(ctx context.Context, request types.LLMRequest)
 		defer close(responseChan)
 		defer stream.Close()
 
-		var fullContent string
 		// 使用map来跟踪正在构建的工具调用，key是index
 		toolCallsMap := make(map[int]*types.ToolCall)
 		var finalResponse *openai.ChatCompletionStreamResponse
+		var usage types.Usage
+		var finishReason openai.FinishReason
 
 		for {
 			response, err := stream.Recv()
@@ -123,28 +135,38 @@ func (c *OpenAIClient) ChatStream(ctx context.Context, request types.LLMRequest)
 					responseID = finalResponse.ID
 				}
 				final := types.LLMResponse{
-					ID:        responseID,
-					Content:   fullContent,
-					Role:      "assistant",
-					ToolCalls: toolCalls,
+					ID:           responseID,
+					Role:         "assistant",
+					ToolCalls:    toolCalls,
+					Usage:        usage,
+					FinishReason: normalizeFinishReason(string(finishReason), len(toolCalls) > 0),
+				}
+				select {
+				case responseChan <- final:
+				case <-ctx.Done():
 				}
-				responseChan <- final
 				return
 			}
 
 			if err != nil {
 				c.logger.Errorf("OpenAI stream error: %v", err)
+				select {
+				case responseChan <- streamErrorResponse(fmt.Errorf("OpenAI stream error: %w", err)):
+				case <-ctx.Done():
+				}
 				return
 			}
 
 			finalResponse = &response
+			if response.Usage != nil {
+				usage = convertOpenAIUsage(*response.Usage)
+			}
 
 			if len(response.Choices) > 0 {
 				choice := response.Choices[0]
 				delta := choice.Delta
-
-				if delta.Content != "" {
-					fullContent += delta.Content
+				if choice.FinishReason != "" {
+					finishReason = choice.FinishReason
 				}
 
 				// 处理工具调用流式数据
@@ -184,11 +206,16 @@ func (c *OpenAIClient) ChatStream(ctx context.Context, request types.LLMRequest)
 
 				// 发送增量响应
 				streamResp := types.LLMResponse{
-					ID:      response.ID,
-					Content: delta.Content,
-					Role:    "assistant",
+					ID:        response.ID,
+					Content:   delta.Content,
+					Reasoning: delta.ReasoningContent,
+					Role:      "assistant",
+				}
+				select {
+				case responseChan <- streamResp:
+				case <-ctx.Done():
+					return
 				}
-				responseChan <- streamResp
 			}
 		}
 	}()
@@ -196,15 +223,90 @@ func (c *OpenAIClient) ChatStream(ctx context.Context, request types.LLMRequest)
 	return responseChan, nil
 }
 
+// Ping 通过模型列表接口检查连通性和密钥
+func (c *OpenAIClient) Ping(ctx context.Context) error {
+	_, err := c.client.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("OpenAI API error: %w", err)
+	}
+	return nil
+}
+
+// ListModels 列出 /models 返回的模型
+func (c *OpenAIClient) ListModels(ctx context.Context) ([]types.ModelInfo, error) {
+	list, err := c.client.ListModels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI API error: %w", err)
+	}
+
+	models := make([]types.ModelInfo, 0, len(list.Models))
+	for _, model := range list.Models {
+		models = append(models, types.ModelInfo{ID: model.ID, OwnedBy: model.OwnedBy})
+	}
+	return models, nil
+}
+
+// Capabilities 接口不返回模型能力，按模型名推断
+func (c *OpenAIClient) Capabilities(ctx context.Context, model string) (types.ModelCapabilities, error) {
+	return inferCapabilities(types.ProviderOpenAI, c.getModel(model)), nil
+}
+
+// Embed 调用 /embeddings 生成向量，单次请求，分批由调用方处理
+func (c *OpenAIClient) Embed(ctx context.Context, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	model, err := embeddingModel(request, c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input:      request.Input,
+		Model:      openai.EmbeddingModel(model),
+		Dimensions: request.Dimensions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI API error: %w", err)
+	}
+
+	// 按 index 还原输入顺序
+	embeddings := make([][]float32, len(request.Input))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(embeddings) {
+			return nil, fmt.Errorf("OpenAI API returned embedding index %d out of range", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	// 缺少某个 index 时对应位置为空，不能按顺序使用
+	for i, embedding := range embeddings {
+		if embedding == nil {
+			return nil, fmt.Errorf("OpenAI API returned no embedding for input %d", i)
+		}
+	}
+
+	response := &types.EmbeddingResponse{
+		Model:      string(resp.Model),
+		Embeddings: embeddings,
+		Usage:      types.Usage{PromptTokens: resp.Usage.PromptTokens, TotalTokens: resp.Usage.TotalTokens},
+	}
+	if len(embeddings) > 0 {
+		response.Dimensions = len(embeddings[0])
+	}
+	return response, nil
+}
+
 // convertMessages 转换消息格式
 func (c *OpenAIClient) convertMessages(messages []types.Message) []openai.ChatCompletionMessage {
-	result := make([]openai.ChatCompletionMessage, len(messages))
+	result := make([]openai.ChatCompletionMessage, 0, len(messages))
+	// 工具消息只能是文本，工具返回的图片放到紧随其后的用户消息中
+	var toolImages []openai.ChatMessagePart
 
 	for i, msg := range messages {
-		result[i] = openai.ChatCompletionMessage{
+		message := openai.ChatCompletionMessage{
 			Role:    string(msg.Role),
 			Content: msg.Content,
 		}
+		if c.config.SendReasoning && msg.Role == types.RoleAssistant {
+			message.ReasoningContent = msg.Reasoning
+		}
 
 		// 处理工具调用
 		if len(msg.ToolCalls) > 0 {
@@ -219,13 +321,76 @@ func (c *OpenAIClient) convertMessages(messages []types.Message) []openai.ChatCo
 					},
 				}
 			}
-			result[i].ToolCalls = toolCalls
+			message.ToolCalls = toolCalls
+		}
+
+		images := c.convertImages(msg.Parts)
+		switch {
+		case len(images) == 0:
+		case msg.Role == types.RoleTool:
+			toolImages = append(toolImages, images...)
+		default:
+			if msg.Content != "" {
+				message.MultiContent = []openai.ChatMessagePart{{
+					Type: openai.ChatMessagePartTypeText,
+					Text: msg.Content,
+				}}
+			}
+			message.MultiContent = append(message.MultiContent, images...)
+			message.Content = ""
+		}
+		result = append(result, message)
+
+		lastTool := i+1 == len(messages) || messages[i+1].Role != types.RoleTool
+		if msg.Role == types.RoleTool && lastTool && len(toolImages) > 0 {
+			result = append(result, openai.ChatCompletionMessage{
+				Role:         string(types.RoleUser),
+				MultiContent: toolImages,
+			})
+			toolImages = nil
 		}
 	}
 
 	return result
 }
 
+// convertImages 将图片片段转换为 data URL 形式的 image_url
+func (c *OpenAIClient) convertImages(parts []types.ContentPart) []openai.ChatMessagePart {
+	var images []openai.ChatMessagePart
+	for _, part := range parts {
+		if part.Type != types.ContentPartImage {
+			continue
+		}
+		mediaType, data, err := utils.ResolveImage(part)
+		if err != nil {
+			c.logger.Warnf("Skip image: %v", err)
+			continue
+		}
+		images = append(images, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{
+				URL:    "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data),
+				Detail: openai.ImageURLDetailAuto,
+			},
+		})
+	}
+	return images
+}
+
+// convertResponseFormat 转换为 json_schema 响应格式
+func (c *OpenAIClient) convertResponseFormat(format *types.ResponseFormat) *openai.ChatCompletionResponseFormat {
+	schema, _ := json.Marshal(format.Schema)
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:        format.Name,
+			Description: format.Description,
+			Schema:      json.RawMessage(schema),
+			Strict:      format.Strict,
+		},
+	}
+}
+
 // convertTools 转换工具格式
 func (c *OpenAIClient) convertTools(tools []types.Tool) []openai.Tool {
 	result := make([]openai.Tool, len(tools))
@@ -233,7 +398,7 @@ func (c *OpenAIClient) convertTools(tools []types.Tool) []openai.Tool {
 	for i, tool := range tools {
 		result[i] = openai.Tool{
 			Type: openai.ToolType(tool.Type),
-			Function: openai.FunctionDefinition{
+			Function: &openai.FunctionDefinition{
 				Name:        tool.Function.Name,
 				Description: tool.Function.Description,
 				Parameters:  tool.Function.Parameters,
@@ -248,26 +413,19 @@ func (c *OpenAIClient) convertTools(tools []types.Tool) []openai.Tool {
 func (c *OpenAIClient) convertResponse(resp openai.ChatCompletionResponse) *types.LLMResponse {
 	if len(resp.Choices) == 0 {
 		return &types.LLMResponse{
-			ID: resp.ID,
-			Usage: types.Usage{
-				PromptTokens:     resp.Usage.PromptTokens,
-				CompletionTokens: resp.Usage.CompletionTokens,
-				TotalTokens:      resp.Usage.TotalTokens,
-			},
+			ID:    resp.ID,
+			Usage: convertOpenAIUsage(resp.Usage),
 		}
 	}
 
 	choice := resp.Choices[0]
 
 	response := &types.LLMResponse{
-		ID:      resp.ID,
-		Content: choice.Message.Content,
-		Role:    choice.Message.Role,
-		Usage: types.Usage{
-			PromptTokens:     resp.Usage.PromptTokens,
-			CompletionTokens: resp.Usage.CompletionTokens,
-			TotalTokens:      resp.Usage.TotalTokens,
-		},
+		ID:        resp.ID,
+		Content:   choice.Message.Content,
+		Reasoning: choice.Message.ReasoningContent,
+		Role:      choice.Message.Role,
+		Usage:     convertOpenAIUsage(resp.Usage),
 	}
 
 	// 处理工具调用
@@ -285,10 +443,24 @@ func (c *OpenAIClient) convertResponse(resp openai.ChatCompletionResponse) *type
 		}
 		response.ToolCalls = toolCalls
 	}
+	response.FinishReason = normalizeFinishReason(string(choice.FinishReason), len(response.ToolCalls) > 0)
 
 	return response
 }
 
+// convertOpenAIUsage 转换用量，包括命中缓存的提示词token
+func convertOpenAIUsage(usage openai.Usage) types.Usage {
+	result := types.Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+	if usage.PromptTokensDetails != nil {
+		result.CachedTokens = usage.PromptTokensDetails.CachedTokens
+	}
+	return result
+}
+
 // getModel 获取模型名称
 func (c *OpenAIClient) getModel(requestModel string) string {
 	if requestModel != "" {
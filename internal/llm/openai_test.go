@@ -0,0 +1,144 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestOpenAIStreamUsage(t *testing.T) {
+	chunks := []string{
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"你好"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"上海\"}"}}]},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":100,"completion_tokens":20,"total_tokens":120,"prompt_tokens_details":{"cached_tokens":64}}}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("expected stream_options.include_usage in request (err: %v)", err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewOpenAIClient(types.LLMConfig{APIKey: "test-key", BaseURL: server.URL, Model: "gpt-test"}, logger)
+
+	stream, err := client.ChatStream(context.Background(), types.LLMRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "上海天气"}},
+	})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var content string
+	var toolCalls []types.ToolCall
+	var usage types.Usage
+	var finishReason types.FinishReason
+	usageChunks := 0
+	for resp := range stream {
+		if resp.FinishReason != "" {
+			finishReason = resp.FinishReason
+		}
+		content += resp.Content
+		toolCalls = append(toolCalls, resp.ToolCalls...)
+		if !resp.Usage.IsZero() {
+			usage = resp.Usage
+			usageChunks++
+		}
+	}
+
+	if content != "你好" {
+		t.Errorf("unexpected content: %q", content)
+	}
+	if len(toolCalls) != 1 || toolCalls[0].Function.Arguments != `{"city":"上海"}` {
+		t.Errorf("unexpected tool calls: %+v", toolCalls)
+	}
+	if finishReason != types.FinishReasonToolCalls {
+		t.Errorf("unexpected finish reason: %q", finishReason)
+	}
+	if usageChunks != 1 {
+		t.Errorf("expected usage on exactly one chunk, got %d", usageChunks)
+	}
+	if usage.PromptTokens != 100 || usage.CompletionTokens != 20 || usage.TotalTokens != 120 || usage.CachedTokens != 64 {
+		t.Errorf("unexpected stream usage: %+v", usage)
+	}
+}
+
+func TestOpenAIConvertImages(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewOpenAIClient(types.LLMConfig{APIKey: "test-key", Model: "gpt-test"}, logger)
+	image := types.ContentPart{Type: types.ContentPartImage, Data: base64.StdEncoding.EncodeToString(testPNG)}
+
+	messages := client.convertMessages([]types.Message{
+		{Role: types.RoleUser, Content: "看看这张图", Parts: []types.ContentPart{image}},
+		{Role: types.RoleAssistant, ToolCalls: []types.ToolCall{{ID: "call_1", Type: "function"}, {ID: "call_2", Type: "function"}}},
+		{Role: types.RoleTool, Content: "Image file", Parts: []types.ContentPart{image}},
+		{Role: types.RoleTool, Content: "ok"},
+	})
+
+	if len(messages) != 5 {
+		t.Fatalf("expected tool images in an extra user message, got %d messages", len(messages))
+	}
+
+	user := messages[0]
+	if user.Content != "" || len(user.MultiContent) != 2 || user.MultiContent[0].Text != "看看这张图" {
+		t.Errorf("unexpected user message: %+v", user)
+	}
+	if url := user.MultiContent[1].ImageURL.URL; url != "data:image/png;base64,"+image.Data {
+		t.Errorf("unexpected image url: %s", url)
+	}
+
+	// 图片放在连续的工具消息之后
+	if messages[2].Content != "Image file" || messages[3].Content != "ok" {
+		t.Errorf("unexpected tool messages: %+v", messages[2:4])
+	}
+	if messages[4].Role != "user" || len(messages[4].MultiContent) != 1 {
+		t.Errorf("unexpected tool image message: %+v", messages[4])
+	}
+}
+
+func TestOpenAIStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"你好\"}}]}\n\n")
+		// 流中途返回错误
+		fmt.Fprint(w, "data: {\"error\":{\"message\":\"server overloaded\",\"type\":\"server_error\"}}\n\n")
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewOpenAIClient(types.LLMConfig{APIKey: "test-key", BaseURL: server.URL, Model: "gpt-test"}, logger)
+
+	stream, err := client.ChatStream(context.Background(), types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "你好"}}})
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+
+	var last types.LLMResponse
+	for resp := range stream {
+		last = resp
+	}
+	if err := StreamError(last); err == nil || !strings.Contains(err.Error(), "server overloaded") {
+		t.Errorf("expected the last chunk to carry the stream error, got %+v", last)
+	}
+}
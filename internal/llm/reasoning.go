@@ -0,0 +1,88 @@
+package llm
+
+import "strings"
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// thinkTagParser 从流式文本中分离 <think>...</think> 推理内容，标签可能被拆分到相邻的数据块中
+type thinkTagParser struct {
+	inThink    bool
+	afterThink bool
+	pending    string
+}
+
+// Feed 处理一个数据块，返回其中的正文和推理内容
+func (p *thinkTagParser) Feed(chunk string) (content, reasoning string) {
+	var contentBuf, reasoningBuf strings.Builder
+	text := p.pending + chunk
+	p.pending = ""
+
+	for text != "" {
+		tag := thinkOpenTag
+		if p.inThink {
+			tag = thinkCloseTag
+		}
+
+		if i := strings.Index(text, tag); i >= 0 {
+			p.write(text[:i], &contentBuf, &reasoningBuf)
+			text = text[i+len(tag):]
+			p.inThink = !p.inThink
+			p.afterThink = !p.inThink
+			continue
+		}
+
+		// 末尾可能是不完整的标签，留到下一个数据块再判断
+		keep := partialSuffix(text, tag)
+		p.write(text[:len(text)-keep], &contentBuf, &reasoningBuf)
+		p.pending = text[len(text)-keep:]
+		break
+	}
+
+	return contentBuf.String(), reasoningBuf.String()
+}
+
+// Flush 输出剩余的缓冲内容
+func (p *thinkTagParser) Flush() (content, reasoning string) {
+	var contentBuf, reasoningBuf strings.Builder
+	p.write(p.pending, &contentBuf, &reasoningBuf)
+	p.pending = ""
+	return contentBuf.String(), reasoningBuf.String()
+}
+
+// write 按当前状态写入正文或推理内容，思考结束后紧跟的换行不计入正文
+func (p *thinkTagParser) write(text string, content, reasoning *strings.Builder) {
+	if p.inThink {
+		reasoning.WriteString(text)
+		return
+	}
+
+	if p.afterThink {
+		text = strings.TrimLeft(text, "\r\n")
+		if text == "" {
+			return
+		}
+		p.afterThink = false
+	}
+	content.WriteString(text)
+}
+
+// splitThinkTags 分离完整文本中的推理内容
+func splitThinkTags(text string) (content, reasoning string) {
+	var parser thinkTagParser
+	content, reasoning = parser.Feed(text)
+	restContent, restReasoning := parser.Flush()
+	return content + restContent, strings.TrimSpace(reasoning + restReasoning)
+}
+
+// partialSuffix 返回 text 末尾与 tag 前缀重合的最大长度
+func partialSuffix(text, tag string) int {
+	for k := len(tag) - 1; k > 0; k-- {
+		if strings.HasSuffix(text, tag[:k]) {
+			return k
+		}
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+package llm
+
+import "testing"
+
+func TestThinkTagParser(t *testing.T) {
+	var parser thinkTagParser
+	var content, reasoning string
+	for _, chunk := range []string{"<thi", "nk>先看", "目录结构", "</th", "ink>\n\n好的", "，<b>完成</b>"} {
+		c, r := parser.Feed(chunk)
+		content += c
+		reasoning += r
+	}
+	c, r := parser.Flush()
+	content += c
+	reasoning += r
+
+	if reasoning != "先看目录结构" {
+		t.Errorf("unexpected reasoning: %q", reasoning)
+	}
+	if content != "好的，<b>完成</b>" {
+		t.Errorf("unexpected content: %q", content)
+	}
+
+	content, reasoning = splitThinkTags("没有推理内容 <thin")
+	if content != "没有推理内容 <thin" || reasoning != "" {
+		t.Errorf("unexpected split without tags: %q / %q", content, reasoning)
+	}
+}
@@ -0,0 +1,336 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+const (
+	ReplayModeRecord = "record" // 调用上游提供商并写入录制文件
+	ReplayModeReplay = "replay" // 从录制文件回放，不访问网络
+)
+
+// Cassette 录制文件
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction 一次录制的请求与响应
+type Interaction struct {
+	Fingerprint string              `json:"fingerprint"`
+	Request     types.LLMRequest    `json:"request"`
+	Response    *types.LLMResponse  `json:"response,omitempty"`
+	Stream      []types.LLMResponse `json:"stream,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// ReplayClient 录制回放客户端。record模式包装真实客户端并记录请求响应，
+// replay模式按请求指纹从录制文件中返回响应。
+type ReplayClient struct {
+	config   types.LLMConfig
+	mode     string
+	path     string
+	upstream types.LLMClient
+	logger   log.Logger
+
+	mu       sync.Mutex
+	cassette Cassette
+	played   map[string]int
+}
+
+// NewReplayClient 创建录制回放客户端，record模式需要提供上游客户端
+func NewReplayClient(config types.LLMConfig, upstream types.LLMClient, logger log.Logger) (*ReplayClient, error) {
+	if config.Cassette == "" {
+		return nil, fmt.Errorf("replay provider requires cassette path")
+	}
+
+	mode := strings.ToLower(config.Mode)
+	if mode == "" {
+		mode = ReplayModeReplay
+	}
+
+	client := &ReplayClient{
+		config:   config,
+		mode:     mode,
+		path:     utils.ExpandPath(config.Cassette),
+		upstream: upstream,
+		logger:   logger,
+		played:   make(map[string]int),
+	}
+
+	switch mode {
+	case ReplayModeRecord:
+		if upstream == nil {
+			return nil, fmt.Errorf("replay provider in record mode requires upstream provider")
+		}
+	case ReplayModeReplay:
+		if err := client.load(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported replay mode: %s", config.Mode)
+	}
+
+	return client, nil
+}
+
+// GetConfig 获取配置
+func (c *ReplayClient) GetConfig() types.LLMConfig {
+	return c.config
+}
+
+// GetProvider 获取提供商
+func (c *ReplayClient) GetProvider() types.LLMProvider {
+	return types.ProviderReplay
+}
+
+// Chat 对话
+func (c *ReplayClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	fingerprint := RequestFingerprint(request)
+
+	if c.mode == ReplayModeRecord {
+		response, err := c.upstream.Chat(ctx, request)
+		interaction := Interaction{Fingerprint: fingerprint, Request: request, Response: response}
+		if err != nil {
+			interaction.Error = err.Error()
+		}
+		if saveErr := c.record(interaction); saveErr != nil {
+			c.logger.Errorf("Failed to write cassette: %v", saveErr)
+		}
+		return response, err
+	}
+
+	interaction, err := c.lookup(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+	if interaction.Response != nil {
+		response := *interaction.Response
+		return &response, nil
+	}
+
+	// 录制时为流式请求，合并为一个响应
+	return mergeStream(interaction.Stream), nil
+}
+
+// ChatStream 流式对话
+func (c *ReplayClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	fingerprint := RequestFingerprint(request)
+
+	if c.mode == ReplayModeRecord {
+		return c.recordStream(ctx, fingerprint, request)
+	}
+
+	interaction, err := c.lookup(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if interaction.Error != "" {
+		return nil, errors.New(interaction.Error)
+	}
+
+	chunks := interaction.Stream
+	if len(chunks) == 0 && interaction.Response != nil {
+		// 录制时为非流式请求，拆成内容块和携带工具调用的最终块
+		response := *interaction.Response
+		final := response
+		final.Content = ""
+		final.Reasoning = ""
+		response.ToolCalls = nil
+		response.Usage = types.Usage{}
+		chunks = []types.LLMResponse{response, final}
+	}
+
+	responseChan := make(chan types.LLMResponse, len(chunks))
+	for _, chunk := range chunks {
+		responseChan <- chunk
+	}
+	close(responseChan)
+
+	return responseChan, nil
+}
+
+// recordStream 转发上游流式响应，流结束后写入录制文件
+func (c *ReplayClient) recordStream(ctx context.Context, fingerprint string, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	stream, err := c.upstream.ChatStream(ctx, request)
+	if err != nil {
+		if saveErr := c.record(Interaction{Fingerprint: fingerprint, Request: request, Error: err.Error()}); saveErr != nil {
+			c.logger.Errorf("Failed to write cassette: %v", saveErr)
+		}
+		return nil, err
+	}
+
+	responseChan := make(chan types.LLMResponse, 10)
+
+	go func() {
+		defer close(responseChan)
+
+		var chunks []types.LLMResponse
+		defer func() {
+			if err := c.record(Interaction{Fingerprint: fingerprint, Request: request, Stream: chunks}); err != nil {
+				c.logger.Errorf("Failed to write cassette: %v", err)
+			}
+		}()
+
+		for resp := range stream {
+			chunks = append(chunks, resp)
+			select {
+			case responseChan <- resp:
+			case <-ctx.Done():
+				// 排空上游通道，避免上游协程阻塞
+				for range stream {
+				}
+				return
+			}
+		}
+	}()
+
+	return responseChan, nil
+}
+
+// lookup 查找指纹对应的录制，同一指纹多次录制时按顺序返回，用尽后重复最后一次
+func (c *ReplayClient) lookup(fingerprint string) (Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matches []Interaction
+	for _, interaction := range c.cassette.Interactions {
+		if interaction.Fingerprint == fingerprint {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return Interaction{}, fmt.Errorf("no recorded interaction for request fingerprint %s in %s", fingerprint, c.path)
+	}
+
+	index := c.played[fingerprint]
+	if index >= len(matches) {
+		index = len(matches) - 1
+	}
+	c.played[fingerprint]++
+
+	return matches[index], nil
+}
+
+// record 追加录制并写入文件
+func (c *ReplayClient) record(interaction Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cassette.Interactions = append(c.cassette.Interactions, interaction)
+
+	data, err := json.MarshalIndent(c.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := utils.EnsureDir(filepath.Dir(c.path)); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// load 读取录制文件
+func (c *ReplayClient) load() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &c.cassette); err != nil {
+		return fmt.Errorf("failed to parse cassette %s: %w", c.path, err)
+	}
+
+	return nil
+}
+
+// fingerprintMessage 参与指纹计算的消息字段
+type fingerprintMessage struct {
+	Role      types.MessageRole `json:"role"`
+	Content   string            `json:"content"`
+	ToolCalls []string          `json:"tool_calls,omitempty"`
+}
+
+// RequestFingerprint 计算请求指纹。系统消息、标记为易变的消息、ID、时间戳、
+// 元数据、工具调用ID以及采样参数均不参与计算，保证提示词调整或环境变化后录制仍可用。
+func RequestFingerprint(request types.LLMRequest) string {
+	messages := make([]fingerprintMessage, 0, len(request.Messages))
+	for _, msg := range request.Messages {
+		if msg.Role == types.RoleSystem || msg.Metadata[types.MetadataVolatile] == "true" {
+			continue
+		}
+
+		fm := fingerprintMessage{Role: msg.Role, Content: msg.Content}
+		for _, toolCall := range msg.ToolCalls {
+			fm.ToolCalls = append(fm.ToolCalls, toolCall.Function.Name+" "+toolCall.Function.Arguments)
+		}
+		messages = append(messages, fm)
+	}
+
+	tools := make([]string, 0, len(request.Tools))
+	for _, tool := range request.Tools {
+		tools = append(tools, tool.Function.Name)
+	}
+	// 工具顺序取决于启用配置，排序后不受其影响
+	sort.Strings(tools)
+
+	data, _ := json.Marshal(struct {
+		Model    string               `json:"model,omitempty"`
+		Tools    []string             `json:"tools,omitempty"`
+		Messages []fingerprintMessage `json:"messages"`
+	}{request.Model, tools, messages})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// mergeStream 将流式响应块合并为一个完整响应
+func mergeStream(chunks []types.LLMResponse) *types.LLMResponse {
+	response := &types.LLMResponse{Role: string(types.RoleAssistant)}
+	var content, reasoning strings.Builder
+
+	for _, chunk := range chunks {
+		if chunk.ID != "" {
+			response.ID = chunk.ID
+		}
+		content.WriteString(chunk.Content)
+		reasoning.WriteString(chunk.Reasoning)
+		response.ToolCalls = append(response.ToolCalls, chunk.ToolCalls...)
+		if !chunk.Usage.IsZero() {
+			response.Usage = chunk.Usage
+		}
+		if chunk.FinishReason != "" {
+			response.FinishReason = chunk.FinishReason
+		}
+		if chunk.Error != "" {
+			response.Error = chunk.Error
+		}
+		for key, value := range chunk.Metadata {
+			if response.Metadata == nil {
+				response.Metadata = make(map[string]string)
+			}
+			response.Metadata[key] = value
+		}
+	}
+
+	response.Content = content.String()
+	response.Reasoning = reasoning.String()
+	return response
+}
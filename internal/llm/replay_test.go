@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func newReplayTestClient(t *testing.T, mode, cassette string, upstream types.LLMClient) *ReplayClient {
+	t.Helper()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client, err := NewReplayClient(types.LLMConfig{Mode: mode, Cassette: cassette}, upstream, logger)
+	if err != nil {
+		t.Fatalf("NewReplayClient error: %v", err)
+	}
+	return client
+}
+
+func replayTestRequest(question string) types.LLMRequest {
+	return types.LLMRequest{
+		Messages: []types.Message{
+			{ID: "sys", Role: types.RoleSystem, Content: "You are a coding agent."},
+			{ID: "env", Role: types.RoleUser, Content: "date: 2024-01-01", Metadata: map[string]string{types.MetadataVolatile: "true"}},
+			{ID: "q", Role: types.RoleUser, Content: question},
+		},
+	}
+}
+
+func TestReplayRecordAndReplay(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	upstream := &fakeClient{
+		provider: types.ProviderDeepSeek,
+		chunks: []types.LLMResponse{
+			{Content: "let me "},
+			{Content: "look", ToolCalls: []types.ToolCall{{ID: "call_1", Type: "function", Function: types.ToolCallFunction{Name: "ls", Arguments: `{"path":"."}`}}}},
+		},
+	}
+
+	recorder := newReplayTestClient(t, ReplayModeRecord, cassette, upstream)
+	if _, err := recorder.Chat(context.Background(), replayTestRequest("hello")); err != nil {
+		t.Fatalf("record Chat error: %v", err)
+	}
+	stream, err := recorder.ChatStream(context.Background(), replayTestRequest("list files"))
+	if err != nil {
+		t.Fatalf("record ChatStream error: %v", err)
+	}
+	for range stream {
+	}
+	if upstream.calls != 2 {
+		t.Fatalf("expected 2 upstream calls, got %d", upstream.calls)
+	}
+
+	player := newReplayTestClient(t, ReplayModeReplay, cassette, nil)
+
+	// 系统提示词和易变消息变化不影响匹配
+	request := replayTestRequest("hello")
+	request.Messages[0].Content = "You are an updated coding agent."
+	request.Messages[1].Content = "date: 2025-06-30"
+	response, err := player.Chat(context.Background(), request)
+	if err != nil {
+		t.Fatalf("replay Chat error: %v", err)
+	}
+	if response.Content != string(types.ProviderDeepSeek) {
+		t.Errorf("unexpected replayed content: %q", response.Content)
+	}
+
+	stream, err = player.ChatStream(context.Background(), replayTestRequest("list files"))
+	if err != nil {
+		t.Fatalf("replay ChatStream error: %v", err)
+	}
+	var content string
+	var toolCalls []types.ToolCall
+	for resp := range stream {
+		content += resp.Content
+		toolCalls = append(toolCalls, resp.ToolCalls...)
+	}
+	if content != "let me look" || len(toolCalls) != 1 || toolCalls[0].Function.Name != "ls" {
+		t.Errorf("unexpected replayed stream: %q %+v", content, toolCalls)
+	}
+
+	// 流式录制也可按非流式回放
+	merged, err := player.Chat(context.Background(), replayTestRequest("list files"))
+	if err != nil {
+		t.Fatalf("replay merged Chat error: %v", err)
+	}
+	if merged.Content != "let me look" || len(merged.ToolCalls) != 1 {
+		t.Errorf("unexpected merged response: %+v", merged)
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "cassette.json")
+	recorder := newReplayTestClient(t, ReplayModeRecord, cassette, &fakeClient{provider: types.ProviderOllama})
+	if _, err := recorder.Chat(context.Background(), replayTestRequest("hello")); err != nil {
+		t.Fatalf("record Chat error: %v", err)
+	}
+
+	player := newReplayTestClient(t, ReplayModeReplay, cassette, nil)
+	if _, err := player.Chat(context.Background(), replayTestRequest("goodbye")); err == nil {
+		t.Fatal("expected error for unrecorded request")
+	}
+}
@@ -0,0 +1,280 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 1000  // milliseconds
+	defaultMaxBackoff     = 30000 // milliseconds
+)
+
+// RetryClient 为任意LLM客户端增加指数退避重试
+type RetryClient struct {
+	client         types.LLMClient
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	logger         log.Logger
+}
+
+// NewRetryClient 创建重试客户端
+func NewRetryClient(client types.LLMClient, config types.RetryConfig, logger log.Logger) *RetryClient {
+	maxRetries := config.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	initialBackoff := config.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = defaultInitialBackoff
+	}
+
+	maxBackoff := config.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	if maxBackoff < initialBackoff {
+		maxBackoff = initialBackoff
+	}
+
+	return &RetryClient{
+		client:         client,
+		maxRetries:     maxRetries,
+		initialBackoff: time.Duration(initialBackoff) * time.Millisecond,
+		maxBackoff:     time.Duration(maxBackoff) * time.Millisecond,
+		logger:         logger,
+	}
+}
+
+// GetConfig 获取配置
+func (c *RetryClient) GetConfig() types.LLMConfig {
+	return c.client.GetConfig()
+}
+
+// GetProvider 获取提供商
+func (c *RetryClient) GetProvider() types.LLMProvider {
+	return c.client.GetProvider()
+}
+
+// Unwrap 返回被包装的客户端
+func (c *RetryClient) Unwrap() types.LLMClient {
+	return c.client
+}
+
+// Chat 对话，可重试错误按退避策略重试
+func (c *RetryClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	var response *types.LLMResponse
+	err := c.do(ctx, "Chat", func(ctx context.Context) error {
+		var err error
+		response, err = c.client.Chat(ctx, request)
+		return err
+	})
+	return response, err
+}
+
+// ChatStream 流式对话，仅重试建立流之前的错误
+func (c *RetryClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	var stream <-chan types.LLMResponse
+	err := c.do(ctx, "ChatStream", func(ctx context.Context) error {
+		var err error
+		stream, err = c.client.ChatStream(ctx, request)
+		return err
+	})
+	return stream, err
+}
+
+// Embed 向量化，可重试错误按退避策略重试
+func (c *RetryClient) Embed(ctx context.Context, request types.EmbeddingRequest) (*types.EmbeddingResponse, error) {
+	embedder, ok := Embedder(c.client)
+	if !ok {
+		return nil, ErrEmbeddingsNotSupported
+	}
+
+	var response *types.EmbeddingResponse
+	err := c.do(ctx, "embed", func(ctx context.Context) error {
+		var err error
+		response, err = embedder.Embed(ctx, request)
+		return err
+	})
+	return response, err
+}
+
+// do 执行调用并在可重试错误时等待后重试
+func (c *RetryClient) do(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		callCtx, hint := withRetryAfterHint(ctx)
+		err := call(callCtx)
+		if err == nil || attempt >= c.maxRetries || !c.shouldRetry(ctx, err) {
+			return err
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter := RetryAfterOf(err); retryAfter > 0 {
+			delay = retryAfter
+		} else if retryAfter := hint.get(); retryAfter > 0 {
+			delay = retryAfter
+		}
+
+		// 等待时间超过调用方截止时间则直接放弃
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		c.logger.Warnf("%s %s failed (attempt %d/%d), retrying in %v: %v",
+			c.client.GetProvider(), method, attempt+1, c.maxRetries+1, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// shouldRetry 判断错误是否可重试
+func (c *RetryClient) shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	return IsRetryableError(err)
+}
+
+// backoff 计算带抖动的指数退避时间
+func (c *RetryClient) backoff(attempt int) time.Duration {
+	backoff := c.initialBackoff
+	for i := 0; i < attempt && backoff < c.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+
+	// 一半固定，一半随机，避免多个会话同时重试
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// IsRetryableError 判断错误是否可重试：网络错误、超时、429、408及5xx
+func IsRetryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if IsTransportError(err) {
+		return true
+	}
+
+	switch code := StatusCodeOf(err); {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryAfterOf 获取错误携带的 Retry-After 时长
+func RetryAfterOf(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// parseRetryAfter 解析 Retry-After 头，支持秒数和HTTP日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// retryAfterKey 上下文中 Retry-After 记录的键
+type retryAfterKey struct{}
+
+// retryAfterHint 记录SDK客户端响应中的 Retry-After 头
+type retryAfterHint struct {
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+func (h *retryAfterHint) set(delay time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.delay = delay
+}
+
+func (h *retryAfterHint) get() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delay
+}
+
+// withRetryAfterHint 在上下文中挂载 Retry-After 记录
+func withRetryAfterHint(ctx context.Context) (context.Context, *retryAfterHint) {
+	hint := &retryAfterHint{}
+	return context.WithValue(ctx, retryAfterKey{}, hint), hint
+}
+
+// retryAfterTransport 将响应中的 Retry-After 头写入请求上下文的记录中。
+// SDK返回的错误不包含响应头，通过该Transport取回。
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip 实现http.RoundTripper接口
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if hint, ok := req.Context().Value(retryAfterKey{}).(*retryAfterHint); ok {
+		if delay := parseRetryAfter(resp.Header.Get("Retry-After")); delay > 0 {
+			hint.set(delay)
+		}
+	}
+
+	return resp, nil
+}
+
+// newHTTPClient 创建LLM客户端使用的HTTP客户端
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &retryAfterTransport{base: http.DefaultTransport},
+	}
+}
@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func newRetryTestClient(t *testing.T, maxRetries int, handler http.HandlerFunc) *RetryClient {
+	t.Helper()
+
+	logger, _ := log.New(log.DefaultConfig())
+	return NewRetryClient(newClaudeTestClient(t, handler), types.RetryConfig{
+		MaxRetries:     maxRetries,
+		InitialBackoff: 1,
+		MaxBackoff:     5,
+	}, logger)
+}
+
+func writeClaudeText(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"msg_01","type":"message","role":"assistant","content":[{"type":"text","text":%q}],"usage":{"input_tokens":1,"output_tokens":1}}`, text)
+}
+
+func TestRetryClientRetriesRateLimit(t *testing.T) {
+	var requests int32
+	client := newRetryTestClient(t, 3, func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0.01")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			writeClaudeText(w, "ok")
+		}
+	})
+
+	response, err := client.Chat(context.Background(), types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Content != "ok" {
+		t.Errorf("unexpected content: %q", response.Content)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestRetryClientDoesNotRetryClientError(t *testing.T) {
+	var requests int32
+	client := newRetryTestClient(t, 3, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	if _, err := client.ChatStream(context.Background(), types.LLMRequest{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+}
+
+func TestRetryClientGivesUpAfterMaxRetries(t *testing.T) {
+	var requests int32
+	client := newRetryTestClient(t, 2, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, err := client.Chat(context.Background(), types.LLMRequest{})
+	if StatusCodeOf(err) != http.StatusBadGateway {
+		t.Fatalf("expected 502 error, got %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestRetryClientRespectsCancellation(t *testing.T) {
+	client := newRetryTestClient(t, 3, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Chat(ctx, types.LLMRequest{})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("retry did not stop on cancellation")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("2"); got != 2*time.Second {
+		t.Errorf("parseRetryAfter(2) = %v", got)
+	}
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("parseRetryAfter(empty) = %v", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 0 || got > time.Minute {
+		t.Errorf("parseRetryAfter(%s) = %v", date, got)
+	}
+}
+
+func TestDeepSeekClientRecordsRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewDeepSeekClient(types.LLMConfig{Provider: types.ProviderDeepSeek, APIKey: "test", BaseURL: server.URL + "/", Model: "deepseek-chat"}, logger)
+
+	// SDK返回的错误不含响应头，Retry-After 经由HTTP客户端记录
+	ctx, hint := withRetryAfterHint(context.Background())
+	if _, err := client.Chat(ctx, types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "hi"}}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if hint.get() != 7*time.Second {
+		t.Errorf("expected Retry-After to be recorded, got %v", hint.get())
+	}
+}
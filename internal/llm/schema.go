@@ -0,0 +1,286 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSON 解析模型返回的JSON并按 Schema 校验，返回去掉代码块标记后的JSON文本。
+// 支持 JSON Schema 的常用子集：type、enum、const、properties、required、
+// additionalProperties、items、anyOf 以及长度和数值范围约束。
+func ValidateJSON(content string, schema map[string]any) (string, error) {
+	content = extractJSON(content)
+
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if err := validateSchema(value, schema, "$"); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// extractJSON 去掉模型常加的 ```json 代码块标记
+func extractJSON(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+
+	content = strings.TrimPrefix(content, "```")
+	if i := strings.IndexByte(content, '\n'); i >= 0 {
+		content = content[i+1:]
+	}
+	content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	return strings.TrimSpace(content)
+}
+
+// validateSchema 递归校验值，path 为出错位置
+func validateSchema(value any, schema map[string]any, path string) error {
+	if len(schema) == 0 {
+		return nil
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, typ := range types {
+			if matchType(value, typ) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(value))
+		}
+	}
+
+	if enum, ok := schema["enum"]; ok {
+		if !inEnum(value, enum) {
+			return fmt.Errorf("%s: value %v not in enum %v", path, value, enum)
+		}
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(value, constant) {
+		return fmt.Errorf("%s: expected constant %v", path, constant)
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		if err := validateAnyOf(value, anyOf, path); err != nil {
+			return err
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		return validateObject(v, schema, path)
+	case []any:
+		return validateArray(v, schema, path)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if min, ok := schemaNumber(schema["minLength"]); ok && float64(length) < min {
+			return fmt.Errorf("%s: string shorter than %v", path, min)
+		}
+		if max, ok := schemaNumber(schema["maxLength"]); ok && float64(length) > max {
+			return fmt.Errorf("%s: string longer than %v", path, max)
+		}
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && v < min {
+			return fmt.Errorf("%s: %v is less than minimum %v", path, v, min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && v > max {
+			return fmt.Errorf("%s: %v is greater than maximum %v", path, v, max)
+		}
+	}
+	return nil
+}
+
+// validateObject 校验对象的必填字段、属性和额外属性
+func validateObject(object map[string]any, schema map[string]any, path string) error {
+	for _, name := range schemaStrings(schema["required"]) {
+		if _, exists := object[name]; !exists {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	properties := schemaMap(schema["properties"])
+
+	// 按键排序，保证错误信息稳定
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		propPath := path + "." + key
+		if propSchema, ok := properties[key]; ok {
+			if err := validateSchema(object[key], schemaMap(propSchema), propPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: unexpected property", propPath)
+			}
+		case map[string]any:
+			if err := validateSchema(object[key], additional, propPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateArray 校验数组长度和元素
+func validateArray(array []any, schema map[string]any, path string) error {
+	if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(array)) < min {
+		return fmt.Errorf("%s: expected at least %v items", path, min)
+	}
+	if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(array)) > max {
+		return fmt.Errorf("%s: expected at most %v items", path, max)
+	}
+
+	items := schemaMap(schema["items"])
+	for i, item := range array {
+		if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAnyOf 至少满足一个子 Schema
+func validateAnyOf(value any, anyOf []any, path string) error {
+	var errs []string
+	for _, sub := range anyOf {
+		err := validateSchema(value, schemaMap(sub), path)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("%s: does not match any schema (%s)", path, strings.Join(errs, "; "))
+}
+
+// matchType 判断值是否为 Schema 中的类型
+func matchType(value any, typ string) bool {
+	switch typ {
+	case "integer":
+		v, ok := value.(float64)
+		return ok && v == math.Trunc(v)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonType(value) == typ
+	}
+}
+
+// jsonType 返回解析后值的JSON类型名
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// inEnum 判断值是否在枚举中
+func inEnum(value any, enum any) bool {
+	rv := reflect.ValueOf(enum)
+	if rv.Kind() != reflect.Slice {
+		return false
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if jsonEqual(value, rv.Index(i).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual 按JSON语义比较，兼容 Schema 中的 int、[]string 等Go类型
+func jsonEqual(value, expected any) bool {
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	var normalized any
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(value, normalized)
+}
+
+// schemaMap 将子 Schema 转换为 map，兼容 types.ToolCallFunctionArguments 等命名类型
+func schemaMap(value any) map[string]any {
+	if m, ok := value.(map[string]any); ok {
+		return m
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Map && rv.Type().ConvertibleTo(reflect.TypeOf(map[string]any{})) {
+		return rv.Convert(reflect.TypeOf(map[string]any{})).Interface().(map[string]any)
+	}
+	return nil
+}
+
+// schemaTypes 读取 type 字段，支持字符串和字符串数组
+func schemaTypes(value any) []string {
+	if typ, ok := value.(string); ok {
+		return []string{typ}
+	}
+	return schemaStrings(value)
+}
+
+// schemaStrings 读取字符串数组，兼容 []string 和 []any
+func schemaStrings(value any) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// schemaNumber 读取数值约束
+func schemaNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
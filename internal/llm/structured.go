@@ -0,0 +1,95 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// StructuredClient 校验结构化输出，响应不符合 Schema 时带上校验错误重新请求一次
+type StructuredClient struct {
+	client types.LLMClient
+	logger log.Logger
+}
+
+// NewStructuredClient 创建结构化输出校验客户端
+func NewStructuredClient(client types.LLMClient, logger log.Logger) *StructuredClient {
+	return &StructuredClient{
+		client: client,
+		logger: logger,
+	}
+}
+
+// GetConfig 获取配置
+func (c *StructuredClient) GetConfig() types.LLMConfig {
+	return c.client.GetConfig()
+}
+
+// GetProvider 获取提供商
+func (c *StructuredClient) GetProvider() types.LLMProvider {
+	return c.client.GetProvider()
+}
+
+// Unwrap 返回被包装的客户端
+func (c *StructuredClient) Unwrap() types.LLMClient {
+	return c.client
+}
+
+// Chat 对话，设置了 ResponseFormat 时校验响应并在失败时重试一次
+func (c *StructuredClient) Chat(ctx context.Context, request types.LLMRequest) (*types.LLMResponse, error) {
+	response, err := c.client.Chat(ctx, request)
+	if err != nil || request.ResponseFormat == nil {
+		return response, err
+	}
+
+	content, err := ValidateJSON(response.Content, request.ResponseFormat.Schema)
+	if err == nil {
+		response.Content = content
+		return response, nil
+	}
+
+	c.logger.Warnf("%s response does not match schema %s, asking again: %v",
+		c.client.GetProvider(), request.ResponseFormat.Name, err)
+
+	retryRequest := request
+	retryRequest.Messages = append(append([]types.Message{}, request.Messages...),
+		types.Message{Role: types.RoleAssistant, Content: response.Content},
+		types.Message{Role: types.RoleUser, Content: schemaRetryPrompt(request.ResponseFormat, err)},
+	)
+
+	retried, retryErr := c.client.Chat(ctx, retryRequest)
+	if retryErr != nil {
+		return nil, retryErr
+	}
+	retried.Usage.Add(response.Usage)
+
+	content, err = ValidateJSON(retried.Content, request.ResponseFormat.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("response does not match schema %s: %w", request.ResponseFormat.Name, err)
+	}
+	retried.Content = content
+	return retried, nil
+}
+
+// ChatStream 流式对话，流式响应无法整体校验，直接透传
+func (c *StructuredClient) ChatStream(ctx context.Context, request types.LLMRequest) (<-chan types.LLMResponse, error) {
+	return c.client.ChatStream(ctx, request)
+}
+
+// schemaInstruction 不支持 Schema 参数的提供商通过提示词约束输出
+func schemaInstruction(format *types.ResponseFormat) string {
+	schema, _ := json.Marshal(format.Schema)
+	instruction := "Respond only with a JSON value that matches the following JSON schema, without any other text or markdown code fences."
+	if format.Description != "" {
+		instruction = format.Description + "\n\n" + instruction
+	}
+	return fmt.Sprintf("%s\n\n%s", instruction, schema)
+}
+
+// schemaRetryPrompt 校验失败后要求模型重新输出
+func schemaRetryPrompt(format *types.ResponseFormat, err error) string {
+	return fmt.Sprintf("The previous response is not valid: %v\n\n%s", err, schemaInstruction(format))
+}
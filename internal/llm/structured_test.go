@@ -0,0 +1,146 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+var summarySchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"summary": map[string]any{"type": "string", "minLength": 1},
+		"files":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		"status":  map[string]any{"type": "string", "enum": []string{"done", "pending"}},
+	},
+	"required":             []string{"summary", "status"},
+	"additionalProperties": false,
+}
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"summary":"ok","status":"done","files":["a.go"]}`, ""},
+		{"code fence", "```json\n{\"summary\":\"ok\",\"status\":\"pending\"}\n```", ""},
+		{"invalid json", `{"summary":`, "invalid JSON"},
+		{"missing required", `{"summary":"ok"}`, `missing required property "status"`},
+		{"wrong type", `{"summary":"ok","status":"done","files":[1]}`, "$.files[0]: expected string"},
+		{"enum", `{"summary":"ok","status":"unknown"}`, "not in enum"},
+		{"additional property", `{"summary":"ok","status":"done","extra":true}`, "$.extra: unexpected property"},
+		{"min length", `{"summary":"","status":"done"}`, "shorter than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := ValidateJSON(tt.content, summarySchema)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if strings.HasPrefix(content, "```") {
+					t.Errorf("code fence not stripped: %q", content)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func writeClaudeToolUse(w http.ResponseWriter, name, input string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"msg_01","type":"message","role":"assistant","content":[{"type":"tool_use","id":"toolu_01","name":%q,"input":%s}],"usage":{"input_tokens":10,"output_tokens":5}}`, name, input)
+}
+
+func TestStructuredClientRetriesOnce(t *testing.T) {
+	var requests []ClaudeRequest
+	claude := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req ClaudeRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		if len(requests) == 1 {
+			writeClaudeToolUse(w, "summary", `{"summary":"ok"}`)
+			return
+		}
+		writeClaudeToolUse(w, "summary", `{"summary":"ok","status":"done"}`)
+	})
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewStructuredClient(claude, logger)
+
+	response, err := client.Chat(context.Background(), types.LLMRequest{
+		Messages:       []types.Message{{Role: types.RoleUser, Content: "总结一下"}},
+		ResponseFormat: &types.ResponseFormat{Name: "summary", Schema: summarySchema},
+	})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+
+	if response.Content != `{"summary":"ok","status":"done"}` || len(response.ToolCalls) != 0 {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	if response.Usage.PromptTokens != 20 {
+		t.Errorf("expected usage of both calls, got %+v", response.Usage)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	first := requests[0]
+	if first.ToolChoice == nil || first.ToolChoice.Type != "tool" || first.ToolChoice.Name != "summary" {
+		t.Errorf("expected forced tool choice, got %+v", first.ToolChoice)
+	}
+	retry := requests[1].Messages
+	if last := retry[len(retry)-1]; last.Role != "user" || !strings.Contains(last.Content[0].Text, `missing required property "status"`) {
+		t.Errorf("expected validation error in retry prompt, got %+v", last)
+	}
+}
+
+func TestStructuredClientFailsAfterRetry(t *testing.T) {
+	claude := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeClaudeToolUse(w, "summary", `{"summary":"ok"}`)
+	})
+
+	logger, _ := log.New(log.DefaultConfig())
+	client := NewStructuredClient(claude, logger)
+
+	_, err := client.Chat(context.Background(), types.LLMRequest{
+		Messages:       []types.Message{{Role: types.RoleUser, Content: "总结一下"}},
+		ResponseFormat: &types.ResponseFormat{Name: "summary", Schema: summarySchema},
+	})
+	if err == nil || !strings.Contains(err.Error(), "does not match schema summary") {
+		t.Errorf("expected schema error, got %v", err)
+	}
+}
+
+func TestClaudeStructuredNonObjectSchema(t *testing.T) {
+	claude := newClaudeTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeClaudeToolUse(w, "files", `{"value":["a.go","b.go"]}`)
+	})
+
+	response, err := claude.Chat(context.Background(), types.LLMRequest{
+		Messages: []types.Message{{Role: types.RoleUser, Content: "列出文件"}},
+		ResponseFormat: &types.ResponseFormat{
+			Name:   "files",
+			Schema: map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+	if response.Content != `["a.go","b.go"]` {
+		t.Errorf("unexpected content: %s", response.Content)
+	}
+}
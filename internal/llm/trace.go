@@ -0,0 +1,285 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/types"
+	"github.com/zboya/nala-coder/pkg/utils"
+)
+
+// DefaultTracePath 未配置路径时的追踪文件
+const DefaultTracePath = "~/.nala-coder/trace.jsonl"
+
+// redactedValue 密钥脱敏后的替换值
+const redactedValue = "[REDACTED]"
+
+// secretPatterns 常见API密钥格式，未在配置中出现的密钥（如工具读取到的 .env 内容）也会被脱敏
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{20,}`),
+	regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`),
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._\-]{20,}`),
+}
+
+// TraceConfig LLM调用追踪配置
+type TraceConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Path    string `mapstructure:"path"` // JSONL文件路径
+}
+
+// TraceRecord 一次LLM调用的追踪记录，故障转移时每个提供商的尝试各一条
+type TraceRecord struct {
+	Time      time.Time          `json:"time"`
+	SessionID string             `json:"session_id,omitempty"`
+	Loop      int                `json:"loop,omitempty"`
+	Provider  types.LLMProvider  `json:"provider"`
+	Model     string             `json:"model,omitempty"`
+	Stream    bool               `json:"stream"`
+	LatencyMs int64              `json:"latency_ms"`
+	Request   types.LLMRequest   `json:"request"`
+	Response  *types.LLMResponse `json:"response,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// Tracer 将LLM调用以JSONL格式追加写入文件，写入前对密钥脱敏
+type Tracer struct {
+	path    string
+	secrets []string
+	mu      sync.Mutex
+}
+
+// NewTracer 创建追踪器，secrets 为需要脱敏的配置密钥
+func NewTracer(path string, secrets []string) (*Tracer, error) {
+	if path == "" {
+		path = DefaultTracePath
+	}
+
+	path = utils.ExpandPath(path)
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %w", err)
+	}
+
+	tracer := &Tracer{path: path}
+	for _, secret := range secrets {
+		// 过短的值脱敏会误伤正常内容
+		if len(secret) >= 8 {
+			tracer.secrets = append(tracer.secrets, secret)
+		}
+	}
+	return tracer, nil
+}
+
+// Path 追踪文件路径
+func (t *Tracer) Path() string {
+	return t.path
+}
+
+// Record 追加一条记录
+func (t *Tracer) Record(record TraceRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace record: %w", err)
+	}
+	line := t.redact(string(data)) + "\n"
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	file, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write trace record: %w", err)
+	}
+	return nil
+}
+
+// redact 替换配置中的密钥和常见格式的密钥
+func (t *Tracer) redact(text string) string {
+	for _, secret := range t.secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllString(text, redactedValue)
+	}
+	return text
+}
+
+// ReadTrace 读取追踪文件，跳过无法解析的行（例如写入中断的最后一行）
+func ReadTrace(path string) ([]TraceRecord, error) {
+	file, err := os.Open(utils.ExpandPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer file.Close()
+
+	var records []TraceRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record TraceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, fmt.Errorf("failed to read trace file: %w", err)
+	}
+
+	return records, nil
+}
+
+// traceInfoKey 上下文中追踪信息的键
+type traceInfoKey struct{}
+
+// traceInfo 调用所属的会话和Agent循环
+type traceInfo struct {
+	sessionID string
+	loop      int
+}
+
+// WithTraceInfo 在上下文中记录会话和循环序号，写入该上下文发起的LLM调用的追踪记录
+func WithTraceInfo(ctx context.Context, sessionID string, loop int) context.Context {
+	return context.WithValue(ctx, traceInfoKey{}, traceInfo{sessionID: sessionID, loop: loop})
+}
+
+// traceInfoFrom 读取上下文中的追踪信息
+func traceInfoFrom(ctx context.Context) traceInfo {
+	info, _ := ctx.Value(traceInfoKey{}).(traceInfo)
+	return info
+}
+
+// PromptChangeKind 提示词消息的变化类型
+type PromptChangeKind string
+
+const (
+	PromptUnchanged PromptChangeKind = "unchanged"
+	PromptRemoved   PromptChangeKind = "removed"
+	PromptAdded     PromptChangeKind = "added"
+	PromptModified  PromptChangeKind = "modified" // 同一位置同角色的消息内容变化，如环境信息或压缩后的历史
+)
+
+// PromptChange 一条消息的变化，Modified 时 Previous 为修改前的消息
+type PromptChange struct {
+	Kind     PromptChangeKind
+	Message  types.Message
+	Previous types.Message
+}
+
+// DiffPrompts 比较相邻两次调用的消息列表，按最长公共子序列对齐。
+// 相邻的删除和新增消息角色相同时合并为一次修改
+func DiffPrompts(previous, current []types.Message) []PromptChange {
+	ops := diffSequence(len(previous), len(current), func(i, j int) bool {
+		return sameMessage(previous[i], current[j])
+	})
+
+	var changes []PromptChange
+	for k := 0; k < len(ops); k++ {
+		op := ops[k]
+		switch op.kind {
+		case PromptUnchanged:
+			changes = append(changes, PromptChange{Kind: PromptUnchanged, Message: current[op.j]})
+		case PromptAdded:
+			changes = append(changes, PromptChange{Kind: PromptAdded, Message: current[op.j]})
+		case PromptRemoved:
+			if k+1 < len(ops) && ops[k+1].kind == PromptAdded && previous[op.i].Role == current[ops[k+1].j].Role {
+				changes = append(changes, PromptChange{Kind: PromptModified, Message: current[ops[k+1].j], Previous: previous[op.i]})
+				k++
+				continue
+			}
+			changes = append(changes, PromptChange{Kind: PromptRemoved, Message: previous[op.i]})
+		}
+	}
+	return changes
+}
+
+// sameMessage 比较发送给模型的消息内容，忽略ID和时间戳
+func sameMessage(a, b types.Message) bool {
+	if a.Role != b.Role || a.Content != b.Content || a.Reasoning != b.Reasoning || len(a.ToolCalls) != len(b.ToolCalls) {
+		return false
+	}
+	for i := range a.ToolCalls {
+		if a.ToolCalls[i].ID != b.ToolCalls[i].ID || a.ToolCalls[i].Function != b.ToolCalls[i].Function {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffLines 逐行比较两段文本，返回以 "  "、"- "、"+ " 开头的行
+func DiffLines(a, b string) []string {
+	left, right := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	ops := diffSequence(len(left), len(right), func(i, j int) bool { return left[i] == right[j] })
+	lines := make([]string, 0, len(ops))
+	for _, op := range ops {
+		switch op.kind {
+		case PromptUnchanged:
+			lines = append(lines, "  "+right[op.j])
+		case PromptRemoved:
+			lines = append(lines, "- "+left[op.i])
+		case PromptAdded:
+			lines = append(lines, "+ "+right[op.j])
+		}
+	}
+	return lines
+}
+
+// diffOp 序列比较的一步，i、j 分别为两个序列中的下标
+type diffOp struct {
+	kind PromptChangeKind
+	i, j int
+}
+
+// diffSequence 按最长公共子序列比较两个序列，删除排在新增之前
+func diffSequence(n, m int, equal func(i, j int) bool) []diffOp {
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if equal(i, j) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case equal(i, j):
+			ops = append(ops, diffOp{PromptUnchanged, i, j})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{PromptRemoved, i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{PromptAdded, i, j})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{PromptRemoved, i, j})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{PromptAdded, i, j})
+	}
+	return ops
+}
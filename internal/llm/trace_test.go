@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+func TestManagerTrace(t *testing.T) {
+	logger, _ := log.New(log.DefaultConfig())
+	path := filepath.Join(t.TempDir(), "trace", "calls.jsonl")
+	tracer, err := NewTracer(path, []string{"config-secret-key", "short"})
+	if err != nil {
+		t.Fatalf("NewTracer error: %v", err)
+	}
+
+	failing := NewMockClientFromScript(types.LLMConfig{Provider: "primary", Model: "m1"}, MockScript{
+		Turns: []MockTurn{{StatusCode: 503, Error: "overloaded"}}, Repeat: true,
+	}, logger)
+	fallback := NewMockClientFromScript(types.LLMConfig{Provider: types.ProviderMock, Model: "m2"}, MockScript{
+		Turns: []MockTurn{{
+			Content:   "读取 sk-abcdefghijklmnopqrstuvwx 的配置",
+			ChunkSize: 2,
+			Usage:     types.Usage{PromptTokens: 12, CompletionTokens: 5},
+		}}, Repeat: true,
+	}, logger)
+
+	manager := NewManager("primary", logger)
+	manager.RegisterClient("primary", failing)
+	manager.RegisterClient(types.ProviderMock, fallback)
+	if err := manager.SetFallbackProviders([]types.LLMProvider{types.ProviderMock}); err != nil {
+		t.Fatalf("SetFallbackProviders error: %v", err)
+	}
+	manager.SetTracer(tracer)
+
+	ctx := WithTraceInfo(context.Background(), "s1", 2)
+	request := types.LLMRequest{Messages: []types.Message{{Role: types.RoleUser, Content: "key is config-secret-key, short"}}}
+	if _, err := manager.Chat(ctx, request); err != nil {
+		t.Fatalf("Chat error: %v", err)
+	}
+
+	stream, err := manager.ChatStream(ctx, request)
+	if err != nil {
+		t.Fatalf("ChatStream error: %v", err)
+	}
+	for range stream {
+	}
+
+	// 流式记录在转发协程结束时写入
+	var records []TraceRecord
+	waitQueueDepth(t, func() int {
+		records, _ = ReadTrace(path)
+		return len(records)
+	}, 4)
+
+	// 故障转移的每次尝试各一条记录
+	for i, want := range []struct {
+		provider types.LLMProvider
+		stream   bool
+		failed   bool
+	}{{"primary", false, true}, {types.ProviderMock, false, false}, {"primary", true, true}, {types.ProviderMock, true, false}} {
+		record := records[i]
+		if record.Provider != want.provider || record.Stream != want.stream || (record.Error != "") != want.failed {
+			t.Errorf("record %d: unexpected %+v", i, record)
+		}
+		if record.SessionID != "s1" || record.Loop != 2 {
+			t.Errorf("record %d: expected session s1 loop 2, got %q %d", i, record.SessionID, record.Loop)
+		}
+	}
+
+	if response := records[3].Response; response == nil || response.Content != "读取 [REDACTED] 的配置" || response.Usage.CompletionTokens != 5 {
+		t.Errorf("expected merged and redacted stream response, got %+v", response)
+	}
+	if records[1].Model != "m2" || records[1].Request.Messages[0].Content != "key is [REDACTED], short" {
+		t.Errorf("unexpected request record: %+v", records[1])
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "config-secret-key") || strings.Contains(string(data), "sk-abcdefghijklmnopqrstuvwx") {
+		t.Errorf("trace file contains secrets")
+	}
+}
+
+func TestDiffPrompts(t *testing.T) {
+	system := types.Message{Role: types.RoleSystem, Content: "system"}
+	info := types.Message{Role: types.RoleUser, Content: "cwd: /a\ndate: 2026-01-01"}
+	user := types.Message{Role: types.RoleUser, Content: "hello", ID: "1"}
+
+	kinds := func(changes []PromptChange) string {
+		var kinds []string
+		for _, change := range changes {
+			kinds = append(kinds, string(change.Kind))
+		}
+		return strings.Join(kinds, ",")
+	}
+
+	changes := DiffPrompts(
+		[]types.Message{system, info, user},
+		[]types.Message{system, info, {Role: types.RoleUser, Content: "hello", ID: "2"}, {Role: types.RoleAssistant, Content: "hi"}},
+	)
+	if got := kinds(changes); got != "unchanged,unchanged,unchanged,added" {
+		t.Errorf("expected one appended message, got %s", got)
+	}
+
+	// 中间的消息被修改，之后的消息仍然对齐
+	changed := types.Message{Role: types.RoleUser, Content: "cwd: /b\ndate: 2026-01-01"}
+	changes = DiffPrompts([]types.Message{system, info, user}, []types.Message{changed, user})
+	if got := kinds(changes); got != "removed,modified,unchanged" || changes[1].Previous.Content != info.Content {
+		t.Errorf("unexpected diff: %s", got)
+	}
+
+	lines := DiffLines(info.Content, changed.Content)
+	want := []string{"- cwd: /a", "+ cwd: /b", "  date: 2026-01-01"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected line diff: %q", lines)
+	}
+}
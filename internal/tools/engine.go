@@ -19,6 +19,7 @@ type Engine struct {
 	mu             sync.RWMutex
 	logger         log.Logger
 	timeouts       map[string]time.Duration
+	hooks          HooksConfig
 }
 
 // Config 工具引擎配置
@@ -26,6 +27,37 @@ type Config struct {
 	MaxConcurrency int            `mapstructure:"max_concurrency"`
 	EnabledTools   []string       `mapstructure:"enabled_tools"`
 	Timeouts       map[string]int `mapstructure:"timeouts"` // milliseconds
+
+	Permissions PermissionConfig `mapstructure:"permissions"`
+	Hooks       HooksConfig      `mapstructure:"hooks"`
+}
+
+// readOnlyKey 上下文中标记只允许执行只读工具的键
+type readOnlyKey struct{}
+
+// WithReadOnly 标记只允许执行只读工具，用于计划模式
+func WithReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// ReadOnly 上下文是否只允许执行只读工具
+func ReadOnly(ctx context.Context) bool {
+	readOnly, _ := ctx.Value(readOnlyKey{}).(bool)
+	return readOnly
+}
+
+// sessionKey 上下文中保存工具调用所属会话的键
+type sessionKey struct{}
+
+// WithSessionID 记录工具调用所属的会话
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, sessionID)
+}
+
+// SessionID 工具调用所属的会话，未记录时为空
+func SessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionKey{}).(string)
+	return sessionID
 }
 
 // NewEngine 创建工具引擎
@@ -41,6 +73,7 @@ func NewEngine(config *Config, logger log.Logger) *Engine {
 		semaphore:      make(chan struct{}, maxConcurrency),
 		logger:         logger,
 		timeouts:       make(map[string]time.Duration),
+		hooks:          config.Hooks,
 	}
 
 	// 设置超时配置
@@ -54,6 +87,29 @@ func NewEngine(config *Config, logger log.Logger) *Engine {
 	return engine
 }
 
+// Subset 创建只提供指定工具的引擎，与原引擎共享工具实例和超时配置，并发上限单独计算，
+// 避免子任务占满原引擎的并发槽位后自己的工具调用无法执行
+func (e *Engine) Subset(names []string) *Engine {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	subset := &Engine{
+		tools:          make(map[string]types.ToolExecutor),
+		maxConcurrency: e.maxConcurrency,
+		semaphore:      make(chan struct{}, e.maxConcurrency),
+		logger:         e.logger,
+		timeouts:       e.timeouts,
+		hooks:          e.hooks,
+	}
+	for _, name := range names {
+		if tool, exists := e.tools[name]; exists {
+			subset.tools[name] = tool
+			subset.enabledTools = append(subset.enabledTools, name)
+		}
+	}
+	return subset
+}
+
 // RegisterTool 注册工具
 func (e *Engine) RegisterTool(name string, executor types.ToolExecutor) error {
 	e.mu.Lock()
@@ -202,10 +258,27 @@ func (e *Engine) executeSingleTool(ctx context.Context, call types.ToolCall) typ
 		}
 	}
 
-	// 设置超时
+	// 只读模式下即使模型调用了会产生修改的工具也拒绝执行
+	if ReadOnly(ctx) && !tool.IsReadOnly() {
+		return types.ToolCallResult{
+			Content:   "",
+			Success:   false,
+			Error:     fmt.Sprintf("tool %s modifies files or system state and is not available in read-only plan mode", call.Function.Name),
+			Timestamp: time.Now(),
+		}
+	}
+
+	// 执行前的钩子可以阻止调用
+	blocked, hookOutputs := e.runPreHooks(ctx, call)
+	if blocked != nil {
+		return blockedByHook(blocked)
+	}
+
+	// 设置超时，只作用于工具本身，不包括钩子
+	toolCtx := ctx
 	if timeout, exists := e.timeouts[call.Function.Name]; exists && timeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, timeout)
+		toolCtx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
@@ -213,12 +286,15 @@ func (e *Engine) executeSingleTool(ctx context.Context, call types.ToolCall) typ
 	startTime := time.Now()
 
 	// 执行工具
-	result := tool.Execute(ctx, call)
+	result := tool.Execute(toolCtx, call)
 
 	// 记录执行时间
 	duration := time.Since(startTime)
 	e.logger.Debugf("Tool %s executed in %v,result: %+v", call.Function.Name, duration, result)
 
+	hookOutputs = append(hookOutputs, e.runPostHooks(ctx, call, result)...)
+	appendHookOutputs(result, hookOutputs)
+
 	return *result
 }
 
@@ -50,6 +50,11 @@ func (t *ReadTool) Execute(ctx context.Context, call types.ToolCall) *types.Tool
 		}
 	}
 
+	// 图片以内容片段返回，交给支持视觉的模型查看
+	if utils.DetectImageType([]byte(content)) != "" {
+		return t.readImage(params.FilePath, []byte(content))
+	}
+
 	lines := strings.Split(content, "\n")
 
 	// 处理分页
@@ -81,6 +86,23 @@ func (t *ReadTool) Execute(ctx context.Context, call types.ToolCall) *types.Tool
 	}
 }
 
+// readImage 将图片文件作为图片片段返回
+func (t *ReadTool) readImage(path string, data []byte) *types.ToolCallResult {
+	part, err := utils.ImagePart(data)
+	if err != nil {
+		return &types.ToolCallResult{
+			Success: false,
+			Error:   fmt.Sprintf("failed to read image: %v", err),
+		}
+	}
+
+	return &types.ToolCallResult{
+		Success: true,
+		Content: fmt.Sprintf("Image file %s (%s, %d bytes)", path, part.MediaType, len(data)),
+		Parts:   []types.ContentPart{part},
+	}
+}
+
 func (t *ReadTool) GetDefinition() types.Tool {
 	return types.Tool{
 		Type: "function",
@@ -95,6 +117,7 @@ Usage:
 - You can optionally specify a line offset and limit (especially handy for long files), but it's recommended to read the whole file by not providing these parameters.
 - Any lines longer than 2000 characters will be truncated.
 - Results are returned using cat -n format, with line numbers starting at 1.
+- This tool allows reading images (PNG, JPEG, GIF, WebP). The image contents are presented visually to models that support image input.
 - You have the capability to call multiple tools in a single response. It is always better to speculatively read multiple files as a batch that are potentially useful. 
 - If you read a file that exists but has empty contents you will receive a system reminder warning in place of file contents."`,
 			Parameters: map[string]any{
@@ -123,6 +146,10 @@ func (t *ReadTool) IsConcurrencySafe() bool {
 	return true
 }
 
+func (t *ReadTool) IsReadOnly() bool {
+	return true
+}
+
 // WriteTool 文件写入工具
 type WriteTool struct{}
 
@@ -196,6 +223,10 @@ func (t *WriteTool) IsConcurrencySafe() bool {
 	return false
 }
 
+func (t *WriteTool) IsReadOnly() bool {
+	return false
+}
+
 // EditTool 文件编辑工具
 type EditTool struct{}
 
@@ -317,6 +348,10 @@ func (t *EditTool) IsConcurrencySafe() bool {
 	return false
 }
 
+func (t *EditTool) IsReadOnly() bool {
+	return false
+}
+
 // MultiEditTool 多重编辑工具
 type MultiEditTool struct{}
 
@@ -421,3 +456,7 @@ func (t *MultiEditTool) GetDefinition() types.Tool {
 func (t *MultiEditTool) IsConcurrencySafe() bool {
 	return false
 }
+
+func (t *MultiEditTool) IsReadOnly() bool {
+	return false
+}
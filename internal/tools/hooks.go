@@ -0,0 +1,268 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// 钩子事件
+const (
+	HookPreToolUse  = "pre_tool_use"
+	HookPostToolUse = "post_tool_use"
+)
+
+const (
+	// defaultHookTimeout 钩子命令默认的超时时间
+	defaultHookTimeout = 60 * time.Second
+	// maxHookOutput 追加到工具结果中的钩子输出长度上限
+	maxHookOutput = 10000
+)
+
+// HooksConfig 工具调用前后执行的命令。执行前的钩子以非零状态退出时阻止本次调用，
+// 输出作为原因返回给模型；其他情况下钩子的输出追加到工具结果中
+type HooksConfig struct {
+	PreToolUse  []HookConfig `mapstructure:"pre_tool_use"`
+	PostToolUse []HookConfig `mapstructure:"post_tool_use"`
+}
+
+// HookConfig 单个钩子。命令通过 bash -c 执行，标准输入为包含工具调用的JSON，
+// 环境变量 NALA_TOOL_NAME 和 NALA_FILE_PATH 为工具名和调用中的文件路径
+type HookConfig struct {
+	Tools   []string `mapstructure:"tools"`   // 匹配的工具名，为空时匹配所有工具
+	Files   []string `mapstructure:"files"`   // 匹配文件路径参数的 glob，如 *.go、vendor/**，为空时不限制
+	Command string   `mapstructure:"command"` // 执行的命令
+	Timeout int      `mapstructure:"timeout"` // milliseconds，默认 60000
+}
+
+// hookInput 钩子命令标准输入的内容
+type hookInput struct {
+	Event     string                `json:"event"`
+	SessionID string                `json:"session_id,omitempty"`
+	ToolCall  types.ToolCall        `json:"tool_call"`
+	Result    *types.ToolCallResult `json:"result,omitempty"` // 只在执行后的钩子中提供
+}
+
+// hookOutcome 钩子的执行结果，Err 为命令无法启动或超时等错误
+type hookOutcome struct {
+	Command  string
+	Output   string
+	ExitCode int
+	Err      error
+}
+
+// String 追加到工具结果中的文本
+func (o hookOutcome) String() string {
+	if o.Err != nil {
+		return fmt.Sprintf("Hook `%s` failed: %v\n%s", o.Command, o.Err, o.Output)
+	}
+	return fmt.Sprintf("Hook `%s` (exit code %d):\n%s", o.Command, o.ExitCode, o.Output)
+}
+
+// matches 判断钩子是否匹配工具调用，filePath 为调用中的文件路径
+func (h HookConfig) matches(call types.ToolCall, filePath string) bool {
+	if len(h.Tools) > 0 && !slices.Contains(h.Tools, call.Function.Name) {
+		return false
+	}
+	if len(h.Files) == 0 {
+		return true
+	}
+	if filePath == "" {
+		return false
+	}
+	return slices.ContainsFunc(h.Files, func(pattern string) bool { return matchFileGlob(pattern, filePath) })
+}
+
+// run 执行钩子命令
+func (h HookConfig) run(ctx context.Context, input hookInput, filePath string) hookOutcome {
+	outcome := hookOutcome{Command: h.Command}
+
+	timeout := defaultHookTimeout
+	if h.Timeout > 0 {
+		timeout = time.Duration(h.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		outcome.Err = fmt.Errorf("failed to encode hook input: %w", err)
+		return outcome
+	}
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", h.Command)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = bashWaitDelay
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"NALA_TOOL_NAME="+input.ToolCall.Function.Name,
+		"NALA_FILE_PATH="+filePath,
+	)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	outcome.Output = strings.TrimSpace(output.String())
+	if len(outcome.Output) > maxHookOutput {
+		outcome.Output = outcome.Output[:maxHookOutput] + "\n... (output truncated)"
+	}
+
+	var exitError *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		outcome.Err = fmt.Errorf("timed out after %v", timeout)
+	case errors.As(err, &exitError):
+		outcome.ExitCode = exitError.ExitCode()
+	case err != nil:
+		outcome.Err = err
+	}
+	return outcome
+}
+
+// runPreHooks 执行匹配调用的执行前钩子，返回阻止调用的钩子结果（为空时继续执行）和需要追加到结果中的输出
+func (e *Engine) runPreHooks(ctx context.Context, call types.ToolCall) (*hookOutcome, []hookOutcome) {
+	filePath := hookFilePath(call)
+	input := hookInput{Event: HookPreToolUse, SessionID: SessionID(ctx), ToolCall: call}
+
+	var outputs []hookOutcome
+	for _, hook := range e.hooks.PreToolUse {
+		if !hook.matches(call, filePath) {
+			continue
+		}
+		outcome := hook.run(ctx, input, filePath)
+		// 无法确认是否允许时按阻止处理
+		if outcome.Err != nil || outcome.ExitCode != 0 {
+			e.logger.Infof("Tool %s blocked by hook %q: %s", call.Function.Name, hook.Command, outcome.Output)
+			return &outcome, nil
+		}
+		if outcome.Output != "" {
+			outputs = append(outputs, outcome)
+		}
+	}
+	return nil, outputs
+}
+
+// runPostHooks 对执行成功的调用执行匹配的执行后钩子，返回需要追加到结果中的输出
+func (e *Engine) runPostHooks(ctx context.Context, call types.ToolCall, result *types.ToolCallResult) []hookOutcome {
+	if !result.Success {
+		return nil
+	}
+
+	filePath := hookFilePath(call)
+	input := hookInput{Event: HookPostToolUse, SessionID: SessionID(ctx), ToolCall: call, Result: result}
+
+	var outputs []hookOutcome
+	for _, hook := range e.hooks.PostToolUse {
+		if !hook.matches(call, filePath) {
+			continue
+		}
+		outcome := hook.run(ctx, input, filePath)
+		if outcome.Err != nil {
+			e.logger.Warnf("Hook %q for tool %s failed: %v", hook.Command, call.Function.Name, outcome.Err)
+		}
+		if outcome.Err != nil || outcome.ExitCode != 0 || outcome.Output != "" {
+			outputs = append(outputs, outcome)
+		}
+	}
+	return outputs
+}
+
+// blockedByHook 被执行前钩子阻止的调用结果
+func blockedByHook(outcome *hookOutcome) types.ToolCallResult {
+	reason := outcome.Output
+	if outcome.Err != nil {
+		reason = strings.TrimSpace(fmt.Sprintf("%v\n%s", outcome.Err, outcome.Output))
+	}
+	if reason == "" {
+		reason = fmt.Sprintf("exit code %d", outcome.ExitCode)
+	}
+	return types.ToolCallResult{
+		Success:   false,
+		Error:     fmt.Sprintf("blocked by hook `%s`: %s", outcome.Command, reason),
+		Timestamp: time.Now(),
+	}
+}
+
+// appendHookOutputs 把钩子输出追加到工具结果内容后
+func appendHookOutputs(result *types.ToolCallResult, outputs []hookOutcome) {
+	for _, outcome := range outputs {
+		result.Content = strings.TrimRight(result.Content, "\n") + "\n\n" + outcome.String()
+	}
+}
+
+// hookFilePath 工具调用中的文件路径。路径先规范化，避免 src/../vendor/a.go 这样的写法绕过匹配；
+// 工作目录内的路径转换为相对于工作目录的路径，工作目录外的路径为绝对路径
+func hookFilePath(call types.ToolCall) string {
+	var arguments map[string]any
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &arguments); err != nil {
+		return ""
+	}
+
+	path, _ := arguments["file_path"].(string)
+	if path == "" {
+		path, _ = arguments["path"].(string)
+	}
+	if path == "" {
+		return ""
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	path = filepath.Clean(path)
+
+	if rel, err := filepath.Rel(cwd, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+	return path
+}
+
+// matchFileGlob 匹配文件路径。不含 / 的模式匹配文件名，如 *.go；
+// 含 / 的模式匹配整个路径，* 不跨目录，** 匹配任意层目录，如 vendor/**
+func matchFileGlob(pattern, path string) bool {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	if !strings.Contains(pattern, "/") {
+		matched, _ := filepath.Match(pattern, filepath.Base(path))
+		return matched
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, _ := regexp.MatchString(expr.String(), path)
+	return matched
+}
@@ -0,0 +1,143 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zboya/nala-coder/pkg/log"
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// echoTool 测试用工具，原样返回参数
+type echoTool struct{}
+
+func (t *echoTool) Name() string { return "echo" }
+
+func (t *echoTool) IsConcurrencySafe() bool { return true }
+
+func (t *echoTool) IsReadOnly() bool { return false }
+
+func (t *echoTool) GetDefinition() types.Tool {
+	return types.Tool{Type: "function", Function: types.ToolFunction{Name: "echo"}}
+}
+
+func (t *echoTool) Execute(ctx context.Context, call types.ToolCall) *types.ToolCallResult {
+	return &types.ToolCallResult{Success: true, Content: call.Function.Arguments, Timestamp: time.Now()}
+}
+
+// newHookEngine 创建配置了钩子并注册 echo 工具的引擎
+func newHookEngine(t *testing.T, hooks HooksConfig) *Engine {
+	t.Helper()
+
+	logger, _ := log.New(log.DefaultConfig())
+	engine := NewEngine(&Config{Hooks: hooks}, logger)
+	if err := engine.RegisterTool("echo", &echoTool{}); err != nil {
+		t.Fatalf("RegisterTool error: %v", err)
+	}
+	return engine
+}
+
+func TestPreToolUseHook(t *testing.T) {
+	engine := newHookEngine(t, HooksConfig{PreToolUse: []HookConfig{
+		{Tools: []string{"echo"}, Files: []string{"vendor/**"}, Command: `echo "vendor is read-only" >&2; exit 2`},
+		{Tools: []string{"echo"}, Command: `echo "checked $NALA_TOOL_NAME"`},
+	}})
+
+	results := engine.ExecuteTools(context.Background(), []types.ToolCall{
+		toolCall("echo", `{"file_path":"vendor/github.com/x/y.go"}`),
+		toolCall("echo", `{"file_path":"main.go"}`),
+	})
+
+	if results[0].Success || !strings.Contains(results[0].Error, "blocked by hook") || !strings.Contains(results[0].Error, "vendor is read-only") {
+		t.Errorf("expected call under vendor to be blocked: %+v", results[0])
+	}
+	if !results[1].Success || !strings.Contains(results[1].Content, "checked echo") {
+		t.Errorf("expected call to run with hook output: %+v", results[1])
+	}
+}
+
+func TestPostToolUseHook(t *testing.T) {
+	engine := newHookEngine(t, HooksConfig{PostToolUse: []HookConfig{
+		{Files: []string{"*.go"}, Command: `cat; echo; echo "vet $NALA_FILE_PATH"; exit 1`},
+	}})
+
+	results := engine.ExecuteTools(context.Background(), []types.ToolCall{
+		toolCall("echo", `{"file_path":"internal/tools/engine.go"}`),
+		toolCall("echo", `{"file_path":"README.md"}`),
+	})
+
+	// 钩子从标准输入收到工具调用和结果，输出和退出码追加到结果中
+	content := results[0].Content
+	for _, want := range []string{`"event":"post_tool_use"`, `"name":"echo"`, `"success":true`, "vet internal/tools/engine.go", "exit code 1"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("expected %q in result: %s", want, content)
+		}
+	}
+	if !results[0].Success {
+		t.Errorf("expected post hook not to change the result status")
+	}
+	if strings.Contains(results[1].Content, "Hook") {
+		t.Errorf("expected hook not to match README.md: %s", results[1].Content)
+	}
+}
+
+func TestHookFilePath(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd error: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"main.go", "main.go"},
+		{"./vendor/a.go", filepath.Join("vendor", "a.go")},
+		{"src/../vendor/a.go", filepath.Join("vendor", "a.go")},
+		{filepath.Join(cwd, "src", "..", "vendor", "a.go"), filepath.Join("vendor", "a.go")},
+		{"../outside/a.go", filepath.Join(filepath.Dir(cwd), "outside", "a.go")},
+	}
+	for _, tt := range tests {
+		arguments, _ := json.Marshal(map[string]string{"file_path": tt.path})
+		if got := hookFilePath(toolCall("write", string(arguments))); got != tt.want {
+			t.Errorf("hookFilePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	// 规范化后的路径不能绕过 vendor/** 的钩子
+	engine := newHookEngine(t, HooksConfig{PreToolUse: []HookConfig{
+		{Files: []string{"vendor/**"}, Command: "exit 2"},
+	}})
+	results := engine.ExecuteTools(context.Background(), []types.ToolCall{toolCall("echo", `{"file_path":"src/../vendor/a.go"}`)})
+	if results[0].Success {
+		t.Errorf("expected call under vendor to be blocked: %+v", results[0])
+	}
+}
+
+func TestMatchFileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "internal/tools/hooks.go", true},
+		{"*.go", "README.md", false},
+		{"vendor/**", "vendor/github.com/x/y.go", true},
+		{"vendor/**", "./vendor/a.go", true},
+		{"vendor/**", "internal/vendor/a.go", false},
+		{"**/testdata/*", "internal/tools/testdata/a.txt", true},
+		{"**/testdata/*", "testdata/a.txt", true},
+		{"internal/*.go", "internal/tools/hooks.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchFileGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchFileGlob(%q, %q) = %t, want %t", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
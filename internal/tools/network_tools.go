@@ -319,6 +319,10 @@ func (t *WebSearchTool) IsConcurrencySafe() bool {
 	return true
 }
 
+func (t *WebSearchTool) IsReadOnly() bool {
+	return true
+}
+
 // WebFetchTool 网页内容获取工具
 type WebFetchTool struct{}
 
@@ -481,3 +485,7 @@ func (t *WebFetchTool) GetDefinition() types.Tool {
 func (t *WebFetchTool) IsConcurrencySafe() bool {
 	return true
 }
+
+func (t *WebFetchTool) IsReadOnly() bool {
+	return true
+}
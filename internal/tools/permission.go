@@ -0,0 +1,521 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/zboya/nala-coder/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// permissionsDir 默认存放各项目记住的规则的目录，位于用户目录下，不受工作区中的文件控制
+const permissionsDir = ".nala-coder/projects"
+
+// PermissionMode 工具调用的权限模式
+type PermissionMode string
+
+const (
+	PermissionAllow PermissionMode = "allow" // 直接执行
+	PermissionAsk   PermissionMode = "ask"   // 执行前请求用户确认
+	PermissionDeny  PermissionMode = "deny"  // 拒绝执行
+)
+
+// PermissionConfig 工具权限配置。规则写作 tool 或 tool(pattern)，
+// pattern 匹配工具的主要参数（bash 的命令、文件工具的路径、web_fetch 的URL等），* 匹配任意字符
+type PermissionConfig struct {
+	Default     PermissionMode `mapstructure:"default"` // 没有规则匹配时的模式，默认 allow
+	Allow       []string       `mapstructure:"allow"`
+	Ask         []string       `mapstructure:"ask"`
+	Deny        []string       `mapstructure:"deny"`
+	ProjectFile string         `mapstructure:"project_file"` // 记住的项目级规则文件，默认为用户目录下按工作目录区分的文件
+}
+
+// PermissionRule 一条权限规则，Pattern 为空时匹配工具的所有调用
+type PermissionRule struct {
+	Tool    string
+	Pattern string
+}
+
+var (
+	// permissionRulePattern 规则语法 tool(pattern)
+	permissionRulePattern = regexp.MustCompile(`^([A-Za-z0-9_\-]+)(?:\((.*)\))?$`)
+	// subcommandPattern 可以作为规则前缀一部分的子命令，如 go test、git status
+	subcommandPattern = regexp.MustCompile(`^[a-z][a-z0-9_\-]*$`)
+	// shellOperatorPattern 分隔组合命令的控制操作符
+	shellOperatorPattern = regexp.MustCompile(`&&|\|\||[;&|\n]`)
+	// shellSubstitutionPattern 命令替换、进程替换和子shell的边界，其中的命令同样会执行
+	shellSubstitutionPattern = regexp.MustCompile("\\$\\(|[<>]\\(|[()`]")
+	// shellAssignmentPattern 命令前的环境变量赋值，如 FOO=1 make
+	shellAssignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+var (
+	// shellKeywords 命令前可以跳过的shell关键字
+	shellKeywords = map[string]bool{
+		"!": true, "{": true, "}": true, "if": true, "then": true, "else": true, "elif": true, "fi": true,
+		"while": true, "until": true, "do": true, "done": true, "esac": true,
+	}
+	// shellWrappers 执行其后命令的包装命令，跳过后检查被执行的命令
+	shellWrappers = map[string]bool{
+		"sudo": true, "env": true, "command": true, "exec": true, "nohup": true, "time": true, "nice": true, "xargs": true,
+	}
+	// shellOpaque 执行的命令在参数或脚本中，无法按命令名判断的写法
+	shellOpaque = map[string]bool{
+		"eval": true, "sh": true, "bash": true, "zsh": true, "dash": true, "source": true, ".": true,
+		"for": true, "case": true, "select": true, "function": true,
+	}
+)
+
+// shellMetacharacters 组合命令、命令替换、变量展开和重定向，包含这些字符的命令不能按前缀判断效果
+const shellMetacharacters = "&|;><`$\n"
+
+// ParsePermissionRule 解析 tool 或 tool(pattern) 形式的规则
+func ParsePermissionRule(rule string) (PermissionRule, error) {
+	matches := permissionRulePattern.FindStringSubmatch(strings.TrimSpace(rule))
+	if matches == nil {
+		return PermissionRule{}, fmt.Errorf("invalid permission rule %q, expected tool or tool(pattern)", rule)
+	}
+	return PermissionRule{Tool: matches[1], Pattern: matches[2]}, nil
+}
+
+// String 规则的文本形式
+func (r PermissionRule) String() string {
+	if r.Pattern == "" {
+		return r.Tool
+	}
+	return fmt.Sprintf("%s(%s)", r.Tool, r.Pattern)
+}
+
+// Matches 判断规则是否匹配工具调用，deny 和 ask 规则按此匹配
+func (r PermissionRule) Matches(call types.ToolCall) bool {
+	if r.Tool != call.Function.Name {
+		return false
+	}
+	if r.Pattern == "" {
+		return true
+	}
+	return matchWildcard(r.Pattern, strings.TrimSpace(PermissionSubject(call)))
+}
+
+// Allows 判断放行规则是否匹配工具调用。带通配符的 bash 规则不放行组合命令和含替换、展开或重定向的命令，
+// 否则 bash(go test *) 会放行 go test ./... && rm -rf ~
+func (r PermissionRule) Allows(call types.ToolCall) bool {
+	if r.Tool == "bash" && strings.Contains(r.Pattern, "*") && strings.ContainsAny(strings.TrimSpace(PermissionSubject(call)), shellMetacharacters) {
+		return false
+	}
+	return r.Matches(call)
+}
+
+// matchWildcard 通配符匹配，* 匹配包括空格和路径分隔符在内的任意字符
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	matched, _ := regexp.MatchString("^"+strings.Join(parts, ".*")+"$", s)
+	return matched
+}
+
+// permissionSubjectArguments 各工具中规则 pattern 匹配的参数
+var permissionSubjectArguments = map[string]string{
+	"bash":       "command",
+	"read":       "file_path",
+	"write":      "file_path",
+	"edit":       "file_path",
+	"multi_edit": "file_path",
+	"web_fetch":  "url",
+	"web_search": "query",
+	"glob":       "pattern",
+	"grep":       "query",
+	"ls":         "path",
+}
+
+// PermissionSubject 工具调用中规则 pattern 匹配的内容，未知工具使用完整的参数JSON
+func PermissionSubject(call types.ToolCall) string {
+	if name, ok := permissionSubjectArguments[call.Function.Name]; ok {
+		var arguments map[string]any
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &arguments); err == nil {
+			if value, ok := arguments[name].(string); ok {
+				return value
+			}
+		}
+	}
+	return call.Function.Arguments
+}
+
+// SuggestPermissionRule 用户选择记住确认结果时添加的规则。bash 命令按命令和子命令放行，
+// 组合命令只放行完全相同的命令；web_fetch 按站点放行；其他工具放行所有调用
+func SuggestPermissionRule(call types.ToolCall) PermissionRule {
+	rule := PermissionRule{Tool: call.Function.Name}
+	subject := strings.TrimSpace(PermissionSubject(call))
+
+	switch call.Function.Name {
+	case "bash":
+		if strings.ContainsAny(subject, shellMetacharacters) {
+			rule.Pattern = subject
+			break
+		}
+		fields := strings.Fields(subject)
+		if len(fields) == 0 {
+			break
+		}
+		prefix := fields[0]
+		if len(fields) > 1 && subcommandPattern.MatchString(fields[1]) {
+			prefix += " " + fields[1]
+		}
+		rule.Pattern = prefix + " *"
+	case "web_fetch":
+		if u, err := url.Parse(subject); err == nil && u.Host != "" {
+			rule.Pattern = fmt.Sprintf("%s://%s/*", u.Scheme, u.Host)
+		}
+	}
+	return rule
+}
+
+// PermissionDecision 权限检查结果，Rule 为匹配的规则，按默认模式决定时为空
+type PermissionDecision struct {
+	Mode PermissionMode
+	Rule string
+}
+
+// PermissionPolicy 工具权限策略。检查顺序：deny 规则、带 pattern 的 ask/allow 规则、
+// 只有工具名的 ask/allow 规则、记住的规则、默认模式。更具体的规则优先，例如 ask bash 同时 allow bash(go test *)；
+// 记住的规则只放行配置中没有规则匹配的调用，不能绕过配置的 ask
+type PermissionPolicy struct {
+	defaultMode PermissionMode
+	allow       []PermissionRule
+	ask         []PermissionRule
+	deny        []PermissionRule
+	projectFile string
+
+	mu      sync.RWMutex
+	project []PermissionRule
+}
+
+// projectPermissions 项目级规则文件内容
+type projectPermissions struct {
+	Allow []string `yaml:"allow"`
+}
+
+// NewPermissionPolicy 根据配置创建权限策略，并加载记住的项目级规则
+func NewPermissionPolicy(config PermissionConfig) (*PermissionPolicy, error) {
+	policy := &PermissionPolicy{
+		defaultMode: config.Default,
+		projectFile: config.ProjectFile,
+	}
+	if policy.defaultMode == "" {
+		policy.defaultMode = PermissionAllow
+	}
+	switch policy.defaultMode {
+	case PermissionAllow, PermissionAsk, PermissionDeny:
+	default:
+		return nil, fmt.Errorf("invalid default permission mode %q", config.Default)
+	}
+	if policy.projectFile == "" {
+		file, err := defaultPermissionsFile()
+		if err != nil {
+			return nil, err
+		}
+		policy.projectFile = file
+	}
+
+	var err error
+	if policy.allow, err = parsePermissionRules(config.Allow); err != nil {
+		return nil, err
+	}
+	if policy.ask, err = parsePermissionRules(config.Ask); err != nil {
+		return nil, err
+	}
+	if policy.deny, err = parsePermissionRules(config.Deny); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(policy.projectFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read permissions file: %w", err)
+	}
+	if err == nil {
+		var saved projectPermissions
+		if err := yaml.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("failed to parse permissions file %s: %w", policy.projectFile, err)
+		}
+		if policy.project, err = parsePermissionRules(saved.Allow); err != nil {
+			return nil, fmt.Errorf("permissions file %s: %w", policy.projectFile, err)
+		}
+	}
+
+	return policy, nil
+}
+
+// defaultPermissionsFile 当前工作目录默认的项目级规则文件，如 ~/.nala-coder/projects/-home-user-repo/permissions.yaml
+func defaultPermissionsFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	project := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '-'
+		}
+		return r
+	}, cwd)
+	return filepath.Join(home, permissionsDir, project, "permissions.yaml"), nil
+}
+
+// parsePermissionRules 解析规则列表
+func parsePermissionRules(rules []string) ([]PermissionRule, error) {
+	parsed := make([]PermissionRule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := ParsePermissionRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// Check 检查工具调用的权限，remembered 为会话中记住的放行规则。
+// bash 命令按控制操作符拆分后逐个部分检查，取最严格的结果
+func (p *PermissionPolicy) Check(call types.ToolCall, remembered []PermissionRule) PermissionDecision {
+	if call.Function.Name != "bash" {
+		return p.check(call, remembered)
+	}
+
+	var strictest PermissionDecision
+	configuredAsk := false
+	for _, part := range splitShellCommand(PermissionSubject(call)) {
+		decision := p.checkShellPart(call, part, remembered)
+		if strictest.Mode == "" || permissionSeverity[decision.Mode] > permissionSeverity[strictest.Mode] {
+			strictest = decision
+		}
+		if decision.Mode == PermissionAsk && decision.Rule != "" {
+			configuredAsk = true
+		}
+	}
+	if strictest.Mode == "" {
+		return p.check(call, remembered)
+	}
+
+	// 完全相同的命令被明确放行过（记住的确认结果），不再询问；
+	// 被拒绝或被配置的 ask 规则匹配的部分仍然拒绝或询问
+	if strictest.Mode == PermissionAsk && !configuredAsk {
+		if whole := p.check(call, remembered); whole.Mode == PermissionAllow {
+			if rule, err := ParsePermissionRule(whole.Rule); err == nil && rule.Pattern != "" {
+				return whole
+			}
+		}
+	}
+	return strictest
+}
+
+// permissionSeverity 合并组合命令各部分的结果时各模式的严格程度
+var permissionSeverity = map[PermissionMode]int{
+	PermissionAllow: 0,
+	PermissionAsk:   1,
+	PermissionDeny:  2,
+}
+
+// checkShellPart 检查组合命令中的一部分。deny 和 ask 规则还匹配其中命令替换里的命令，
+// 以及去掉环境变量、包装命令和路径后的命令；无法确定执行的命令时不按默认模式放行
+func (p *PermissionPolicy) checkShellPart(call types.ToolCall, part string, remembered []PermissionRule) PermissionDecision {
+	decision := p.check(withCommand(call, part), remembered)
+
+	determinable := true
+	for _, segment := range shellSegments(part) {
+		candidates := []string{segment}
+		command, ok := leadingCommand(segment)
+		if !ok {
+			determinable = false
+		} else if command != "" && command != segment {
+			candidates = append(candidates, command)
+		}
+
+		for _, candidate := range candidates {
+			if candidate == part {
+				continue
+			}
+			if restricted := p.checkRestricted(withCommand(call, candidate)); permissionSeverity[restricted.Mode] > permissionSeverity[decision.Mode] {
+				decision = restricted
+			}
+		}
+	}
+
+	if !determinable && decision.Mode == PermissionAllow && decision.Rule == "" {
+		return PermissionDecision{Mode: PermissionAsk}
+	}
+	return decision
+}
+
+// checkRestricted 只按 deny 规则和带 pattern 的 ask 规则检查，没有匹配时为 allow
+func (p *PermissionPolicy) checkRestricted(call types.ToolCall) PermissionDecision {
+	if rule, ok := firstMatch(p.deny, call, nil); ok {
+		return PermissionDecision{Mode: PermissionDeny, Rule: rule.String()}
+	}
+	if rule, ok := firstMatch(p.ask, call, func(r PermissionRule) bool { return r.Pattern != "" }); ok {
+		return PermissionDecision{Mode: PermissionAsk, Rule: rule.String()}
+	}
+	return PermissionDecision{Mode: PermissionAllow}
+}
+
+// withCommand 返回执行指定命令的 bash 调用
+func withCommand(call types.ToolCall, command string) types.ToolCall {
+	arguments, _ := json.Marshal(map[string]string{"command": command})
+	call.Function.Arguments = string(arguments)
+	return call
+}
+
+// splitShellCommand 按控制操作符拆分组合命令
+func splitShellCommand(command string) []string {
+	var parts []string
+	for _, part := range shellOperatorPattern.Split(command, -1) {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// shellSegments 按命令替换和子shell的边界拆分命令，如 echo $(rm -rf ~) 拆为 echo 和 rm -rf ~
+func shellSegments(part string) []string {
+	var segments []string
+	for _, segment := range shellSubstitutionPattern.Split(part, -1) {
+		if segment = strings.Trim(segment, " \t\"'"); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// leadingCommand 去掉命令前的环境变量赋值、关键字、包装命令和命令名中的路径，返回实际执行的命令，
+// 如 FOO=1 sudo /bin/rm -rf x 返回 rm -rf x。命令名来自变量展开、需要解释参数（eval、sh -c）
+// 或包装命令带有选项时无法确定，返回 false；只有关键字时返回空字符串
+func leadingCommand(segment string) (string, bool) {
+	fields := strings.Fields(segment)
+	for len(fields) > 0 {
+		word := fields[0]
+		if shellAssignmentPattern.MatchString(word) || shellKeywords[word] {
+			fields = fields[1:]
+			continue
+		}
+		if !shellWrappers[word] {
+			break
+		}
+		fields = fields[1:]
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			return "", false
+		}
+	}
+	if len(fields) == 0 {
+		return "", true
+	}
+
+	name := fields[0]
+	// 紧跟命令名的重定向，如 rm>/dev/null
+	if i := strings.IndexAny(name, "<>"); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" || strings.ContainsAny(name, "$`'\"\\(){}") || shellOpaque[name] || shellOpaque[path.Base(name)] {
+		return "", false
+	}
+	fields[0] = path.Base(name)
+	return strings.Join(fields, " "), true
+}
+
+// check 按规则检查单个工具调用的权限
+func (p *PermissionPolicy) check(call types.ToolCall, remembered []PermissionRule) PermissionDecision {
+	if rule, ok := firstMatch(p.deny, call, nil); ok {
+		return PermissionDecision{Mode: PermissionDeny, Rule: rule.String()}
+	}
+
+	for _, specific := range []bool{true, false} {
+		filter := func(r PermissionRule) bool { return (r.Pattern != "") == specific }
+		if rule, ok := firstMatch(p.ask, call, filter); ok {
+			return PermissionDecision{Mode: PermissionAsk, Rule: rule.String()}
+		}
+		if rule, ok := firstAllow(p.allow, call, filter); ok {
+			return PermissionDecision{Mode: PermissionAllow, Rule: rule.String()}
+		}
+	}
+
+	p.mu.RLock()
+	project := p.project
+	p.mu.RUnlock()
+	if rule, ok := firstAllow(remembered, call, nil); ok {
+		return PermissionDecision{Mode: PermissionAllow, Rule: rule.String()}
+	}
+	if rule, ok := firstAllow(project, call, nil); ok {
+		return PermissionDecision{Mode: PermissionAllow, Rule: rule.String()}
+	}
+
+	return PermissionDecision{Mode: p.defaultMode}
+}
+
+// firstMatch 返回第一条匹配调用的 deny 或 ask 规则，filter 不为空时只考虑满足条件的规则
+func firstMatch(rules []PermissionRule, call types.ToolCall, filter func(PermissionRule) bool) (PermissionRule, bool) {
+	for _, rule := range rules {
+		if filter != nil && !filter(rule) {
+			continue
+		}
+		if rule.Matches(call) {
+			return rule, true
+		}
+	}
+	return PermissionRule{}, false
+}
+
+// firstAllow 返回第一条放行调用的规则，filter 不为空时只考虑满足条件的规则
+func firstAllow(rules []PermissionRule, call types.ToolCall, filter func(PermissionRule) bool) (PermissionRule, bool) {
+	for _, rule := range rules {
+		if filter != nil && !filter(rule) {
+			continue
+		}
+		if rule.Allows(call) {
+			return rule, true
+		}
+	}
+	return PermissionRule{}, false
+}
+
+// RememberForProject 记住项目级放行规则并写入规则文件
+func (p *PermissionPolicy) RememberForProject(rule PermissionRule) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, existing := range p.project {
+		if existing == rule {
+			return nil
+		}
+	}
+	project := append(append([]PermissionRule{}, p.project...), rule)
+
+	saved := projectPermissions{Allow: make([]string, len(project))}
+	for i, r := range project {
+		saved.Allow[i] = r.String()
+	}
+	data, err := yaml.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("failed to encode permissions: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(p.projectFile), 0755); err != nil {
+		return fmt.Errorf("failed to create permissions directory: %w", err)
+	}
+	if err := os.WriteFile(p.projectFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write permissions file: %w", err)
+	}
+
+	p.project = project
+	return nil
+}
@@ -0,0 +1,208 @@
+package tools
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// toolCall 构造测试用工具调用
+func toolCall(name, arguments string) types.ToolCall {
+	return types.ToolCall{ID: "call", Type: "function", Function: types.ToolCallFunction{Name: name, Arguments: arguments}}
+}
+
+func TestPermissionPolicyCheck(t *testing.T) {
+	policy, err := NewPermissionPolicy(PermissionConfig{
+		Default:     PermissionAsk,
+		Allow:       []string{"read", "bash(go test *)"},
+		Ask:         []string{"bash", "bash(rm *)"},
+		Deny:        []string{"bash(sudo *)", "write(/etc/*)"},
+		ProjectFile: filepath.Join(t.TempDir(), "permissions.yaml"),
+	})
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+
+	tests := []struct {
+		call types.ToolCall
+		mode PermissionMode
+		rule string
+	}{
+		{toolCall("read", `{"file_path":"main.go"}`), PermissionAllow, "read"},
+		{toolCall("bash", `{"command":"go test ./..."}`), PermissionAllow, "bash(go test *)"},
+		{toolCall("bash", `{"command":"rm -rf build"}`), PermissionAsk, "bash(rm *)"},
+		{toolCall("bash", `{"command":"ls"}`), PermissionAsk, "bash"},
+		{toolCall("bash", `{"command":"sudo go test ./..."}`), PermissionDeny, "bash(sudo *)"},
+		{toolCall("write", `{"file_path":"/etc/hosts","content":""}`), PermissionDeny, "write(/etc/*)"},
+		{toolCall("write", `{"file_path":"main.go","content":""}`), PermissionAsk, ""},
+		// 组合命令逐个部分检查，通配符规则不能放行整个命令
+		{toolCall("bash", `{"command":"go test ./... && rm -rf ~"}`), PermissionAsk, "bash(rm *)"},
+		{toolCall("bash", `{"command":"go test ./...\nrm -rf ~"}`), PermissionAsk, "bash(rm *)"},
+		{toolCall("bash", `{"command":"go test ./... | sudo tee /etc/hosts"}`), PermissionDeny, "bash(sudo *)"},
+		{toolCall("bash", `{"command":"go test ./... && go test ./cmd/"}`), PermissionAllow, "bash(go test *)"},
+		{toolCall("bash", `{"command":"go test $(rm -rf ~)"}`), PermissionAsk, "bash"},
+		{toolCall("bash", `{"command":"go test ./... > ~/.bashrc"}`), PermissionAsk, "bash"},
+	}
+	for _, tt := range tests {
+		decision := policy.Check(tt.call, nil)
+		if decision.Mode != tt.mode || decision.Rule != tt.rule {
+			t.Errorf("Check(%s %s) = %+v, want %s %s", tt.call.Function.Name, tt.call.Function.Arguments, decision, tt.mode, tt.rule)
+		}
+	}
+
+	// 记住的规则不能绕过配置的 ask 和 deny
+	remembered := []PermissionRule{{Tool: "bash", Pattern: "rm *"}, {Tool: "bash", Pattern: "sudo *"}, {Tool: "write"}}
+	if decision := policy.Check(toolCall("bash", `{"command":"rm -rf build"}`), remembered); decision.Mode != PermissionAsk {
+		t.Errorf("expected configured ask to win over remembered rule, got %+v", decision)
+	}
+	if decision := policy.Check(toolCall("bash", `{"command":"sudo ls"}`), remembered); decision.Mode != PermissionDeny {
+		t.Errorf("expected deny to win over remembered rule, got %+v", decision)
+	}
+	// 没有配置规则匹配时，记住的规则代替默认模式
+	if decision := policy.Check(toolCall("write", `{"file_path":"main.go","content":""}`), remembered); decision.Mode != PermissionAllow {
+		t.Errorf("expected remembered rule to allow, got %+v", decision)
+	}
+
+	// 记住的完全相同的组合命令不再询问，除非其中部分被配置的 ask 规则匹配
+	policy, err = NewPermissionPolicy(PermissionConfig{
+		Default:     PermissionAsk,
+		Ask:         []string{"bash(rm *)"},
+		ProjectFile: filepath.Join(t.TempDir(), "permissions.yaml"),
+	})
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+	remembered = []PermissionRule{{Tool: "bash", Pattern: "make && ls"}, {Tool: "bash", Pattern: "make && rm -rf build"}}
+	if decision := policy.Check(toolCall("bash", `{"command":"make && ls"}`), remembered); decision.Mode != PermissionAllow {
+		t.Errorf("expected remembered compound command to allow, got %+v", decision)
+	}
+	if decision := policy.Check(toolCall("bash", `{"command":"make && rm -rf build"}`), remembered); decision.Mode != PermissionAsk {
+		t.Errorf("expected configured ask to win over remembered compound command, got %+v", decision)
+	}
+}
+
+func TestPermissionPolicyShellBypass(t *testing.T) {
+	policy, err := NewPermissionPolicy(PermissionConfig{
+		Default:     PermissionAllow,
+		Allow:       []string{"bash(go test *)"},
+		Ask:         []string{"bash(git push *)"},
+		Deny:        []string{"bash(rm *)"},
+		ProjectFile: filepath.Join(t.TempDir(), "permissions.yaml"),
+	})
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+
+	// 含变量展开、重定向、命令替换或写法不同的命令同样受 deny 和 ask 规则约束
+	tests := []struct {
+		command string
+		mode    PermissionMode
+		rule    string
+	}{
+		{"rm -rf $HOME", PermissionDeny, "bash(rm *)"},
+		{"rm -rf x > /dev/null", PermissionDeny, "bash(rm *)"},
+		{"cd /; rm -rf x 2>/dev/null", PermissionDeny, "bash(rm *)"},
+		{"  rm -rf x", PermissionDeny, "bash(rm *)"},
+		{"rm>/dev/null -rf x", PermissionDeny, "bash(rm *)"},
+		{"/bin/rm -rf x", PermissionDeny, "bash(rm *)"},
+		{"FOO=1 sudo rm -rf x", PermissionDeny, "bash(rm *)"},
+		{"echo $(rm -rf x)", PermissionDeny, "bash(rm *)"},
+		{"echo `rm -rf x`", PermissionDeny, "bash(rm *)"},
+		{"(cd build && rm -rf x)", PermissionDeny, "bash(rm *)"},
+		{"git push origin main > /dev/null", PermissionAsk, "bash(git push *)"},
+		{"go test ./... $(git push origin main)", PermissionAsk, "bash(git push *)"},
+		// 无法确定执行的命令时不按默认模式放行
+		{"$CMD -rf x", PermissionAsk, ""},
+		{`sh -c "rm -rf x"`, PermissionAsk, ""},
+		{`eval "$CMD"`, PermissionAsk, ""},
+		{"sudo -u root make clean", PermissionAsk, ""},
+		// 普通命令仍按规则和默认模式放行
+		{"go test ./... > test.log", PermissionAllow, ""},
+		{"ls -la build", PermissionAllow, ""},
+		{"  go test ./...", PermissionAllow, "bash(go test *)"},
+		{`git commit -m "rm old files"`, PermissionAllow, ""},
+	}
+	for _, tt := range tests {
+		arguments, _ := json.Marshal(map[string]string{"command": tt.command})
+		decision := policy.Check(toolCall("bash", string(arguments)), nil)
+		if decision.Mode != tt.mode || decision.Rule != tt.rule {
+			t.Errorf("Check(%q) = %+v, want %s %s", tt.command, decision, tt.mode, tt.rule)
+		}
+	}
+
+	// 记住的完全相同的命令可以放行无法确定的命令
+	remembered := []PermissionRule{{Tool: "bash", Pattern: `sh -c "make lint"`}}
+	if decision := policy.Check(toolCall("bash", `{"command":"sh -c \"make lint\""}`), remembered); decision.Mode != PermissionAllow {
+		t.Errorf("expected remembered command to allow, got %+v", decision)
+	}
+}
+
+func TestRememberForProject(t *testing.T) {
+	projectFile := filepath.Join(t.TempDir(), ".nala-coder", "permissions.yaml")
+	config := PermissionConfig{Default: PermissionAsk, ProjectFile: projectFile}
+
+	policy, err := NewPermissionPolicy(config)
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+	call := toolCall("bash", `{"command":"go vet ./internal/..."}`)
+	rule := SuggestPermissionRule(call)
+	if rule.String() != "bash(go vet *)" {
+		t.Fatalf("unexpected suggested rule: %s", rule)
+	}
+	if err := policy.RememberForProject(rule); err != nil {
+		t.Fatalf("RememberForProject error: %v", err)
+	}
+	if err := policy.RememberForProject(rule); err != nil {
+		t.Fatalf("RememberForProject error: %v", err)
+	}
+
+	data, err := os.ReadFile(projectFile)
+	if err != nil || strings.Count(string(data), "bash(go vet *)") != 1 {
+		t.Fatalf("unexpected permissions file: %q, %v", data, err)
+	}
+
+	// 新的策略加载文件中记住的规则
+	reloaded, err := NewPermissionPolicy(config)
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+	if decision := reloaded.Check(call, nil); decision.Mode != PermissionAllow {
+		t.Errorf("expected project rule to allow, got %+v", decision)
+	}
+}
+
+func TestDefaultPermissionsFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// 默认的项目级规则文件在用户目录下，工作区中的文件不能预先放行工具调用
+	policy, err := NewPermissionPolicy(PermissionConfig{})
+	if err != nil {
+		t.Fatalf("NewPermissionPolicy error: %v", err)
+	}
+	if !strings.HasPrefix(policy.projectFile, filepath.Join(home, permissionsDir)+string(filepath.Separator)) {
+		t.Errorf("expected permissions file under home directory, got %s", policy.projectFile)
+	}
+}
+
+func TestSuggestPermissionRule(t *testing.T) {
+	tests := []struct {
+		call types.ToolCall
+		want string
+	}{
+		{toolCall("bash", `{"command":"ls -la"}`), "bash(ls *)"},
+		{toolCall("bash", `{"command":"make && rm -rf /"}`), "bash(make && rm -rf /)"},
+		{toolCall("web_fetch", `{"url":"https://go.dev/doc/effective_go"}`), "web_fetch(https://go.dev/*)"},
+		{toolCall("write", `{"file_path":"main.go"}`), "write"},
+	}
+	for _, tt := range tests {
+		if got := SuggestPermissionRule(tt.call).String(); got != tt.want {
+			t.Errorf("SuggestPermissionRule(%s) = %s, want %s", tt.call.Function.Arguments, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package tools
+
+import "os/exec"
+
+// killProcessGroup 不支持进程组的平台上取消时只结束命令本身
+func killProcessGroup(cmd *exec.Cmd) {}
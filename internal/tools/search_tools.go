@@ -146,6 +146,10 @@ func (t *GlobTool) IsConcurrencySafe() bool {
 	return true
 }
 
+func (t *GlobTool) IsReadOnly() bool {
+	return true
+}
+
 // GrepTool 内容搜索工具
 type GrepTool struct{}
 
@@ -261,6 +265,10 @@ func (t *GrepTool) IsConcurrencySafe() bool {
 	return true
 }
 
+func (t *GrepTool) IsReadOnly() bool {
+	return true
+}
+
 // LSTool 目录列举工具
 type LSTool struct{}
 
@@ -391,3 +399,7 @@ func (t *LSTool) GetDefinition() types.Tool {
 func (t *LSTool) IsConcurrencySafe() bool {
 	return true
 }
+
+func (t *LSTool) IsReadOnly() bool {
+	return true
+}
@@ -17,6 +17,9 @@ func init() {
 	registerBuiltinTool("bash", &BashTool{})
 }
 
+// bashWaitDelay 命令被取消后等待输出管道关闭的最长时间
+const bashWaitDelay = 2 * time.Second
+
 // BashTool 系统命令执行工具
 type BashTool struct{}
 
@@ -78,6 +81,10 @@ func (t *BashTool) Execute(ctx context.Context, call types.ToolCall) *types.Tool
 		cmd = exec.CommandContext(cmdCtx, parts[0], parts[1:]...)
 	}
 
+	// 取消时结束命令派生的所有子进程，子进程仍占用输出管道时最多再等待 waitDelay
+	killProcessGroup(cmd)
+	cmd.WaitDelay = bashWaitDelay
+
 	// 设置工作目录
 	cwd, err := os.Getwd()
 	if err == nil {
@@ -109,6 +116,9 @@ func (t *BashTool) Execute(ctx context.Context, call types.ToolCall) *types.Tool
 		if cmdCtx.Err() == context.DeadlineExceeded {
 			result.WriteString("Status: TIMEOUT\n")
 			result.WriteString(fmt.Sprintf("Error: Command timed out after %d ms\n", timeout))
+		} else if cmdCtx.Err() == context.Canceled {
+			result.WriteString("Status: CANCELLED\n")
+			result.WriteString("Error: Command was interrupted by the user\n")
 		} else {
 			result.WriteString("Status: FAILED\n")
 			if exitError, ok := err.(*exec.ExitError); ok {
@@ -178,3 +188,7 @@ func (t *BashTool) GetDefinition() types.Tool {
 func (t *BashTool) IsConcurrencySafe() bool {
 	return false // 命令执行可能有副作用
 }
+
+func (t *BashTool) IsReadOnly() bool {
+	return false // 命令可能修改文件和系统状态
+}
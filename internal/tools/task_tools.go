@@ -218,6 +218,10 @@ func (t *TodoReadTool) IsConcurrencySafe() bool {
 	return true
 }
 
+func (t *TodoReadTool) IsReadOnly() bool {
+	return true
+}
+
 // TodoWriteTool 任务写入工具
 type TodoWriteTool struct{}
 
@@ -381,3 +385,7 @@ func (t *TodoWriteTool) GetDefinition() types.Tool {
 func (t *TodoWriteTool) IsConcurrencySafe() bool {
 	return false
 }
+
+func (t *TodoWriteTool) IsReadOnly() bool {
+	return true // 只修改任务列表，不修改文件
+}
@@ -0,0 +1,174 @@
+package tokenizer
+
+import (
+	"math"
+	"unicode/utf8"
+)
+
+const (
+	// 超过该长度的文本（通常是大段工具输出）按采样窗口估算
+	sampleThreshold  = 64 << 10
+	sampleWindows    = 16
+	sampleWindowSize = 2 << 10
+
+	// 超长片段分块合并，避免合并算法的平方复杂度
+	maxPieceBytes = 512
+)
+
+// Encoding BPE词表，与 tiktoken 的合并规则一致
+type Encoding struct {
+	name  string
+	ranks map[string]int
+	split func(text string, yield func(piece string))
+}
+
+// NewEncoding 使用词表和预分词规则创建编码，pattern 为 CL100K 或 O200K
+func NewEncoding(name string, ranks map[string]int, pattern string) *Encoding {
+	split := splitCL100K
+	if pattern == O200K {
+		split = splitO200K
+	}
+	return &Encoding{name: name, ranks: ranks, split: split}
+}
+
+// Name 词表名称
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Count 计算token数，超长文本按均匀采样估算
+func (e *Encoding) Count(text string) int {
+	if len(text) <= sampleThreshold {
+		return e.count(text)
+	}
+	return e.estimate(text)
+}
+
+// Encode 编码为token序列
+func (e *Encoding) Encode(text string) []int {
+	tokens := make([]int, 0, len(text)/3)
+	e.split(text, func(piece string) {
+		if rank, ok := e.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			return
+		}
+		bounds := e.bytePairMerge(piece)
+		for i := 0; i < len(bounds)-1; i++ {
+			tokens = append(tokens, e.ranks[piece[bounds[i]:bounds[i+1]]])
+		}
+	})
+	return tokens
+}
+
+// count 精确计数
+func (e *Encoding) count(text string) int {
+	total := 0
+	e.split(text, func(piece string) {
+		total += e.countPiece(piece)
+	})
+	return total
+}
+
+// countPiece 计算单个预分词片段的token数
+func (e *Encoding) countPiece(piece string) int {
+	if _, ok := e.ranks[piece]; ok {
+		return 1
+	}
+
+	if len(piece) <= maxPieceBytes {
+		return len(e.bytePairMerge(piece)) - 1
+	}
+
+	total := 0
+	for len(piece) > 0 {
+		end := runeBoundary(piece, maxPieceBytes)
+		total += len(e.bytePairMerge(piece[:end])) - 1
+		piece = piece[end:]
+	}
+	return total
+}
+
+// estimate 在文本中均匀取多个窗口精确计数，按字节比例推算总数
+func (e *Encoding) estimate(text string) int {
+	step := len(text) / sampleWindows
+	sampledBytes, sampledTokens := 0, 0
+
+	for i := 0; i < sampleWindows; i++ {
+		start := runeBoundary(text, i*step)
+		end := start + runeBoundary(text[start:], sampleWindowSize)
+		sampledBytes += end - start
+		sampledTokens += e.count(text[start:end])
+	}
+
+	if sampledBytes == 0 {
+		return e.count(text)
+	}
+	return int(int64(sampledTokens) * int64(len(text)) / int64(sampledBytes))
+}
+
+// bytePairMerge 按词表优先级反复合并相邻片段，返回最终各token的起始位置（含末尾）
+func (e *Encoding) bytePairMerge(piece string) []int {
+	type part struct {
+		start int
+		rank  int
+	}
+
+	parts := make([]part, len(piece)+1)
+	for i := range parts {
+		parts[i] = part{start: i, rank: math.MaxInt}
+	}
+
+	// rankAt 合并 parts[i] 和 parts[i+1] 后的优先级
+	rankAt := func(i int) int {
+		if i+2 < len(parts) {
+			if rank, ok := e.ranks[piece[parts[i].start:parts[i+2].start]]; ok {
+				return rank
+			}
+		}
+		return math.MaxInt
+	}
+
+	for i := 0; i < len(parts)-2; i++ {
+		parts[i].rank = rankAt(i)
+	}
+
+	for len(parts) > 1 {
+		minRank, minIndex := math.MaxInt, -1
+		for i := 0; i < len(parts)-1; i++ {
+			if parts[i].rank < minRank {
+				minRank, minIndex = parts[i].rank, i
+			}
+		}
+		if minIndex < 0 {
+			break
+		}
+
+		parts = append(parts[:minIndex+1], parts[minIndex+2:]...)
+		parts[minIndex].rank = rankAt(minIndex)
+		if minIndex > 0 {
+			parts[minIndex-1].rank = rankAt(minIndex - 1)
+		}
+	}
+
+	bounds := make([]int, len(parts))
+	for i, p := range parts {
+		bounds[i] = p.start
+	}
+	return bounds
+}
+
+// runeBoundary 返回不超过 n 且不截断字符的位置
+func runeBoundary(s string, n int) int {
+	if n >= len(s) {
+		return len(s)
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	if n == 0 {
+		// 首个字符本身超过 n 字节
+		_, size := utf8.DecodeRuneInString(s)
+		return size
+	}
+	return n
+}
@@ -0,0 +1,235 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// splitCL100K 按 cl100k_base 的预分词正则切分文本：
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// 正则需要环视和回溯，这里手工实现同样的匹配顺序
+func splitCL100K(text string, yield func(piece string)) {
+	for i := 0; i < len(text); {
+		end := nextCL100K(text, i)
+		yield(text[i:end])
+		i = end
+	}
+}
+
+// splitO200K 按 o200k_base 的预分词正则切分文本：
+//
+//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//	[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|
+//	\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+
+func splitO200K(text string, yield func(piece string)) {
+	for i := 0; i < len(text); {
+		end := nextO200K(text, i)
+		yield(text[i:end])
+		i = end
+	}
+}
+
+// nextCL100K 返回从 i 开始的 cl100k 片段结束位置
+func nextCL100K(s string, i int) int {
+	r, n := utf8.DecodeRuneInString(s[i:])
+
+	if end := matchContraction(s, i); end > i {
+		return end
+	}
+
+	if unicode.IsLetter(r) {
+		return scanRunes(s, i, unicode.IsLetter)
+	}
+	if isWordPrefix(r) && i+n < len(s) {
+		if next, _ := utf8.DecodeRuneInString(s[i+n:]); unicode.IsLetter(next) {
+			return scanRunes(s, i+n, unicode.IsLetter)
+		}
+	}
+
+	if unicode.IsNumber(r) {
+		return scanNumbers(s, i)
+	}
+
+	if end := matchPunct(s, i, false); end > i {
+		return end
+	}
+	return matchSpace(s, i)
+}
+
+// nextO200K 返回从 i 开始的 o200k 片段结束位置
+func nextO200K(s string, i int) int {
+	r, n := utf8.DecodeRuneInString(s[i:])
+
+	for _, word := range []func(string, int) int{matchLowerTail, matchUpperHead} {
+		if isWordPrefix(r) {
+			if end := word(s, i+n); end > 0 {
+				return end
+			}
+		}
+		if end := word(s, i); end > 0 {
+			return end
+		}
+	}
+
+	if unicode.IsNumber(r) {
+		return scanNumbers(s, i)
+	}
+
+	if end := matchPunct(s, i, true); end > i {
+		return end
+	}
+	return matchSpace(s, i)
+}
+
+// matchLowerTail 匹配 [Upper]*[Lower]+ 及可选的缩写，失败返回 -1
+func matchLowerTail(s string, p int) int {
+	upper := scanRunes(s, p, isUpperClass)
+
+	end := -1
+	if upper < len(s) {
+		if r, _ := utf8.DecodeRuneInString(s[upper:]); isLowerClass(r) {
+			end = scanRunes(s, upper, isLowerClass)
+		}
+	}
+	if end < 0 {
+		// [Upper]* 回溯到最后一个同时属于 [Lower] 的字符
+		for j := p; j < upper; {
+			r, size := utf8.DecodeRuneInString(s[j:])
+			if isLowerClass(r) {
+				end = j + size
+			}
+			j += size
+		}
+	}
+	if end < 0 {
+		return -1
+	}
+	return matchContraction(s, end)
+}
+
+// matchUpperHead 匹配 [Upper]+[Lower]* 及可选的缩写，失败返回 -1
+func matchUpperHead(s string, p int) int {
+	upper := scanRunes(s, p, isUpperClass)
+	if upper == p {
+		return -1
+	}
+	return matchContraction(s, scanRunes(s, upper, isLowerClass))
+}
+
+// matchContraction 匹配 (?i:'s|'t|'re|'ve|'m|'ll|'d)，返回结束位置，不匹配时返回 i
+func matchContraction(s string, i int) int {
+	if i+1 >= len(s) || s[i] != '\'' {
+		return i
+	}
+
+	first := s[i+1] | 0x20
+	if i+2 < len(s) {
+		second := s[i+2] | 0x20
+		if (first == 'r' || first == 'v') && second == 'e' || first == 'l' && second == 'l' {
+			return i + 3
+		}
+	}
+	if first == 's' || first == 't' || first == 'm' || first == 'd' {
+		return i + 2
+	}
+	return i
+}
+
+// matchPunct 匹配 ` ?[^\s\p{L}\p{N}]+` 及其后的换行（o200k 还包括 /），不匹配时返回 i
+func matchPunct(s string, i int, slash bool) int {
+	j := i
+	if s[j] == ' ' {
+		j++
+	}
+
+	end := scanRunes(s, j, isPunct)
+	if end == j {
+		return i
+	}
+
+	for end < len(s) && (s[end] == '\r' || s[end] == '\n' || (slash && s[end] == '/')) {
+		end++
+	}
+	return end
+}
+
+// matchSpace 匹配 `\s*[\r\n]+|\s+(?!\S)|\s+`，非空白字符单独成段
+func matchSpace(s string, i int) int {
+	end := scanRunes(s, i, unicode.IsSpace)
+	if end == i {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		return i + size
+	}
+
+	// \s*[\r\n]+ 止于空白中最后一个换行
+	lastNewline, last := -1, i
+	for j := i; j < end; {
+		r, size := utf8.DecodeRuneInString(s[j:])
+		if r == '\r' || r == '\n' {
+			lastNewline = j + size
+		}
+		last = j
+		j += size
+	}
+	if lastNewline > 0 {
+		return lastNewline
+	}
+
+	// \s+(?!\S) 把最后一个空白留给后面的单词
+	if end < len(s) && last > i {
+		return last
+	}
+	return end
+}
+
+// scanNumbers 匹配最多3个数字
+func scanNumbers(s string, i int) int {
+	for k := 0; k < 3 && i < len(s); k++ {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !unicode.IsNumber(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// scanRunes 从 i 开始跳过所有满足条件的字符
+func scanRunes(s string, i int, match func(rune) bool) int {
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !match(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// isWordPrefix 单词前可附带的字符 [^\r\n\p{L}\p{N}]
+func isWordPrefix(r rune) bool {
+	return r != '\r' && r != '\n' && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isPunct [^\s\p{L}\p{N}]
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// isUpperClass [\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]
+func isUpperClass(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r >= 'A' && r <= 'Z'
+	}
+	return unicode.In(r, unicode.Lu, unicode.Lt, unicode.Lm, unicode.Lo, unicode.M)
+}
+
+// isLowerClass [\p{Ll}\p{Lm}\p{Lo}\p{M}]
+func isLowerClass(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r >= 'a' && r <= 'z'
+	}
+	return unicode.In(r, unicode.Ll, unicode.Lm, unicode.Lo, unicode.M)
+}
@@ -0,0 +1,147 @@
+// Package tokenizer 提供基于BPE词表的token计数，按提供商和模型选择词表，
+// 未知模型或词表不可用时退回字符数估算。
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+)
+
+// 内置词表名称
+const (
+	CL100K = "cl100k_base"
+	O200K  = "o200k_base"
+)
+
+// Tokenizer token计数器
+type Tokenizer interface {
+	// Name 词表名称
+	Name() string
+	// Count 计算文本的token数
+	Count(text string) int
+}
+
+// modelEncodings 模型名前缀到词表的映射，按顺序匹配
+var modelEncodings = []struct {
+	prefix   string
+	encoding string
+}{
+	{"gpt-4o", O200K},
+	{"chatgpt-4o", O200K},
+	{"gpt-4.1", O200K},
+	{"gpt-4.5", O200K},
+	{"gpt-5", O200K},
+	{"o1", O200K},
+	{"o3", O200K},
+	{"o4", O200K},
+	{"gpt-4", CL100K},
+	{"gpt-3.5", CL100K},
+	{"text-embedding-3", CL100K},
+	{"text-embedding-ada-002", CL100K},
+	// 以下模型的分词器未公开或未内置，使用误差较小的近似词表
+	{"claude", CL100K},
+	{"deepseek", CL100K},
+	{"qwen", O200K},
+	{"gemini", O200K},
+}
+
+// providerEncodings 模型未匹配时按提供商选择词表
+var providerEncodings = map[string]string{
+	"openai":   O200K,
+	"claude":   CL100K,
+	"deepseek": CL100K,
+	"gemini":   O200K,
+}
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*Encoding)
+	loadErrors  = make(map[string]error)
+)
+
+// ForModel 按提供商和模型选择计数器。对应词表未内置时使用随源码提供的 cl100k，
+// 比字符估算更接近；未知模型返回估算计数器
+func ForModel(provider, model string) Tokenizer {
+	if name := EncodingForModel(provider, model); name != "" {
+		for _, candidate := range []string{name, CL100K} {
+			if encoding, err := Get(candidate); err == nil {
+				return encoding
+			}
+		}
+	}
+	return Heuristic()
+}
+
+// EncodingForModel 返回模型对应的词表名称，未知模型返回空字符串
+func EncodingForModel(provider, model string) string {
+	model = strings.ToLower(model)
+	// 兼容 "Qwen/Qwen2.5-Coder" 和 "qwen3:30b" 等带组织或标签的名称
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+
+	for _, entry := range modelEncodings {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.encoding
+		}
+	}
+	return providerEncodings[strings.ToLower(provider)]
+}
+
+// Default 默认计数器：cl100k 词表可用时使用词表，否则使用估算
+func Default() Tokenizer {
+	if encoding, err := Get(CL100K); err == nil {
+		return encoding
+	}
+	return Heuristic()
+}
+
+// Get 获取内置词表，首次使用时从嵌入文件加载
+func Get(name string) (*Encoding, error) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if encoding, exists := encodings[name]; exists {
+		return encoding, nil
+	}
+	if err, failed := loadErrors[name]; failed {
+		return nil, err
+	}
+
+	encoding, err := loadEmbedded(name)
+	if err != nil {
+		loadErrors[name] = err
+		return nil, err
+	}
+
+	encodings[name] = encoding
+	return encoding, nil
+}
+
+// heuristic 字符数估算计数器
+type heuristic struct{}
+
+// Heuristic 返回估算计数器：1 token ≈ 4个字符（英文）或 1.5个中文字符
+func Heuristic() Tokenizer {
+	return heuristic{}
+}
+
+// Name 计数器名称
+func (heuristic) Name() string {
+	return "heuristic"
+}
+
+// Count 估算token数
+func (heuristic) Count(text string) int {
+	chars := 0
+	chineseCount := 0
+	for _, r := range text {
+		chars++
+		if r >= 0x4e00 && r <= 0x9fff {
+			chineseCount++
+		}
+	}
+
+	englishCount := chars - chineseCount
+	return int(float64(englishCount)/4 + float64(chineseCount)/1.5)
+}
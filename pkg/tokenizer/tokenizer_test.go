@@ -0,0 +1,226 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRanks 小型测试词表：256个单字节加少量合并规则
+func testRanks() map[string]int {
+	ranks := make(map[string]int)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	for i, merge := range []string{"he", "ll", "hell", "hello", " w", "or", " wor", "ld", " world"} {
+		ranks[merge] = 256 + i
+	}
+	return ranks
+}
+
+func collect(split func(string, func(string)), text string) []string {
+	var pieces []string
+	split(text, func(piece string) {
+		pieces = append(pieces, piece)
+	})
+	return pieces
+}
+
+func TestSplitCL100K(t *testing.T) {
+	cases := map[string][]string{
+		"Hello world":   {"Hello", " world"},
+		"I'm fine":      {"I", "'m", " fine"},
+		"WE'RE here":    {"WE", "'RE", " here"},
+		"12345":         {"123", "45"},
+		"foo  bar":      {"foo", " ", " bar"},
+		"a\n\n  b":      {"a", "\n\n", " ", " b"},
+		"x = y+1;\n":    {"x", " =", " y", "+", "1", ";\n"},
+		"hi  ":          {"hi", "  "},
+		"你好，世界":         {"你好", "，世界"},
+		"func main() {": {"func", " main", "()", " {"},
+	}
+
+	for text, want := range cases {
+		if got := collect(splitCL100K, text); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitCL100K(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSplitO200K(t *testing.T) {
+	cases := map[string][]string{
+		"HelloWorld":   {"Hello", "World"},
+		"HELLOworld":   {"HELLOworld"},
+		"don't stop":   {"don't", " stop"},
+		"path/to\n":    {"path", "/to", "\n"},
+		"a//\nb":       {"a", "//\n", "b"},
+		"getHTTPReply": {"get", "HTTPReply"},
+	}
+
+	for text, want := range cases {
+		if got := collect(splitO200K, text); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitO200K(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSplitCoversInput(t *testing.T) {
+	text := "日本語 テキスト\r\n\tmixed 'quotes' ＡＢＣ ١٢٣ é 🙂🙂  \n"
+	for name, split := range map[string]func(string, func(string)){CL100K: splitCL100K, O200K: splitO200K} {
+		pieces := collect(split, text)
+		if joined := strings.Join(pieces, ""); joined != text {
+			t.Errorf("%s: pieces do not reassemble input: %q", name, joined)
+		}
+		for _, piece := range pieces {
+			if piece == "" {
+				t.Errorf("%s: empty piece in %q", name, pieces)
+			}
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	ranks := testRanks()
+	encoding := NewEncoding("test", ranks, CL100K)
+
+	got := encoding.Encode("hello world!")
+	want := []int{ranks["hello"], ranks[" world"], '!'}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode = %v, want %v", got, want)
+	}
+
+	// "hel" 先合并 "he"，剩余 "l"
+	if got := encoding.Encode("hel"); !reflect.DeepEqual(got, []int{ranks["he"], 'l'}) {
+		t.Errorf("Encode(hel) = %v", got)
+	}
+
+	if got := encoding.Count("hello world!"); got != 3 {
+		t.Errorf("Count = %d, want 3", got)
+	}
+}
+
+func TestLoadRanks(t *testing.T) {
+	var vocab strings.Builder
+	for token, rank := range map[string]int{"a": 0, "b": 1, "ab": 2} {
+		fmt.Fprintf(&vocab, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+
+	ranks, err := LoadRanks(strings.NewReader(vocab.String()))
+	if err != nil {
+		t.Fatalf("LoadRanks error: %v", err)
+	}
+	if len(ranks) != 3 || ranks["ab"] != 2 {
+		t.Errorf("unexpected ranks: %v", ranks)
+	}
+
+	if _, err := LoadRanks(strings.NewReader("YQ==\n")); err == nil {
+		t.Error("expected error for malformed entry")
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	cases := []struct {
+		provider, model, want string
+	}{
+		{"openai", "gpt-4o-mini", O200K},
+		{"openai", "gpt-4-turbo", CL100K},
+		{"openai", "o3-mini", O200K},
+		{"openai", "my-finetune", O200K},
+		{"claude", "claude-sonnet-4", CL100K},
+		{"ollama", "Qwen/Qwen2.5-Coder", O200K},
+		{"ollama", "llama3", ""},
+	}
+
+	for _, c := range cases {
+		if got := EncodingForModel(c.provider, c.model); got != c.want {
+			t.Errorf("EncodingForModel(%q, %q) = %q, want %q", c.provider, c.model, got, c.want)
+		}
+	}
+
+	if got := ForModel("ollama", "llama3").Name(); got != "heuristic" {
+		t.Errorf("expected heuristic fallback for unknown model, got %s", got)
+	}
+}
+
+func TestEmbeddedCL100K(t *testing.T) {
+	encoding, err := Get(CL100K)
+	if err != nil {
+		t.Fatalf("Get(%s) error: %v", CL100K, err)
+	}
+
+	// 与 tiktoken 的编码结果一致
+	cases := map[string][]int{
+		"hello world":        {15339, 1917},
+		"tiktoken is great!": {83, 1609, 5963, 374, 2294, 0},
+	}
+	for text, want := range cases {
+		if got := encoding.Encode(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Encode(%q) = %v, want %v", text, got, want)
+		}
+	}
+
+	if got := ForModel("claude", "claude-sonnet-4").Name(); got != CL100K {
+		t.Errorf("expected embedded %s for claude, got %s", CL100K, got)
+	}
+	// o200k 未下载时使用 cl100k 近似
+	if got := ForModel("openai", "gpt-4o").Name(); got == "heuristic" {
+		t.Errorf("expected a vocabulary for gpt-4o, got %s", got)
+	}
+}
+
+func TestCountLargeText(t *testing.T) {
+	encoding := NewEncoding("test", testRanks(), CL100K)
+
+	text := strings.Repeat("hello world, 你好世界\n", 20_000)
+	exact := encoding.count(text)
+	estimated := encoding.Count(text)
+
+	if diff := float64(estimated-exact) / float64(exact); diff > 0.02 || diff < -0.02 {
+		t.Errorf("estimate %d deviates from exact %d by %.1f%%", estimated, exact, diff*100)
+	}
+}
+
+// benchmarkEncoding 优先使用内置词表，缺失时使用测试词表
+func benchmarkEncoding(b *testing.B) *Encoding {
+	if encoding, err := Get(CL100K); err == nil {
+		return encoding
+	}
+	return NewEncoding("test", testRanks(), CL100K)
+}
+
+const benchmarkMessage = `I've updated internal/context/context_manager.go so that AddMessage counts tokens
+with the model's tokenizer. 修改后的代码如下：
+
+func (cm *ContextManager) AddMessage(ctx context.Context, sessionID string, message types.Message) error {
+	tokens := cm.tokenizer.Count(message.Content)
+	session.TotalTokens += tokens
+}
+`
+
+func BenchmarkCountMessage(b *testing.B) {
+	encoding := benchmarkEncoding(b)
+	b.SetBytes(int64(len(benchmarkMessage)))
+	for i := 0; i < b.N; i++ {
+		encoding.Count(benchmarkMessage)
+	}
+}
+
+func BenchmarkCountToolOutput(b *testing.B) {
+	encoding := benchmarkEncoding(b)
+	output := strings.Repeat(benchmarkMessage, 1<<20/len(benchmarkMessage))
+	b.SetBytes(int64(len(output)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encoding.Count(output)
+	}
+}
+
+func BenchmarkHeuristic(b *testing.B) {
+	tokenizer := Heuristic()
+	b.SetBytes(int64(len(benchmarkMessage)))
+	for i := 0; i < b.N; i++ {
+		tokenizer.Count(benchmarkMessage)
+	}
+}
@@ -0,0 +1,73 @@
+package tokenizer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// vocabFS 内置词表。cl100k_base 随源码提供，其他词表由 scripts/fetch-vocab.sh 下载
+//
+//go:embed vocab
+var vocabFS embed.FS
+
+// loadEmbedded 加载内置词表 vocab/<name>.tiktoken.gz
+func loadEmbedded(name string) (*Encoding, error) {
+	file, err := vocabFS.Open("vocab/" + name + ".tiktoken.gz")
+	if err != nil {
+		return nil, fmt.Errorf("vocabulary %s not embedded: %w", name, err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress vocabulary %s: %w", name, err)
+	}
+	defer reader.Close()
+
+	ranks, err := LoadRanks(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vocabulary %s: %w", name, err)
+	}
+
+	return NewEncoding(name, ranks, name), nil
+}
+
+// LoadRanks 读取 tiktoken 格式的词表，每行为 "<base64 token> <rank>"
+func LoadRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int, 200_000)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: malformed entry", line)
+		}
+
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid token: %w", line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid rank: %w", line, err)
+		}
+
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ranks, nil
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -10,12 +11,31 @@ type Message struct {
 	ID         string            `json:"id"`
 	Role       MessageRole       `json:"role"`
 	Content    string            `json:"content"`
+	Reasoning  string            `json:"reasoning,omitempty"` // 模型的推理/思考内容
+	Parts      []ContentPart     `json:"parts,omitempty"`     // 附加的多模态内容，排在 Content 文本之后
 	ToolCalls  []ToolCall        `json:"tool_calls,omitempty"`
 	ToolCallID string            `json:"tool_call_id,omitempty"`
 	Metadata   map[string]string `json:"metadata,omitempty"`
 	Timestamp  time.Time         `json:"timestamp"`
 }
 
+// ContentPartType 消息内容片段类型
+type ContentPartType string
+
+const (
+	ContentPartText  ContentPartType = "text"
+	ContentPartImage ContentPartType = "image"
+)
+
+// ContentPart 多模态消息内容片段，图片以base64数据或本地文件路径给出
+type ContentPart struct {
+	Type      ContentPartType `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	MediaType string          `json:"media_type,omitempty"` // image/png、image/jpeg 等，为空时根据数据推断
+	Data      string          `json:"data,omitempty"`       // base64编码的图片数据
+	Path      string          `json:"path,omitempty"`       // 本地图片路径，发送给模型时读取，只用于CLI和工具，HTTP接口不接受
+}
+
 // MessageRole 消息角色
 type MessageRole string
 
@@ -26,6 +46,12 @@ const (
 	RoleTool      MessageRole = "tool"
 )
 
+// MetadataVolatile 标记内容随运行环境变化（日期、目录结构等）的消息，录制回放时不参与请求指纹
+const MetadataVolatile = "volatile"
+
+// MetadataReasoningState 回传推理内容所需的提供商私有数据（如Claude思考块签名），由响应带出并保存在消息中
+const MetadataReasoningState = "reasoning_state"
+
 // ToolCall 工具调用
 type ToolCall struct {
 	ID       string                 `json:"id"`
@@ -43,10 +69,14 @@ type ToolCallFunction struct {
 
 // ToolCallResult 工具调用结果
 type ToolCallResult struct {
-	Content   string    `json:"content"`
-	Success   bool      `json:"success"`
-	Error     string    `json:"error,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
+	Content   string        `json:"content"`
+	Parts     []ContentPart `json:"parts,omitempty"` // 工具返回的图片等内容
+	Success   bool          `json:"success"`
+	Error     string        `json:"error,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+
+	Usage    *Usage            `json:"usage,omitempty"`    // 工具内部调用LLM的用量，如子任务，计入本轮总用量
+	Metadata map[string]string `json:"metadata,omitempty"` // 写入工具结果消息元数据的附加信息
 }
 
 // LLMProvider 大模型提供商类型
@@ -57,42 +87,177 @@ const (
 	ProviderDeepSeek LLMProvider = "deepseek"
 	ProviderClaude   LLMProvider = "claude"
 	ProviderOllama   LLMProvider = "ollama"
+	ProviderGemini   LLMProvider = "gemini"
+	ProviderReplay   LLMProvider = "replay"
+	ProviderMock     LLMProvider = "mock"
 )
 
 // LLMConfig 大模型配置
 type LLMConfig struct {
-	Provider    LLMProvider `mapstructure:"provider"`
-	APIKey      string      `mapstructure:"api_key"`
-	BaseURL     string      `mapstructure:"base_url"`
-	Model       string      `mapstructure:"model"`
-	MaxTokens   int         `mapstructure:"max_tokens"`
-	Temperature float64     `mapstructure:"temperature"`
+	Provider    LLMProvider     `mapstructure:"provider"`
+	APIKey      string          `mapstructure:"api_key"`
+	BaseURL     string          `mapstructure:"base_url"`
+	Model       string          `mapstructure:"model"`
+	MaxTokens   int             `mapstructure:"max_tokens"`
+	Temperature float64         `mapstructure:"temperature"`
+	Retry       RetryConfig     `mapstructure:"retry"`
+	RateLimit   RateLimitConfig `mapstructure:"rate_limit"`
+	Protocol    LLMProvider     `mapstructure:"protocol"` // 命名提供商使用的协议，为空时与提供商同名
+
+	SendReasoning  bool `mapstructure:"send_reasoning"`  // 后续轮次是否把推理内容回传给模型，部分提供商会拒绝
+	ThinkingBudget int  `mapstructure:"thinking_budget"` // Claude 扩展思考的token预算，0为关闭，需小于 max_tokens
+
+	// 向量化配置，仅 OpenAI 兼容协议和 Ollama 支持
+	EmbeddingModel      string `mapstructure:"embedding_model"`      // 向量化模型
+	EmbeddingDimensions int    `mapstructure:"embedding_dimensions"` // 输出维度，0使用模型默认维度，仅部分模型支持
+	EmbeddingBatchSize  int    `mapstructure:"embedding_batch_size"` // 每次请求的最大输入条数，0使用默认值
+
+	// 以下仅 replay 提供商使用
+	Mode     string      `mapstructure:"mode"`     // record 或 replay
+	Cassette string      `mapstructure:"cassette"` // 录制文件路径
+	Upstream LLMProvider `mapstructure:"upstream"` // record 模式下实际调用的提供商
+
+	// 以下仅 mock 提供商使用
+	Script string `mapstructure:"script"` // YAML 或 JSON 脚本路径
+}
+
+// RetryConfig 大模型调用重试配置
+type RetryConfig struct {
+	MaxRetries     int `mapstructure:"max_retries"`     // 0 使用默认值，负数表示不重试
+	InitialBackoff int `mapstructure:"initial_backoff"` // milliseconds
+	MaxBackoff     int `mapstructure:"max_backoff"`     // milliseconds
+}
+
+// RateLimitConfig 提供商限流配置，同一提供商的所有会话共享，0 表示不限制
+type RateLimitConfig struct {
+	MaxConcurrency    int `mapstructure:"max_concurrency"`     // 同时进行的请求数
+	RequestsPerMinute int `mapstructure:"requests_per_minute"` // 每分钟请求数
+	TokensPerMinute   int `mapstructure:"tokens_per_minute"`   // 每分钟token数，调用前估算，调用后按实际用量修正
+}
+
+// IsZero 是否没有设置任何限制
+func (c RateLimitConfig) IsZero() bool {
+	return c.MaxConcurrency <= 0 && c.RequestsPerMinute <= 0 && c.TokensPerMinute <= 0
 }
 
 // LLMRequest 大模型请求
 type LLMRequest struct {
-	Messages    []Message `json:"messages"`
-	Tools       []Tool    `json:"tools,omitempty"`
-	Stream      bool      `json:"stream"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	Model       string    `json:"model,omitempty"`
+	Messages       []Message       `json:"messages"`
+	Tools          []Tool          `json:"tools,omitempty"`
+	Stream         bool            `json:"stream"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"` // 设置后要求模型只返回符合 Schema 的JSON
+}
+
+// ResponseFormat 结构化输出格式
+type ResponseFormat struct {
+	Name        string         `json:"name"`                  // 格式名称，只能包含字母、数字、下划线和连字符
+	Description string         `json:"description,omitempty"` // 格式说明
+	Schema      map[string]any `json:"schema"`                // 响应需要符合的 JSON Schema
+	Strict      bool           `json:"strict,omitempty"`      // 严格模式，要求 Schema 满足 OpenAI structured outputs 的限制
 }
 
 // LLMResponse 大模型响应
 type LLMResponse struct {
-	ID        string     `json:"id"`
-	Content   string     `json:"content"`
-	Role      string     `json:"role"`
-	Usage     Usage      `json:"usage"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ID           string            `json:"id"`
+	Content      string            `json:"content"`
+	Reasoning    string            `json:"reasoning,omitempty"`
+	Role         string            `json:"role"`
+	Usage        Usage             `json:"usage"` // 流式响应中仅最后一个响应块携带整次调用的用量
+	ToolCalls    []ToolCall        `json:"tool_calls,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	FinishReason FinishReason      `json:"finish_reason,omitempty"` // 流式响应中由最后一个响应块携带
+	Error        string            `json:"error,omitempty"`         // 流式响应中途出错时的错误信息，FinishReason 为 error
 }
 
+// FinishReason 生成结束原因，各提供商的取值统一为以下几种
+type FinishReason string
+
+const (
+	FinishReasonStop          FinishReason = "stop"           // 正常结束
+	FinishReasonLength        FinishReason = "length"         // 达到输出长度上限，内容或工具调用参数被截断
+	FinishReasonToolCalls     FinishReason = "tool_calls"     // 需要执行工具调用
+	FinishReasonContentFilter FinishReason = "content_filter" // 内容被安全策略拦截
+	FinishReasonError         FinishReason = "error"          // 流式响应中途出错，已输出的内容不完整
+)
+
 // Usage token使用情况
 type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	CachedTokens     int `json:"cached_tokens,omitempty"` // 命中缓存的提示词token，包含在PromptTokens中
+}
+
+// IsZero 是否没有用量
+func (u Usage) IsZero() bool {
+	return u == Usage{}
+}
+
+// Add 累加用量
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.CachedTokens += other.CachedTokens
+}
+
+// UsageRecord 单次LLM调用的用量记录
+type UsageRecord struct {
+	ID               string      `json:"id"`
+	SessionID        string      `json:"session_id"`
+	Provider         LLMProvider `json:"provider"`
+	Model            string      `json:"model"`
+	PromptTokens     int         `json:"prompt_tokens"`
+	CompletionTokens int         `json:"completion_tokens"`
+	CachedTokens     int         `json:"cached_tokens"`
+	Timestamp        time.Time   `json:"timestamp"`
+}
+
+// UsageGroupBy 用量汇总维度
+type UsageGroupBy string
+
+const (
+	UsageGroupBySession  UsageGroupBy = "session"
+	UsageGroupByDay      UsageGroupBy = "day"
+	UsageGroupByProvider UsageGroupBy = "provider"
+)
+
+// UsageQuery 用量查询条件，零值表示不限制
+type UsageQuery struct {
+	SessionID string       `json:"session_id,omitempty"`
+	Since     time.Time    `json:"since,omitempty"`
+	Until     time.Time    `json:"until,omitempty"`
+	GroupBy   UsageGroupBy `json:"group_by"`
+}
+
+// UsageSummary 用量汇总
+type UsageSummary struct {
+	Key              string  `json:"key"`
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CachedTokens     int     `json:"cached_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageReport 用量报告
+type UsageReport struct {
+	GroupBy UsageGroupBy   `json:"group_by"`
+	Rows    []UsageSummary `json:"rows"`
+	Total   UsageSummary   `json:"total"`
+}
+
+// ModelPrice 模型价格，单位为美元/百万token
+type ModelPrice struct {
+	Provider    LLMProvider `mapstructure:"provider" json:"provider,omitempty"` // 为空时匹配所有提供商
+	Model       string      `mapstructure:"model" json:"model,omitempty"`       // 为空时作为提供商的默认价格
+	Input       float64     `mapstructure:"input" json:"input"`
+	Output      float64     `mapstructure:"output" json:"output"`
+	CachedInput float64     `mapstructure:"cached_input" json:"cached_input"` // 为0时按Input计价
 }
 
 // Tool 工具定义
@@ -128,32 +293,75 @@ type AgentConfig struct {
 
 // AgentState Agent状态
 type AgentState struct {
-	SessionID         string    `json:"session_id"`
-	Status            string    `json:"status"`
-	CurrentLoop       int       `json:"current_loop"`
-	Messages          []Message `json:"messages"`
-	CompressedHistory string    `json:"compressed_history,omitempty"`
-	ActiveTools       []string  `json:"active_tools"`
-	LastActivity      time.Time `json:"last_activity"`
+	SessionID         string      `json:"session_id"`
+	Status            string      `json:"status"`
+	CurrentLoop       int         `json:"current_loop"`
+	Messages          []Message   `json:"messages"`
+	CompressedHistory string      `json:"compressed_history,omitempty"`
+	ActiveTools       []string    `json:"active_tools"`
+	LastActivity      time.Time   `json:"last_activity"`
+	Provider          LLMProvider `json:"provider"`
+	Model             string      `json:"model,omitempty"`
+	Mode              AgentMode   `json:"mode"`
 }
 
+// AgentMode Agent的工作模式
+type AgentMode string
+
+const (
+	ModeExecute AgentMode = "execute" // 可以使用所有工具
+	ModePlan    AgentMode = "plan"    // 只能使用只读工具，先给出计划，用户同意后切换到执行模式
+)
+
 // ChatRequest 聊天请求
 type ChatRequest struct {
 	Message   string            `json:"message"`
 	SessionID string            `json:"session_id,omitempty"`
 	Stream    bool              `json:"stream,omitempty"`
 	Metadata  map[string]string `json:"metadata,omitempty"`
+	Parts     []ContentPart     `json:"parts,omitempty"`    // 随消息附带的图片等内容
+	Provider  LLMProvider       `json:"provider,omitempty"` // 为空时沿用会话之前的选择
+	Model     string            `json:"model,omitempty"`    // 为空时使用提供商配置的模型
+	Mode      AgentMode         `json:"mode,omitempty"`     // 为空时沿用会话之前的模式
 }
 
 // ChatResponse 聊天响应
 type ChatResponse struct {
 	SessionID string                 `json:"session_id"`
 	Response  string                 `json:"response"`
+	Reasoning string                 `json:"reasoning,omitempty"`
+	Approval  *ToolApproval          `json:"approval,omitempty"` // 等待用户确认的工具调用，确认前本轮暂停
 	Finished  bool                   `json:"finished"`
 	Usage     Usage                  `json:"usage"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
+// ToolApproval 等待用户确认的工具调用
+type ToolApproval struct {
+	ID         string `json:"id"`
+	ToolCallID string `json:"tool_call_id"`
+	Tool       string `json:"tool"`
+	Arguments  string `json:"arguments"`
+	Subject    string `json:"subject"`        // 规则匹配的内容，如 bash 的命令
+	Rule       string `json:"rule,omitempty"` // 触发确认的规则，按默认模式确认时为空
+	Suggested  string `json:"suggested"`      // 选择记住时添加的放行规则
+}
+
+// ApprovalScope 记住确认结果的范围
+type ApprovalScope string
+
+const (
+	ApprovalOnce    ApprovalScope = ""        // 只放行这一次
+	ApprovalSession ApprovalScope = "session" // 本会话内不再询问
+	ApprovalProject ApprovalScope = "project" // 写入项目规则文件，之后的会话也不再询问
+)
+
+// ApprovalDecision 用户对工具调用的确认结果，拒绝时不记住
+type ApprovalDecision struct {
+	Approved bool          `json:"approved"`
+	Remember ApprovalScope `json:"remember,omitempty"`
+}
+
 // SessionContext 会话上下文
 type SessionContext struct {
 	ID                string            `json:"id"`
@@ -173,6 +381,9 @@ type ContextManager interface {
 	LoadPersistentContext(ctx context.Context, sessionID string) (string, error)
 	SavePersistentContext(ctx context.Context, sessionID string, context string) error
 	GetSessionContext(sessionID string) (*SessionContext, error)
+	SetSessionMetadata(ctx context.Context, sessionID string, metadata map[string]string) error
+	RecordUsage(ctx context.Context, record UsageRecord) error
+	GetUsageReport(ctx context.Context, query UsageQuery) (*UsageReport, error)
 }
 
 // LLMClient 大模型客户端接口
@@ -183,12 +394,72 @@ type LLMClient interface {
 	GetConfig() LLMConfig
 }
 
+// Embedder 支持向量化的LLM客户端实现的接口
+type Embedder interface {
+	Embed(ctx context.Context, request EmbeddingRequest) (*EmbeddingResponse, error)
+}
+
+// EmbeddingRequest 向量化请求
+type EmbeddingRequest struct {
+	Input      []string `json:"input"`
+	Model      string   `json:"model,omitempty"`      // 为空时使用提供商配置的 embedding_model
+	Dimensions int      `json:"dimensions,omitempty"` // 为0时使用提供商配置的维度
+}
+
+// EmbeddingResponse 向量化响应，Embeddings 与 Input 按顺序一一对应
+type EmbeddingResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+	Dimensions int         `json:"dimensions"`
+	Usage      Usage       `json:"usage"`
+}
+
+// LLMHealthChecker LLM客户端可选实现的健康检查和模型发现接口
+type LLMHealthChecker interface {
+	// Ping 检查提供商是否可达且凭据有效
+	Ping(ctx context.Context) error
+	// ListModels 列出提供商可用的模型
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+	// Capabilities 查询模型能力，model 为空时使用配置的模型
+	Capabilities(ctx context.Context, model string) (ModelCapabilities, error)
+}
+
+// ModelInfo 提供商可用的模型
+type ModelInfo struct {
+	ID      string `json:"id"`
+	OwnedBy string `json:"owned_by,omitempty"`
+}
+
+// ModelCapabilities 模型能力
+type ModelCapabilities struct {
+	Tools            bool `json:"tools"`
+	Vision           bool `json:"vision"`
+	Reasoning        bool `json:"reasoning"`
+	StructuredOutput bool `json:"structured_output"` // 原生支持 JSON Schema 约束输出
+	Probed           bool `json:"probed"`            // 由提供商接口查询得到，否则按协议和模型名推断
+}
+
+// ProviderStatus 提供商健康检查结果
+type ProviderStatus struct {
+	Provider     LLMProvider        `json:"provider"`
+	Protocol     LLMProvider        `json:"protocol"`
+	Model        string             `json:"model"`
+	Default      bool               `json:"default"`
+	Supported    bool               `json:"supported"` // 客户端是否实现了健康检查
+	Reachable    bool               `json:"reachable"`
+	LatencyMs    int64              `json:"latency_ms"`
+	Models       []ModelInfo        `json:"models,omitempty"`
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+	Error        string             `json:"error,omitempty"`
+}
+
 // ToolExecutor 工具执行器接口
 type ToolExecutor interface {
 	Name() string
 	Execute(ctx context.Context, call ToolCall) *ToolCallResult
 	GetDefinition() Tool
 	IsConcurrencySafe() bool
+	IsReadOnly() bool // 不修改文件和系统状态，计划模式下可用
 }
 
 // ToolEngine 工具引擎接口
@@ -199,11 +470,22 @@ type ToolEngine interface {
 	GetTool(name string) (ToolExecutor, bool)
 }
 
+var (
+	// ErrTurnInProgress 会话已有正在运行的一轮，同一会话同时只能运行一轮
+	ErrTurnInProgress = errors.New("session has a turn in progress")
+	// ErrApprovalNotFound 没有等待确认的工具调用
+	ErrApprovalNotFound = errors.New("approval not found")
+)
+
 // Agent 主要Agent接口
 type Agent interface {
 	Chat(ctx context.Context, request ChatRequest) (*ChatResponse, error)
 	ChatStream(ctx context.Context, request ChatRequest) (<-chan ChatResponse, error)
 	GetState(sessionID string) (*AgentState, error)
+	GetUsageReport(ctx context.Context, query UsageQuery) (*UsageReport, error)
+	CheckProviders(ctx context.Context) []ProviderStatus
+	CancelTurn(sessionID string) bool
+	ResolveApproval(sessionID, approvalID string, decision ApprovalDecision) error
 }
 
 // PromptManager 提示词管理器接口
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/zboya/nala-coder/pkg/types"
+)
+
+// MaxImageSize 单张图片大小上限，与主流模型API的限制一致
+const MaxImageSize = 5 << 20
+
+// imageTypes 模型支持的图片类型
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// DetectImageType 根据内容判断图片类型，不是支持的图片时返回空字符串
+func DetectImageType(data []byte) string {
+	mediaType := http.DetectContentType(data)
+	if imageTypes[mediaType] {
+		return mediaType
+	}
+	return ""
+}
+
+// ImagePart 将图片数据构造成内容片段
+func ImagePart(data []byte) (types.ContentPart, error) {
+	mediaType := DetectImageType(data)
+	if mediaType == "" {
+		return types.ContentPart{}, fmt.Errorf("unsupported image type: %s", http.DetectContentType(data))
+	}
+	if len(data) > MaxImageSize {
+		return types.ContentPart{}, fmt.Errorf("image too large: %d bytes (max %d)", len(data), MaxImageSize)
+	}
+
+	return types.ContentPart{
+		Type:      types.ContentPartImage,
+		MediaType: mediaType,
+		Data:      base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// ResolveImage 读取图片片段的数据，返回图片类型和原始字节
+func ResolveImage(part types.ContentPart) (string, []byte, error) {
+	var data []byte
+	switch {
+	case part.Data != "":
+		// 兼容 data:image/png;base64,... 形式
+		encoded := part.Data
+		if i := strings.Index(encoded, ";base64,"); strings.HasPrefix(encoded, "data:") && i > 0 {
+			encoded = encoded[i+len(";base64,"):]
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid image data: %w", err)
+		}
+		data = decoded
+
+	case part.Path != "":
+		content, err := os.ReadFile(ExpandPath(part.Path))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		data = content
+
+	default:
+		return "", nil, fmt.Errorf("image part has neither data nor path")
+	}
+
+	resolved, err := ImagePart(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if part.MediaType != "" {
+		resolved.MediaType = part.MediaType
+	}
+	return resolved.MediaType, data, nil
+}
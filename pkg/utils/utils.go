@@ -13,6 +13,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/browser"
+	"github.com/zboya/nala-coder/pkg/tokenizer"
 )
 
 // GenerateID 生成唯一ID
@@ -27,19 +28,9 @@ func GenerateShortID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// CountTokens 简单的token计数估算
+// CountTokens token计数，具体模型请使用 tokenizer.ForModel
 func CountTokens(text string) int {
-	// 简单估算: 1 token ≈ 4个字符 (英文) 或 1.5个中文字符
-	chars := len([]rune(text))
-	chineseCount := 0
-	for _, r := range text {
-		if r >= 0x4e00 && r <= 0x9fff {
-			chineseCount++
-		}
-	}
-
-	englishCount := chars - chineseCount
-	return int(float64(englishCount)/4 + float64(chineseCount)/1.5)
+	return tokenizer.Default().Count(text)
 }
 
 // FormatTime 格式化时间
@@ -47,6 +38,33 @@ func FormatTime(t time.Time) string {
 	return t.Format("2006-01-02 15:04:05")
 }
 
+// ParseDate 解析日期（2006-01-02，本地时区）或RFC3339时间，空字符串返回零值
+func ParseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC3339", value)
+	}
+	return t, nil
+}
+
+// ParseDateEnd 解析结束日期，只有日期时返回次日零点，使该日期包含在范围内
+func ParseDateEnd(value string) (time.Time, error) {
+	t, err := ParseDate(value)
+	if err != nil || t.IsZero() {
+		return t, err
+	}
+	if _, dateErr := time.ParseInLocation("2006-01-02", value, time.Local); dateErr == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	return t, nil
+}
+
 // EnsureDir 确保目录存在
 func EnsureDir(dir string) error {
 	return os.MkdirAll(dir, 0755)
@@ -0,0 +1,25 @@
+#!/bin/bash
+
+# 下载 BPE 词表到 pkg/tokenizer/vocab，重新编译后内置到二进制
+
+set -eo pipefail
+
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+PROJECT_ROOT="$(dirname "$SCRIPT_DIR")"
+VOCAB_DIR="$PROJECT_ROOT/pkg/tokenizer/vocab"
+BASE_URL="https://openaipublic.blob.core.windows.net/encodings"
+
+mkdir -p "$VOCAB_DIR"
+
+for name in cl100k_base o200k_base; do
+    target="$VOCAB_DIR/$name.tiktoken.gz"
+    if [ -f "$target" ]; then
+        echo "⚠️  词表已存在，跳过下载: $name"
+        continue
+    fi
+
+    echo "📥 下载词表: $name"
+    curl -fsSL "$BASE_URL/$name.tiktoken" | gzip -9 > "$target.tmp"
+    mv "$target.tmp" "$target"
+    echo "✅ 已保存: $target"
+done